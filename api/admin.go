@@ -4,15 +4,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 	"yanshu-imgbed/database"
 	"yanshu-imgbed/service"
 	"yanshu-imgbed/storage"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // BatchAdminImageRequest defines the structure for admin-level batch operations.
@@ -20,6 +24,10 @@ type BatchAdminImageRequest struct {
 	Action     string   `json:"action" binding:"required"`
 	ImageUUIDs []string `json:"image_uuids" binding:"required"`
 	BackendID  uint     `json:"backend_id"` // Optional, for backfill
+	// TargetUserID is required for the transfer action.
+	TargetUserID uint `json:"target_user_id"`
+	// Tags is required for the add_tags and remove_tags actions.
+	Tags []string `json:"tags"`
 }
 
 // BatchAdminImageHandler handles batch operations initiated by admins.
@@ -48,13 +56,32 @@ func (h *APIHandlers) BatchAdminImageHandler(c *gin.Context) {
 		err = service.BatchSetRandomStatus(req.ImageUUIDs, true)
 	case "remove_from_random":
 		err = service.BatchSetRandomStatus(req.ImageUUIDs, false)
+	case "add_tags":
+		err = service.AddImageTags(req.ImageUUIDs, req.Tags, userID, userRole)
+	case "remove_tags":
+		err = service.RemoveImageTags(req.ImageUUIDs, req.Tags, userID, userRole)
+	case "transfer":
+		if req.TargetUserID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "target_user_id is required for transfer action"})
+			return
+		}
+		var result *service.TransferResult
+		result, err = service.TransferImages(req.ImageUUIDs, req.TargetUserID)
+		if errors.Is(err, service.ErrTargetUserNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{"message": "Images transferred", "result": result})
+			return
+		}
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid action"})
 		return
 	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondTagError(c, err)
 		return
 	}
 
@@ -65,9 +92,82 @@ func (h *APIHandlers) BatchAdminImageHandler(c *gin.Context) {
 	}
 }
 
+// ListTasksHandler lists background tasks, newest first, with optional status filtering.
 func ListTasksHandler(c *gin.Context) {
-	tasks := service.GetTasks()
-	c.JSON(http.StatusOK, tasks)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	c.JSON(http.StatusOK, service.ListTasks(service.TaskFilter{
+		Status:   c.Query("status"),
+		Order:    c.DefaultQuery("order", "desc"),
+		Page:     page,
+		PageSize: pageSize,
+	}))
+}
+
+const (
+	// taskStreamInterval is the minimum gap between two batches of task events; updates in between are merged.
+	taskStreamInterval = 500 * time.Millisecond
+	// taskStreamHeartbeat keeps idle streams alive through proxies.
+	taskStreamHeartbeat = 15 * time.Second
+)
+
+// StreamTasksHandler streams task progress as server-sent events so the admin UI does not have to poll.
+// Every "task" event carries a full task snapshot including the recent per-item errors; the stream starts
+// with the current tasks. ?id= limits the stream to a single task.
+func StreamTasksHandler(c *gin.Context) {
+	id := c.Query("id")
+	sub := service.SubscribeTasks()
+	defer sub.Close()
+
+	var initial []service.Task
+	if id != "" {
+		if task, ok := service.GetTask(id); ok {
+			initial = append(initial, task)
+		}
+	} else {
+		// Running tasks plus the most recent ones, oldest first: the UI prepends unknown rows, so the newest ends up on top.
+		seen := make(map[string]bool)
+		for _, filter := range []service.TaskFilter{{Status: "running", PageSize: 100}, {PageSize: 100}} {
+			for _, task := range service.ListTasks(filter).Tasks {
+				if !seen[task.ID] {
+					seen[task.ID] = true
+					initial = append(initial, *task)
+				}
+			}
+		}
+		sort.Slice(initial, func(i, j int) bool { return initial[i].CreatedAt.Before(initial[j].CreatedAt) })
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	heartbeat := time.NewTicker(taskStreamHeartbeat)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		if initial != nil {
+			for _, task := range initial {
+				c.SSEvent("task", task)
+			}
+			initial = nil
+			return true
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-service.TaskStreamsDone():
+			return false
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			return true
+		case <-sub.C:
+			for _, task := range sub.Changed() {
+				if id == "" || task.ID == id {
+					c.SSEvent("task", task)
+				}
+			}
+			time.Sleep(taskStreamInterval)
+			return true
+		}
+	})
 }
 
 // DeleteImageHandler is a method of APIHandlers to access the StorageManager
@@ -77,7 +177,11 @@ func (h *APIHandlers) DeleteImageHandler(c *gin.Context) {
 	userRole := c.MustGet("userRole").(string)
 
 	if err := service.DeleteImage(uuid, userID, userRole, h.StorageManager); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
@@ -88,7 +192,11 @@ func ToggleImageRandomStatusHandler(c *gin.Context) {
 	uuid := c.Param("uuid")
 	image, err := service.ToggleImageRandomStatus(uuid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, image)
@@ -105,6 +213,10 @@ func (h *APIHandlers) CreateBackendHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON in config field"})
 		return
 	}
+	if backend.Weight < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Weight must not be negative"})
+		return
+	}
 	if err := database.DB.Create(&backend).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backend"})
 		return
@@ -115,7 +227,7 @@ func (h *APIHandlers) CreateBackendHandler(c *gin.Context) {
 
 func (h *APIHandlers) ToggleBackendFlagHandler(c *gin.Context) {
 	idStr := c.Param("id")
-	flag := c.Param("flag") // "upload" or "redirect"
+	flag := c.Param("flag") // "upload", "redirect", "dryrun" or "proxy"
 	id, _ := strconv.Atoi(idStr)
 
 	var backend database.Backend
@@ -129,6 +241,10 @@ func (h *APIHandlers) ToggleBackendFlagHandler(c *gin.Context) {
 		backend.AllowUpload = !backend.AllowUpload
 	case "redirect":
 		backend.AllowRedirect = !backend.AllowRedirect
+	case "dryrun":
+		backend.DryRun = !backend.DryRun
+	case "proxy":
+		backend.AllowProxy = !backend.AllowProxy
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flag specified"})
 		return
@@ -149,15 +265,19 @@ func SaveSettingsHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := service.ValidateSettings(newSettings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	for key, value := range newSettings {
 		// Use transaction for multiple updates? For now, this is fine.
 		setting := database.Setting{Key: key, Value: value}
-		if err := database.DB.Where("key = ?", key).First(&database.Setting{}).Error; err != nil {
+		if err := database.DB.Where(clause.Eq{Column: "key", Value: key}).First(&database.Setting{}).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				database.DB.Create(&setting)
 			}
 		} else {
-			database.DB.Model(&database.Setting{}).Where("key = ?", key).Update("value", value)
+			database.DB.Model(&database.Setting{}).Where(clause.Eq{Column: "key", Value: key}).Update("value", value)
 		}
 	}
 
@@ -219,6 +339,16 @@ func (h *APIHandlers) UpdateBackendHandler(c *gin.Context) {
 	// existingBackend.Type = req.Type
 	existingBackend.Config = req.Config
 	existingBackend.Priority = req.Priority
+	if req.BandwidthLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "BandwidthLimit must not be negative"})
+		return
+	}
+	existingBackend.BandwidthLimit = req.BandwidthLimit
+	if req.Weight < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Weight must not be negative"})
+		return
+	}
+	existingBackend.Weight = req.Weight
 
 	if err := database.DB.Save(&existingBackend).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update backend"})
@@ -234,7 +364,7 @@ func (h *APIHandlers) DeleteBackendHandler(c *gin.Context) {
 	var count int64
 	database.DB.Model(&database.StorageLocation{}).Where("backend_id = ?", backendID).Count(&count)
 	if count > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete backend: still associated with stored images."})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete backend: still associated with stored images. Decommission it to migrate or drop its storage locations first."})
 		return
 	}
 	if err := database.DB.Delete(&database.Backend{}, backendID).Error; err != nil {
@@ -245,6 +375,34 @@ func (h *APIHandlers) DeleteBackendHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Backend deleted successfully"})
 }
 
+// DecommissionBackendHandler starts a task that migrates or drops a backend's storage locations and then deletes it.
+func (h *APIHandlers) DecommissionBackendHandler(c *gin.Context) {
+	backendID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	var req service.DecommissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	taskID, err := service.DecommissionBackend(uint(backendID), req, h.StorageManager)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrBackendNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidDecommissionMode), errors.Is(err, service.ErrDecommissionTargetInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Decommission task started", "task_id": taskID})
+}
+
 // ValidateSmmsTokenHandler (no manager needed)
 func ValidateSmmsTokenHandler(c *gin.Context) {
 	var req struct {
@@ -284,9 +442,16 @@ func (h *APIHandlers) GetImageDetailsHandler(c *gin.Context) {
 	type ImageDetailResponse struct {
 		database.Image
 		StorageLocations []StorageLocationResponse `json:"StorageLocations"`
+		PosterURL        string                    `json:"PosterURL,omitempty"`
+		DailyViews       []service.DailyViewCount  `json:"DailyViews"`
 	}
 
-	response := ImageDetailResponse{Image: image}
+	dailyViews, err := service.GetImageDailyViews(image.ID, 30)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	response := ImageDetailResponse{Image: image, PosterURL: service.PosterURL(&image), DailyViews: dailyViews}
 	for _, loc := range image.StorageLocations {
 		response.StorageLocations = append(response.StorageLocations, StorageLocationResponse{
 			StorageLocation: loc,
@@ -319,3 +484,449 @@ func ToggleStorageLocationStatusHandler(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, loc)
 }
+
+// ListPendingDeletionsHandler lists physical deletions waiting for their grace period to expire.
+func ListPendingDeletionsHandler(c *gin.Context) {
+	pending, err := service.ListPendingDeletions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list pending deletions"})
+		return
+	}
+	c.JSON(http.StatusOK, pending)
+}
+
+// CancelPendingDeletionHandler cancels a pending physical deletion and restores the image.
+func CancelPendingDeletionHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	image, err := service.CancelPendingDeletion(uint(id))
+	if err != nil {
+		if errors.Is(err, service.ErrPendingDeletionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Deletion cancelled and image restored", "image": image})
+}
+
+// ListFailedDeletionsHandler lists physical deletions that failed and are waiting to be retried.
+func ListFailedDeletionsHandler(c *gin.Context) {
+	failed, err := service.ListFailedDeletions()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, failed)
+}
+
+// RetryFailedDeletionHandler retries a failed deletion immediately; on failure it stays queued.
+func (h *APIHandlers) RetryFailedDeletionHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	if err := service.RetryFailedDeletion(uint(id), h.StorageManager); err != nil {
+		if errors.Is(err, service.ErrFailedDeletionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Deletion failed again and was rescheduled: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "File deleted"})
+}
+
+// DiscardFailedDeletionHandler stops retrying a failed deletion.
+func DiscardFailedDeletionHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	if err := service.DiscardFailedDeletion(uint(id)); err != nil {
+		if errors.Is(err, service.ErrFailedDeletionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Failed deletion discarded"})
+}
+
+// BatchToggleBackendLocationsHandler activates or deactivates all storage locations of a backend.
+func BatchToggleBackendLocationsHandler(c *gin.Context) {
+	backendID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	var req struct {
+		IsActive *bool `json:"is_active" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	taskID, err := service.BatchSetBackendLocationsStatus(uint(backendID), *req.IsActive)
+	if err != nil {
+		if errors.Is(err, service.ErrBackendNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Batch task started", "task_id": taskID})
+}
+
+// GetUserStatsHandler returns the activity and usage statistics of any user.
+func GetUserStatsHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	stats, err := service.GetUserStats(uint(userID), days)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetDailyTrendsHandler returns the daily upload, view and traffic rollups of the last ?days= days
+// for the dashboard trend charts. The rollups are refreshed in the background, not on request.
+func GetDailyTrendsHandler(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	trends, err := service.GetDailyTrends(days)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, trends)
+}
+
+// TransferUserImagesHandler transfers all images of a user to another user.
+func TransferUserImagesHandler(c *gin.Context) {
+	fromUserID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+	var req struct {
+		TargetUserID uint `json:"target_user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := service.TransferAllImages(uint(fromUserID), req.TargetUserID)
+	if err != nil {
+		if errors.Is(err, service.ErrTargetUserNotFound) || errors.Is(err, service.ErrSameTransferUser) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Images transferred", "result": result})
+}
+
+// GetBackendsHealthHandler summarizes reachability, recent operation stats and usage for every backend.
+func (h *APIHandlers) GetBackendsHealthHandler(c *gin.Context) {
+	windowHours, _ := strconv.Atoi(c.DefaultQuery("window_hours", "24"))
+	health, err := service.GetBackendsHealth(windowHours, h.StorageManager)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, health)
+}
+
+// ImportLocalDirectoryHandler starts a task that imports every image found in a directory on the server.
+func (h *APIHandlers) ImportLocalDirectoryHandler(c *gin.Context) {
+	var req service.LocalImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UserID == 0 {
+		req.UserID = c.MustGet("userID").(uint)
+	} else if err := database.DB.First(&database.User{}, req.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+		return
+	}
+
+	taskID, err := service.ImportLocalDirectory(req, h.StorageManager)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		switch {
+		case errors.Is(err, service.ErrImportPathInvalid), errors.Is(err, service.ErrImportOutsideLocalStore):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.As(err, &rejected):
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Import task started", "task_id": taskID})
+}
+
+// StartOrphanScanHandler starts a background scan of local storage directories for unreferenced files.
+func (h *APIHandlers) StartOrphanScanHandler(c *gin.Context) {
+	var req service.OrphanScanRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UserID == 0 {
+		req.UserID = c.MustGet("userID").(uint)
+	} else if err := database.DB.First(&database.User{}, req.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+		return
+	}
+
+	taskID, err := service.StartOrphanScan(req, h.StorageManager)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		switch {
+		case errors.Is(err, service.ErrInvalidOrphanAction):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.As(err, &rejected):
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+		case errors.Is(err, service.ErrBackendNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Local backend not found"})
+		case errors.Is(err, service.ErrOrphanScanRunning):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Orphan scan started", "task_id": taskID})
+}
+
+// GetOrphanReportHandler returns the report of the latest (or running) orphan file scan.
+func GetOrphanReportHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"report": service.GetOrphanReport()})
+}
+
+// GetServeMetricsHandler returns per-backend counts and decision latency of /image requests.
+func GetServeMetricsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.GetServeMetrics())
+}
+
+// ResetServeMetricsHandler clears the /image serving metrics.
+func ResetServeMetricsHandler(c *gin.Context) {
+	service.ResetServeMetrics()
+	c.JSON(http.StatusOK, gin.H{"message": "Serving metrics reset"})
+}
+
+// GetMostViewedHandler reports the most viewed images, either all-time or over the last ?days= days.
+func GetMostViewedHandler(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	images, err := service.GetMostViewedImages(days, limit, 0)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"days": days, "images": images})
+}
+
+// GetReplicaReportHandler returns the result of the last min_replicas reconciliation run.
+func GetReplicaReportHandler(c *gin.Context) {
+	report := service.GetReplicaReport()
+	c.JSON(http.StatusOK, gin.H{"min_replicas": service.GetMinReplicas(), "report": report})
+}
+
+// ReconcileReplicasHandler runs the min_replicas reconciliation immediately and returns its report.
+func (h *APIHandlers) ReconcileReplicasHandler(c *gin.Context) {
+	if service.GetMinReplicas() <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_replicas is not enabled"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"min_replicas": service.GetMinReplicas(), "report": service.ReconcileReplicas(h.StorageManager)})
+}
+
+// StartIntegrityCheckHandler starts a background task that verifies the files behind active storage locations.
+func StartIntegrityCheckHandler(c *gin.Context) {
+	var req service.IntegrityCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	taskID, err := service.StartIntegrityCheck(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidIntegrityMode):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrBackendNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrIntegrityCheckRunning):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Integrity check started", "task_id": taskID})
+}
+
+// GetIntegrityReportHandler returns the report of the latest (or running) integrity check.
+func GetIntegrityReportHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"report": service.GetIntegrityReport()})
+}
+
+// ResolveDuplicatesRequest selects a duplicate group and how to resolve it.
+type ResolveDuplicatesRequest struct {
+	ContentKey string `json:"content_key" binding:"required"`
+	// Action is "sync" (give every record all active files) or "cleanup" (keep one record, delete the rest).
+	Action   string `json:"action" binding:"required"`
+	KeepUUID string `json:"keep_uuid"`
+}
+
+// ListDuplicatesHandler reports images that share the same content, optionally only those whose storage locations diverge.
+func ListDuplicatesHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	report, err := service.FindDuplicateImages(page, pageSize, c.Query("divergent") == "true")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ResolveDuplicatesHandler syncs or cleans up one duplicate group.
+func (h *APIHandlers) ResolveDuplicatesHandler(c *gin.Context) {
+	var req ResolveDuplicatesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rejected *service.UploadRejectedError
+	switch req.Action {
+	case "sync":
+		added, err := service.SyncDuplicateLocations(req.ContentKey)
+		if err != nil {
+			respondDuplicateError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Storage locations synced", "added": added})
+	case "cleanup":
+		if req.KeepUUID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "keep_uuid is required for cleanup"})
+			return
+		}
+		removed, err := service.CleanupDuplicateImages(req.ContentKey, req.KeepUUID, c.MustGet("userID").(uint), h.StorageManager)
+		if err != nil {
+			if errors.As(err, &rejected) || errors.Is(err, service.ErrDuplicateGroupNotFound) {
+				respondDuplicateError(c, err)
+				return
+			}
+			abortWithErrorFields(c, err, gin.H{"removed": removed})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Duplicates cleaned up", "removed": removed})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid action"})
+	}
+}
+
+// respondDuplicateError maps duplicate finder errors to HTTP responses.
+func respondDuplicateError(c *gin.Context, err error) {
+	var rejected *service.UploadRejectedError
+	switch {
+	case errors.Is(err, service.ErrDuplicateGroupNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+	default:
+		abortWithError(c, err)
+	}
+}
+
+// ListNotificationsHandler lists admin notifications, newest first; ?unread=true returns only unread ones.
+func ListNotificationsHandler(c *gin.Context) {
+	unreadOnly, _ := strconv.ParseBool(c.Query("unread"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	result, err := service.ListAdminNotifications(unreadOnly, page, pageSize)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// MarkNotificationsReadHandler marks the given notifications as read, or all of them when ids is empty.
+func MarkNotificationsReadHandler(c *gin.Context) {
+	var req struct {
+		IDs []uint `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := service.MarkNotificationsRead(req.IDs); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications marked as read"})
+}
+
+// GetPlaceholderHandler reports whether a placeholder image is uploaded and enabled.
+func GetPlaceholderHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.GetPlaceholderInfo())
+}
+
+// UploadPlaceholderHandler uploads the image served in place of missing or unreachable images.
+// Serving it is turned on separately with the placeholder_enabled setting.
+func UploadPlaceholderHandler(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	info, err := service.SavePlaceholderImage(file)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// DeletePlaceholderHandler removes the placeholder image.
+func DeletePlaceholderHandler(c *gin.Context) {
+	if err := service.RemovePlaceholderImage(); err != nil {
+		if errors.Is(err, service.ErrNoPlaceholder) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Placeholder image deleted"})
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListAlbumsHandler lists the current user's albums with their image counts.
+func ListAlbumsHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	albums, err := service.ListAlbums(userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, albums)
+}
+
+// CreateAlbumHandler creates a new album for the current user.
+func CreateAlbumHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req service.AlbumInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	album, err := service.CreateAlbum(userID, req)
+	if err != nil {
+		respondAlbumError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, album)
+}
+
+// GetAlbumHandler returns one of the current user's albums.
+func GetAlbumHandler(c *gin.Context) {
+	id, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+	album, err := service.GetAlbum(id, c.MustGet("userID").(uint))
+	if err != nil {
+		respondAlbumError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, album)
+}
+
+// UpdateAlbumHandler replaces the details of one of the current user's albums.
+func UpdateAlbumHandler(c *gin.Context) {
+	id, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+	var req service.AlbumInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	album, err := service.UpdateAlbum(id, c.MustGet("userID").(uint), req)
+	if err != nil {
+		respondAlbumError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, album)
+}
+
+// DeleteAlbumHandler deletes one of the current user's albums; its images are kept.
+func DeleteAlbumHandler(c *gin.Context) {
+	id, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+	if err := service.DeleteAlbum(id, c.MustGet("userID").(uint)); err != nil {
+		respondAlbumError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Album deleted"})
+}
+
+// ListAlbumImagesHandler lists the images in one of the current user's albums.
+func ListAlbumImagesHandler(c *gin.Context) {
+	id, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+	if _, err := service.GetAlbum(id, userID); err != nil {
+		respondAlbumError(c, err)
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+
+	// 相册只包含所有者的图片，按普通用户身份查询即可
+	response, err := service.ListImages(userID, "user", service.ImageFilter{
+		Keyword:  c.Query("keyword"),
+		AlbumID:  &id,
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetPublicAlbumHandler returns a public album and a page of its images to anonymous visitors.
+func GetPublicAlbumHandler(c *gin.Context) {
+	id, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+	album, err := service.GetPublicAlbum(id)
+	if err != nil {
+		respondAlbumError(c, err)
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "30"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 30
+	}
+	images, err := service.ListPublicAlbumImages(album, page, pageSize)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"name":        album.Name,
+		"description": album.Description,
+		"cover_uuid":  album.CoverUUID,
+		"images":      images,
+	})
+}
+
+// parseAlbumID reads the :id path parameter, writing a 400 response when it is invalid.
+func parseAlbumID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// respondAlbumError maps album service errors to HTTP responses.
+func respondAlbumError(c *gin.Context, err error) {
+	var rejected *service.UploadRejectedError
+	switch {
+	case errors.Is(err, service.ErrAlbumNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+	default:
+		abortWithError(c, err)
+	}
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"errors"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"yanshu-imgbed/database"
@@ -13,25 +16,101 @@ import (
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	// CaptchaToken 连续失败后需要提交的 hCaptcha/Turnstile token
+	CaptchaToken string `json:"captcha_token"`
 }
 
-// LoginHandler 处理用户登录
+// LoginHandler 处理用户登录，连续失败的用户名或 IP 会被暂时锁定，达到阈值后还需要验证码
 func LoginHandler(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	ip := c.ClientIP()
+
+	var locked *service.LoginLockedError
+	if err := service.CheckLoginLockout(req.Username, ip); errors.As(err, &locked) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if service.CaptchaRequiredForLogin(req.Username, ip) {
+		if err := service.VerifyCaptcha(req.CaptchaToken, ip); err != nil {
+			if !errors.Is(err, service.ErrCaptchaFailed) {
+				abortWithError(c, err)
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "captcha_required": true, "captcha": service.GetCaptchaInfo()})
+			return
+		}
+	}
 
 	token, err := service.Login(req.Username, req.Password)
+	if errors.Is(err, service.ErrUserSuspended) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		service.RecordLoginFailure(req.Username, ip)
+		resp := gin.H{"error": err.Error()}
+		if service.CaptchaRequiredForLogin(req.Username, ip) {
+			resp["captcha_required"] = true
+			resp["captcha"] = service.GetCaptchaInfo()
+		}
+		c.JSON(http.StatusUnauthorized, resp)
 		return
 	}
+	service.ResetLoginFailures(req.Username)
 
 	c.JSON(http.StatusOK, gin.H{"token": token, "message": "登录成功"})
 }
 
+// CaptchaInfoHandler 返回登录验证码的服务商和站点密钥，未启用时 captcha 为 null
+func CaptchaInfoHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"captcha": service.GetCaptchaInfo()})
+}
+
+// SelfRegisterRequest 自助注册请求结构
+type SelfRegisterRequest struct {
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	InviteCode string `json:"invite_code"`
+}
+
+// SelfRegisterHandler 访客自助注册，角色和配额使用管理员设置的默认值
+func SelfRegisterHandler(c *gin.Context) {
+	var req SelfRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := service.SelfRegister(req.Username, req.Password, req.InviteCode)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		switch {
+		case errors.As(err, &rejected):
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+		case errors.Is(err, service.ErrRegistrationClosed):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInviteRequired), errors.Is(err, service.ErrInviteInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrUsernameTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "注册成功", "user_id": user.ID, "username": user.Username})
+}
+
+// RegistrationStatusHandler 返回是否开放注册，登录页据此显示注册表单
+func RegistrationStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.GetRegistrationStatus())
+}
+
 // GetUserInfo 获取当前登录用户信息
 func GetUserInfoHandler(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
@@ -45,12 +124,22 @@ func GetUserInfoHandler(c *gin.Context) {
 	})
 }
 
+// GetDailyUploadStatusHandler 获取当前用户今天的上传次数、上传量和每日上限
+func GetDailyUploadStatusHandler(c *gin.Context) {
+	status, err := service.GetDailyUploadStatus(c.MustGet("userID").(uint))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
 // --- User Management (Admin Only) ---
 
 // ListUsersHandler 列出所有用户
 func ListUsersHandler(c *gin.Context) {
 	var users []database.User
-	database.DB.Select("id", "username", "role", "created_at", "updated_at").Find(&users)
+	database.DB.Select("id", "username", "role", "watermark_disabled", "is_active", "created_at", "updated_at").Find(&users)
 	c.JSON(http.StatusOK, users)
 }
 
@@ -68,12 +157,16 @@ func RegisterUserHandler(c *gin.Context) {
 		return
 	}
 	if req.Role == "" {
-		req.Role = "user" // 默认普通用户
+		req.Role = service.UserRoleName // 默认普通用户
+	}
+	if !service.RoleExists(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "角色不存在"})
+		return
 	}
 
 	user, err := service.RegisterUser(req.Username, req.Password, req.Role)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"message": "用户创建成功", "user_id": user.ID, "username": user.Username})
@@ -92,20 +185,153 @@ func ResetPasswordHandler(c *gin.Context) {
 		return
 	}
 	if err := service.ResetUserPassword(uint(userID), req.NewPassword); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if err.Error() == "用户不存在" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "密码重置成功"})
 }
 
-// DeleteUserHandler 删除用户 (管理员)
-func DeleteUserHandler(c *gin.Context) {
+// DeleteUserHandler 删除用户 (管理员)，请求体指定用户图片的处理方式：
+// {"images": "purge"} 从所有后端删除，{"images": "transfer", "transfer_to": 2} 转移给其他用户
+func (h *APIHandlers) DeleteUserHandler(c *gin.Context) {
 	userID, _ := strconv.Atoi(c.Param("id"))
-	if err := service.DeleteUser(uint(userID)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var req service.DeleteUserRequest
+	// 请求体可以省略，没有图片的用户不需要指定处理方式
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "用户删除成功"})
+	result, err := service.DeleteUser(uint(userID), req, h.StorageManager)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrDeleteUserImagesAction), errors.Is(err, service.ErrTargetUserNotFound), errors.Is(err, service.ErrSameTransferUser):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "用户删除成功", "result": result})
+}
+
+// ToggleUserWatermarkHandler 切换用户上传时是否默认添加水印 (管理员)
+func ToggleUserWatermarkHandler(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.Param("id"))
+	user, err := service.ToggleUserWatermark(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "watermark_disabled": user.WatermarkDisabled})
+}
+
+// --- Invite Codes (Admin Only) ---
+
+// ListInviteCodesHandler 列出所有邀请码
+func ListInviteCodesHandler(c *gin.Context) {
+	invites, err := service.ListInviteCodes()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, invites)
+}
+
+// CreateInviteCodesRequest 生成邀请码请求，max_uses 为 0 表示不限次数
+type CreateInviteCodesRequest struct {
+	Count     int    `json:"count"`
+	MaxUses   *int   `json:"max_uses"`
+	ExpiresIn string `json:"expires_in"`
+	Note      string `json:"note"`
+}
+
+func CreateInviteCodesHandler(c *gin.Context) {
+	adminID := c.MustGet("userID").(uint)
+	var req CreateInviteCodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxUses := 1 // 默认一次性邀请码
+	if req.MaxUses != nil {
+		maxUses = *req.MaxUses
+	}
+
+	invites, err := service.CreateInviteCodes(adminID, req.Count, maxUses, req.ExpiresIn, req.Note)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, invites)
+}
+
+// RevokeInviteCodeHandler 撤销邀请码
+func RevokeInviteCodeHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := service.RevokeInviteCode(uint(id)); err != nil {
+		if errors.Is(err, service.ErrInviteNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "邀请码已撤销"})
+}
+
+// ToggleUserActiveHandler 停用或重新启用用户 (管理员)
+func ToggleUserActiveHandler(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.Param("id"))
+	var user database.User
+	if err := database.DB.Select("id", "is_active").First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	updated, err := service.SetUserActive(user.ID, c.MustGet("userID").(uint), !user.IsActive)
+	if err != nil {
+		if errors.Is(err, service.ErrSuspendSelf) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": updated.ID, "is_active": updated.IsActive})
+}
+
+// SetUserQuotaHandler 设置用户的存储配额 (管理员)
+type SetUserQuotaRequest struct {
+	QuotaMB int64 `json:"quota_mb"`
+}
+
+func SetUserQuotaHandler(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.Param("id"))
+	var req SetUserQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.QuotaMB < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "配额不能为负数"})
+		return
+	}
+	user, err := service.SetUserStorageQuota(uint(userID), req.QuotaMB)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "storage_quota_mb": user.StorageQuotaMB})
 }
 
 // --- Self-Service Password Change ---
@@ -146,6 +372,21 @@ func ListAPITokensHandler(c *gin.Context) {
 // CreateAPITokenHandler 为当前用户创建API Token
 type CreateAPITokenRequest struct {
 	Name string `json:"name" binding:"required"`
+	// 委托上传绑定：设置后该 Token 上传的图片固定放入该文件夹并使用这些处理预设
+	Folder    string `json:"folder"`
+	Watermark *bool  `json:"watermark"`
+	Compress  *bool  `json:"compress"`
+	// FilenameStrategy 该 Token 上传时默认的文件名策略：uuid、original、date、hash
+	FilenameStrategy string `json:"filename_strategy"`
+	// BackendIDs 该 Token 上传时固定使用的后端，客户端传入的后端列表会被忽略
+	BackendIDs []uint `json:"backend_ids"`
+	// Scopes 权限范围：upload、read、delete、admin，为空时只允许上传
+	Scopes []string `json:"scopes"`
+	// ExpiresIn Token 的有效期，例如 "30d"，为空表示永不过期
+	ExpiresIn string `json:"expires_in"`
+	// DailyUploadLimit / DailyUploadMB 通过该 Token 每天最多上传的次数和 MB 数，0 表示不单独限制
+	DailyUploadLimit int64 `json:"daily_upload_limit"`
+	DailyUploadMB    int64 `json:"daily_upload_mb"`
 }
 
 func CreateAPITokenHandler(c *gin.Context) {
@@ -155,8 +396,44 @@ func CreateAPITokenHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	token, err := service.CreateAPIToken(userID, req.Name)
+	folder, err := service.NormalizeFolder(req.Folder)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	strategy, err := service.NormalizeFilenameStrategy(req.FilenameStrategy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	scopes, err := service.NormalizeTokenScopes(req.Scopes, c.MustGet("userRole").(string))
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	expiresAt, err := service.ParseExpiresIn(req.ExpiresIn)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DailyUploadLimit < 0 || req.DailyUploadMB < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "每日上传上限不能为负数"})
+		return
+	}
+	binding := service.APITokenUploadBinding{Folder: folder, Watermark: req.Watermark, Compress: req.Compress, FilenameStrategy: strategy, BackendIDs: req.BackendIDs,
+		DailyUploadLimit: req.DailyUploadLimit, DailyUploadMB: req.DailyUploadMB}
+	token, err := service.CreateAPIToken(userID, req.Name, scopes, expiresAt, binding)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建API Token失败"})
 		return
 	}
@@ -180,7 +457,30 @@ func ToggleAPITokenStatusHandler(c *gin.Context) {
 
 	token, err := service.ToggleAPITokenStatus(uint(tokenID))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, token)
+}
+
+// RotateAPITokenHandler 为API Token生成新的值，旧值立即失效
+func RotateAPITokenHandler(c *gin.Context) {
+	tokenID, _ := strconv.Atoi(c.Param("id"))
+	userID := c.MustGet("userID").(uint) // 确保只能操作自己的Token
+
+	var apiToken database.APIToken
+	if err := database.DB.First(&apiToken, tokenID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API Token not found"})
+		return
+	}
+	if apiToken.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权操作此API Token"})
+		return
+	}
+
+	token, err := service.RotateAPIToken(uint(tokenID))
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, token)
@@ -202,8 +502,44 @@ func DeleteAPITokenHandler(c *gin.Context) {
 	}
 
 	if err := service.DeleteAPIToken(uint(tokenID)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "API Token删除成功"})
 }
+
+// GetAPITokenUsageHandler 获取API Token最近若干天的逐日用量 (Token所有者或管理员)
+func GetAPITokenUsageHandler(c *gin.Context) {
+	tokenID, _ := strconv.Atoi(c.Param("id"))
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+
+	var apiToken database.APIToken
+	if err := database.DB.First(&apiToken, tokenID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API Token not found"})
+		return
+	}
+	if apiToken.UserID != userID && !service.HasPermission(userRole, service.PermManageSystem) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权查看此API Token"})
+		return
+	}
+
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	usage, err := service.GetAPITokenUsage(apiToken.ID, days)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token_id": apiToken.ID, "name": apiToken.Name, "usage": usage})
+}
+
+// ListAPITokenUsageHandler 汇总所有API Token最近若干天的用量 (管理员)
+func ListAPITokenUsageHandler(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "7"))
+	summaries, err := service.ListAPITokenUsage(days)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, summaries)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateUploadSessionHandler starts a chunked upload and returns the upload ID and chunk layout.
+func CreateUploadSessionHandler(c *gin.Context) {
+	var req struct {
+		Filename  string `json:"filename" binding:"required"`
+		Size      int64  `json:"size" binding:"required"`
+		ChunkSize int64  `json:"chunk_size"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+	status, err := service.CreateUploadSession(userID, req.Filename, req.Size, req.ChunkSize)
+	if err != nil {
+		respondChunkedUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// GetUploadSessionHandler returns which chunks have been received so a client can resume.
+func GetUploadSessionHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	status, err := service.GetUploadSessionStatus(c.Param("id"), userID)
+	if err != nil {
+		respondChunkedUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// PutUploadChunkHandler stores one chunk; the request body is the raw chunk bytes.
+func PutUploadChunkHandler(c *gin.Context) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chunk index"})
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+	if err := service.SaveUploadChunk(c.Param("id"), userID, index, c.Request.Body); err != nil {
+		respondChunkedUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Chunk received", "index": index})
+}
+
+// CompleteUploadSessionHandler assembles the chunks and runs the normal upload pipeline.
+// It accepts the same form fields as the regular upload endpoint (backends, watermark, annotations).
+func (h *APIHandlers) CompleteUploadSessionHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	targetBackendIDs, opts, ok := parseUploadOptions(c)
+	if !ok {
+		return
+	}
+	image, err := service.CompleteUploadSession(c.Param("id"), userID, targetBackendIDs, opts, h.StorageManager)
+	if err != nil {
+		respondChunkedUploadError(c, err)
+		return
+	}
+	h.respondUploadedImage(c, image)
+}
+
+// AbortUploadSessionHandler cancels a chunked upload and discards its chunks.
+func AbortUploadSessionHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	if err := service.AbortUploadSession(c.Param("id"), userID); err != nil {
+		respondChunkedUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Upload aborted"})
+}
+
+func respondChunkedUploadError(c *gin.Context, err error) {
+	var rejected *service.UploadRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+	case errors.Is(err, service.ErrUploadSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidChunk), errors.Is(err, service.ErrUploadIncomplete):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		abortWithError(c, err)
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+	"yanshu-imgbed/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// abortWithError 上报内部错误并终止请求
+// 错误详情由 middleware.ErrorMiddleware 记录到日志，客户端只收到脱敏后的响应
+func abortWithError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// abortWithErrorFields 与 abortWithError 相同，但在脱敏后的响应中附加字段，例如失败前已完成的数量
+func abortWithErrorFields(c *gin.Context, err error, fields gin.H) {
+	_ = c.Error(err)
+	body := middleware.InternalErrorBody(c)
+	for key, value := range fields {
+		body[key] = value
+	}
+	c.AbortWithStatusJSON(http.StatusInternalServerError, body)
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExternalFrontendHandler serves a custom frontend from dir for requests that match no API route.
+// Existing files are served as-is; other page requests get index.html so client-side routing works.
+func ExternalFrontendHandler(dir string) gin.HandlerFunc {
+	root := filepath.Clean(dir)
+	index := filepath.Join(root, "index.html")
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if (method != http.MethodGet && method != http.MethodHead) || strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			NoRouteHandler(c)
+			return
+		}
+		// 重写规则优先于前端页面
+		if target, ok := service.ResolveRewrite(c.Request.URL.Path); ok {
+			c.Redirect(http.StatusMovedPermanently, target)
+			return
+		}
+
+		// 先按根路径清理，".." 无法越过 root
+		target := filepath.Join(root, filepath.FromSlash(path.Clean("/"+c.Request.URL.Path)))
+		if info, err := os.Stat(target); err == nil && !info.IsDir() {
+			c.File(target)
+			return
+		}
+		if _, err := os.Stat(index); err != nil {
+			NoRouteHandler(c)
+			return
+		}
+		c.File(index)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"yanshu-imgbed/config"
 	"yanshu-imgbed/database"
 	"yanshu-imgbed/manager"
 	"yanshu-imgbed/storage"
@@ -36,6 +37,6 @@ func (h *APIHandlers) getFullURL(loc database.StorageLocation) string {
 		return loc.URL
 	}
 
-	// 使用当前最新的 PublicURL 配置来拼接
-	return localUploader.PublicURL + loc.URL
+	// 使用当前最新的 PublicURL 配置来拼接，PublicURL 只包含站点地址，子路径由 server.base_path 补上
+	return localUploader.PublicURL + config.WithBasePath(loc.URL)
 }
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blockHotlink applies the hotlink protection settings to an image request and reports whether the
+// response has already been written. uuid is empty for the random API, where the watermark action
+// is left to the /image request the client is redirected to.
+func blockHotlink(c *gin.Context, uuid string) bool {
+	if service.GetHotlinkSettings().Mode == service.HotlinkOff {
+		return false
+	}
+	// 响应随来源不同而不同，避免 CDN 把放行的响应缓存给盗链请求
+	c.Header("Vary", "Referer, Origin")
+
+	switch service.CheckHotlink(c.GetHeader("Referer"), c.GetHeader("Origin"), c.Request.Host) {
+	case "":
+		return false
+	case service.HotlinkPlaceholder:
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, service.GetHotlinkSettings().PlaceholderURL)
+		return true
+	case service.HotlinkWatermark:
+		if uuid == "" {
+			return false
+		}
+		data, contentType, err := service.RenderHotlinkWatermark(uuid)
+		if err != nil {
+			if !errors.Is(err, service.ErrHotlinkNotWatermarkable) {
+				log.Printf("Failed to watermark hotlinked image %s: %v", uuid, err)
+			}
+			break
+		}
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, contentType, data)
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Hotlinking is not allowed"})
+	return true
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondMaintenanceError 当前数据库不支持的操作返回 400，其他错误返回 500
+func respondMaintenanceError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrMaintenanceUnsupported) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	abortWithError(c, err)
+}
+
+// GetDatabaseInfoHandler 返回数据库大小信息
+func GetDatabaseInfoHandler(c *gin.Context) {
+	info, err := service.GetDatabaseInfo()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// VacuumDatabaseHandler 执行 VACUUM
+func VacuumDatabaseHandler(c *gin.Context) {
+	if err := service.VacuumDatabase(); err != nil {
+		respondMaintenanceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "VACUUM completed successfully"})
+}
+
+// AnalyzeDatabaseHandler 执行 ANALYZE
+func AnalyzeDatabaseHandler(c *gin.Context) {
+	if err := service.AnalyzeDatabase(); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ANALYZE completed successfully"})
+}
+
+// IntegrityCheckHandler 执行完整性检查
+func IntegrityCheckHandler(c *gin.Context) {
+	results, err := service.CheckDatabaseIntegrity()
+	if err != nil {
+		respondMaintenanceError(c, err)
+		return
+	}
+	ok := len(results) == 1 && results[0] == "ok"
+	c.JSON(http.StatusOK, gin.H{"ok": ok, "results": results})
+}
+
+// ExportMetadataHandler 导出全部元数据。format=csv 时只导出图片列表；
+// include_secrets=true 时包含后端凭据和用户密码哈希
+func ExportMetadataHandler(c *gin.Context) {
+	filename := "imgbed-metadata-" + time.Now().Format("20060102-150405")
+	if c.Query("format") == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".csv"))
+		if err := service.WriteImagesCSV(c.Writer); err != nil {
+			c.Error(err)
+		}
+		return
+	}
+
+	export, err := service.ExportMetadata(c.Query("include_secrets") == "true")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+	c.JSON(http.StatusOK, export)
+}
+
+// ImportMetadataHandler 导入由 ExportMetadataHandler 导出的 JSON 元数据
+func (h *APIHandlers) ImportMetadataHandler(c *gin.Context) {
+	var export service.MetadataExport
+	if err := c.ShouldBindJSON(&export); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := service.ImportMetadata(&export)
+	if err != nil {
+		if errors.Is(err, service.ErrUnsupportedExportVersion) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	go h.StorageManager.Refresh()
+	c.JSON(http.StatusOK, result)
+}
+
+// ListDatabaseBackupsHandler 列出已上传到后端的数据库备份
+func ListDatabaseBackupsHandler(c *gin.Context) {
+	backups, err := service.ListDatabaseBackups()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, backups)
+}
+
+// RunDatabaseBackupHandler 立即备份数据库到 backup.backend
+func (h *APIHandlers) RunDatabaseBackupHandler(c *gin.Context) {
+	backup, err := service.RunDatabaseBackup(h.StorageManager)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrBackupNotConfigured):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrBackupRunning):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, backup)
+}
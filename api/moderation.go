@@ -0,0 +1,47 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListModerationQueueHandler lists images flagged or quarantined by content moderation.
+func ListModerationQueueHandler(c *gin.Context) {
+	images, err := service.ListModerationQueue()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, images)
+}
+
+// ApproveModeratedImageHandler approves an image in the moderation queue.
+func ApproveModeratedImageHandler(c *gin.Context) {
+	image, err := service.ApproveModeratedImage(c.Param("uuid"))
+	if err != nil {
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, image)
+}
+
+// RejectModeratedImageHandler rejects an image in the moderation queue and deletes it.
+func (h *APIHandlers) RejectModeratedImageHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	if err := service.RejectModeratedImage(c.Param("uuid"), userID, h.StorageManager); err != nil {
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Image rejected and deleted"})
+}
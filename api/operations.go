@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListStorageOperationsHandler lists storage operations filtered by backend, type, result and time range.
+func ListStorageOperationsHandler(c *gin.Context) {
+	filter := service.OperationFilter{
+		Operation: c.Query("operation"),
+		Keyword:   c.Query("keyword"),
+	}
+	filter.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	filter.PageSize, _ = strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+
+	if backendID := c.Query("backend_id"); backendID != "" {
+		id, err := strconv.ParseUint(backendID, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backend_id"})
+			return
+		}
+		filter.BackendID = uint(id)
+	}
+	if success := c.Query("success"); success != "" {
+		v, err := strconv.ParseBool(success)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid success flag"})
+			return
+		}
+		filter.Success = &v
+	}
+	for param, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " time, expected RFC3339"})
+				return
+			}
+			*target = &t
+		}
+	}
+
+	response, err := service.ListStorageOperations(filter)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
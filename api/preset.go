@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListUploadPresetsHandler lists the current user's upload presets.
+func ListUploadPresetsHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	presets, err := service.ListUploadPresets(userID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, presets)
+}
+
+// CreateUploadPresetHandler saves a new named upload preset for the current user.
+func CreateUploadPresetHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var req service.UploadPresetInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	preset, err := service.CreateUploadPreset(userID, req)
+	if err != nil {
+		respondPresetError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, preset)
+}
+
+// UpdateUploadPresetHandler replaces one of the current user's upload presets.
+func UpdateUploadPresetHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid preset ID"})
+		return
+	}
+	var req service.UploadPresetInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	preset, err := service.UpdateUploadPreset(uint(id), userID, req)
+	if err != nil {
+		respondPresetError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, preset)
+}
+
+// DeleteUploadPresetHandler deletes one of the current user's upload presets.
+func DeleteUploadPresetHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid preset ID"})
+		return
+	}
+	if err := service.DeleteUploadPreset(uint(id), userID); err != nil {
+		respondPresetError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Preset deleted"})
+}
+
+// respondPresetError maps preset service errors to HTTP responses.
+func respondPresetError(c *gin.Context, err error) {
+	var rejected *service.UploadRejectedError
+	switch {
+	case errors.Is(err, service.ErrPresetNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrPresetNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+	default:
+		abortWithError(c, err)
+	}
+}
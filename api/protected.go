@@ -1,8 +1,10 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 	"yanshu-imgbed/database"
 	"yanshu-imgbed/service"
@@ -15,6 +17,9 @@ type BatchUserImageRequest struct {
 	Action     string   `json:"action" binding:"required"`
 	ImageUUIDs []string `json:"image_uuids" binding:"required"`
 	BackendID  uint     `json:"backend_id"` // For backfill
+	AlbumID    uint     `json:"album_id"`   // For move_to_album, 0 removes the images from their album
+	Tags       []string `json:"tags"`       // For add_tags and remove_tags
+	Visibility string   `json:"visibility"` // For set_visibility
 }
 
 // BatchUserImageHandler handles batch operations initiated by non-admin users.
@@ -29,6 +34,16 @@ func (h *APIHandlers) BatchUserImageHandler(c *gin.Context) {
 	var taskID string
 	var err error
 
+	userRole := c.MustGet("userRole").(string)
+	if req.Action == "delete" && !service.HasPermission(userRole, service.PermBatchDelete) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: missing permission " + service.PermBatchDelete})
+		return
+	}
+	if (req.Action == "add_to_random" || req.Action == "remove_from_random") && !service.HasPermission(userRole, service.PermRandomPool) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: missing permission " + service.PermRandomPool})
+		return
+	}
+
 	switch req.Action {
 	case "delete":
 		taskID, err = service.BatchDeleteImagesForUser(req.ImageUUIDs, userID, h.StorageManager)
@@ -38,30 +53,241 @@ func (h *APIHandlers) BatchUserImageHandler(c *gin.Context) {
 			return
 		}
 		taskID, err = service.BatchBackfillImagesForUser(req.ImageUUIDs, req.BackendID, userID, h.StorageManager)
+	case "move_to_album":
+		err = service.MoveImagesToAlbum(req.ImageUUIDs, req.AlbumID, userID)
+	case "set_visibility":
+		err = service.SetImageVisibility(req.ImageUUIDs, req.Visibility, userID, "user")
+	case "add_tags":
+		err = service.AddImageTags(req.ImageUUIDs, req.Tags, userID, "user")
+	case "remove_tags":
+		err = service.RemoveImageTags(req.ImageUUIDs, req.Tags, userID, "user")
+	case "add_to_random":
+		err = service.BatchSetRandomStatusForUser(req.ImageUUIDs, userID, true)
+	case "remove_from_random":
+		err = service.BatchSetRandomStatusForUser(req.ImageUUIDs, userID, false)
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid action for user"})
 		return
 	}
 
 	if err != nil {
-		// This could be a permission error or other internal error.
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		if errors.Is(err, service.ErrNotImageOwner) || errors.Is(err, service.ErrRandomPoolForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrAlbumNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		respondTagError(c, err)
+		return
+	}
+	if taskID == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "Batch operation completed successfully"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Batch task started for your images", "task_id": taskID})
 }
 
+// SignImageURLsRequest lists the images to sign and how long the URLs stay valid.
+type SignImageURLsRequest struct {
+	UUIDs     []string `json:"uuids" binding:"required"`
+	ExpiresIn int      `json:"expires_in"` // Seconds, defaults to one hour
+}
+
+// SignImageURLsHandler returns signed, time-limited /image URLs for many images in one call.
+func SignImageURLsHandler(c *gin.Context) {
+	var req SignImageURLsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+
+	result, err := service.SignImageURLs(req.UUIDs, time.Duration(req.ExpiresIn)*time.Second, userID, userRole)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// UpdateImageHandler edits the filename, description, alt text and tags of an image.
+func UpdateImageHandler(c *gin.Context) {
+	var req service.ImageEditInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+	image, err := service.UpdateImageInfo(c.Param("uuid"), req, userID, userRole)
+	if err != nil {
+		respondTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, image)
+}
+
+// SetImageVisibilityHandler makes one image public or private; regular users may only change their own images.
+func SetImageVisibilityHandler(c *gin.Context) {
+	var req struct {
+		Visibility string `json:"visibility" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+	if err := service.SetImageVisibility([]string{c.Param("uuid")}, req.Visibility, userID, userRole); err != nil {
+		respondTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Visibility updated"})
+}
+
+// ToggleMyImageRandomStatusHandler toggles the random status for one of the user's own images.
+func ToggleMyImageRandomStatusHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	image, err := service.ToggleImageRandomStatusForUser(c.Param("uuid"), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrRandomPoolForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, image)
+}
+
 // ListImagesHandler lists images, filtered by user role.
 func ListImagesHandler(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 	userRole := c.MustGet("userRole").(string)
 
-	keyword := c.Query("keyword")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	filter := service.ImageFilter{
+		Keyword:     c.Query("keyword"),
+		ContentType: strings.ToLower(strings.TrimSpace(c.Query("content_type"))),
+		Orientation: c.Query("orientation"),
+		Sort:        c.Query("sort"),
+		Order:       c.Query("order"),
+	}
+	filter.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	filter.PageSize, _ = strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	// 带 cursor 参数 (第一页为空值) 时使用游标分页，总数只在 with_total=true 时统计
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		filter.Cursor = &cursor
+		filter.WithTotal, _ = strconv.ParseBool(c.Query("with_total"))
+	}
 
-	response, err := service.ListImages(userID, userRole, keyword, page, pageSize)
+	for param, target := range map[string]*int64{"min_size": &filter.MinSize, "max_size": &filter.MaxSize} {
+		if value := c.Query(param); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			*target = n
+		}
+	}
+	for param, target := range map[string]*int{
+		"min_width": &filter.MinWidth, "max_width": &filter.MaxWidth,
+		"min_height": &filter.MinHeight, "max_height": &filter.MaxHeight,
+	} {
+		if value := c.Query(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			*target = n
+		}
+	}
+	for param, target := range map[string]*uint{"backend_id": &filter.BackendID, "user_id": &filter.UserID} {
+		if value := c.Query(param); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			*target = uint(id)
+		}
+	}
+	// from/to 接受 RFC3339 时间或 2006-01-02 日期，只给日期时 to 包含当天
+	for param, target := range map[string]**time.Time{"from": &filter.CreatedFrom, "to": &filter.CreatedTo} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				day, dayErr := time.ParseInLocation("2006-01-02", value, time.Local)
+				if dayErr != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + ", expected RFC3339 or YYYY-MM-DD"})
+					return
+				}
+				t = day
+				if param == "to" {
+					t = day.Add(24*time.Hour - time.Nanosecond)
+				}
+			}
+			*target = &t
+		}
+	}
+	if value := c.Query("allow_random"); value != "" {
+		allowRandom, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid allow_random flag"})
+			return
+		}
+		filter.AllowRandom = &allowRandom
+	}
+
+	if value, ok := c.GetQuery("folder"); ok {
+		normalized, err := service.NormalizeFolder(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.Folder = &normalized
+	}
+
+	if value := c.Query("album_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid album_id"})
+			return
+		}
+		albumID := uint(id)
+		filter.AlbumID = &albumID
+	}
+
+	// tag 可以重复出现，图片需同时带有所有标签
+	if values := c.QueryArray("tag"); len(values) > 0 {
+		tags, err := service.NormalizeTags(values)
+		if err != nil {
+			respondTagError(c, err)
+			return
+		}
+		filter.Tags = tags
+	}
+
+	response, err := service.ListImages(userID, userRole, filter)
 	if err != nil {
+		var rejected *service.UploadRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list images"})
 		return
 	}
@@ -84,35 +310,71 @@ func GetStatsHandler(c *gin.Context) {
 	var totalSize int64
 
 	queryTotalImages := database.DB.Model(&database.Image{})
-	if userRole != "admin" {
+	if !service.CanAccessAllImages(userRole) {
 		queryTotalImages = queryTotalImages.Where("user_id = ?", userID)
 	}
 	queryTotalImages.Count(&totalImages)
 
 	queryTotalSize := database.DB.Model(&database.Image{})
-	if userRole != "admin" {
+	if !service.CanAccessAllImages(userRole) {
 		queryTotalSize = queryTotalSize.Where("user_id = ?", userID)
 	}
-	queryTotalSize.Select("IFNULL(sum(file_size), 0)").Row().Scan(&totalSize)
+	queryTotalSize.Select("COALESCE(SUM(file_size), 0)").Row().Scan(&totalSize)
 
 	// Total backends is a global stat
 	database.DB.Model(&database.Backend{}).Count(&totalBackends)
 
-	today := time.Now().Format("2006-01-02")
+	// Compare against the local midnight instead of DATE(created_at), which depends on how the dialect stores times.
+	now := time.Now()
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 	queryTodayUploads := database.DB.Model(&database.Image{})
-	if userRole != "admin" {
+	if !service.CanAccessAllImages(userRole) {
 		queryTodayUploads = queryTodayUploads.Where("user_id = ?", userID)
 	}
-	queryTodayUploads.Where("DATE(created_at) = ?", today).Count(&todayUploads)
+	queryTodayUploads.Where("created_at >= ?", startOfDay).Count(&todayUploads)
 
 	c.JSON(http.StatusOK, gin.H{
-		"totalImages":   totalImages,
-		"totalSize":     totalSize,
-		"totalBackends": totalBackends,
-		"todayUploads":  todayUploads,
+		"totalImages":      totalImages,
+		"totalSize":        totalSize,
+		"totalBackends":    totalBackends,
+		"todayUploads":     todayUploads,
+		"todayBytesServed": service.GetTodayBytesServed(userID, userRole),
 	})
 }
 
+// GetMyStatsHandler returns the current user's upload trend, storage per backend,
+// most viewed images and API call counts over the last ?days= days.
+func GetMyStatsHandler(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	stats, err := service.GetUserStats(c.MustGet("userID").(uint), days)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetBandwidthStatsHandler aggregates bytes served and redirects over the last ?days= days,
+// grouped by backend (default), user or day. Regular users only see traffic to their own images.
+func GetBandwidthStatsHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	group := c.DefaultQuery("group", service.BandwidthByBackend)
+
+	stats, err := service.GetBandwidthStats(days, group, userID, userRole)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"days": days, "group": group, "stats": stats})
+}
+
 // ListRecentImagesHandler lists recent images, filtered by user role.
 func ListRecentImagesHandler(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
@@ -120,7 +382,7 @@ func ListRecentImagesHandler(c *gin.Context) {
 
 	var recentImages []database.Image
 	query := database.DB.Order("created_at desc").Limit(8)
-	if userRole != "admin" {
+	if !service.CanAccessAllImages(userRole) {
 		query = query.Where("user_id = ?", userID)
 	}
 	query.Find(&recentImages)
@@ -135,5 +397,55 @@ func GetSettingsHandler(c *gin.Context) {
 	for _, s := range settings {
 		settingsMap[s.Key] = s.Value
 	}
+	// Rate limits are reported with their effective values so unset ones show the defaults.
+	for key, value := range service.GetRateLimitSettings() {
+		if _, ok := settingsMap[key]; !ok {
+			settingsMap[key] = value
+		}
+	}
 	c.JSON(http.StatusOK, settingsMap)
 }
+
+// CompareImagesHandler returns the perceptual-hash similarity of two images and, with diff=true, a visual diff.
+func CompareImagesHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+
+	uuidA, uuidB := c.Query("a"), c.Query("b")
+	if uuidA == "" || uuidB == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameters a and b are required"})
+		return
+	}
+	withDiff, _ := strconv.ParseBool(c.Query("diff"))
+
+	result, err := service.CompareImages(uuidA, uuidB, userID, userRole, withDiff)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrImageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrImageNotDecodable):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetImageMetadataHandler returns the EXIF, ICC and XMP metadata embedded in an image file.
+func GetImageMetadataHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+
+	meta, err := service.GetImageMetadata(c.Param("uuid"), userID, userRole)
+	if err != nil {
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, meta)
+}
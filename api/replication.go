@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListReplicationChangesHandler returns image metadata changed after the (since, since_id) cursor for mirrors.
+func ListReplicationChangesHandler(c *gin.Context) {
+	since := time.Time{}
+	if sinceParam := c.Query("since"); sinceParam != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, sinceParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since parameter, expected RFC3339"})
+			return
+		}
+		since = parsed
+	}
+	sinceID, _ := strconv.ParseUint(c.DefaultQuery("since_id", "0"), 10, 64)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+
+	changes, err := service.ListReplicationChanges(since, uint(sinceID), limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, changes)
+}
+
+// GetReplicationFileHandler streams the stored file of an image to a mirror.
+func GetReplicationFileHandler(c *gin.Context) {
+	rc, image, err := service.OpenReplicationFile(c.Param("uuid"))
+	if err != nil {
+		if errors.Is(err, service.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Failed to open replication file for %s: %v", c.Param("uuid"), err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Image content is temporarily unavailable"})
+		return
+	}
+	defer rc.Close()
+
+	c.Header("Content-Type", image.ContentType)
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, rc)
+}
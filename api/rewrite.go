@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRewriteRulesHandler lists all rewrite rules.
+func ListRewriteRulesHandler(c *gin.Context) {
+	var rules []database.RewriteRule
+	database.DB.Order("priority asc, id asc").Find(&rules)
+	c.JSON(http.StatusOK, rules)
+}
+
+// rewriteRuleRequest is the body for creating or updating a rewrite rule.
+// Priority and IsActive are pointers so that explicit zero values are not replaced by column defaults.
+type rewriteRuleRequest struct {
+	Pattern  string `json:"Pattern"`
+	Target   string `json:"Target"`
+	Priority *int   `json:"Priority"`
+	IsActive *bool  `json:"IsActive"`
+}
+
+// apply copies the request onto rule, keeping the rule's current Priority/IsActive when omitted.
+func (r rewriteRuleRequest) apply(rule *database.RewriteRule) {
+	rule.Pattern = r.Pattern
+	rule.Target = r.Target
+	if r.Priority != nil {
+		rule.Priority = *r.Priority
+	}
+	if r.IsActive != nil {
+		rule.IsActive = *r.IsActive
+	}
+}
+
+// CreateRewriteRuleHandler creates a new rewrite rule.
+func CreateRewriteRuleHandler(c *gin.Context) {
+	var req rewriteRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule := database.RewriteRule{Priority: 1, IsActive: true}
+	req.apply(&rule)
+	if err := service.ValidateRewriteRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 显式写入所有列，否则 GORM 会把 false/0 当作未设置而使用列默认值
+	if err := database.DB.Select("Pattern", "Target", "Priority", "IsActive", "CreatedAt", "UpdatedAt").Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rewrite rule"})
+		return
+	}
+	if !reloadRewriteRules(c) {
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// UpdateRewriteRuleHandler updates an existing rewrite rule.
+func UpdateRewriteRuleHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var existing database.RewriteRule
+	if err := database.DB.First(&existing, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rewrite rule not found"})
+		return
+	}
+
+	var req rewriteRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.apply(&existing)
+	if err := service.ValidateRewriteRule(&existing); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Save(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rewrite rule"})
+		return
+	}
+	if !reloadRewriteRules(c) {
+		return
+	}
+	c.JSON(http.StatusOK, existing)
+}
+
+// DeleteRewriteRuleHandler deletes a rewrite rule.
+func DeleteRewriteRuleHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := database.DB.Delete(&database.RewriteRule{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rewrite rule"})
+		return
+	}
+	if !reloadRewriteRules(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rewrite rule deleted successfully"})
+}
+
+// NoRouteHandler applies legacy rewrite rules before falling back to 404.
+func NoRouteHandler(c *gin.Context) {
+	if target, ok := service.ResolveRewrite(c.Request.URL.Path); ok {
+		c.Redirect(http.StatusMovedPermanently, target)
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+}
+
+// reloadRewriteRules reloads the rules synchronously so the change is live before the response is sent.
+func reloadRewriteRules(c *gin.Context) bool {
+	if err := service.ReloadRewriteRules(); err != nil {
+		abortWithError(c, err)
+		return false
+	}
+	return true
+}
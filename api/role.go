@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRolesHandler lists all roles together with the permissions that can be assigned.
+func ListRolesHandler(c *gin.Context) {
+	roles, err := service.ListRoles()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles, "permissions": service.AllPermissions})
+}
+
+// CreateRoleHandler creates a custom role.
+func CreateRoleHandler(c *gin.Context) {
+	var req service.RoleInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	role, err := service.CreateRole(req)
+	if err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, role)
+}
+
+// UpdateRoleHandler changes a role's description, permissions and allowed backends.
+func UpdateRoleHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req service.RoleInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	role, err := service.UpdateRole(uint(id), req)
+	if err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// DeleteRoleHandler deletes a custom role that no user is assigned to.
+func DeleteRoleHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := service.DeleteRole(uint(id)); err != nil {
+		respondRoleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+}
+
+func respondRoleError(c *gin.Context, err error) {
+	var rejected *service.UploadRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+	case errors.Is(err, service.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrRoleInUse), errors.Is(err, service.ErrBuiltInRole):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		abortWithError(c, err)
+	}
+}
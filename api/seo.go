@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// RobotsTxtHandler serves robots.txt according to the search indexing setting.
+func RobotsTxtHandler(c *gin.Context) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if !service.GetAllowSearchIndexing() {
+		b.WriteString("Disallow: /\n")
+	} else {
+		basePath := config.BasePath()
+		fmt.Fprintf(&b, "Disallow: %s/admin\nDisallow: %s/api/\nDisallow: %s/login\n", basePath, basePath, basePath)
+		fmt.Fprintf(&b, "\nSitemap: %s/sitemap.xml\n", requestBaseURL(c))
+	}
+	c.String(http.StatusOK, b.String())
+}
+
+// SitemapHandler serves an XML sitemap of the public pages; it is 404 when indexing is disabled.
+func SitemapHandler(c *gin.Context) {
+	if !service.GetAllowSearchIndexing() {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	base := requestBaseURL(c)
+	set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, entry := range service.ListSitemapEntries() {
+		u := sitemapURL{Loc: base + entry.Path}
+		if !entry.LastMod.IsZero() {
+			u.LastMod = entry.LastMod.Format("2006-01-02")
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	c.XML(http.StatusOK, set)
+}
+
+// requestBaseURL builds the scheme://host prefix of the current request, including server.base_path.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, config.BasePath())
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListShareLinksHandler lists the share links created by the current user.
+func ListShareLinksHandler(c *gin.Context) {
+	links, err := service.ListShareLinks(c.MustGet("userID").(uint))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, links)
+}
+
+// CreateShareLinkHandler creates a share link for one of the current user's images or albums.
+func CreateShareLinkHandler(c *gin.Context) {
+	var req service.ShareLinkInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	link, err := service.CreateShareLink(c.MustGet("userID").(uint), req)
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, link)
+}
+
+// DeleteShareLinkHandler revokes one of the current user's share links.
+func DeleteShareLinkHandler(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link ID"})
+		return
+	}
+	if err := service.DeleteShareLink(uint(id), c.MustGet("userID").(uint)); err != nil {
+		respondShareError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Share link deleted"})
+}
+
+// ShareHandler serves a shared image, or lists a shared album with signed image URLs.
+// The password is only accepted in the X-Share-Password header so that it never shows up in access logs.
+func ShareHandler(c *gin.Context) {
+	link, err := service.ResolveShareLink(c.Param("token"), c.GetHeader("X-Share-Password"))
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+
+	if link.ImageID != nil {
+		uuid, err := service.SharedImageUUID(link)
+		if err != nil {
+			respondShareError(c, err)
+			return
+		}
+		c.Header("Cache-Control", "private, no-store")
+		serveImage(c, uuid, service.ImageViewer{Signed: true, Country: service.VisitorCountry(c.Request.Header, c.ClientIP())})
+		return
+	}
+
+	album, err := service.SharedAlbum(link)
+	if err != nil {
+		respondShareError(c, err)
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "30"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 30
+	}
+	images, err := service.ListSharedAlbumImages(album, page, pageSize)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"name":        album.Name,
+		"description": album.Description,
+		"cover_uuid":  album.CoverUUID,
+		"images":      images,
+	})
+}
+
+// respondShareError maps share link errors to HTTP responses.
+func respondShareError(c *gin.Context, err error) {
+	var rejected *service.UploadRejectedError
+	var locked *service.SharePasswordLockedError
+	switch {
+	case errors.As(err, &locked):
+		c.Header("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": locked.Error()})
+	case errors.Is(err, service.ErrShareNotFound), errors.Is(err, service.ErrImageNotFound), errors.Is(err, service.ErrAlbumNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrShareExpired):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSharePasswordRequired), errors.Is(err, service.ErrSharePasswordInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+	default:
+		abortWithError(c, err)
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetImageSlugHandler returns the custom slug of an image.
+func GetImageSlugHandler(c *gin.Context) {
+	info, err := service.GetImageSlug(c.Param("uuid"), c.MustGet("userID").(uint), c.MustGet("userRole").(string))
+	if err != nil {
+		respondSlugError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// SetImageSlugHandler assigns a custom slug to an image, replacing its previous one.
+func SetImageSlugHandler(c *gin.Context) {
+	var req struct {
+		Slug string `json:"slug" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	info, err := service.SetImageSlug(c.Param("uuid"), req.Slug, c.MustGet("userID").(uint), c.MustGet("userRole").(string))
+	if err != nil {
+		respondSlugError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// DeleteImageSlugHandler removes the custom slug of an image.
+func DeleteImageSlugHandler(c *gin.Context) {
+	if err := service.RemoveImageSlug(c.Param("uuid"), c.MustGet("userID").(uint), c.MustGet("userRole").(string)); err != nil {
+		respondSlugError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Slug deleted"})
+}
+
+// ServeSlugHandler serves the image behind a custom slug exactly like /i/:uuid.
+func ServeSlugHandler(c *gin.Context) {
+	uuid, err := service.ResolveImageSlug(c.Param("slug"))
+	if err != nil {
+		if errors.Is(err, service.ErrSlugNotFound) {
+			if target, ok := service.ResolveRewrite(c.Request.URL.Path); ok {
+				c.Redirect(http.StatusMovedPermanently, target)
+				return
+			}
+			respondImageUnavailable(c, http.StatusNotFound, err)
+			return
+		}
+		respondSlugError(c, err)
+		return
+	}
+	if err := service.CheckImageSignature(uuid, c.Query("expires"), c.Query("sig")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	serveImage(c, uuid, imageViewer(c))
+}
+
+func respondSlugError(c *gin.Context, err error) {
+	var rejected *service.UploadRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+	case errors.Is(err, service.ErrSlugTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrNotImageOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrSlugNotFound), errors.Is(err, service.ErrImageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		abortWithError(c, err)
+	}
+}
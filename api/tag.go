@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImageTagsRequest lists the tags to add to an image.
+type ImageTagsRequest struct {
+	Tags []string `json:"tags" binding:"required"`
+}
+
+// AddImageTagsHandler adds tags to a single image; regular users may only tag their own images.
+func AddImageTagsHandler(c *gin.Context) {
+	var req ImageTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+	if err := service.AddImageTags([]string{c.Param("uuid")}, req.Tags, userID, userRole); err != nil {
+		respondTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tags added"})
+}
+
+// RemoveImageTagHandler removes one tag from a single image.
+func RemoveImageTagHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+	if err := service.RemoveImageTags([]string{c.Param("uuid")}, []string{c.Param("tag")}, userID, userRole); err != nil {
+		respondTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tag removed"})
+}
+
+// SuggestTagsHandler autocompletes tag names by prefix, most used first.
+func SuggestTagsHandler(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	userRole := c.MustGet("userRole").(string)
+	suggestions, err := service.SuggestTags(c.Query("q"), userID, userRole)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, suggestions)
+}
+
+// respondTagError maps tagging and ownership errors to HTTP responses.
+func respondTagError(c *gin.Context, err error) {
+	var rejected *service.UploadRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+	case errors.Is(err, service.ErrNotImageOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrImageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		abortWithError(c, err)
+	}
+}
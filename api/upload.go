@@ -1,26 +1,60 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/middleware"
 	"yanshu-imgbed/service"
 
 	"github.com/gin-gonic/gin"
 )
 
+// imageCacheMaxAge is how long clients may cache images served by this server (local or proxied).
+const imageCacheMaxAge = 24 * time.Hour
+
 // GetRandomImageRedirectHandler handles requests for a random image.
 func GetRandomImageRedirectHandler(c *gin.Context) {
-	uuid, err := service.GetRandomImageUUID()
+	settings := service.GetRandomAPISettings()
+	if !settings.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Random image API is disabled"})
+		return
+	}
+	if blockHotlink(c, "") {
+		return
+	}
+
+	var uuid string
+	var err error
+	if tag := c.Query("tag"); tag != "" {
+		uuid, err = service.GetRandomImageUUIDByTag(tag)
+	} else {
+		uuid, err = service.GetRandomImageUUID()
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
-	// --- 已修改：跳转到新的URL格式 ---
-	redirectURL := fmt.Sprintf("/image/%s.jpg", uuid)
+	// 跳转到不带扩展名的地址，由 /i/ 按记录的类型输出，免去一次查询
+	redirectURL := service.ImageViewPath(uuid, "")
+	if service.GetRequireSignedURLs() {
+		// 随机图库是公开的，强制签名时为跳转地址签一个短期签名
+		redirectURL = service.SignedImagePath(uuid, "", time.Now().Add(5*time.Minute))
+	}
+	if settings.CacheSeconds > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", settings.CacheSeconds))
+	} else {
+		c.Header("Cache-Control", "no-store")
+	}
 	c.Redirect(http.StatusFound, redirectURL)
 }
 
@@ -40,26 +74,138 @@ func (h *APIHandlers) UploadHandler(c *gin.Context) {
 	}
 
 	userID := c.MustGet("userID").(uint)
+	targetBackendIDs, opts, ok := parseUploadOptions(c)
+	if !ok {
+		return
+	}
+
+	image, err := service.UploadImage(file, userID, targetBackendIDs, opts, h.StorageManager)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	h.respondUploadedImage(c, image)
+}
 
-	var targetBackendIDs []uint
+// parseUploadOptions reads the target backends and processing options shared by all upload endpoints.
+// It writes a 400 response and returns ok=false when a parameter is invalid.
+func parseUploadOptions(c *gin.Context) (targetBackendIDs []uint, opts service.UploadOptions, ok bool) {
 	backendIDsParam := c.PostFormArray("backends")
 	if len(backendIDsParam) > 0 {
 		for _, idStr := range backendIDsParam {
 			id, parseErr := strconv.ParseUint(idStr, 10, 32)
 			if parseErr != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid backend ID: %s", idStr)})
-				return
+				return nil, opts, false
 			}
 			targetBackendIDs = append(targetBackendIDs, uint(id))
 		}
 	}
 
-	image, err := service.UploadImage(file, userID, targetBackendIDs, h.StorageManager)
+	if watermarkParam := c.PostForm("watermark"); watermarkParam != "" {
+		watermark, parseErr := strconv.ParseBool(watermarkParam)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid watermark flag: %s", watermarkParam)})
+			return nil, opts, false
+		}
+		opts.Watermark = &watermark
+	}
+
+	for _, field := range []string{"source_app", "page_url", "note"} {
+		if value := strings.TrimSpace(c.PostForm(field)); value != "" {
+			if len(value) > 1024 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Field %s is too long", field)})
+				return nil, opts, false
+			}
+			if opts.Annotations == nil {
+				opts.Annotations = make(map[string]string)
+			}
+			opts.Annotations[field] = value
+		}
+	}
+
+	folder, err := service.NormalizeFolder(c.PostForm("folder"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, opts, false
+	}
+	opts.Folder = folder
+
+	if opts.FilenameStrategy, err = service.NormalizeFilenameStrategy(c.PostForm("filename_strategy")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, opts, false
+	}
+
+	if opts.ExpiresAt, err = service.ParseExpiresIn(c.PostForm("expires_in")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, opts, false
+	}
+
+	if opts.Visibility, err = service.NormalizeVisibility(c.PostForm("visibility")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, opts, false
+	}
+
+	if albumParam := c.PostForm("album_id"); albumParam != "" {
+		albumID, parseErr := strconv.ParseUint(albumParam, 10, 32)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid album ID: %s", albumParam)})
+			return nil, opts, false
+		}
+		if opts.AlbumID, err = service.ResolveUploadAlbum(uint(albumID), c.MustGet("userID").(uint)); err != nil {
+			if errors.Is(err, service.ErrAlbumNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else {
+				abortWithError(c, err)
+			}
+			return nil, opts, false
+		}
 	}
 
+	// 预设只填充请求中没有显式给出的参数
+	if presetName := strings.TrimSpace(c.PostForm("preset")); presetName != "" {
+		targetBackendIDs, err = service.ApplyUploadPreset(c.MustGet("userID").(uint), presetName, targetBackendIDs, &opts)
+		if err != nil {
+			if errors.Is(err, service.ErrPresetNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else {
+				abortWithError(c, err)
+			}
+			return nil, opts, false
+		}
+	}
+
+	opts.ClientIP = c.ClientIP()
+	opts.UserAgent = c.Request.UserAgent()
+	opts.Source = service.UploadSourceWeb
+
+	// 委托上传 Token 绑定的文件夹和预设优先于客户端参数
+	if token, exists := c.Get("apiToken"); exists {
+		opts.Source = service.UploadSourceAPI
+		targetBackendIDs = service.ApplyAPITokenBinding(token.(*database.APIToken), targetBackendIDs, &opts)
+	}
+	// 角色限制了可用后端时，最终的目标后端必须在允许范围内
+	if targetBackendIDs, err = service.RestrictUploadBackends(c.MustGet("userRole").(string), targetBackendIDs); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return nil, opts, false
+	}
+	return targetBackendIDs, opts, true
+}
+
+// respondUploadedImage writes the standard upload response for a stored image.
+func (h *APIHandlers) respondUploadedImage(c *gin.Context, image *database.Image) {
+	uploadedBytes := image.OriginalSize
+	if uploadedBytes == 0 {
+		uploadedBytes = image.FileSize
+	}
+	c.Set(middleware.UploadedBytesKey, uploadedBytes)
+	middleware.SetQuotaHeaders(c)
+
 	var locationsResponse []gin.H
 	for _, loc := range image.StorageLocations {
 		backendName := loc.StorageType
@@ -76,30 +222,99 @@ func (h *APIHandlers) UploadHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"data": gin.H{
-			"hash":      image.UUID,
-			"filename":  image.OriginalFilename,
-			"size":      image.FileSize,
-			"locations": locationsResponse,
-			// --- 已修改：更新 view_url 格式 ---
-			"view_url": fmt.Sprintf("/image/%s.jpg", image.UUID),
+			"hash":        image.UUID,
+			"filename":    image.OriginalFilename,
+			"annotations": image.Annotations,
+			"size":        image.FileSize,
+			"locations":   locationsResponse,
+			"poster_url":  service.PosterURL(image),
+			"folder":      image.Folder,
+			"expires_at":  image.ExpiresAt,
+			"visibility":  image.Visibility,
+			"view_url":    service.ImageViewPath(image.UUID, image.ContentType),
 		},
 	})
 }
 
-// ServeImageHandler -- 已修改：从新的URL格式中解析UUID
+// ServeImageHandler serves /image/<uuid>.<ext> and the extensionless /i/<uuid>.
+// The extension is ignored; the response always uses the stored Content-Type.
 func ServeImageHandler(c *gin.Context) {
 	filename := c.Param("filename")
 	// 从 "ca154ca5-8409-40bb-aa5e-162c8a3ba6e6.jpg" 中提取 "ca154ca5-8409-40bb-aa5e-162c8a3ba6e6"
 	uuid := strings.TrimSuffix(filename, filepath.Ext(filename))
 
-	location, err := service.GetHealthyStorageLocation(uuid)
+	if err := service.CheckImageSignature(uuid, c.Query("expires"), c.Query("sig")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	serveImage(c, uuid, imageViewer(c))
+}
+
+// imageViewer describes who is requesting an image: the optional JWT user and whether a valid signature was given.
+// It must be called after the signature has been checked.
+func imageViewer(c *gin.Context) service.ImageViewer {
+	viewer := service.ImageViewer{Signed: c.Query("sig") != "", Country: service.VisitorCountry(c.Request.Header, c.ClientIP())}
+	if userID, exists := c.Get("userID"); exists {
+		viewer.UserID = userID.(uint)
+		viewer.Role = c.GetString("userRole")
+	}
+	return viewer
+}
+
+// serveImage picks a storage location for the image and serves or redirects to it.
+func serveImage(c *gin.Context, uuid string, viewer service.ImageViewer) {
+	start := time.Now()
+	location, err := service.GetHealthyStorageLocation(uuid, viewer)
 
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		if errors.Is(err, service.ErrImageQuarantined) || errors.Is(err, service.ErrImagePrivate) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
 		}
+		if errors.Is(err, service.ErrImageExpired) {
+			c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrImageNotFound) {
+			if target, ok := service.ResolveRewrite(c.Request.URL.Path); ok {
+				c.Redirect(http.StatusMovedPermanently, target)
+				return
+			}
+			respondImageUnavailable(c, http.StatusNotFound, err)
+			return
+		}
+		if errors.Is(err, service.ErrNoHealthyLocation) || errors.Is(err, service.ErrLocationsUnreachable) {
+			respondImageUnavailable(c, http.StatusServiceUnavailable, err)
+			return
+		}
+		abortWithError(c, err)
+		return
+	}
+	service.RecordServeDecision(location, time.Since(start))
+	if blockHotlink(c, uuid) {
+		return
+	}
+	// HEAD 请求 (下载工具、CDN 预取) 不算作访问
+	if c.Request.Method != http.MethodHead {
+		service.RecordImageView(location.ImageID)
+	}
+
+	if location.StorageType != "local" && !location.Backend.AllowProxy {
+		service.RecordBandwidth(location, 0, true)
+		c.Redirect(http.StatusFound, location.URL)
+		return
+	}
+	meta, err := service.GetServeMeta(location)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	// 响应写完后按实际输出的字节数记账，304 和出错的响应计为 0 字节
+	defer func() {
+		service.RecordBandwidth(location, int64(c.Writer.Size()), false)
+	}()
+	if notModified(c, meta, viewer) {
+		c.Status(http.StatusNotModified)
 		return
 	}
 
@@ -110,8 +325,228 @@ func ServeImageHandler(c *gin.Context) {
 			return
 		}
 		localPath := "." + parsedURL.Path
+		if strings.HasPrefix(meta.ContentType, "image/svg") || strings.EqualFold(filepath.Ext(localPath), ".svg") {
+			middleware.SetSVGSafeHeaders(c)
+		}
+		// 文件扩展名可能与实际类型不符 (例如按原始文件名保存)，以记录的类型为准
+		if meta.ContentType != "" {
+			c.Header("Content-Type", meta.ContentType)
+		}
+		// c.File 使用 http.ServeFile，支持 HEAD 和 Range 请求
 		c.File(localPath)
 	} else {
-		c.Redirect(http.StatusFound, location.URL)
+		proxyImage(c, location, meta)
+	}
+}
+
+// respondImageUnavailable answers a request for a missing or unreachable image. When the placeholder
+// image is enabled it is served with the given status so broken <img> tags still render something.
+func respondImageUnavailable(c *gin.Context, status int, err error) {
+	data, contentType, ok := service.GetPlaceholderImage()
+	if !ok {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	// 图片可能稍后恢复 (补传、后端恢复)，占位图不应被缓存
+	c.Header("Cache-Control", "no-store")
+	c.Data(status, contentType, data)
+}
+
+// notModified writes the caching headers for an image response and reports whether the
+// client's cached copy is still valid. If-None-Match takes precedence over If-Modified-Since.
+func notModified(c *gin.Context, meta *service.ServeMeta, viewer service.ImageViewer) bool {
+	// 私有图片和签名地址只允许浏览器缓存，公开图片允许 CDN 缓存
+	if viewer.Signed || viewer.UserID != 0 {
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(imageCacheMaxAge.Seconds())))
+	} else {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(imageCacheMaxAge.Seconds())))
+	}
+	lastModified := meta.LastModified.UTC().Truncate(time.Second)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	if meta.ETag != "" {
+		c.Header("ETag", meta.ETag)
+	}
+
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		if meta.ETag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == meta.ETag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := c.GetHeader("If-Modified-Since"); ims != "" {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyImage streams a remote image through the server instead of redirecting, hiding the backend URL.
+// Range requests are answered from the proxy cache when possible and forwarded to the backend otherwise.
+func proxyImage(c *gin.Context, location *database.StorageLocation, meta *service.ServeMeta) {
+	byteRange := c.GetHeader("Range")
+	// If-Range 不匹配时客户端的缓存已过期，应返回完整内容
+	if ifRange := c.GetHeader("If-Range"); ifRange != "" && ifRange != meta.ETag {
+		byteRange = ""
+	}
+	proxied, err := service.OpenProxiedImage(location, meta, service.ProxyRequest{
+		Range:    byteRange,
+		HeadOnly: c.Request.Method == http.MethodHead,
+	})
+	if err != nil {
+		log.Printf("Failed to proxy image from backend %d: %v", location.BackendID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch image from storage backend"})
+		return
+	}
+	defer proxied.Body.Close()
+	if proxied.CacheHit {
+		c.Header("X-Proxy-Cache", "HIT")
+	} else {
+		c.Header("X-Proxy-Cache", "MISS")
+	}
+	if strings.HasPrefix(proxied.ContentType, "image/svg") {
+		middleware.SetSVGSafeHeaders(c)
+	}
+	// 缓存文件可以随机读取，Range 和 HEAD 交给 http.ServeContent 处理
+	if content, ok := proxied.Body.(io.ReadSeeker); ok && proxied.CacheHit {
+		c.Header("Content-Type", proxied.ContentType)
+		http.ServeContent(c.Writer, c.Request, "", meta.LastModified, content)
+		return
+	}
+	c.Header("Accept-Ranges", "bytes")
+	if proxied.ContentRange != "" {
+		c.Header("Content-Range", proxied.ContentRange)
+	}
+	c.DataFromReader(proxied.StatusCode, proxied.ContentLength, proxied.ContentType, proxied.Body, nil)
+}
+
+// ServePosterHandler serves the static first frame of an animated image.
+func ServePosterHandler(c *gin.Context) {
+	filename := c.Param("filename")
+	uuid := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	if err := service.CheckImageSignature(uuid, c.Query("expires"), c.Query("sig")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	path, err := service.GetPosterPath(uuid, imageViewer(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrImageQuarantined), errors.Is(err, service.ErrImagePrivate):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrImageExpired):
+			c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrImageNotFound), errors.Is(err, service.ErrNoPoster):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	c.File(path)
+}
+
+// ServeLocalUploadHandler serves files of the local storage backend under /uploads.
+// Only files registered as a storage location are served, with the same checks as /image.
+func ServeLocalUploadHandler(c *gin.Context) {
+	name := strings.TrimPrefix(path.Clean("/"+c.Param("filepath")), "/")
+	if name == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": service.ErrImageNotFound.Error()})
+		return
+	}
+
+	viewer := imageViewer(c)
+	localPath, err := service.GetLocalUploadPath(name, c.Query("expires"), c.Query("sig"), viewer)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrImageQuarantined), errors.Is(err, service.ErrImagePrivate),
+			errors.Is(err, service.ErrSignatureRequired), errors.Is(err, service.ErrSignatureInvalid), errors.Is(err, service.ErrSignatureExpired):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrImageExpired):
+			c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrImageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			abortWithError(c, err)
+		}
+		return
+	}
+	// 与 /image 相同，带签名或登录后访问的响应不允许 CDN 缓存
+	if viewer.Signed || viewer.UserID != 0 {
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(imageCacheMaxAge.Seconds())))
+	}
+	c.File(localPath)
+}
+
+// UploadFromURLHandler fetches an image from a remote URL server-side and stores it like a regular upload.
+func (h *APIHandlers) UploadFromURLHandler(c *gin.Context) {
+	rawURL := c.PostForm("url")
+	if rawURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+	targetBackendIDs, opts, ok := parseUploadOptions(c)
+	if !ok {
+		return
+	}
+	opts.Source = service.UploadSourceURLFetch
+
+	file, err := service.FetchRemoteImage(rawURL)
+	if err == nil {
+		var image *database.Image
+		if image, err = service.UploadImage(file, userID, targetBackendIDs, opts, h.StorageManager); err == nil {
+			h.respondUploadedImage(c, image)
+			return
+		}
+	}
+	var rejected *service.UploadRejectedError
+	if errors.As(err, &rejected) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+		return
+	}
+	abortWithError(c, err)
+}
+
+// InstantUploadHandler registers an image by content hash without transferring the file.
+// It answers 404 when no stored file matches, in which case the client should fall back to a normal upload.
+func (h *APIHandlers) InstantUploadHandler(c *gin.Context) {
+	size, err := strconv.ParseInt(c.PostForm("size"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid size"})
+		return
+	}
+	userID := c.MustGet("userID").(uint)
+	_, opts, ok := parseUploadOptions(c)
+	if !ok {
+		return
+	}
+
+	image, err := service.InstantUpload(service.InstantUploadRequest{
+		SHA256:   c.PostForm("sha256"),
+		Size:     size,
+		Filename: c.PostForm("filename"),
+	}, userID, opts)
+	if err != nil {
+		var rejected *service.UploadRejectedError
+		switch {
+		case errors.Is(err, service.ErrInstantUploadMiss):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "exists": false})
+		case errors.Is(err, service.ErrInstantUploadDisabled):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.As(err, &rejected):
+			c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+		default:
+			abortWithError(c, err)
+		}
+		return
 	}
+	h.respondUploadedImage(c, image)
 }
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListWebhooksHandler lists all webhooks together with the events that can be subscribed to.
+func ListWebhooksHandler(c *gin.Context) {
+	hooks, err := service.ListWebhooks()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "events": service.AllWebhookEvents})
+}
+
+// CreateWebhookHandler creates a webhook. The response is the only place the signing secret is shown.
+func CreateWebhookHandler(c *gin.Context) {
+	var req service.WebhookInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hook, err := service.CreateWebhook(req)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, hook)
+}
+
+// UpdateWebhookHandler changes a webhook; the new secret is included when it was replaced.
+func UpdateWebhookHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req service.WebhookInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hook, secret, err := service.UpdateWebhook(uint(id), req)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	if secret != "" {
+		c.JSON(http.StatusOK, service.WebhookWithSecret{Webhook: *hook, Secret: secret})
+		return
+	}
+	c.JSON(http.StatusOK, hook)
+}
+
+// DeleteWebhookHandler deletes a webhook and its delivery log.
+func DeleteWebhookHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := service.DeleteWebhook(uint(id)); err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// PingWebhookHandler sends a ping event to the webhook right away and returns the delivery result.
+func PingWebhookHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	delivery, err := service.PingWebhook(uint(id))
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
+
+// ListWebhookDeliveriesHandler lists a webhook's deliveries, newest first, optionally filtered by status.
+func ListWebhookDeliveriesHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	result, err := service.ListWebhookDeliveries(uint(id), c.Query("status"), page, pageSize)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// RedeliverWebhookHandler queues a delivery to be sent again with a fresh retry budget.
+func RedeliverWebhookHandler(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := service.RedeliverWebhookDelivery(uint(id)); err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued"})
+}
+
+func respondWebhookError(c *gin.Context, err error) {
+	var rejected *service.UploadRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": rejected.Reason})
+	case errors.Is(err, service.ErrWebhookNotFound), errors.Is(err, service.ErrWebhookDeliveryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		abortWithError(c, err)
+	}
+}
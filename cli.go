@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+	"yanshu-imgbed/service"
+)
+
+// command 一个命令行子命令，不带子命令运行时默认执行 serve
+type command struct {
+	name  string
+	args  string // 用法说明中的参数部分
+	short string
+	run   func(args []string) error
+}
+
+// errUsage 参数错误，打印用法后以状态码 2 退出
+var errUsage = errors.New("invalid arguments")
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "", "启动图床服务 (默认)", runServe},
+		{"migrate", "", "创建或升级数据库表结构后退出", runMigrate},
+		{"create-admin", "[-username admin] [-password 密码]", "创建管理员账户，未指定密码时随机生成", runCreateAdmin},
+		{"reset-password", "<用户名> [-password 密码] [-activate]", "重置用户密码，-activate 同时解除停用", runResetPassword},
+		{"import", "<目录> [-user 用户名] [-in-place] [-folder 文件夹] [-backends 1,2]", "导入服务器上的目录，等待完成后退出", runImport},
+		{"restore", "<备份文件或地址>", "在服务停止时用备份覆盖数据库", runRestore},
+		{"help", "", "显示本帮助", runHelp},
+	}
+}
+
+// runCommand 执行 args[0] 对应的子命令并返回进程退出码
+func runCommand(args []string) int {
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(args)
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, errUsage):
+			fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n", os.Args[0], cmd.name, cmd.args)
+			return 2
+		default:
+			log.Printf("%s failed: %v", cmd.name, err)
+			return 1
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	printUsage()
+	return 2
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [arguments]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", cmd.name, cmd.short)
+		if cmd.args != "" {
+			fmt.Fprintf(os.Stderr, "  %-15s   %s %s\n", "", cmd.name, cmd.args)
+		}
+	}
+}
+
+func runHelp(args []string) error {
+	printUsage()
+	return nil
+}
+
+// parseFlags 解析参数，允许选项出现在位置参数之后 (例如 "reset-password alice -activate")，返回位置参数
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(os.Stderr)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// openDatabase 连接数据库并执行迁移
+func openDatabase() error {
+	if err := database.Init(config.Cfg.Database); err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return nil
+}
+
+func runMigrate(args []string) error {
+	if _, err := parseFlags(flag.NewFlagSet("migrate", flag.ContinueOnError), args); err != nil {
+		return err
+	}
+	if err := openDatabase(); err != nil {
+		return err
+	}
+	log.Println("Database schema is up to date.")
+	return nil
+}
+
+func runCreateAdmin(args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := fs.String("username", "admin", "用户名")
+	password := fs.String("password", os.Getenv("IMGBED_ADMIN_PASSWORD"), "密码，默认读取 IMGBED_ADMIN_PASSWORD，都为空时随机生成")
+	if positional, err := parseFlags(fs, args); err != nil || len(positional) > 0 {
+		return errUsage
+	}
+	if err := openDatabase(); err != nil {
+		return err
+	}
+	generated, err := passwordOrRandom(password)
+	if err != nil {
+		return err
+	}
+	if _, err := service.CreateAdminUser(*username, *password); err != nil {
+		if errors.Is(err, service.ErrUsernameTaken) {
+			return fmt.Errorf("user %q already exists, use reset-password instead", *username)
+		}
+		return err
+	}
+	log.Printf("Admin user %q created.", *username)
+	if generated {
+		log.Printf("Generated password: %s", *password)
+	}
+	return nil
+}
+
+func runResetPassword(args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	password := fs.String("password", "", "新密码，为空时随机生成")
+	activate := fs.Bool("activate", false, "同时解除用户的停用状态")
+	positional, err := parseFlags(fs, args)
+	if err != nil || len(positional) != 1 {
+		return errUsage
+	}
+	if err := openDatabase(); err != nil {
+		return err
+	}
+	generated, err := passwordOrRandom(password)
+	if err != nil {
+		return err
+	}
+	user, err := service.ResetPasswordByUsername(positional[0], *password, *activate)
+	if err != nil {
+		return err
+	}
+	log.Printf("Password of user %q reset.", user.Username)
+	if generated {
+		log.Printf("Generated password: %s", *password)
+	}
+	if !user.IsActive && !*activate {
+		log.Printf("User %q is suspended, run again with -activate to allow logging in.", user.Username)
+	}
+	return nil
+}
+
+// passwordOrRandom 密码为空时随机生成一个，返回是否为生成的密码
+func passwordOrRandom(password *string) (bool, error) {
+	if *password != "" {
+		return false, nil
+	}
+	generated, err := service.GenerateRandomPassword()
+	if err != nil {
+		return false, err
+	}
+	*password = generated
+	return true, nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	username := fs.String("user", "", "图片归属的用户，默认为第一个管理员")
+	inPlace := fs.Bool("in-place", false, "不复制文件，直接登记到所在的本地后端")
+	folder := fs.String("folder", "", "导入到的文件夹")
+	backends := fs.String("backends", "", "复制模式下上传到的后端 ID，逗号分隔，默认为所有允许上传的后端")
+	positional, err := parseFlags(fs, args)
+	if err != nil || len(positional) != 1 {
+		return errUsage
+	}
+	req := service.LocalImportRequest{Path: positional[0], InPlace: *inPlace, Folder: *folder}
+	for _, id := range strings.Split(*backends, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		backendID, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return errUsage
+		}
+		req.BackendIDs = append(req.BackendIDs, uint(backendID))
+	}
+
+	if err := openDatabase(); err != nil {
+		return err
+	}
+	service.InitSettings()
+	service.InitRoles()
+	service.InitSuspendedUsers()
+	storageManager, err := manager.NewStorageManager()
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage manager: %w", err)
+	}
+	if req.UserID, err = service.FindUserID(*username); err != nil {
+		return fmt.Errorf("failed to find user %q: %w", *username, err)
+	}
+
+	taskID, err := service.ImportLocalDirectory(req, storageManager)
+	if err != nil {
+		return err
+	}
+	for {
+		time.Sleep(time.Second)
+		task, ok := service.GetTask(taskID)
+		if !ok {
+			return errors.New("import task disappeared")
+		}
+		if task.Status != "running" {
+			log.Printf("Import %s: %s", task.Status, task.Message)
+			if task.Status != "completed" {
+				return errors.New(task.Message)
+			}
+			return nil
+		}
+		log.Printf("Importing... %d/%d", task.Progress, task.Total)
+	}
+}
+
+func runRestore(args []string) error {
+	positional, err := parseFlags(flag.NewFlagSet("restore", flag.ContinueOnError), args)
+	if err != nil || len(positional) != 1 {
+		return errUsage
+	}
+	if err := service.RestoreDatabaseBackup(positional[0], config.Cfg.Database); err != nil {
+		return fmt.Errorf("failed to restore database backup: %w", err)
+	}
+	log.Println("Database backup restored, start the server normally to use it.")
+	return nil
+}
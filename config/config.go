@@ -2,26 +2,65 @@ package config
 
 import (
 	"log"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
 // AppConfig 保存了应用的所有配置
 type AppConfig struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
+	Server       ServerConfig
+	Database     DatabaseConfig
+	JWT          JWTConfig
+	Imaging      ImagingConfig
+	Replication  ReplicationConfig
+	Tasks        TasksConfig
+	Distribution DistributionConfig
+	Frontend     FrontendConfig
+	GeoIP        GeoIPConfig
+	HealthCheck  HealthCheckConfig `mapstructure:"health_check"`
+	Captcha      CaptchaConfig
+	Backup       BackupConfig
 }
 
 // ServerConfig 服务器相关配置
 type ServerConfig struct {
 	Port string
 	Mode string
+	// Listen 监听地址列表，支持 "127.0.0.1:3030"、"[::1]:3030" 和 "unix:/run/imgbed.sock"
+	// 为空时监听所有地址上的 Port
+	Listen []string
+	// SocketMode Unix socket 文件的权限 (八进制字符串)，例如 "0660"
+	SocketMode string `mapstructure:"socket_mode"`
+	// StrictStartup 为 true 时，release 模式下启动自检有严重问题则拒绝启动
+	StrictStartup bool `mapstructure:"strict_startup"`
+	// AdminAllowedCIDRs 允许访问 /admin 和 /api/admin 的网段 (如 VPN 地址段)，为空时不限制
+	AdminAllowedCIDRs []string `mapstructure:"admin_allowed_cidrs"`
+	// TrustedProxies 可信的反向代理地址 (IP 或 CIDR)，只有来自这些地址的请求才会采用 RemoteIPHeaders 中的客户端地址。
+	// 也可以用环境变量 IMGBED_TRUSTED_PROXIES (逗号分隔) 设置
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// RemoteIPHeaders 按顺序读取客户端真实地址的请求头，例如 Cloudflare 的 "CF-Connecting-IP"。
+	// 也可以用环境变量 IMGBED_REMOTE_IP_HEADERS (逗号分隔) 设置
+	RemoteIPHeaders []string `mapstructure:"remote_ip_headers"`
+	// BasePath 部署在反向代理子路径下时的路径前缀，例如 "/img"，为空时部署在根路径
+	BasePath string `mapstructure:"base_path"`
+	// ShutdownTimeoutSeconds 收到 SIGTERM/SIGINT 后等待进行中的请求和后台任务完成的最长时间
+	ShutdownTimeoutSeconds int `mapstructure:"shutdown_timeout_seconds"`
 }
 
 // DatabaseConfig 数据库相关配置
 type DatabaseConfig struct {
-	DSN string // Data Source Name
+	// Driver 数据库类型，"sqlite" (默认) 或 "mysql"
+	Driver string
+	// DSN SQLite 为数据库文件路径，MySQL 为 "user:pass@tcp(host:3306)/dbname?charset=utf8mb4"
+	// SQLite 默认开启 WAL、5 秒 busy timeout 和外键约束，可在 DSN 中用 "?_journal_mode=DELETE" 等参数覆盖
+	DSN string
+	// MaxOpenConns 连接池的最大连接数，<= 0 时 SQLite 为 4，MySQL 不限制
+	MaxOpenConns int `mapstructure:"max_open_conns"`
+	// MaxIdleConns 最大空闲连接数，<= 0 时与 MaxOpenConns 相同 (MySQL 使用驱动默认值)
+	MaxIdleConns int `mapstructure:"max_idle_conns"`
+	// ConnMaxLifetimeMinutes 连接的最长复用时间，<= 0 表示不限制
+	ConnMaxLifetimeMinutes int `mapstructure:"conn_max_lifetime_minutes"`
 }
 
 // JWTConfig JWT 相关配置
@@ -30,6 +69,104 @@ type JWTConfig struct {
 	ExpirationHours int `mapstructure:"expiration_hours"`
 }
 
+// ImagingConfig 图片处理相关配置
+type ImagingConfig struct {
+	// HeicConverter 将 HEIC/HEIF 转换为 JPEG 的外部命令，{input} 和 {output} 会被替换为临时文件路径
+	HeicConverter string `mapstructure:"heic_converter"`
+	// HeicTimeoutSeconds 转换命令的最长执行时间，超时后终止进程并拒绝上传
+	HeicTimeoutSeconds int `mapstructure:"heic_timeout_seconds"`
+	// PosterCacheDir 动图首帧预览图的缓存目录
+	PosterCacheDir string `mapstructure:"poster_cache_dir"`
+	// ProxyCacheDir 代理模式下远程图片的本地缓存目录
+	ProxyCacheDir string `mapstructure:"proxy_cache_dir"`
+	// ProxyCacheMaxMB 代理缓存的总大小上限，超出后淘汰最久未访问的图片，<= 0 表示不缓存
+	ProxyCacheMaxMB int `mapstructure:"proxy_cache_max_mb"`
+	// PlaceholderPath 管理员上传的占位图的保存位置，图片缺失或不可用时输出
+	PlaceholderPath string `mapstructure:"placeholder_path"`
+}
+
+// ReplicationConfig 热备同步相关配置
+type ReplicationConfig struct {
+	// Mode 为 "primary" 时对外提供同步接口，为 "mirror" 时定期从主实例拉取，留空则不启用
+	Mode            string
+	Token           string // 主备之间共享的同步密钥
+	PrimaryURL      string `mapstructure:"primary_url"`      // 镜像模式下主实例的地址
+	IntervalSeconds int    `mapstructure:"interval_seconds"` // 镜像模式下的拉取间隔
+}
+
+// TasksConfig 后台任务相关配置
+type TasksConfig struct {
+	// RetentionHours 已结束的任务在列表中保留的时间，<= 0 表示永久保留
+	RetentionHours int `mapstructure:"retention_hours"`
+}
+
+// DistributionConfig 上传文件分发到各个后端的并发与重试配置
+type DistributionConfig struct {
+	// Workers 同时进行的后端上传数上限，所有上传共用
+	Workers int
+	// Retries 单个后端上传失败后的重试次数
+	Retries int
+	// BackoffMs 第一次重试前的等待时间，之后每次翻倍
+	BackoffMs int `mapstructure:"backoff_ms"`
+	// TimeoutSeconds 一次分发的总超时时间，<= 0 表示不限制
+	TimeoutSeconds int `mapstructure:"timeout_seconds"`
+}
+
+// FrontendConfig 网页前端相关配置
+type FrontendConfig struct {
+	// Mode 为 "embedded" 时使用内置页面，"none" 时只提供 API，"external" 时托管 Dir 中的自定义前端
+	Mode string
+	// Dir external 模式下前端文件所在目录，未匹配到文件的页面请求返回其中的 index.html
+	Dir string
+}
+
+// GeoIPConfig 按访客所在国家/地区选择后端时使用的 IP 归属地配置
+type GeoIPConfig struct {
+	// CountryHeader 反向代理或 CDN 写入的国家代码请求头 (如 Cloudflare 的 CF-IPCountry)，优先于 CIDRFile
+	CountryHeader string `mapstructure:"country_header"`
+	// CIDRFile IP 段归属地文件，每行 "1.0.1.0/24,CN"，留空则只使用请求头
+	CIDRFile string `mapstructure:"cidr_file"`
+}
+
+// HealthCheckConfig 后台健康检查与熔断相关配置
+type HealthCheckConfig struct {
+	// IntervalSeconds 两轮检查之间的间隔，<= 0 表示不启用后台检查
+	IntervalSeconds int `mapstructure:"interval_seconds"`
+	// BatchSize 每轮最多检查的存储位置数
+	BatchSize int `mapstructure:"batch_size"`
+	// RecheckMinutes 同一个存储位置两次检查的最短间隔
+	RecheckMinutes int `mapstructure:"recheck_minutes"`
+	// BreakerThreshold 后端连续探测失败多少次后熔断
+	BreakerThreshold int `mapstructure:"breaker_threshold"`
+	// BreakerCooldownSeconds 熔断持续时间，期间访问优先使用其他后端，结束后由下一轮探测决定是否恢复
+	BreakerCooldownSeconds int `mapstructure:"breaker_cooldown_seconds"`
+}
+
+// CaptchaConfig 登录验证码的服务端密钥，服务商和站点密钥在后台设置中配置
+type CaptchaConfig struct {
+	// SecretKey hCaptcha 或 Turnstile 的 secret key，为空时不启用验证码
+	SecretKey string `mapstructure:"secret_key"`
+}
+
+// BackupConfig 数据库定时备份相关配置
+type BackupConfig struct {
+	// Backend 备份文件上传到的后端名称，为空时不启用定时备份
+	Backend string
+	// IntervalHours 两次备份之间的间隔，<= 0 表示只能在后台手动备份
+	IntervalHours int `mapstructure:"interval_hours"`
+	// Retention 后端上保留的备份数量，超出后删除最旧的备份，<= 0 表示全部保留
+	Retention int
+	// MysqldumpCommand MySQL 导出命令，连接参数会自动追加
+	MysqldumpCommand string `mapstructure:"mysqldump_command"`
+	// MysqlCommand 恢复 MySQL 备份时使用的客户端命令
+	MysqlCommand string `mapstructure:"mysql_command"`
+	// EncryptionKey 加密备份文件的密钥，为空时拒绝备份；恢复加密的备份时也需要它
+	EncryptionKey string `mapstructure:"encryption_key"`
+}
+
+// DefaultJWTSecret 未配置 jwt.secret 时使用的默认密钥，生产环境必须修改
+const DefaultJWTSecret = "your-super-secret-key-that-should-be-changed"
+
 // Cfg 是全局可访问的配置实例
 var Cfg *AppConfig
 
@@ -38,15 +175,62 @@ func Init() error {
 	// --- 新增：设置默认配置 ---
 	viper.SetDefault("server.port", "3030")
 	viper.SetDefault("server.mode", "release")
+	viper.SetDefault("server.listen", []string{})
+	viper.SetDefault("server.socket_mode", "0660")
+	viper.SetDefault("server.strict_startup", false)
+	viper.SetDefault("server.admin_allowed_cidrs", []string{})
+	viper.SetDefault("server.trusted_proxies", []string{"127.0.0.1", "::1"})
+	viper.SetDefault("server.remote_ip_headers", []string{"X-Forwarded-For", "X-Real-IP"})
+	viper.SetDefault("server.base_path", "")
+	viper.SetDefault("server.shutdown_timeout_seconds", 30)
+	viper.SetDefault("database.driver", "sqlite")
 	viper.SetDefault("database.dsn", "data/image_bed.db")
-	viper.SetDefault("jwt.secret", "your-super-secret-key-that-should-be-changed")
+	viper.SetDefault("database.max_open_conns", 0)
+	viper.SetDefault("database.max_idle_conns", 0)
+	viper.SetDefault("database.conn_max_lifetime_minutes", 0)
+	viper.SetDefault("jwt.secret", DefaultJWTSecret)
 	viper.SetDefault("jwt.expiration_hours", 24)
+	viper.SetDefault("imaging.heic_converter", "heif-convert -q 90 {input} {output}")
+	viper.SetDefault("imaging.heic_timeout_seconds", 30)
+	viper.SetDefault("imaging.poster_cache_dir", "data/posters")
+	viper.SetDefault("imaging.proxy_cache_dir", "data/proxy_cache")
+	viper.SetDefault("imaging.proxy_cache_max_mb", 512)
+	viper.SetDefault("imaging.placeholder_path", "data/placeholder")
+	viper.SetDefault("replication.mode", "")
+	viper.SetDefault("replication.interval_seconds", 60)
+	viper.SetDefault("tasks.retention_hours", 24)
+	viper.SetDefault("distribution.workers", 8)
+	viper.SetDefault("distribution.retries", 2)
+	viper.SetDefault("distribution.backoff_ms", 500)
+	viper.SetDefault("distribution.timeout_seconds", 300)
+	viper.SetDefault("frontend.mode", "embedded")
+	viper.SetDefault("frontend.dir", "")
+	viper.SetDefault("geoip.country_header", "CF-IPCountry")
+	viper.SetDefault("geoip.cidr_file", "")
+	viper.SetDefault("health_check.interval_seconds", 60)
+	viper.SetDefault("health_check.batch_size", 200)
+	viper.SetDefault("health_check.recheck_minutes", 60)
+	viper.SetDefault("health_check.breaker_threshold", 3)
+	viper.SetDefault("health_check.breaker_cooldown_seconds", 300)
+	viper.SetDefault("captcha.secret_key", "")
+	viper.SetDefault("backup.backend", "")
+	viper.SetDefault("backup.interval_hours", 24)
+	viper.SetDefault("backup.retention", 7)
+	viper.SetDefault("backup.mysqldump_command", "mysqldump --single-transaction --quick")
+	viper.SetDefault("backup.mysql_command", "mysql")
+	viper.SetDefault("backup.encryption_key", "")
 	// --- 默认配置结束 ---
 
 	viper.SetConfigName("config") // 配置文件名 (不带后缀)
 	viper.SetConfigType("yml")    // 配置文件类型
 	viper.AddConfigPath(".")      // 配置文件路径 (当前目录)
 
+	// 容器部署时常用环境变量覆盖代理设置
+	viper.BindEnv("server.trusted_proxies", "IMGBED_TRUSTED_PROXIES")
+	viper.BindEnv("server.remote_ip_headers", "IMGBED_REMOTE_IP_HEADERS")
+	// 备份密钥可以不写入配置文件
+	viper.BindEnv("backup.encryption_key", "IMGBED_BACKUP_ENCRYPTION_KEY")
+
 	// --- 修改：优雅地处理文件不存在的错误 ---
 	if err := viper.ReadInConfig(); err != nil {
 		// 如果错误是“配置文件未找到”，则忽略错误，因为我们将使用默认值
@@ -62,7 +246,30 @@ func Init() error {
 	if err := viper.Unmarshal(Cfg); err != nil {
 		return err
 	}
+	Cfg.Server.BasePath = normalizeBasePath(Cfg.Server.BasePath)
 
 	log.Println("Configuration loaded successfully")
 	return nil
 }
+
+// normalizeBasePath 统一为 "/img" 的形式：以 "/" 开头、不以 "/" 结尾，根路径为空字符串
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// BasePath 返回 server.base_path，未初始化配置时为空
+func BasePath() string {
+	if Cfg == nil {
+		return ""
+	}
+	return Cfg.Server.BasePath
+}
+
+// WithBasePath 在站内路径 (以 "/" 开头) 前加上 server.base_path，用于生成返回给客户端的地址
+func WithBasePath(path string) string {
+	return BasePath() + path
+}
@@ -1,43 +1,178 @@
 package database
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+	"yanshu-imgbed/config"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/datatypes"
+	"gorm.io/driver/mysql"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+// 支持的数据库驱动
+const (
+	DriverSQLite = "sqlite"
+	DriverMySQL  = "mysql"
+)
+
+// sqliteDefaultOptions SQLite 连接的默认参数，DSN 中已经指定的参数 (包括别名) 不会被覆盖
+var sqliteDefaultOptions = []struct {
+	names []string
+	value string
+}{
+	// WAL 模式下读写互不阻塞，后台任务写入时图片访问仍可读取
+	{[]string{"_journal_mode", "_journal"}, "WAL"},
+	// 遇到锁时最多等待 5 秒，而不是立即返回 "database is locked"
+	{[]string{"_busy_timeout", "_timeout"}, "5000"},
+	{[]string{"_foreign_keys", "_fk"}, "1"},
+	// WAL 模式下 NORMAL 不会损坏数据库，只可能在断电时丢失最后几次提交
+	{[]string{"_synchronous", "_sync"}, "NORMAL"},
+	// 事务一开始就获取写锁，避免读事务升级为写事务时因快照过期直接失败 (此时 busy_timeout 不生效)
+	{[]string{"_txlock"}, "immediate"},
+}
+
+// sqliteDefaultMaxOpenConns 未配置 database.max_open_conns 时 SQLite 的连接数上限
+// SQLite 同一时刻只有一个写入者，连接过多只会增加锁等待
+const sqliteDefaultMaxOpenConns = 4
+
 var DB *gorm.DB
 
-func Init(dsn string) error {
-	dbDir := filepath.Dir(dsn)
-	if dbDir != "" {
-		// os.MkdirAll会创建路径中的所有目录，如果目录已存在则什么也不做
-		if err := os.MkdirAll(dbDir, 0755); err != nil {
-			log.Printf("Failed to create data directory '%s': %v", dbDir, err)
-			return err
-		}
+func Init(cfg config.DatabaseConfig) error {
+	dialector, err := openDialector(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return err
 	}
-	var err error
-	DB, err = gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	DB, err = gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return err
 	}
+	if err := configurePool(cfg); err != nil {
+		return err
+	}
 
-	err = DB.AutoMigrate(&Image{}, &StorageLocation{}, &Backend{}, &Setting{}, &User{}, &APIToken{})
+	if err := checkSchemaVersion(); err != nil {
+		return err
+	}
+
+	if err := migrateImageMD5Index(); err != nil {
+		return err
+	}
+
+	if err := DB.SetupJoinTable(&Image{}, "Tags", &ImageTag{}); err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&Image{}, &StorageLocation{}, &Backend{}, &Setting{}, &User{}, &APIToken{}, &PendingDeletion{}, &RewriteRule{}, &StorageOperation{}, &UploadSession{}, &ImageMetadataCache{}, &APITokenUsage{}, &ExpiredImage{}, &UploadPreset{}, &PendingDistribution{}, &Album{}, &Tag{}, &ImageTag{}, &ShareLink{}, &ImageDailyView{}, &BandwidthUsage{}, &ImageSlug{}, &AdminNotification{}, &FailedDeletion{}, &InviteCode{}, &DailyUploadUsage{}, &Role{}, &DatabaseBackup{}, &DailyStat{}, &DailyBackendStat{}, &Webhook{}, &WebhookDelivery{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
+	if err := migrateIndexes(); err != nil {
+		log.Fatalf("Failed to migrate database indexes: %v", err)
+	}
+	if err := saveSchemaVersion(); err != nil {
+		log.Fatalf("Failed to record database schema version: %v", err)
+	}
+
 	initDefaultData()
 
 	return nil
 }
 
+// openDialector 按 database.driver 创建数据库连接
+func openDialector(driver, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case "", DriverSQLite:
+		dbDir := filepath.Dir(SQLiteFilePath(dsn))
+		if dbDir != "" {
+			// os.MkdirAll会创建路径中的所有目录，如果目录已存在则什么也不做
+			if err := os.MkdirAll(dbDir, 0755); err != nil {
+				log.Printf("Failed to create data directory '%s': %v", dbDir, err)
+				return nil, err
+			}
+		}
+		dsn, err := sqliteDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return sqlite.Open(dsn), nil
+	case DriverMySQL:
+		cfg, err := mysqldriver.ParseDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MySQL DSN: %w", err)
+		}
+		// 时间字段需要解析为 time.Time；未指定 loc 时按本地时区读写，与 SQLite 下按天统计的日期一致
+		cfg.ParseTime = true
+		if !strings.Contains(dsn, "loc=") {
+			cfg.Loc = time.Local
+		}
+		return mysql.New(mysql.Config{DSNConfig: cfg}), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q (expected sqlite or mysql)", driver)
+	}
+}
+
+// SQLiteFilePath 返回 SQLite DSN 中的数据库文件路径，去掉 "file:" 前缀和连接参数
+func SQLiteFilePath(dsn string) string {
+	path, _, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	return path
+}
+
+// sqliteDSN 为 DSN 补上 sqliteDefaultOptions 中未指定的参数
+func sqliteDSN(dsn string) (string, error) {
+	path, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid SQLite DSN options: %w", err)
+	}
+	for _, opt := range sqliteDefaultOptions {
+		set := false
+		for _, name := range opt.names {
+			if params.Has(name) {
+				set = true
+				break
+			}
+		}
+		if !set {
+			params.Set(opt.names[0], opt.value)
+		}
+	}
+	return path + "?" + params.Encode(), nil
+}
+
+// configurePool 按配置限制连接池，未配置时 SQLite 使用 sqliteDefaultMaxOpenConns，MySQL 使用驱动默认值
+func configurePool(cfg config.DatabaseConfig) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	maxOpen := cfg.MaxOpenConns
+	if maxOpen <= 0 && DB.Dialector.Name() == DriverSQLite {
+		maxOpen = sqliteDefaultMaxOpenConns
+	}
+	if maxOpen > 0 {
+		sqlDB.SetMaxOpenConns(maxOpen)
+		sqlDB.SetMaxIdleConns(maxOpen)
+	}
+	if cfg.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetimeMinutes > 0 {
+		sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetimeMinutes) * time.Minute)
+	}
+	return nil
+}
+
 func initDefaultData() {
 	// 检查是否已有本地后端
 	var count int64
@@ -71,11 +206,11 @@ func initDefaultData() {
 	var userCount int64
 	DB.Model(&User{}).Count(&userCount)
 	if userCount == 0 {
-		log.Println("Initializing default admin user...")
-		// 默认密码，生产环境应该要求用户首次登录修改或随机生成
-		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("admin123"), bcrypt.DefaultCost)
+		username, password := bootstrapAdminCredentials()
+		log.Printf("Initializing default admin user %q...", username)
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		adminUser := User{
-			Username: "admin",
+			Username: username,
 			Password: string(hashedPassword),
 			Role:     "admin",
 		}
@@ -83,3 +218,25 @@ func initDefaultData() {
 	}
 
 }
+
+// bootstrapAdminCredentials 返回首次启动时创建的管理员账户
+// 优先使用 IMGBED_ADMIN_USER / IMGBED_ADMIN_PASSWORD 环境变量，未设置密码时随机生成并打印到日志
+func bootstrapAdminCredentials() (string, string) {
+	username := strings.TrimSpace(os.Getenv("IMGBED_ADMIN_USER"))
+	if username == "" {
+		username = "admin"
+	}
+	password := os.Getenv("IMGBED_ADMIN_PASSWORD")
+	if password != "" {
+		return username, password
+	}
+
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Failed to generate admin password: %v", err)
+	}
+	password = base64.RawURLEncoding.EncodeToString(buf)
+	log.Printf("IMGBED_ADMIN_PASSWORD is not set; generated password for admin user %q: %s", username, password)
+	log.Println("Please log in and change this password; it will not be shown again.")
+	return username, password
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SchemaVersion 当前代码对应的数据库结构版本，迁移逻辑发生不兼容变化时递增
+const SchemaVersion = 1
+
+// schemaVersionKey 数据库结构版本在 settings 表中的键
+const schemaVersionKey = "schema_version"
+
+// indexDefinition 描述一个需要在迁移时确保存在的索引
+type indexDefinition struct {
+	Table   string
+	Name    string
+	Columns []string
+}
+
+// hotQueryIndexes 列出热点查询依赖的复合索引
+// 列表、去重检查和补传查询在数据量增长后会退化为全表扫描，这些索引用于避免这种情况
+var hotQueryIndexes = []indexDefinition{
+	// 图片列表：WHERE user_id = ? ORDER BY created_at DESC
+	{Table: "images", Name: "idx_images_user_created", Columns: []string{"user_id", "created_at"}},
+	// 补传/去重：按图片和后端查找存储位置
+	{Table: "storage_locations", Name: "idx_storage_locations_image_backend", Columns: []string{"image_id", "backend_id"}},
+	// 删除后端前的引用计数：WHERE backend_id = ?
+	{Table: "storage_locations", Name: "idx_storage_locations_backend", Columns: []string{"backend_id"}},
+	// 操作日志：按后端和时间筛选
+	{Table: "storage_operations", Name: "idx_storage_operations_backend_created", Columns: []string{"backend_id", "created_at"}},
+	{Table: "storage_operations", Name: "idx_storage_operations_created", Columns: []string{"created_at"}},
+}
+
+// migrateIndexes 确保所有热点查询索引存在
+// md5+user_id 的复合唯一索引 (idx_user_md5) 由模型标签通过 AutoMigrate 创建，旧的全局唯一索引由 migrateImageMD5Index 删除
+func migrateIndexes() error {
+	for _, idx := range hotQueryIndexes {
+		if DB.Migrator().HasIndex(idx.Table, idx.Name) {
+			continue
+		}
+		log.Printf("Creating index %s on %s(%s)...", idx.Name, idx.Table, strings.Join(idx.Columns, ", "))
+		sql := fmt.Sprintf("CREATE INDEX %s ON %s (%s)", idx.Name, idx.Table, strings.Join(idx.Columns, ", "))
+		if err := DB.Exec(sql).Error; err != nil {
+			return fmt.Errorf("failed to create index %s: %w", idx.Name, err)
+		}
+	}
+	return nil
+}
+
+// imageMD5IndexName 图片 (md5, user_id) 复合唯一索引的名称，与模型标签一致
+const imageMD5IndexName = "idx_user_md5"
+
+// migrateImageMD5Index 把旧版本在 images.md5 上建立的全局唯一索引换成 (md5, user_id) 复合唯一索引
+// 全局唯一索引会让不同用户上传相同文件时插入失败，与共享已有文件的上传逻辑冲突。
+// 需要在 AutoMigrate 之前执行：先删除旧索引，再检查现有数据能否满足复合唯一约束，索引由 AutoMigrate 创建
+func migrateImageMD5Index() error {
+	migrator := DB.Migrator()
+	if !migrator.HasTable(&Image{}) {
+		return nil
+	}
+	indexes, err := migrator.GetIndexes(&Image{})
+	if err != nil {
+		return fmt.Errorf("failed to read indexes of images: %w", err)
+	}
+	for _, idx := range indexes {
+		unique, _ := idx.Unique()
+		columns := idx.Columns()
+		if !unique || len(columns) != 1 || !strings.EqualFold(columns[0], "md5") {
+			continue
+		}
+		log.Printf("Dropping global unique index %s on images(md5), MD5 is now unique per user...", idx.Name())
+		if err := migrator.DropIndex(&Image{}, idx.Name()); err != nil {
+			return fmt.Errorf("failed to drop index %s: %w", idx.Name(), err)
+		}
+	}
+
+	if migrator.HasIndex(&Image{}, imageMD5IndexName) {
+		return nil
+	}
+	// 同一用户存在重复 MD5 时建索引会失败，提前列出冲突的记录，方便管理员清理
+	var duplicates []struct {
+		MD5    string
+		UserID uint
+		Count  int64
+	}
+	err = DB.Model(&Image{}).
+		Select("md5, user_id, COUNT(*) AS count").
+		Group("md5, user_id").
+		Having("COUNT(*) > 1").
+		Limit(10).
+		Scan(&duplicates).Error
+	if err != nil {
+		return fmt.Errorf("failed to check duplicate images: %w", err)
+	}
+	if len(duplicates) > 0 {
+		groups := make([]string, 0, len(duplicates))
+		for _, d := range duplicates {
+			groups = append(groups, fmt.Sprintf("user %d md5 %q (%d images)", d.UserID, d.MD5, d.Count))
+		}
+		return fmt.Errorf("cannot create unique index %s, remove duplicate images first: %s", imageMD5IndexName, strings.Join(groups, "; "))
+	}
+	return nil
+}
+
+// GetSchemaVersion 读取数据库中记录的结构版本，没有记录 (首次启动或旧数据库) 时返回 0
+func GetSchemaVersion() (int, error) {
+	if !DB.Migrator().HasTable(&Setting{}) {
+		return 0, nil
+	}
+	var setting Setting
+	if err := DB.Where(clause.Eq{Column: "key", Value: schemaVersionKey}).First(&setting).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.Atoi(setting.Value)
+}
+
+// checkSchemaVersion 在迁移前检查结构版本，数据库来自更新的程序版本时拒绝迁移，避免旧代码破坏新结构
+func checkSchemaVersion() error {
+	version, err := GetSchemaVersion()
+	if err != nil {
+		return fmt.Errorf("failed to read database schema version: %w", err)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d, please upgrade the program", version, SchemaVersion)
+	}
+	return nil
+}
+
+// saveSchemaVersion 迁移完成后记录当前结构版本
+func saveSchemaVersion() error {
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value"}),
+	}).Create(&Setting{Key: schemaVersionKey, Value: strconv.Itoa(SchemaVersion)}).Error
+}
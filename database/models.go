@@ -20,6 +20,22 @@ type User struct {
 	Password  string     `gorm:"type:varchar(255);not null"`
 	Role      string     `gorm:"type:varchar(20);default:'user'"`
 	APITokens []APIToken `gorm:"foreignKey:UserID"`
+	// WatermarkDisabled 为 true 时该用户的上传默认不添加水印
+	WatermarkDisabled bool `gorm:"default:false"`
+	// StorageQuotaMB 用户可用的存储空间 (MB)，0 表示不限制
+	StorageQuotaMB int64 `gorm:"default:0"`
+	// IsActive 为 false 时用户被停用：不能登录，API Token 失效，私有图片不再对外提供
+	IsActive bool `gorm:"default:true"`
+}
+
+// Role 角色及其权限，User.Role 保存的是角色名
+type Role struct {
+	CustomModel
+	Name        string         `gorm:"type:varchar(20);uniqueIndex;not null"`
+	Description string         `gorm:"type:varchar(255)"`
+	Permissions datatypes.JSON `gorm:"type:json"` // 权限名列表
+	BackendIDs  datatypes.JSON `gorm:"type:json"` // 允许上传的后端，为空表示不限制
+	BuiltIn     bool           `gorm:"default:false"`
 }
 
 // APIToken API Token 模型
@@ -31,6 +47,54 @@ type APIToken struct {
 	Name      string `gorm:"type:varchar(100)"`
 	IsActive  bool   `gorm:"default:true"`
 	ExpiresAt *time.Time
+	// 以下字段不为空时，通过该 Token 上传的图片强制使用这些值，忽略客户端传入的参数
+	UploadFolder    string `gorm:"type:varchar(255)"`
+	UploadWatermark *bool
+	UploadCompress  *bool
+	// FilenameStrategy 该 Token 上传时默认使用的文件名策略，请求参数可以覆盖
+	FilenameStrategy string `gorm:"type:varchar(20)"`
+	// BackendIDs 不为空时该 Token 上传的图片只写入这些后端，忽略客户端指定的后端列表
+	BackendIDs datatypes.JSON `gorm:"type:json"`
+	// Scopes 逗号分隔的权限范围 (upload、read、delete、admin)，为空的旧 Token 只能上传
+	Scopes string `gorm:"type:varchar(100)"`
+	// LastUsedAt 和 UseCount 在每次通过认证的请求后更新，逐日明细见 APITokenUsage
+	LastUsedAt *time.Time
+	UseCount   int64 `gorm:"default:0"`
+	// DailyUploadLimit / DailyUploadMB 通过该 Token 每天最多上传的次数和 MB 数，0 表示只受用户的每日上限约束
+	DailyUploadLimit int64 `gorm:"default:0"`
+	DailyUploadMB    int64 `gorm:"default:0"`
+}
+
+// UploadPreset 用户保存的命名上传预设，上传时通过 preset 参数按名称引用
+type UploadPreset struct {
+	CustomModel
+	UserID           uint           `gorm:"uniqueIndex:idx_user_preset_name"`
+	Name             string         `gorm:"type:varchar(100);uniqueIndex:idx_user_preset_name"`
+	BackendIDs       datatypes.JSON `gorm:"type:json"` // 目标后端 ID 列表，为空表示所有允许上传的后端
+	Watermark        *bool
+	Compress         *bool
+	Folder           string `gorm:"type:varchar(255)"`
+	FilenameStrategy string `gorm:"type:varchar(20)"`
+}
+
+// DailyUploadUsage 每个用户每天的上传次数和字节数，TokenID 为 0 表示网页上传。
+// 计数保存在数据库中，重启后每日上限依然有效
+type DailyUploadUsage struct {
+	CustomModel
+	UserID  uint   `gorm:"uniqueIndex:idx_daily_upload"`
+	TokenID uint   `gorm:"uniqueIndex:idx_daily_upload"`
+	Day     string `gorm:"type:varchar(10);uniqueIndex:idx_daily_upload"` // 本地日期，格式 2006-01-02
+	Uploads int64
+	Bytes   int64
+}
+
+// APITokenUsage 每个 API Token 每天的请求次数和上传字节数
+type APITokenUsage struct {
+	CustomModel
+	TokenID       uint   `gorm:"uniqueIndex:idx_token_usage_day"`
+	Day           string `gorm:"type:varchar(10);uniqueIndex:idx_token_usage_day"` // 本地日期，格式 2006-01-02
+	Requests      int64
+	BytesUploaded int64
 }
 
 // Image 主表
@@ -38,16 +102,198 @@ type Image struct {
 	CustomModel
 	UUID string `gorm:"type:varchar(36);uniqueIndex;not null"`
 	// --- 已修改：移除独立唯一索引，改为与UserID的复合唯一索引 ---
-	MD5              string `gorm:"type:varchar(32);index:idx_user_md5,unique"`
+	MD5 string `gorm:"type:varchar(32);index:idx_user_md5,unique"`
+	// SHA256 文件内容的 SHA-256，去重以此为准；MD5 仅为兼容旧数据保留
+	SHA256           string `gorm:"type:varchar(64);index"`
 	OriginalFilename string `gorm:"type:varchar(255)"`
-	FileSize         int64
-	ContentType      string            `gorm:"type:varchar(50)"`
-	Width            int               `gorm:"default:0"`
-	Height           int               `gorm:"default:0"`
-	StorageLocations []StorageLocation `gorm:"foreignKey:ImageID"`
+	FileSize         int64  // 实际存储的文件大小 (经过压缩等处理后)
+	OriginalSize     int64  // 用户上传时的原始文件大小
+	ContentType      string `gorm:"type:varchar(50)"`
+	// OriginalContentType 服务端转换格式前的原始类型 (如 HEIC)，未转换时为空
+	OriginalContentType string            `gorm:"type:varchar(50)"`
+	Width               int               `gorm:"default:0"`
+	Height              int               `gorm:"default:0"`
+	StorageLocations    []StorageLocation `gorm:"foreignKey:ImageID"`
 	// --- 已修改：将 UserID 加入复合唯一索引 ---
 	UserID      uint `gorm:"index:idx_user_md5,unique"`
 	AllowRandom bool `gorm:"default:false;index"`
+	// Annotations 上传时客户端附带的注释 (来源应用、页面 URL、备注)，JSON 对象
+	Annotations datatypes.JSON `gorm:"type:json"`
+	// ModerationStatus 内容审核状态：空、flagged、quarantined、approved
+	ModerationStatus string  `gorm:"type:varchar(20);index"`
+	ModerationScore  float64 `gorm:"default:0"`
+	// Folder 图片所属的文件夹，空字符串表示根目录
+	Folder string `gorm:"type:varchar(255);index"`
+	// StorageKey 文件在各后端中的对象名，为空表示旧数据，使用 <uuid>.<ext>
+	StorageKey string `gorm:"type:varchar(255);index"`
+	// ExpiresAt 图片的过期时间，为空表示永久保存，过期后由后台任务删除
+	ExpiresAt *time.Time `gorm:"index"`
+	// UploaderIP / UploaderUA 上传者的 IP 和客户端 User-Agent，用于排查滥用，受 uploader_info_mode 设置控制
+	UploaderIP string `gorm:"type:varchar(45);index"`
+	UploaderUA string `gorm:"type:varchar(255)"`
+	// UploadSource 上传途径：web (网页登录)、api (API Token) 或 url-fetch (服务端抓取远程图片)，不受隐私设置影响
+	UploadSource string `gorm:"type:varchar(20);index"`
+	// AlbumID 图片所属的相册，为空表示不在任何相册中
+	AlbumID *uint `gorm:"index"`
+	// LastServedBackendID / LastServedAt 最近一次 /image 请求选中的后端和时间，用于核对访问策略
+	LastServedBackendID *uint
+	LastServedAt        *time.Time
+	Tags                []Tag `gorm:"many2many:image_tags"`
+	// Visibility 可见性：public 任何人可访问，private 只有所有者、签名地址或分享链接可访问
+	Visibility string `gorm:"type:varchar(20);default:'public';index"`
+	// Description / AltText 用户填写的描述和替代文本 (alt)，上传后可修改
+	Description string `gorm:"type:text"`
+	AltText     string `gorm:"type:varchar(500)"`
+	// ViewCount / LastViewedAt 访问次数和最近访问时间，由后台定期批量写入，会比实际访问稍有延迟
+	ViewCount    int64 `gorm:"default:0;index"`
+	LastViewedAt *time.Time
+}
+
+// Tag 图片标签，名称全局唯一 (统一为小写)
+type Tag struct {
+	CustomModel
+	Name string `gorm:"type:varchar(50);uniqueIndex;not null"`
+}
+
+// ImageTag 图片与标签的关联表
+type ImageTag struct {
+	ImageID   uint `gorm:"primaryKey"`
+	TagID     uint `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+// ImageDailyView 图片每天的访问次数，Day 为服务器本地时区的日期 (2006-01-02)
+type ImageDailyView struct {
+	ImageID uint   `gorm:"primaryKey"`
+	Day     string `gorm:"type:varchar(10);primaryKey;index"`
+	Views   int64  `gorm:"default:0"`
+}
+
+// BandwidthUsage 每天每个后端、每个用户 (图片所有者) 的访问流量
+// 本地和代理输出的请求记录实际字节数；跳转的请求只计次数，流量按文件大小估算
+type BandwidthUsage struct {
+	Day                    string `gorm:"type:varchar(10);primaryKey"`
+	BackendID              uint   `gorm:"primaryKey;index"`
+	UserID                 uint   `gorm:"primaryKey;index"`
+	Requests               int64  `gorm:"default:0"` // 由本服务器输出内容的请求数 (含 304)
+	BytesServed            int64  `gorm:"default:0"`
+	Redirects              int64  `gorm:"default:0"`
+	EstimatedRedirectBytes int64  `gorm:"default:0"`
+}
+
+// DailyStat 全站每天的汇总统计，由后台任务根据图片、访问和流量记录生成，仪表盘的趋势图直接读取该表。
+// 上传数按生成时仍存在的图片计算，已经汇总过的日期不会因之后删除图片而改变
+type DailyStat struct {
+	Day         string `gorm:"type:varchar(10);primaryKey"` // 本地日期，格式 2006-01-02
+	Uploads     int64  `gorm:"default:0"`
+	UploadBytes int64  `gorm:"default:0"`
+	Views       int64  `gorm:"default:0"` // 来自每日访问统计，关闭该设置后为 0
+	Requests    int64  `gorm:"default:0"` // 由本服务器输出和跳转的图片请求总数
+	BytesServed int64  `gorm:"default:0"`
+	UpdatedAt   time.Time
+}
+
+// DailyBackendStat 每天每个后端新增的存储位置及其访问流量
+type DailyBackendStat struct {
+	Day         string `gorm:"type:varchar(10);primaryKey"`
+	BackendID   uint   `gorm:"primaryKey;index"`
+	Locations   int64  `gorm:"default:0"`
+	Bytes       int64  `gorm:"default:0"`
+	Requests    int64  `gorm:"default:0"`
+	BytesServed int64  `gorm:"default:0"`
+	Redirects   int64  `gorm:"default:0"`
+}
+
+// ImageSlug 图片的自定义短链接，通过 /p/:slug 访问，每张图片最多一个
+type ImageSlug struct {
+	CustomModel
+	Slug    string `gorm:"type:varchar(64);uniqueIndex;not null"` // 统一保存为小写
+	ImageID uint   `gorm:"uniqueIndex;not null"`
+	UserID  uint   `gorm:"index"`
+}
+
+// AdminNotification 需要管理员关注的系统事件，例如存储位置失效后的自动补传
+type AdminNotification struct {
+	CustomModel
+	Type    string `gorm:"type:varchar(50);index"`
+	Message string `gorm:"type:text"`
+	Read    bool   `gorm:"default:false;index"`
+}
+
+// Webhook 事件通知的接收地址，事件发生时以带 HMAC 签名的 POST 请求推送
+type Webhook struct {
+	CustomModel
+	Name string `gorm:"type:varchar(100)"`
+	URL  string `gorm:"type:varchar(512);not null"`
+	// Secret 计算 X-Imgbed-Signature 的密钥，不在接口中返回
+	Secret string `gorm:"type:varchar(128)" json:"-"`
+	// Events 订阅的事件名列表，为空表示订阅全部事件
+	Events   datatypes.JSON `gorm:"type:json"`
+	IsActive bool           `gorm:"default:true"`
+}
+
+// WebhookDelivery 一次事件推送及其重试记录，也作为推送日志保留
+type WebhookDelivery struct {
+	CustomModel
+	WebhookID uint           `gorm:"index"`
+	Event     string         `gorm:"type:varchar(50);index"`
+	Payload   datatypes.JSON `gorm:"type:json"`
+	// Status 为 pending (等待推送或重试)、succeeded 或 failed (重试次数用尽)
+	Status       string `gorm:"type:varchar(20);index"`
+	Attempts     int    `gorm:"default:0"`
+	ResponseCode int
+	LastError    string    `gorm:"type:text"`
+	NextRunAt    time.Time `gorm:"index"`
+	DeliveredAt  *time.Time
+}
+
+// Album 用户创建的相册，一张图片最多属于一个相册
+type Album struct {
+	CustomModel
+	UserID      uint   `gorm:"index"`
+	Name        string `gorm:"type:varchar(100);not null"`
+	Description string `gorm:"type:text"`
+	// CoverUUID 封面图片的 UUID，为空时使用相册中最新的图片
+	CoverUUID string `gorm:"type:varchar(36)"`
+	// Visibility 可见性：private 仅所有者可见，public 任何人可通过公开接口浏览
+	Visibility string `gorm:"type:varchar(20);default:'private';index"`
+}
+
+// PendingDistribution 异步分发模式下等待补传到其余后端的任务
+type PendingDistribution struct {
+	CustomModel
+	ImageID   uint      `gorm:"index"`
+	BackendID uint      `gorm:"index"`
+	Attempts  int       `gorm:"default:0"`
+	NextRunAt time.Time `gorm:"index"`
+	LastError string    `gorm:"type:text"`
+}
+
+// ShareLink 图片或相册的分享链接，通过 /s/:token 访问，可设置密码和有效期
+type ShareLink struct {
+	CustomModel
+	Token   string `gorm:"type:varchar(32);uniqueIndex;not null"`
+	UserID  uint   `gorm:"index"`
+	ImageID *uint  `gorm:"index"` // 与 AlbumID 二选一
+	AlbumID *uint  `gorm:"index"`
+	// PasswordHash 访问密码的 bcrypt 哈希，为空表示不需要密码
+	PasswordHash string `gorm:"type:varchar(255)" json:"-"`
+	ExpiresAt    *time.Time
+	Views        int64 `gorm:"default:0"`
+}
+
+// ExpiredImage 已过期并被删除的图片，用于让之后的访问返回 410 而不是 404
+type ExpiredImage struct {
+	CustomModel
+	UUID      string `gorm:"type:varchar(36);uniqueIndex;not null"`
+	ExpiredAt time.Time
+}
+
+// ImageMetadataCache 按需解析的图片技术元数据 (EXIF/ICC/XMP)，首次查看时写入
+type ImageMetadataCache struct {
+	CustomModel
+	ImageID uint           `gorm:"uniqueIndex"`
+	Data    datatypes.JSON `gorm:"type:json"`
 }
 
 // StorageLocation 存储位置表
@@ -61,6 +307,9 @@ type StorageLocation struct {
 	DeleteIdentifier string  `gorm:"type:varchar(255)"`
 	IsActive         bool    `gorm:"default:true"`
 	FailureCount     int     `gorm:"default:0"`
+	// LastCheckedAt/LastLatencyMs 后台健康检查最近一次探测的时间和耗时
+	LastCheckedAt *time.Time `gorm:"index"`
+	LastLatencyMs int64
 }
 
 // Backend 存储后端配置表
@@ -72,6 +321,24 @@ type Backend struct {
 	Priority      int            `gorm:"default:1"`
 	AllowUpload   bool           `gorm:"default:true"`
 	AllowRedirect bool           `gorm:"default:true"`
+	// DryRun 为 true 时只模拟上传，生成的存储位置标记为无效，不写入真实存储
+	DryRun bool `gorm:"default:false"`
+	// BandwidthLimit 迁移/补传写入该后端的带宽上限 (字节/秒)，0 表示不限制
+	BandwidthLimit int64 `gorm:"default:0"`
+	// AllowProxy 为 true 时访问图片不跳转到后端地址，由服务器中转文件内容 (用于私有存储桶或需隐藏源站的后端)
+	AllowProxy bool `gorm:"default:false"`
+	// Weight 加权随机访问策略下的权重，0 表示只在其他后端都不可用时使用
+	Weight int `gorm:"default:1"`
+}
+
+// DatabaseBackup 已上传到后端的数据库备份
+type DatabaseBackup struct {
+	CustomModel
+	BackendID        uint   `gorm:"index"`
+	Filename         string `gorm:"type:varchar(255)"`
+	URL              string `gorm:"type:varchar(512)"`
+	DeleteIdentifier string `gorm:"type:varchar(255)"`
+	Size             int64
 }
 
 // Setting 系统设置表
@@ -80,3 +347,73 @@ type Setting struct {
 	Key   string `gorm:"type:varchar(100);uniqueIndex;not null"`
 	Value string `gorm:"type:text"`
 }
+
+// PendingDeletion 等待宽限期结束后执行的物理删除
+// Snapshot 保存被删除图片及其存储位置的 JSON 快照，用于取消删除时恢复元数据
+type PendingDeletion struct {
+	CustomModel
+	ImageUUID string         `gorm:"type:varchar(36);index"`
+	UserID    uint           `gorm:"index"`
+	Snapshot  datatypes.JSON `gorm:"type:json"`
+	// Relations 随图片一起移除的关联数据 (标签、短链接、分享链接、访问统计、相册封面)，取消删除时恢复
+	// 其中包含分享密码的哈希，不在接口中返回
+	Relations datatypes.JSON `gorm:"type:json" json:"-"`
+	ExecuteAt time.Time      `gorm:"index"`
+}
+
+// FailedDeletion 删除失败的物理文件 (例如远程后端暂时不可用)，定期重试直到成功或被管理员放弃
+type FailedDeletion struct {
+	CustomModel
+	BackendID        uint      `gorm:"index"`
+	StorageType      string    `gorm:"type:varchar(50)"`
+	URL              string    `gorm:"type:varchar(512)"`
+	DeleteIdentifier string    `gorm:"type:varchar(512)"` // 传给后端 Delete 的标识
+	Attempts         int       `gorm:"default:0"`
+	LastError        string    `gorm:"type:text"`
+	NextRunAt        time.Time `gorm:"index"`
+}
+
+// InviteCode 自助注册使用的邀请码，MaxUses 为 0 表示不限次数
+type InviteCode struct {
+	CustomModel
+	Code      string `gorm:"type:varchar(32);uniqueIndex;not null"`
+	CreatedBy uint   `gorm:"index"`
+	Note      string `gorm:"type:varchar(255)"`
+	Revoked   bool   `gorm:"default:false"`
+	Uses      int    `gorm:"default:0"`
+	// MaxUses 不设默认值，否则 0 (不限次数) 会被 gorm 替换成默认值
+	MaxUses   int
+	ExpiresAt *time.Time
+}
+
+// UploadSession 分片上传会话，分片文件保存在磁盘上，会话过期后连同分片一起清理
+type UploadSession struct {
+	CustomModel
+	UploadID    string `gorm:"type:varchar(36);uniqueIndex"`
+	UserID      uint   `gorm:"index"`
+	Filename    string `gorm:"type:varchar(255)"`
+	Size        int64
+	ChunkSize   int64
+	TotalChunks int
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+// RewriteRule 旧链接重写规则，Pattern 匹配请求路径，Target 为图片 UUID 或跳转地址 (支持 $1 等捕获组)
+type RewriteRule struct {
+	CustomModel
+	Pattern  string `gorm:"type:varchar(512);not null"`
+	Target   string `gorm:"type:varchar(512);not null"`
+	Priority int    `gorm:"default:1"`
+	IsActive bool   `gorm:"default:true"`
+}
+
+// StorageOperation 物理存储操作日志 (上传、删除、补传)
+type StorageOperation struct {
+	CustomModel
+	BackendID  uint   `gorm:"index"`
+	Operation  string `gorm:"type:varchar(20);index"` // "upload"、"delete"、"backfill"
+	Key        string `gorm:"type:varchar(512)"`      // 文件名或删除标识
+	Success    bool
+	Error      string `gorm:"type:text"`
+	DurationMs int64
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/service"
+)
+
+// unixSocketPrefix 监听地址中表示 Unix socket 的前缀
+const unixSocketPrefix = "unix:"
+
+// listenAddresses 返回配置的监听地址，未配置时回退到所有地址上的 Port
+func listenAddresses(cfg config.ServerConfig) []string {
+	if len(cfg.Listen) > 0 {
+		return cfg.Listen
+	}
+	return []string{fmt.Sprintf(":%s", cfg.Port)}
+}
+
+// listen 为单个地址创建监听器，Unix socket 会先清理残留的 socket 文件并设置权限
+func listen(addr string, socketMode string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixSocketPrefix) {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, unixSocketPrefix)
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// 上次进程异常退出时留下的 socket 文件
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if socketMode != "" {
+		mode, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("invalid socket_mode %q: %w", socketMode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// serve 在所有配置的地址上提供服务，任一监听器出错时返回；收到 SIGTERM/SIGINT 时优雅关闭
+func serve(handler http.Handler, cfg config.ServerConfig) error {
+	addrs := listenAddresses(cfg)
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := listen(addr, cfg.SocketMode)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, ln)
+		log.Printf("Server is listening on %s", addr)
+	}
+
+	server := &http.Server{Handler: unixPeerHandler(handler)}
+	// 任务进度推送是长连接，关闭时主动结束，不必等到超时
+	server.RegisterOnShutdown(service.CloseTaskStreams)
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			errCh <- server.Serve(ln)
+		}(ln)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		server.Close()
+		return err
+	case <-ctx.Done():
+	}
+	// 恢复默认的信号处理，再次按 Ctrl+C 可立即退出
+	stop()
+	shutdown(server, time.Duration(cfg.ShutdownTimeoutSeconds)*time.Second)
+	return nil
+}
+
+// unixPeerHandler 通过 Unix socket 接入的请求没有对端地址，按 127.0.0.1 处理，
+// 这样前面的反向代理 (通常是同一台机器上的 Nginx) 可以像 TCP 连接一样被信任
+func unixPeerHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
+			r.RemoteAddr = "127.0.0.1:0"
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shutdown 停止接受新连接，在 timeout 内等待进行中的请求 (包括上传) 和后台任务完成，最后写入缓冲的统计数据
+func shutdown(server *http.Server, timeout time.Duration) {
+	log.Printf("Shutting down, waiting up to %s for in-flight requests and background tasks...", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Timed out waiting for in-flight requests, closing remaining connections: %v", err)
+		server.Close()
+	}
+	service.Shutdown(ctx)
+	log.Println("Server stopped.")
+}
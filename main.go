@@ -2,10 +2,12 @@ package main
 
 import (
 	"embed"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"yanshu-imgbed/config"
-	"yanshu-imgbed/database"
 	"yanshu-imgbed/manager"
 	"yanshu-imgbed/router"
 	"yanshu-imgbed/service"
@@ -22,32 +24,61 @@ func main() {
 	if err := config.Init(); err != nil {
 		log.Fatalf("Failed to initialize configuration: %v", err)
 	}
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// runServe 初始化数据库、缓存和后台任务后启动 HTTP 服务，直到收到退出信号
+func runServe(args []string) error {
+	if _, err := parseFlags(flag.NewFlagSet("serve", flag.ContinueOnError), args); err != nil {
+		return err
+	}
 
 	// 2. 初始化数据库 (传入配置)
-	if err := database.Init(config.Cfg.Database.DSN); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	if err := openDatabase(); err != nil {
+		return err
 	}
 
 	// 3. 初始化设置缓存
 	service.InitSettings()
 
 	// 启动随机图片缓存服务 ---
-	if err := database.Init(config.Cfg.Database.DSN); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-	}
+	service.InitRandomImageCache()
+	service.InitRewriteRules()
+	service.InitRoles()
+	service.InitSuspendedUsers()
+	service.InitChunkedUploads()
+	service.InitProxyCache()
+	service.InitViewCounter()
+	service.InitBandwidthAccounting()
+	service.InitDailyStats()
+	service.InitGeoIP()
+	service.InitWebhooks()
 
 	// 4. 初始化存储管理器
 	storageManager, err := manager.NewStorageManager()
 	if err != nil {
-		log.Fatalf("Failed to initialize storage manager: %v", err)
+		return fmt.Errorf("failed to initialize storage manager: %w", err)
+	}
+	// 启动自检，严格模式下 release 环境存在严重问题时拒绝启动
+	report := service.RunSelfCheck(storageManager)
+	report.Log()
+	if report.HasCritical() && config.Cfg.Server.StrictStartup && config.Cfg.Server.Mode == "release" {
+		return errors.New("startup self-check failed with critical issues, refusing to start (server.strict_startup is enabled)")
 	}
 
+	service.InitDeletionScheduler(storageManager)
+	service.InitExpirationScheduler(storageManager)
+	service.InitDistributionQueue(storageManager)
+	service.InitReplicaReconciler(storageManager)
+	service.InitHealthChecker(storageManager)
+	service.InitReplication(storageManager)
+	service.InitBackupScheduler(storageManager)
+
 	// 5. 设置并运行路由 (注入管理器和嵌入的资源)
 	r := router.SetupRouter(storageManager, templatesFS, staticFS)
 
-	serverAddr := fmt.Sprintf(":%s", config.Cfg.Server.Port)
-	log.Printf("Server is running on http://127.0.0.1%s", serverAddr)
-	if err := r.Run(serverAddr); err != nil {
-		log.Fatalf("Failed to run server: %v", err)
+	if err := serve(router.WithBasePath(r), config.Cfg.Server); err != nil {
+		return fmt.Errorf("failed to run server: %w", err)
 	}
+	return nil
 }
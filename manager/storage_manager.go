@@ -87,6 +87,9 @@ func (sm *StorageManager) Refresh() error {
 			log.Printf("Unsupported backend type: %s for backend %s (ID: %d). Skipping.", backend.Type, backend.Name, backend.ID)
 			continue
 		}
+		if backend.DryRun {
+			uploader = storage.NewDryRunUploader(backend.Name, uploader)
+		}
 		newUploaders[backend.ID] = uploader
 	}
 
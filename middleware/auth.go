@@ -13,6 +13,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// UploadedBytesKey 上传处理成功后写入 gin.Context 的字节数，用于 API Token 用量统计
+const UploadedBytesKey = "uploadedBytes"
+
 // AuthMiddleware JWT认证中间件
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -53,6 +56,12 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if service.IsUserSuspended(claims.UserID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+			c.Abort()
+			return
+		}
+
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("userRole", claims.Role)
@@ -60,11 +69,30 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-// AdminAuthMiddleware 检查是否为管理员
+// OptionalAuthMiddleware 请求带有有效的 JWT 时写入用户信息，没有或无效时按匿名访问处理，不拦截请求
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parts := strings.Split(c.GetHeader("Authorization"), " ")
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			claims := &service.Claims{}
+			token, err := jwt.ParseWithClaims(parts[1], claims, func(token *jwt.Token) (interface{}, error) {
+				return []byte(config.Cfg.JWT.Secret), nil
+			})
+			if err == nil && token.Valid && !service.IsUserSuspended(claims.UserID) {
+				c.Set("userID", claims.UserID)
+				c.Set("username", claims.Username)
+				c.Set("userRole", claims.Role)
+			}
+		}
+		c.Next()
+	}
+}
+
+// AdminAuthMiddleware 检查当前角色是否拥有管理权限 (system.manage)
 func AdminAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("userRole")
-		if !exists || role.(string) != "admin" {
+		if !exists || !service.HasPermission(role.(string), service.PermManageSystem) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: Admin access required"})
 			c.Abort()
 			return
@@ -73,8 +101,21 @@ func AdminAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-// APITokenAuthMiddleware 验证API Token
-func APITokenAuthMiddleware() gin.HandlerFunc {
+// RequirePermission 检查当前角色是否拥有指定权限，需要放在认证中间件之后
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, exists := c.Get("userRole")
+		if !exists || !service.HasPermission(role.(string), perm) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: missing permission " + perm})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// APITokenAuthMiddleware 验证API Token，并要求 Token 具备指定的权限范围
+func APITokenAuthMiddleware(scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenValue := c.GetHeader("X-API-TOKEN")
 		if tokenValue == "" {
@@ -93,16 +134,37 @@ func APITokenAuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		serveWithAPIToken(c, &apiToken, scope)
+	}
+}
 
-		c.Set("userID", apiToken.UserID)
-		c.Set("username", apiToken.User.Username)
-		c.Set("userRole", apiToken.User.Role)
-		c.Next()
+// serveWithAPIToken 检查所属用户状态、有效期和权限范围后以 Token 所属用户的身份继续处理请求，并记录 Token 用量
+func serveWithAPIToken(c *gin.Context, apiToken *database.APIToken, scope string) {
+	if service.IsUserSuspended(apiToken.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+		c.Abort()
+		return
+	}
+	if service.APITokenExpired(apiToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API Token has expired"})
+		c.Abort()
+		return
+	}
+	if !service.TokenHasScope(apiToken, scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API Token lacks the '" + scope + "' scope"})
+		c.Abort()
+		return
 	}
+	c.Set("userID", apiToken.UserID)
+	c.Set("username", apiToken.User.Username)
+	c.Set("userRole", service.APITokenRole(apiToken))
+	c.Set("apiToken", apiToken)
+	c.Next()
+	service.RecordAPITokenUsage(apiToken.ID, c.GetInt64(UploadedBytesKey))
 }
 
-// CombinedAuthMiddleware 组合认证 (API Token 和 JWT)
-func CombinedAuthMiddleware() gin.HandlerFunc {
+// CombinedAuthMiddleware 组合认证 (API Token 和 JWT)，使用 API Token 时要求具备指定的权限范围
+func CombinedAuthMiddleware(scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 1. 尝试 API Token
 		tokenValue := c.GetHeader("X-API-TOKEN")
@@ -110,10 +172,7 @@ func CombinedAuthMiddleware() gin.HandlerFunc {
 			var apiToken database.APIToken
 			err := database.DB.Preload("User").Where("token = ? AND is_active = ?", tokenValue, true).First(&apiToken).Error
 			if err == nil {
-				c.Set("userID", apiToken.UserID)
-				c.Set("username", apiToken.User.Username)
-				c.Set("userRole", apiToken.User.Role)
-				c.Next()
+				serveWithAPIToken(c, &apiToken, scope)
 				return
 			}
 		}
@@ -131,6 +190,11 @@ func CombinedAuthMiddleware() gin.HandlerFunc {
 				})
 
 				if err == nil && token.Valid {
+					if service.IsUserSuspended(claims.UserID) {
+						c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+						c.Abort()
+						return
+					}
 					c.Set("userID", claims.UserID)
 					c.Set("username", claims.Username)
 					c.Set("userRole", claims.Role)
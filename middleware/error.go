@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader 关联 ID 使用的响应头
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDMiddleware 为每个请求分配关联 ID，优先沿用上游传入的值
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > 64 {
+			requestID = uuid.New().String()
+		}
+		c.Set("requestID", requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// ErrorMiddleware 捕获 panic 和 handler 通过 c.Error 上报的内部错误
+// 完整错误信息只记录在服务端日志中，客户端只会收到统一的错误响应和关联 ID
+func ErrorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Printf("[%s] Panic recovered on %s %s: %v\n%s", c.GetString("requestID"), c.Request.Method, c.Request.URL.Path, rec, debug.Stack())
+				if !c.Writer.Written() {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, InternalErrorBody(c))
+				} else {
+					c.Abort()
+				}
+			}
+		}()
+
+		c.Next()
+
+		if len(c.Errors) == 0 {
+			return
+		}
+		for _, e := range c.Errors {
+			log.Printf("[%s] Error on %s %s: %v", c.GetString("requestID"), c.Request.Method, c.Request.URL.Path, e.Err)
+		}
+		if !c.Writer.Written() {
+			c.JSON(http.StatusInternalServerError, InternalErrorBody(c))
+		}
+	}
+}
+
+// InternalErrorBody 返回 500 响应的统一内容，只包含通用错误信息和关联 ID
+func InternalErrorBody(c *gin.Context) gin.H {
+	return gin.H{"error": "Internal server error", "request_id": c.GetString("requestID")}
+}
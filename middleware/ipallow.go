@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"yanshu-imgbed/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminIPAllowlistMiddleware 只允许 server.admin_allowed_cidrs 中的地址访问管理后台，列表为空时不限制
+// 配置在启动时解析，格式错误直接拒绝启动，避免管理后台在配置写错时意外暴露
+func AdminIPAllowlistMiddleware() gin.HandlerFunc {
+	networks := parseAllowedCIDRs(config.Cfg.Server.AdminAllowedCIDRs)
+	if len(networks) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	log.Printf("Admin routes restricted to %d network(s)", len(networks))
+	return func(c *gin.Context) {
+		ip := net.ParseIP(c.ClientIP())
+		for _, network := range networks {
+			if ip != nil && network.Contains(ip) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access from your network is not allowed"})
+	}
+}
+
+// parseAllowedCIDRs 解析 CIDR 列表，单个 IP 按 /32 (IPv6 为 /128) 处理
+func parseAllowedCIDRs(entries []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				log.Fatalf("Invalid entry %q in server.admin_allowed_cidrs", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Fatalf("Invalid entry %q in server.admin_allowed_cidrs: %v", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadOnlyMiddleware 维护模式下拒绝上传、删除、修改图片信息等写操作，只读请求照常放行
+// 用于迁移或备份期间，图片访问不受影响。路由层把它加在所有写接口上，只有修改设置和数据库维护接口除外
+func ReadOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if readOnly, message := service.GetReadOnlyMode(); readOnly {
+			c.Header("Retry-After", "300")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": message, "readonly": true})
+			return
+		}
+		c.Next()
+	}
+}
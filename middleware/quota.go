@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaHeadersMiddleware 在上传接口的响应中附带存储配额信息，方便客户端在超出前提醒用户
+// 需要放在认证中间件之后
+func QuotaHeadersMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		SetQuotaHeaders(c)
+		c.Next()
+	}
+}
+
+// SetQuotaHeaders 按当前用户的用量写入 X-Quota-Used 和 X-Quota-Total (不限制配额时不返回 Total)
+// 上传成功后再次调用，使响应中的用量包含本次上传
+func SetQuotaHeaders(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		return
+	}
+	usage, err := service.GetStorageUsage(userID.(uint))
+	if err != nil {
+		log.Printf("Failed to load storage usage for quota headers: %v", err)
+		return
+	}
+	c.Header("X-Quota-Used", strconv.FormatInt(usage.Used, 10))
+	if usage.Quota > 0 {
+		c.Header("X-Quota-Total", strconv.FormatInt(usage.Quota, 10))
+	}
+}
+
+// DailyUploadLimitMiddleware 在上传前检查用户和 API Token 当天的上传上限，上传成功后累加计数
+// 需要放在认证中间件之后
+func DailyUploadLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := c.Get("userID")
+		if !ok {
+			c.Next()
+			return
+		}
+		var token *database.APIToken
+		if t, exists := c.Get("apiToken"); exists {
+			token = t.(*database.APIToken)
+		}
+		incoming := c.Request.ContentLength
+		if incoming < 0 {
+			incoming = 0
+		}
+		if err := service.CheckDailyUploadLimit(userID.(uint), token, incoming); err != nil {
+			if errors.Is(err, service.ErrDailyUploadLimit) {
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+				return
+			}
+			c.Error(err)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+
+		// 只有上传成功的请求会写入 UploadedBytesKey
+		if _, uploaded := c.Get(UploadedBytesKey); uploaded {
+			var tokenID uint
+			if token != nil {
+				tokenID = token.ID
+			}
+			service.RecordDailyUpload(userID.(uint), tokenID, c.GetInt64(UploadedBytesKey))
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// rateLimitSweepThreshold 桶的数量超过该值时清理已补满的桶
+	rateLimitSweepThreshold = 10000
+	// rateLimitSweepInterval 两次清理之间的最短间隔，避免大量不同 IP 请求时每次都遍历整个表
+	rateLimitSweepInterval = time.Minute
+)
+
+// tokenBucket 记录某个客户端桶中剩余的令牌数和上次补充的时间
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ipRateLimiter 是一个按客户端 IP 计数的令牌桶限流器
+// 桶的容量等于每分钟的上限，令牌按 limit/分钟 的速度匀速补充，允许短时突发但不能持续超速
+type ipRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newIPRateLimiter() *ipRateLimiter {
+	return &ipRateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// refill 按经过的时间补充令牌，不超过桶的容量
+func (b *tokenBucket) refill(now time.Time, capacity, perSecond float64) {
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+}
+
+// allow 尝试为 key 取出一个令牌，返回是否放行、剩余令牌数和距离下一个令牌可用的时间 (放行时为距离桶被填满的时间)
+func (l *ipRateLimiter) allow(key string, limit int) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(limit)
+	perSecond := capacity / time.Minute.Seconds()
+	if len(l.buckets) > rateLimitSweepThreshold && now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		// 已经补满的桶与新建的桶等价，可以直接丢弃
+		l.lastSweep = now
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*perSecond >= capacity {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, capacity, perSecond)
+	if b.tokens < 1 {
+		return false, 0, secondsDuration((1 - b.tokens) / perSecond)
+	}
+	b.tokens--
+	return true, int(b.tokens), secondsDuration((capacity - b.tokens) / perSecond)
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// RateLimitMiddleware 按客户端 IP 限制每分钟的请求数
+// limit 在每次请求时调用，以便管理员修改设置后立即生效；返回值 <= 0 表示不限流
+func RateLimitMiddleware(limit func() int) gin.HandlerFunc {
+	limiter := newIPRateLimiter()
+	return func(c *gin.Context) {
+		max := limit()
+		if max <= 0 {
+			c.Next()
+			return
+		}
+		allowed, remaining, wait := limiter.allow(c.ClientIP(), max)
+		waitSeconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+		c.Header("X-RateLimit-Limit", strconv.Itoa(max))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", waitSeconds)
+		if !allowed {
+			c.Header("Retry-After", waitSeconds)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"yanshu-imgbed/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReplicationAuthMiddleware 校验镜像实例携带的同步密钥，仅在 primary 模式下开放
+func ReplicationAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.Cfg.Replication
+		if cfg.Mode != "primary" || cfg.Token == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Replication is not enabled"})
+			return
+		}
+		token := c.GetHeader("X-Replication-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid replication token"})
+			return
+		}
+		c.Next()
+	}
+}
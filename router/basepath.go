@@ -0,0 +1,39 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"yanshu-imgbed/config"
+)
+
+// WithBasePath 去掉请求路径中的 server.base_path 前缀后再交给路由，路由本身始终按根路径注册。
+// 不带前缀的请求原样处理，反向代理转发前已经去掉前缀时同样可用
+func WithBasePath(next http.Handler) http.Handler {
+	basePath := config.BasePath()
+	if basePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			// "/img" 跳转到首页 "/img/"
+			target := basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, basePath+"/") {
+			r2 := new(http.Request)
+			*r2 = *r
+			u := *r.URL
+			u.Path = strings.TrimPrefix(r.URL.Path, basePath)
+			if u.RawPath != "" {
+				u.RawPath = strings.TrimPrefix(u.RawPath, basePath)
+			}
+			r2.URL = &u
+			r = r2
+		}
+		next.ServeHTTP(w, r)
+	})
+}
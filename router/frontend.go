@@ -0,0 +1,67 @@
+package router
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"yanshu-imgbed/api"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/middleware"
+	"yanshu-imgbed/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerFrontend 按 frontend.mode 注册网页前端，返回未匹配路由时使用的处理函数
+// adminAllowlist 用于限制管理后台页面的访问来源
+func registerFrontend(r *gin.Engine, templatesFS embed.FS, staticFS embed.FS, adminAllowlist gin.HandlerFunc) gin.HandlerFunc {
+	cfg := config.Cfg.Frontend
+	switch cfg.Mode {
+	case "none":
+		log.Println("Frontend disabled, serving API only")
+		return api.NoRouteHandler
+	case "external":
+		if cfg.Dir == "" {
+			log.Fatalf("frontend.mode is external but frontend.dir is not set")
+		}
+		log.Printf("Serving external frontend from %s", cfg.Dir)
+		return api.ExternalFrontendHandler(cfg.Dir)
+	case "", "embedded":
+		registerEmbeddedFrontend(r, templatesFS, staticFS, adminAllowlist)
+		return api.NoRouteHandler
+	default:
+		log.Fatalf("Unknown frontend.mode %q (expected embedded, none or external)", cfg.Mode)
+		return nil
+	}
+}
+
+// registerEmbeddedFrontend 注册内置的页面和静态文件
+func registerEmbeddedFrontend(r *gin.Engine, templatesFS embed.FS, staticFS embed.FS, adminAllowlist gin.HandlerFunc) {
+	// Load templates and static files from embedded FS
+	// basePath 供页面拼接 server.base_path，脚本中统一使用模板定义的 BASE_PATH 常量
+	funcs := template.FuncMap{"basePath": config.BasePath}
+	templ := template.Must(template.New("").Funcs(funcs).ParseFS(templatesFS, "templates/*.html"))
+	r.SetHTMLTemplate(templ)
+	subStaticFS, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		log.Fatalf("Failed to create sub filesystem for static files: %v", err)
+	}
+	r.StaticFS("/static", http.FS(subStaticFS))
+
+	// Page routes
+	r.GET("/login", middleware.NoIndexMiddleware(), func(c *gin.Context) { c.HTML(http.StatusOK, "login.html", nil) })
+	r.GET("/", func(c *gin.Context) {
+		var backends []database.Backend
+		database.DB.Where("allow_upload = ?", true).Order("priority asc").Find(&backends)
+		maxUploadMB := service.GetMaxUploadMB()
+		c.HTML(http.StatusOK, "index.html", gin.H{
+			"Backends":    backends,
+			"MaxUploadMB": maxUploadMB,
+		})
+	})
+	r.GET("/admin", adminAllowlist, middleware.NoIndexMiddleware(), func(c *gin.Context) { c.HTML(http.StatusOK, "admin.html", nil) })
+	r.GET("/admin/images/:uuid", adminAllowlist, middleware.NoIndexMiddleware(), func(c *gin.Context) { c.HTML(http.StatusOK, "image_details.html", nil) })
+}
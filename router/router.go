@@ -2,13 +2,10 @@ package router
 
 import (
 	"embed"
-	"html/template"
-	"io/fs"
 	"log"
 	"net/http"
 	"yanshu-imgbed/api"
 	"yanshu-imgbed/config"
-	"yanshu-imgbed/database"
 	"yanshu-imgbed/manager"
 	"yanshu-imgbed/middleware"
 	"yanshu-imgbed/service"
@@ -25,90 +22,241 @@ func SetupRouter(storageManager *manager.StorageManager, templatesFS embed.FS, s
 		gin.SetMode(gin.DebugMode)
 		log.Println("Running in debug mode")
 	}
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.Logger(), middleware.RequestIDMiddleware(), middleware.ErrorMiddleware(), middleware.RobotsTagMiddleware())
 
-	r.SetTrustedProxies([]string{"127.0.0.1", "::1"})
+	// 只有来自可信代理的请求才采用代理传递的客户端地址，之后所有地方统一使用 c.ClientIP()
+	if err := r.SetTrustedProxies(config.Cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid server.trusted_proxies: %v", err)
+	}
+	r.RemoteIPHeaders = config.Cfg.Server.RemoteIPHeaders
+	log.Printf("Trusting client IP headers %v from proxies %v", r.RemoteIPHeaders, config.Cfg.Server.TrustedProxies)
 	apiHandlers := api.NewAPIHandlers(storageManager)
+	// 维护模式下拒绝写操作，GET/HEAD 请求照常放行
+	readOnly := middleware.ReadOnlyMiddleware()
+	// 上传接口共用一个限流器，并在响应头中返回限流和存储配额信息
+	uploadRateLimit := middleware.RateLimitMiddleware(service.GetUploadRateLimitPerMinute)
+	quotaHeaders := middleware.QuotaHeadersMiddleware()
+	dailyUploadLimit := middleware.DailyUploadLimitMiddleware()
+	// 角色权限检查，需要放在认证中间件之后
+	canUpload := middleware.RequirePermission(service.PermUpload)
+	canManageTokens := middleware.RequirePermission(service.PermManageTokens)
+	// 管理后台的页面和接口只对允许的网段开放
+	adminAllowlist := middleware.AdminIPAllowlistMiddleware()
 
-	// Load templates and static files from embedded FS
-	templ := template.Must(template.ParseFS(templatesFS, "templates/*.html"))
-	r.SetHTMLTemplate(templ)
-	subStaticFS, err := fs.Sub(staticFS, "static")
-	if err != nil {
-		log.Fatalf("Failed to create sub filesystem for static files: %v", err)
-	}
-	r.StaticFS("/static", http.FS(subStaticFS))
-
-	r.Static("/uploads", "./uploads")
-
-	// Page routes
-	r.GET("/login", func(c *gin.Context) { c.HTML(http.StatusOK, "login.html", nil) })
-	r.GET("/", func(c *gin.Context) {
-		var backends []database.Backend
-		database.DB.Where("allow_upload = ?", true).Order("priority asc").Find(&backends)
-		maxUploadMB := service.GetMaxUploadMB()
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"Backends":    backends,
-			"MaxUploadMB": maxUploadMB,
-		})
-	})
-	r.GET("/admin", func(c *gin.Context) { c.HTML(http.StatusOK, "admin.html", nil) })
-	r.GET("/admin/images/:uuid", func(c *gin.Context) { c.HTML(http.StatusOK, "image_details.html", nil) })
+	r.GET("/robots.txt", api.RobotsTxtHandler)
+	r.GET("/sitemap.xml", api.SitemapHandler)
+	noRoute := registerFrontend(r, templatesFS, staticFS, adminAllowlist)
 
 	// Public routes
 	authGroup := r.Group("/auth")
 	{
-		authGroup.POST("/login", api.LoginHandler)
+		authGroup.POST("/login", middleware.RateLimitMiddleware(service.GetLoginRateLimitPerMinute), api.LoginHandler)
+		// 注册与登录共用同一个限流阈值，防止批量注册
+		authGroup.POST("/register", readOnly, middleware.RateLimitMiddleware(service.GetLoginRateLimitPerMinute), api.SelfRegisterHandler)
+		authGroup.GET("/registration", api.RegistrationStatusHandler)
+		authGroup.GET("/captcha", api.CaptchaInfoHandler)
+	}
+	// 图片访问的各个地址共用一个限流器
+	imageRateLimit := middleware.RateLimitMiddleware(service.GetImageRateLimitPerMinute)
+	// 图片地址同时响应 HEAD，方便下载工具和 CDN 预取
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		r.Handle(method, "/image/:filename", imageRateLimit, middleware.OptionalAuthMiddleware(), api.ServeImageHandler)
+		r.Handle(method, "/i/:filename", imageRateLimit, middleware.OptionalAuthMiddleware(), api.ServeImageHandler)
+		r.Handle(method, "/image/:filename/poster", imageRateLimit, middleware.OptionalAuthMiddleware(), api.ServePosterHandler)
+		r.Handle(method, "/p/:slug", imageRateLimit, middleware.OptionalAuthMiddleware(), api.ServeSlugHandler)
+		// 本地存储的文件地址与 /image 做相同的可见性、过期和隔离检查，未登记为图片的文件不对外提供
+		r.Handle(method, "/uploads/*filepath", imageRateLimit, middleware.SVGAttachmentMiddleware(), middleware.OptionalAuthMiddleware(), api.ServeLocalUploadHandler)
 	}
-	r.GET("/image/:filename", api.ServeImageHandler)
-	r.GET("/api/random", api.GetRandomImageRedirectHandler) // Random image API
+	// 分享链接与图片地址共用限流器，密码错误次数过多时还会按链接锁定
+	r.GET("/s/:token", imageRateLimit, api.ShareHandler)
+	randomRateLimit := middleware.RateLimitMiddleware(func() int { return service.GetRandomAPISettings().RateLimitPerMinute })
+	r.GET("/api/random", randomRateLimit, api.GetRandomImageRedirectHandler) // Random image API
+	r.GET("/api/public/albums/:id", api.GetPublicAlbumHandler)
 
 	// API routes requiring JWT Token (user and admin)
-	protectedApiGroup := r.Group("/api", middleware.AuthMiddleware())
+	protectedApiGroup := r.Group("/api", middleware.AuthMiddleware(), readOnly)
 	{
-		protectedApiGroup.POST("/upload/web", apiHandlers.UploadHandler)
+		protectedApiGroup.POST("/upload/web", uploadRateLimit, quotaHeaders, canUpload, dailyUploadLimit, apiHandlers.UploadHandler)
+		protectedApiGroup.POST("/upload/url", uploadRateLimit, quotaHeaders, canUpload, dailyUploadLimit, apiHandlers.UploadFromURLHandler)
+		protectedApiGroup.POST("/upload/hash", uploadRateLimit, quotaHeaders, canUpload, dailyUploadLimit, apiHandlers.InstantUploadHandler)
+		registerChunkedUploadRoutes(protectedApiGroup.Group("/upload/chunked", canUpload), apiHandlers)
 		protectedApiGroup.POST("/images/batch", apiHandlers.BatchUserImageHandler) // NEW: User batch endpoint
+		protectedApiGroup.POST("/images/sign", api.SignImageURLsHandler)
 
 		protectedApiGroup.GET("/user/info", api.GetUserInfoHandler)
+		protectedApiGroup.GET("/user/daily-uploads", api.GetDailyUploadStatusHandler)
+		protectedApiGroup.GET("/user/stats", api.GetMyStatsHandler)
 		protectedApiGroup.POST("/user/change-password", api.ChangeMyPasswordHandler)
-		protectedApiGroup.GET("/user/tokens", api.ListAPITokensHandler)
-		protectedApiGroup.POST("/user/tokens", api.CreateAPITokenHandler)
-		protectedApiGroup.POST("/user/tokens/:id/toggle", api.ToggleAPITokenStatusHandler)
-		protectedApiGroup.DELETE("/user/tokens/:id", api.DeleteAPITokenHandler)
-		protectedApiGroup.GET("/stats", api.GetStatsHandler)
+		protectedApiGroup.GET("/user/tokens", canManageTokens, api.ListAPITokensHandler)
+		protectedApiGroup.POST("/user/tokens", canManageTokens, api.CreateAPITokenHandler)
+		protectedApiGroup.POST("/user/tokens/:id/toggle", canManageTokens, api.ToggleAPITokenStatusHandler)
+		protectedApiGroup.POST("/user/tokens/:id/rotate", canManageTokens, api.RotateAPITokenHandler)
+		protectedApiGroup.DELETE("/user/tokens/:id", canManageTokens, api.DeleteAPITokenHandler)
+		protectedApiGroup.GET("/user/tokens/:id/usage", canManageTokens, api.GetAPITokenUsageHandler)
+		protectedApiGroup.GET("/user/presets", api.ListUploadPresetsHandler)
+		protectedApiGroup.POST("/user/presets", api.CreateUploadPresetHandler)
+		protectedApiGroup.PUT("/user/presets/:id", api.UpdateUploadPresetHandler)
+		protectedApiGroup.DELETE("/user/presets/:id", api.DeleteUploadPresetHandler)
+		protectedApiGroup.GET("/albums", api.ListAlbumsHandler)
+		protectedApiGroup.POST("/albums", api.CreateAlbumHandler)
+		protectedApiGroup.GET("/albums/:id", api.GetAlbumHandler)
+		protectedApiGroup.PUT("/albums/:id", api.UpdateAlbumHandler)
+		protectedApiGroup.DELETE("/albums/:id", api.DeleteAlbumHandler)
+		protectedApiGroup.GET("/albums/:id/images", api.ListAlbumImagesHandler)
+		protectedApiGroup.GET("/shares", api.ListShareLinksHandler)
+		protectedApiGroup.POST("/shares", api.CreateShareLinkHandler)
+		protectedApiGroup.DELETE("/shares/:id", api.DeleteShareLinkHandler)
+		protectedApiGroup.GET("/stats/bandwidth", api.GetBandwidthStatsHandler)
 		protectedApiGroup.GET("/images/recent", api.ListRecentImagesHandler)
-		protectedApiGroup.GET("/images", api.ListImagesHandler)
-		protectedApiGroup.DELETE("/images/:uuid", apiHandlers.DeleteImageHandler)
+		protectedApiGroup.GET("/images/compare", api.CompareImagesHandler)
+		protectedApiGroup.PATCH("/images/:uuid", api.UpdateImageHandler)
+		protectedApiGroup.POST("/images/:uuid/toggle-random", middleware.RequirePermission(service.PermRandomPool), api.ToggleMyImageRandomStatusHandler)
+		protectedApiGroup.POST("/images/:uuid/visibility", api.SetImageVisibilityHandler)
+		protectedApiGroup.GET("/images/:uuid/slug", api.GetImageSlugHandler)
+		protectedApiGroup.PUT("/images/:uuid/slug", api.SetImageSlugHandler)
+		protectedApiGroup.DELETE("/images/:uuid/slug", api.DeleteImageSlugHandler)
+		protectedApiGroup.POST("/images/:uuid/tags", api.AddImageTagsHandler)
+		protectedApiGroup.DELETE("/images/:uuid/tags/:tag", api.RemoveImageTagHandler)
+		protectedApiGroup.GET("/tags", api.SuggestTagsHandler)
 		protectedApiGroup.GET("/backends", api.ListBackendsHandler)
 		protectedApiGroup.GET("/settings", api.GetSettingsHandler)
 	}
 
+	// 以下接口同时接受 JWT 和 API Token，API Token 需要具备对应的权限范围
+	tokenReadGroup := r.Group("/api", middleware.CombinedAuthMiddleware(service.TokenScopeRead))
+	{
+		tokenReadGroup.GET("/stats", api.GetStatsHandler)
+		tokenReadGroup.GET("/images", api.ListImagesHandler)
+		tokenReadGroup.GET("/images/:uuid/metadata", api.GetImageMetadataHandler)
+	}
+	r.DELETE("/api/images/:uuid", readOnly, middleware.CombinedAuthMiddleware(service.TokenScopeDelete), apiHandlers.DeleteImageHandler)
+
+	// Replication routes for mirror instances
+	replicationGroup := r.Group("/api/replication", middleware.ReplicationAuthMiddleware())
+	{
+		replicationGroup.GET("/images", api.ListReplicationChangesHandler)
+		replicationGroup.GET("/images/:uuid/file", api.GetReplicationFileHandler)
+	}
+
 	// API route for API token uploads
-	r.POST("/api/upload/api", middleware.APITokenAuthMiddleware(), apiHandlers.UploadHandler)
+	r.POST("/api/upload/api", readOnly, uploadRateLimit, middleware.APITokenAuthMiddleware(service.TokenScopeUpload), quotaHeaders, canUpload, dailyUploadLimit, apiHandlers.UploadHandler)
+	r.POST("/api/upload/api/url", readOnly, uploadRateLimit, middleware.APITokenAuthMiddleware(service.TokenScopeUpload), quotaHeaders, canUpload, dailyUploadLimit, apiHandlers.UploadFromURLHandler)
+	r.POST("/api/upload/api/hash", readOnly, uploadRateLimit, middleware.APITokenAuthMiddleware(service.TokenScopeUpload), quotaHeaders, canUpload, dailyUploadLimit, apiHandlers.InstantUploadHandler)
+	registerChunkedUploadRoutes(r.Group("/api/upload/api/chunked", readOnly, middleware.APITokenAuthMiddleware(service.TokenScopeUpload), canUpload), apiHandlers)
 
 	// Admin-only API routes
-	adminApiGroup := r.Group("/api/admin", middleware.AuthMiddleware(), middleware.AdminAuthMiddleware())
+	// 带 admin 权限范围的 API Token 也可以调用管理接口
+	adminAuth := []gin.HandlerFunc{adminAllowlist, middleware.CombinedAuthMiddleware(service.TokenScopeAdmin), middleware.AdminAuthMiddleware()}
+	// 维护模式下仍然可用的管理接口：修改设置 (包括关闭维护模式) 和数据库维护、备份
+	adminMaintenanceGroup := r.Group("/api/admin", adminAuth...)
+	{
+		adminMaintenanceGroup.POST("/settings", api.SaveSettingsHandler)
+		adminMaintenanceGroup.POST("/maintenance/vacuum", api.VacuumDatabaseHandler)
+		adminMaintenanceGroup.POST("/maintenance/analyze", api.AnalyzeDatabaseHandler)
+		adminMaintenanceGroup.POST("/maintenance/backups", apiHandlers.RunDatabaseBackupHandler)
+	}
+	adminApiGroup := r.Group("/api/admin", append(adminAuth, readOnly)...)
 	{
 		adminApiGroup.GET("/backends/all", api.ListAllBackendsHandler)
+		adminApiGroup.GET("/backends/health", apiHandlers.GetBackendsHealthHandler)
 		adminApiGroup.POST("/backends", apiHandlers.CreateBackendHandler)
 		adminApiGroup.PUT("/backends/:id", apiHandlers.UpdateBackendHandler)
 		adminApiGroup.DELETE("/backends/:id", apiHandlers.DeleteBackendHandler)
+		adminApiGroup.POST("/backends/:id/decommission", apiHandlers.DecommissionBackendHandler)
 		adminApiGroup.POST("/backends/:id/toggle/:flag", apiHandlers.ToggleBackendFlagHandler)
+		adminApiGroup.POST("/backends/:id/locations/status", api.BatchToggleBackendLocationsHandler)
 		adminApiGroup.POST("/backends/smms/validate-token", api.ValidateSmmsTokenHandler)
 
-		adminApiGroup.POST("/settings", api.SaveSettingsHandler)
-
 		adminApiGroup.GET("/users", api.ListUsersHandler)
 		adminApiGroup.POST("/users", api.RegisterUserHandler)
 		adminApiGroup.POST("/users/:id/reset-password", api.ResetPasswordHandler)
-		adminApiGroup.DELETE("/users/:id", api.DeleteUserHandler)
+		adminApiGroup.DELETE("/users/:id", apiHandlers.DeleteUserHandler)
+		adminApiGroup.POST("/users/:id/toggle-watermark", api.ToggleUserWatermarkHandler)
+		adminApiGroup.POST("/users/:id/toggle-active", api.ToggleUserActiveHandler)
+		adminApiGroup.POST("/users/:id/quota", api.SetUserQuotaHandler)
+		adminApiGroup.POST("/users/:id/transfer-images", api.TransferUserImagesHandler)
+		adminApiGroup.GET("/users/:id/stats", api.GetUserStatsHandler)
+		adminApiGroup.GET("/tokens/usage", api.ListAPITokenUsageHandler)
+		adminApiGroup.GET("/stats/daily", api.GetDailyTrendsHandler)
 
 		adminApiGroup.POST("/images/batch", apiHandlers.BatchAdminImageHandler) // Renamed from BatchImageHandler
 		adminApiGroup.POST("/images/:uuid/toggle-random", api.ToggleImageRandomStatusHandler)
 		adminApiGroup.GET("/tasks", api.ListTasksHandler)
+		adminApiGroup.GET("/tasks/stream", api.StreamTasksHandler)
+		adminApiGroup.POST("/import/local", apiHandlers.ImportLocalDirectoryHandler)
 		adminApiGroup.GET("/images/:uuid", apiHandlers.GetImageDetailsHandler)
 		adminApiGroup.POST("/storagelocations/:id/toggle", api.ToggleStorageLocationStatusHandler)
+		adminApiGroup.GET("/operations", api.ListStorageOperationsHandler)
+		adminApiGroup.GET("/metrics/serving", api.GetServeMetricsHandler)
+		adminApiGroup.POST("/metrics/serving/reset", api.ResetServeMetricsHandler)
+		adminApiGroup.GET("/metrics/most-viewed", api.GetMostViewedHandler)
+		adminApiGroup.GET("/replicas/report", api.GetReplicaReportHandler)
+		adminApiGroup.POST("/replicas/reconcile", apiHandlers.ReconcileReplicasHandler)
+		adminApiGroup.POST("/integrity/check", api.StartIntegrityCheckHandler)
+		adminApiGroup.GET("/integrity/report", api.GetIntegrityReportHandler)
+		adminApiGroup.POST("/orphans/scan", apiHandlers.StartOrphanScanHandler)
+		adminApiGroup.GET("/orphans/report", api.GetOrphanReportHandler)
+		adminApiGroup.GET("/duplicates", api.ListDuplicatesHandler)
+		adminApiGroup.POST("/duplicates/resolve", apiHandlers.ResolveDuplicatesHandler)
+		adminApiGroup.GET("/deletions/pending", api.ListPendingDeletionsHandler)
+		adminApiGroup.POST("/deletions/pending/:id/cancel", api.CancelPendingDeletionHandler)
+		adminApiGroup.GET("/deletions/failed", api.ListFailedDeletionsHandler)
+		adminApiGroup.POST("/deletions/failed/:id/retry", apiHandlers.RetryFailedDeletionHandler)
+		adminApiGroup.DELETE("/deletions/failed/:id", api.DiscardFailedDeletionHandler)
+
+		adminApiGroup.GET("/moderation/queue", api.ListModerationQueueHandler)
+		adminApiGroup.POST("/moderation/:uuid/approve", api.ApproveModeratedImageHandler)
+		adminApiGroup.POST("/moderation/:uuid/reject", apiHandlers.RejectModeratedImageHandler)
+
+		adminApiGroup.GET("/rewrite-rules", api.ListRewriteRulesHandler)
+		adminApiGroup.POST("/rewrite-rules", api.CreateRewriteRuleHandler)
+		adminApiGroup.PUT("/rewrite-rules/:id", api.UpdateRewriteRuleHandler)
+		adminApiGroup.DELETE("/rewrite-rules/:id", api.DeleteRewriteRuleHandler)
+
+		adminApiGroup.GET("/maintenance/database", api.GetDatabaseInfoHandler)
+		adminApiGroup.GET("/maintenance/integrity-check", api.IntegrityCheckHandler)
+		adminApiGroup.GET("/maintenance/backups", api.ListDatabaseBackupsHandler)
+		adminApiGroup.GET("/metadata/export", api.ExportMetadataHandler)
+		adminApiGroup.POST("/metadata/import", apiHandlers.ImportMetadataHandler)
+
+		adminApiGroup.GET("/notifications", api.ListNotificationsHandler)
+		adminApiGroup.POST("/notifications/read", api.MarkNotificationsReadHandler)
+
+		adminApiGroup.GET("/webhooks", api.ListWebhooksHandler)
+		adminApiGroup.POST("/webhooks", api.CreateWebhookHandler)
+		adminApiGroup.PUT("/webhooks/:id", api.UpdateWebhookHandler)
+		adminApiGroup.DELETE("/webhooks/:id", api.DeleteWebhookHandler)
+		adminApiGroup.POST("/webhooks/:id/ping", api.PingWebhookHandler)
+		adminApiGroup.GET("/webhooks/:id/deliveries", api.ListWebhookDeliveriesHandler)
+		adminApiGroup.POST("/webhooks/deliveries/:id/redeliver", api.RedeliverWebhookHandler)
+
+		adminApiGroup.GET("/placeholder", api.GetPlaceholderHandler)
+		adminApiGroup.POST("/placeholder", api.UploadPlaceholderHandler)
+		adminApiGroup.DELETE("/placeholder", api.DeletePlaceholderHandler)
+
+		adminApiGroup.GET("/roles", api.ListRolesHandler)
+		adminApiGroup.POST("/roles", api.CreateRoleHandler)
+		adminApiGroup.PUT("/roles/:id", api.UpdateRoleHandler)
+		adminApiGroup.DELETE("/roles/:id", api.DeleteRoleHandler)
+
+		adminApiGroup.GET("/invites", api.ListInviteCodesHandler)
+		adminApiGroup.POST("/invites", api.CreateInviteCodesHandler)
+		adminApiGroup.DELETE("/invites/:id", api.RevokeInviteCodeHandler)
 	}
 
+	r.NoRoute(noRoute)
+
 	return r
 }
+
+// registerChunkedUploadRoutes 注册分片上传接口，网页登录和 API Token 两种认证方式共用
+func registerChunkedUploadRoutes(group *gin.RouterGroup, apiHandlers *api.APIHandlers) {
+	// 创建会话时提前检查每日上限，合并完成时再检查一次并计数
+	dailyUploadLimit := middleware.DailyUploadLimitMiddleware()
+	group.POST("", dailyUploadLimit, api.CreateUploadSessionHandler)
+	group.GET("/:id", api.GetUploadSessionHandler)
+	group.PUT("/:id/chunks/:index", api.PutUploadChunkHandler)
+	group.POST("/:id/complete", dailyUploadLimit, apiHandlers.CompleteUploadSessionHandler)
+	group.DELETE("/:id", api.AbortUploadSessionHandler)
+}
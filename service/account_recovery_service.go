@@ -0,0 +1,80 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"yanshu-imgbed/database"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 以下函数供命令行 (create-admin、reset-password) 使用，管理员被锁在后台之外时
+// 无需登录即可直接操作数据库
+
+// GenerateRandomPassword 生成一个 16 个字符的随机密码
+func GenerateRandomPassword() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CreateAdminUser 创建一个管理员账户，用户名已存在时返回 ErrUsernameTaken
+func CreateAdminUser(username, password string) (*database.User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("用户名只能包含字母、数字、下划线、点和连字符，长度 3-50")
+	}
+	var count int64
+	if err := database.DB.Model(&database.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrUsernameTaken
+	}
+	return RegisterUser(username, password, AdminRoleName)
+}
+
+// ResetPasswordByUsername 按用户名重置密码；activate 为 true 时同时解除停用
+func ResetPasswordByUsername(username, password string, activate bool) (*database.User, error) {
+	var user database.User
+	if err := database.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	updates := map[string]interface{}{"password": string(hashedPassword)}
+	if activate {
+		updates["is_active"] = true
+	}
+	if err := database.DB.Model(&user).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindUserID 按用户名查找用户 ID；username 为空时返回 ID 最小的管理员
+func FindUserID(username string) (uint, error) {
+	var user database.User
+	query := database.DB.Select("id")
+	if username != "" {
+		query = query.Where("username = ?", username)
+	} else {
+		query = query.Where("role = ?", AdminRoleName).Order("id asc")
+	}
+	if err := query.First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+	return user.ID, nil
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+// ErrAlbumNotFound 相册不存在、不属于当前用户或不是公开相册
+var ErrAlbumNotFound = errors.New("album not found")
+
+// maxAlbumNameLength 与 Album.Name 的列宽一致
+const maxAlbumNameLength = 100
+
+// AlbumInput 创建或更新相册的参数
+type AlbumInput struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CoverUUID   string `json:"cover_uuid"`
+	Visibility  string `json:"visibility"`
+}
+
+// AlbumSummary 相册及其图片数量
+type AlbumSummary struct {
+	database.Album
+	ImageCount int64 `json:"image_count"`
+}
+
+// PublicAlbumImage 公开相册中对外展示的图片信息，不包含上传者等内部字段
+type PublicAlbumImage struct {
+	UUID             string    `json:"uuid"`
+	OriginalFilename string    `json:"original_filename"`
+	AltText          string    `json:"alt_text"`
+	ContentType      string    `json:"content_type"`
+	Width            int       `json:"width"`
+	Height           int       `json:"height"`
+	ViewURL          string    `json:"view_url"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// PublicAlbumImages 公开相册的分页图片列表
+type PublicAlbumImages struct {
+	Total    int64              `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"pageSize"`
+	Images   []PublicAlbumImage `json:"images"`
+}
+
+// validate 规范化并校验相册参数，封面必须是该用户的图片
+func (in *AlbumInput) validate(userID uint) error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return &UploadRejectedError{Reason: "Album name is required"}
+	}
+	if len(in.Name) > maxAlbumNameLength {
+		return &UploadRejectedError{Reason: fmt.Sprintf("Album name must not exceed %d characters", maxAlbumNameLength)}
+	}
+	if in.Visibility == "" {
+		in.Visibility = VisibilityPrivate
+	}
+	var err error
+	if in.Visibility, err = NormalizeVisibility(in.Visibility); err != nil {
+		return err
+	}
+	in.CoverUUID = strings.TrimSpace(in.CoverUUID)
+	if in.CoverUUID != "" {
+		var count int64
+		if err := database.DB.Model(&database.Image{}).Where("uuid = ? AND user_id = ?", in.CoverUUID, userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return &UploadRejectedError{Reason: "Cover image not found"}
+		}
+	}
+	return nil
+}
+
+// apply 把参数写入相册记录
+func (in AlbumInput) apply(album *database.Album) {
+	album.Name = in.Name
+	album.Description = in.Description
+	album.CoverUUID = in.CoverUUID
+	album.Visibility = in.Visibility
+}
+
+// ListAlbums 列出用户的所有相册及各自的图片数量
+func ListAlbums(userID uint) ([]AlbumSummary, error) {
+	var albums []database.Album
+	if err := database.DB.Where("user_id = ?", userID).Order("name asc").Find(&albums).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		AlbumID uint
+		Count   int64
+	}
+	if err := database.DB.Model(&database.Image{}).
+		Select("album_id, COUNT(*) AS count").
+		Where("user_id = ? AND album_id IS NOT NULL", userID).
+		Group("album_id").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	countByAlbum := make(map[uint]int64, len(counts))
+	for _, c := range counts {
+		countByAlbum[c.AlbumID] = c.Count
+	}
+
+	summaries := make([]AlbumSummary, 0, len(albums))
+	for _, album := range albums {
+		summaries = append(summaries, AlbumSummary{Album: album, ImageCount: countByAlbum[album.ID]})
+	}
+	return summaries, nil
+}
+
+// GetAlbum 获取用户自己的相册
+func GetAlbum(id, userID uint) (*database.Album, error) {
+	var album database.Album
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&album).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAlbumNotFound
+		}
+		return nil, err
+	}
+	return &album, nil
+}
+
+// GetPublicAlbum 获取公开相册，私有相册按不存在处理
+func GetPublicAlbum(id uint) (*database.Album, error) {
+	var album database.Album
+	if err := database.DB.Where("id = ? AND visibility = ?", id, VisibilityPublic).First(&album).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAlbumNotFound
+		}
+		return nil, err
+	}
+	return &album, nil
+}
+
+// CreateAlbum 为用户创建相册
+func CreateAlbum(userID uint, in AlbumInput) (*database.Album, error) {
+	if err := in.validate(userID); err != nil {
+		return nil, err
+	}
+	album := database.Album{UserID: userID}
+	in.apply(&album)
+	if err := database.DB.Create(&album).Error; err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// UpdateAlbum 更新用户自己的相册
+func UpdateAlbum(id, userID uint, in AlbumInput) (*database.Album, error) {
+	album, err := GetAlbum(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := in.validate(userID); err != nil {
+		return nil, err
+	}
+	in.apply(album)
+	// 显式保存所有列，否则清空描述或封面不会写入
+	if err := database.DB.Select("*").Save(album).Error; err != nil {
+		return nil, err
+	}
+	return album, nil
+}
+
+// DeleteAlbum 删除用户自己的相册，相册中的图片保留并移出相册
+func DeleteAlbum(id, userID uint) error {
+	album, err := GetAlbum(id, userID)
+	if err != nil {
+		return err
+	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.Image{}).Where("album_id = ?", album.ID).Update("album_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("album_id = ?", album.ID).Delete(&database.ShareLink{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(album).Error
+	})
+}
+
+// ResolveUploadAlbum 确认上传指定的相册属于该用户，返回可直接写入 UploadOptions 的相册 ID
+func ResolveUploadAlbum(albumID, userID uint) (*uint, error) {
+	album, err := GetAlbum(albumID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &album.ID, nil
+}
+
+// MoveImagesToAlbum 把用户自己的图片移入相册，albumID 为 0 表示移出相册
+func MoveImagesToAlbum(imageUUIDs []string, albumID, userID uint) error {
+	var count int64
+	database.DB.Model(&database.Image{}).Where("uuid IN ? AND user_id = ?", imageUUIDs, userID).Count(&count)
+	if count != int64(len(imageUUIDs)) {
+		return ErrNotImageOwner
+	}
+
+	var target interface{}
+	if albumID != 0 {
+		if _, err := GetAlbum(albumID, userID); err != nil {
+			return err
+		}
+		target = albumID
+	}
+	return database.DB.Model(&database.Image{}).Where("uuid IN ?", imageUUIDs).Update("album_id", target).Error
+}
+
+// ListPublicAlbumImages 分页列出公开相册中可访问的图片，私有图片不会出现在公开相册中
+func ListPublicAlbumImages(album *database.Album, page, pageSize int) (*PublicAlbumImages, error) {
+	return listAlbumImages(album, false, page, pageSize)
+}
+
+// ListSharedAlbumImages 分页列出通过分享链接访问的相册图片，包括私有图片，地址都带有签名
+func ListSharedAlbumImages(album *database.Album, page, pageSize int) (*PublicAlbumImages, error) {
+	return listAlbumImages(album, true, page, pageSize)
+}
+
+// listAlbumImages 列出相册中可对外展示的图片；includePrivate 为 true 时包括私有图片并总是签名
+func listAlbumImages(album *database.Album, includePrivate bool, page, pageSize int) (*PublicAlbumImages, error) {
+	query := database.DB.Model(&database.Image{}).
+		Where("album_id = ?", album.ID).
+		Where("(moderation_status <> ? OR moderation_status IS NULL)", ModerationQuarantined).
+		Where("(expires_at IS NULL OR expires_at > ?)", time.Now())
+	if !includePrivate {
+		query = query.Where("visibility <> ?", VisibilityPrivate)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var images []database.Image
+	if err := query.Order("created_at desc").Limit(pageSize).Offset((page - 1) * pageSize).Find(&images).Error; err != nil {
+		return nil, err
+	}
+
+	// 强制签名或包含私有图片时，签发与默认有效期相同的地址
+	sign := includePrivate || GetRequireSignedURLs()
+	signedUntil := time.Now().Add(defaultSignedURLTTL)
+	result := &PublicAlbumImages{Total: total, Page: page, PageSize: pageSize, Images: make([]PublicAlbumImage, 0, len(images))}
+	for _, image := range images {
+		viewURL := ImageViewPath(image.UUID, image.ContentType)
+		if sign {
+			viewURL = SignedImagePath(image.UUID, image.ContentType, signedUntil)
+		}
+		result.Images = append(result.Images, PublicAlbumImage{
+			UUID:             image.UUID,
+			OriginalFilename: image.OriginalFilename,
+			AltText:          image.AltText,
+			ContentType:      image.ContentType,
+			Width:            image.Width,
+			Height:           image.Height,
+			ViewURL:          viewURL,
+			CreatedAt:        image.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+// applyDuplicateAlbum 同一用户重复上传并指定了相册时，把已有图片移入该相册
+func applyDuplicateAlbum(image *database.Image, opts UploadOptions) {
+	if opts.AlbumID == nil {
+		return
+	}
+	image.AlbumID = opts.AlbumID
+	database.DB.Model(image).Update("album_id", *opts.AlbumID)
+}
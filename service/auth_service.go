@@ -1,8 +1,11 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 	"yanshu-imgbed/config"
 	"yanshu-imgbed/database"
@@ -34,6 +37,10 @@ func Login(username, password string) (string, error) {
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
 		return "", errors.New("用户名或密码错误")
 	}
+	// 密码正确后再提示停用，不向猜测密码的人暴露账户状态
+	if !user.IsActive {
+		return "", ErrUserSuspended
+	}
 
 	// 使用配置生成JWT Token
 	expirationTime := time.Now().Add(time.Duration(config.Cfg.JWT.ExpirationHours) * time.Hour) // 使用配置的过期时间
@@ -107,32 +114,151 @@ func ResetUserPassword(userID uint, newPassword string) error {
 	return database.DB.Save(&user).Error
 }
 
-// DeleteUser 删除用户 (管理员权限)
-func DeleteUser(userID uint) error {
-	// TODO: 删除用户时，还需要处理该用户上传的图片和API Token
-	// 可以选择：
-	// 1. 将图片和Token转交给一个默认用户
-	// 2. 彻底删除所有关联数据
-	// 简单起见，这里先只删除用户和其API Token
-	return database.DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Where("user_id = ?", userID).Delete(&database.APIToken{}).Error; err != nil {
-			return err
+// ToggleUserWatermark 切换用户的默认水印开关 (管理员权限)
+func ToggleUserWatermark(userID uint) (*database.User, error) {
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	user.WatermarkDisabled = !user.WatermarkDisabled
+	if err := database.DB.Model(&user).Update("watermark_disabled", user.WatermarkDisabled).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// APITokenUploadBinding 委托上传 Token 绑定的文件夹和处理预设，零值表示不绑定
+type APITokenUploadBinding struct {
+	Folder           string
+	Watermark        *bool
+	Compress         *bool
+	FilenameStrategy string // 仅作为默认值，不覆盖客户端显式指定的策略
+	BackendIDs       []uint
+	// DailyUploadLimit / DailyUploadMB 该 Token 每天的上传上限，在用户的每日上限之外额外生效
+	DailyUploadLimit int64
+	DailyUploadMB    int64
+}
+
+// ApplyAPITokenBinding 用 Token 绑定的值覆盖客户端传入的上传参数，返回实际使用的目标后端
+func ApplyAPITokenBinding(token *database.APIToken, targetBackendIDs []uint, opts *UploadOptions) []uint {
+	if len(token.BackendIDs) > 0 {
+		var bound []uint
+		if err := json.Unmarshal(token.BackendIDs, &bound); err != nil {
+			log.Printf("Ignoring unreadable backend binding of API token %d: %v", token.ID, err)
+		} else if len(bound) > 0 {
+			targetBackendIDs = bound
 		}
-		if err := tx.Delete(&database.User{}, userID).Error; err != nil {
-			return err
+	}
+	if token.UploadFolder != "" {
+		opts.Folder = token.UploadFolder
+	}
+	if token.UploadWatermark != nil {
+		opts.Watermark = token.UploadWatermark
+	}
+	if token.UploadCompress != nil {
+		opts.Compress = token.UploadCompress
+	}
+	if opts.FilenameStrategy == "" {
+		opts.FilenameStrategy = token.FilenameStrategy
+	}
+	return targetBackendIDs
+}
+
+// API Token 的权限范围，admin 包含其他所有范围
+const (
+	TokenScopeUpload = "upload"
+	TokenScopeRead   = "read"
+	TokenScopeDelete = "delete"
+	TokenScopeAdmin  = "admin"
+)
+
+var tokenScopeOrder = []string{TokenScopeUpload, TokenScopeRead, TokenScopeDelete, TokenScopeAdmin}
+
+// NormalizeTokenScopes 校验并去重权限范围，返回按固定顺序拼接的字符串。
+// 未指定时只授予 upload，和引入权限范围之前的 Token 一致；只有管理员能创建 admin 范围的 Token
+func NormalizeTokenScopes(scopes []string, ownerRole string) (string, error) {
+	wanted := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" {
+			continue
 		}
-		return nil
-	})
+		known := false
+		for _, s := range tokenScopeOrder {
+			if s == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return "", &UploadRejectedError{Reason: fmt.Sprintf("Unknown token scope %q, expected one of: %s", scope, strings.Join(tokenScopeOrder, ", "))}
+		}
+		wanted[scope] = true
+	}
+	if len(wanted) == 0 {
+		return TokenScopeUpload, nil
+	}
+	if wanted[TokenScopeAdmin] && !HasPermission(ownerRole, PermManageSystem) {
+		return "", &UploadRejectedError{Reason: "Only administrators can create tokens with the admin scope"}
+	}
+	normalized := make([]string, 0, len(wanted))
+	for _, s := range tokenScopeOrder {
+		if wanted[s] {
+			normalized = append(normalized, s)
+		}
+	}
+	return strings.Join(normalized, ","), nil
+}
+
+// TokenHasScope 判断 Token 是否具备指定的权限范围
+func TokenHasScope(token *database.APIToken, scope string) bool {
+	scopes := token.Scopes
+	if scopes == "" {
+		scopes = TokenScopeUpload
+	}
+	for _, s := range strings.Split(scopes, ",") {
+		if s == scope || s == TokenScopeAdmin {
+			return true
+		}
+	}
+	return false
 }
 
-// CreateAPIToken 为用户创建API Token
-func CreateAPIToken(userID uint, name string) (*database.APIToken, error) {
+// APITokenRole 返回通过 Token 访问时使用的角色：没有 admin 范围的 Token 即使属于管理员也只按普通用户处理
+func APITokenRole(token *database.APIToken) string {
+	if HasPermission(token.User.Role, PermManageSystem) && !TokenHasScope(token, TokenScopeAdmin) {
+		return UserRoleName
+	}
+	return token.User.Role
+}
+
+// APITokenExpired 判断 Token 是否已过有效期，ExpiresAt 为空表示永不过期
+func APITokenExpired(token *database.APIToken) bool {
+	return token.ExpiresAt != nil && !token.ExpiresAt.After(time.Now())
+}
+
+// CreateAPIToken 为用户创建API Token，scopes 需先经过 NormalizeTokenScopes，expiresAt 为空表示永不过期
+func CreateAPIToken(userID uint, name, scopes string, expiresAt *time.Time, binding APITokenUploadBinding) (*database.APIToken, error) {
 	tokenValue := uuid.New().String() // 生成随机Token值
 	apiToken := database.APIToken{
-		UserID:   userID,
-		Token:    tokenValue,
-		Name:     name,
-		IsActive: true,
+		UserID:           userID,
+		Token:            tokenValue,
+		Name:             name,
+		IsActive:         true,
+		Scopes:           scopes,
+		ExpiresAt:        expiresAt,
+		UploadFolder:     binding.Folder,
+		UploadWatermark:  binding.Watermark,
+		UploadCompress:   binding.Compress,
+		FilenameStrategy: binding.FilenameStrategy,
+		DailyUploadLimit: binding.DailyUploadLimit,
+		DailyUploadMB:    binding.DailyUploadMB,
+	}
+	if len(binding.BackendIDs) > 0 {
+		if err := ValidateBackendIDs(binding.BackendIDs); err != nil {
+			return nil, err
+		}
+		apiToken.BackendIDs, _ = json.Marshal(binding.BackendIDs)
 	}
 	if err := database.DB.Create(&apiToken).Error; err != nil {
 		return nil, err
@@ -140,6 +266,24 @@ func CreateAPIToken(userID uint, name string) (*database.APIToken, error) {
 	return &apiToken, nil
 }
 
+// RotateAPIToken 为 Token 生成新的值，旧值立即失效，名称、权限范围、绑定和用量统计保持不变。
+// 用一条 UPDATE 完成替换，不会出现新旧值同时有效或都无效的窗口
+func RotateAPIToken(tokenID uint) (*database.APIToken, error) {
+	newValue := uuid.New().String()
+	result := database.DB.Model(&database.APIToken{}).Where("id = ?", tokenID).Update("token", newValue)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("API Token not found")
+	}
+	var apiToken database.APIToken
+	if err := database.DB.First(&apiToken, tokenID).Error; err != nil {
+		return nil, err
+	}
+	return &apiToken, nil
+}
+
 // ToggleAPITokenStatus 启用/禁用API Token
 func ToggleAPITokenStatus(tokenID uint) (*database.APIToken, error) {
 	var apiToken database.APIToken
@@ -155,7 +299,12 @@ func ToggleAPITokenStatus(tokenID uint) (*database.APIToken, error) {
 
 // DeleteAPIToken 删除API Token
 func DeleteAPIToken(tokenID uint) error {
-	return database.DB.Delete(&database.APIToken{}, tokenID).Error
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&database.APITokenUsage{}, "token_id = ?", tokenID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&database.APIToken{}, tokenID).Error
+	})
 }
 
 // GetUserAPITokens 获取用户的API Token列表
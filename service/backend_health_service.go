@@ -0,0 +1,154 @@
+package service
+
+import (
+	"sync"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+)
+
+// BackendHealth 单个存储后端的健康状况汇总
+type BackendHealth struct {
+	BackendID     uint   `json:"backend_id"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	AllowUpload   bool   `json:"allow_upload"`
+	AllowRedirect bool   `json:"allow_redirect"`
+	// Loaded 存储管理器中是否成功加载了该后端的 Uploader
+	Loaded bool `json:"loaded"`
+	// Reachable 探测最近一个存储对象的结果，没有可探测对象时为 null
+	Reachable            *bool      `json:"reachable"`
+	LastSuccessfulUpload *time.Time `json:"last_successful_upload"`
+	// 统计窗口内的操作数、失败数、错误率和平均耗时
+	WindowHours  int     `json:"window_hours"`
+	Operations   int64   `json:"operations"`
+	Failures     int64   `json:"failures"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	// ObjectCount/StoredBytes 按物理文件去重 (共享文件只计一次)
+	ObjectCount int64 `json:"object_count"`
+	StoredBytes int64 `json:"stored_bytes"`
+	// Breaker 后台健康检查维护的熔断状态
+	Breaker BreakerState `json:"breaker"`
+}
+
+// GetBackendsHealth 汇总所有后端的可达性、操作统计和存储用量
+func GetBackendsHealth(windowHours int, storageManager *manager.StorageManager) ([]BackendHealth, error) {
+	if windowHours <= 0 {
+		windowHours = 24
+	}
+	var backends []database.Backend
+	if err := database.DB.Order("priority asc").Find(&backends).Error; err != nil {
+		return nil, err
+	}
+
+	type opStats struct {
+		BackendID    uint
+		Operations   int64
+		Failures     int64
+		AvgLatencyMs float64
+	}
+	var stats []opStats
+	since := time.Now().Add(-time.Duration(windowHours) * time.Hour)
+	err := database.DB.Model(&database.StorageOperation{}).
+		Select("backend_id, COUNT(*) AS operations, SUM(CASE WHEN success THEN 0 ELSE 1 END) AS failures, AVG(duration_ms) AS avg_latency_ms").
+		Where("created_at >= ?", since).Group("backend_id").Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	statsByBackend := make(map[uint]opStats, len(stats))
+	for _, s := range stats {
+		statsByBackend[s.BackendID] = s
+	}
+
+	type lastUpload struct {
+		BackendID uint
+		Last      string
+	}
+	var lastUploads []lastUpload
+	err = database.DB.Model(&database.StorageOperation{}).
+		Select("backend_id, MAX(created_at) AS last").
+		Where("success = ? AND operation IN ?", true, []string{OperationUpload, OperationBackfill}).
+		Group("backend_id").Scan(&lastUploads).Error
+	if err != nil {
+		return nil, err
+	}
+	lastByBackend := make(map[uint]time.Time, len(lastUploads))
+	for _, l := range lastUploads {
+		// SQLite 的聚合结果是字符串，需要手动解析
+		for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano} {
+			if t, err := time.Parse(layout, l.Last); err == nil {
+				lastByBackend[l.BackendID] = t
+				break
+			}
+		}
+	}
+
+	type usage struct {
+		BackendID   uint
+		ObjectCount int64
+		StoredBytes int64
+	}
+	var usages []usage
+	err = database.DB.Raw(`SELECT backend_id, COUNT(*) AS object_count, COALESCE(SUM(size), 0) AS stored_bytes
+		FROM (SELECT sl.backend_id, sl.url, MAX(i.file_size) AS size
+			FROM storage_locations sl JOIN images i ON i.id = sl.image_id
+			GROUP BY sl.backend_id, sl.url) AS objects
+		GROUP BY backend_id`).Scan(&usages).Error
+	if err != nil {
+		return nil, err
+	}
+	usageByBackend := make(map[uint]usage, len(usages))
+	for _, u := range usages {
+		usageByBackend[u.BackendID] = u
+	}
+
+	result := make([]BackendHealth, len(backends))
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		_, loaded := storageManager.Get(b.ID)
+		s := statsByBackend[b.ID]
+		u := usageByBackend[b.ID]
+		h := BackendHealth{
+			BackendID:     b.ID,
+			Name:          b.Name,
+			Type:          b.Type,
+			AllowUpload:   b.AllowUpload,
+			AllowRedirect: b.AllowRedirect,
+			Loaded:        loaded,
+			WindowHours:   windowHours,
+			Operations:    s.Operations,
+			Failures:      s.Failures,
+			AvgLatencyMs:  s.AvgLatencyMs,
+			ObjectCount:   u.ObjectCount,
+			StoredBytes:   u.StoredBytes,
+			Breaker:       GetBreakerState(b.ID),
+		}
+		if s.Operations > 0 {
+			h.ErrorRate = float64(s.Failures) / float64(s.Operations)
+		}
+		if last, ok := lastByBackend[b.ID]; ok {
+			h.LastSuccessfulUpload = &last
+		}
+		result[i] = h
+
+		// 可达性探测涉及网络请求，并发执行
+		wg.Add(1)
+		go func(i int, backendID uint) {
+			defer wg.Done()
+			result[i].Reachable = probeBackend(backendID)
+		}(i, b.ID)
+	}
+	wg.Wait()
+	return result, nil
+}
+
+// probeBackend 检查后端最近写入的一个对象是否仍可访问，没有对象时返回 nil
+func probeBackend(backendID uint) *bool {
+	var loc database.StorageLocation
+	if err := database.DB.Where("backend_id = ? AND is_active = ?", backendID, true).Order("id desc").First(&loc).Error; err != nil {
+		return nil
+	}
+	reachable := checkLocationHealth(&loc)
+	return &reachable
+}
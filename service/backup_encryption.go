@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// 加密备份的文件格式：
+//
+//	"IMGBKENC" | 版本 (1 字节) | scrypt 盐 (16 字节) | nonce 前缀 (7 字节) | 密文块...
+//
+// 明文按 64 KiB 分块，用由 backup.encryption_key 派生的密钥做 AES-256-GCM 加密，
+// 每块的 nonce 为前缀 + 4 字节块序号 + 1 字节结束标记。最后一块明文不足 64 KiB (可以为空) 并带结束标记，
+// 文件被截断、块被调换或密钥错误都会导致解密失败
+const (
+	backupMagic           = "IMGBKENC"
+	backupFormatVersion   = 1
+	backupChunkSize       = 64 * 1024
+	backupSaltSize        = 16
+	backupNoncePrefixSize = 7
+)
+
+var (
+	// ErrBackupKeyMissing 备份已加密，但没有配置 backup.encryption_key
+	ErrBackupKeyMissing = errors.New("backup is encrypted but backup.encryption_key is not configured")
+	// ErrBackupDecrypt 密钥不正确或备份文件已损坏
+	ErrBackupDecrypt = errors.New("failed to decrypt backup: wrong encryption key or corrupted file")
+)
+
+// backupCipher 用 scrypt 从配置的密钥和盐派生 AES-256-GCM 密钥
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupNonce 第 counter 块的 nonce，last 表示最后一块
+func backupNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, backupNoncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[backupNoncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptBackupFile 把 src 加密后写入 dst
+func encryptBackupFile(src, dst, passphrase string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	header := make([]byte, len(backupMagic)+1+backupSaltSize+backupNoncePrefixSize)
+	copy(header, backupMagic)
+	header[len(backupMagic)] = backupFormatVersion
+	if _, err := rand.Read(header[len(backupMagic)+1:]); err != nil {
+		return err
+	}
+	salt := header[len(backupMagic)+1 : len(backupMagic)+1+backupSaltSize]
+	prefix := header[len(backupMagic)+1+backupSaltSize:]
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	buf := make([]byte, backupChunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(in, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		if _, err := out.Write(aead.Seal(nil, backupNonce(prefix, counter, last), buf[:n], nil)); err != nil {
+			return err
+		}
+		if last {
+			break
+		}
+	}
+	return out.Close()
+}
+
+// openBackupPlaintext 返回备份的 gzip 内容：加密的备份用 passphrase 边读边解密，
+// 加密功能上线前的备份没有文件头，原样返回
+func openBackupPlaintext(src io.Reader, passphrase string) (io.Reader, error) {
+	br := bufio.NewReader(src)
+	magic, err := br.Peek(len(backupMagic))
+	if err != nil || string(magic) != backupMagic {
+		return br, nil
+	}
+	if passphrase == "" {
+		return nil, ErrBackupKeyMissing
+	}
+
+	header := make([]byte, len(backupMagic)+1+backupSaltSize+backupNoncePrefixSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, ErrBackupDecrypt
+	}
+	if header[len(backupMagic)] != backupFormatVersion {
+		return nil, errors.New("unsupported encrypted backup version")
+	}
+	aead, err := backupCipher(passphrase, header[len(backupMagic)+1:len(backupMagic)+1+backupSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	return &backupDecryptReader{
+		src:    br,
+		aead:   aead,
+		prefix: header[len(backupMagic)+1+backupSaltSize:],
+		chunk:  make([]byte, backupChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// backupDecryptReader 逐块解密加密的备份
+type backupDecryptReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte // 已解密但还没有读出的明文
+	done    bool
+}
+
+func (r *backupDecryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// nextChunk 读取并解密下一块，不足一整块的是最后一块
+func (r *backupDecryptReader) nextChunk() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	last := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !last {
+		return err
+	}
+	// 缺少带结束标记的最后一块说明文件被截断
+	if n < r.aead.Overhead() {
+		return ErrBackupDecrypt
+	}
+	plain, err := r.aead.Open(r.chunk[:0], backupNonce(r.prefix, r.counter, last), r.chunk[:n], nil)
+	if err != nil {
+		return ErrBackupDecrypt
+	}
+	r.plain = plain
+	r.counter++
+	r.done = last
+	return nil
+}
@@ -0,0 +1,359 @@
+package service
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// backupRetryDelay 定时备份失败后再次尝试前的等待时间
+const backupRetryDelay = time.Hour
+
+var (
+	// ErrBackupNotConfigured 未配置 backup.backend 或该后端不存在
+	ErrBackupNotConfigured = errors.New("database backup backend is not configured")
+	// ErrBackupRunning 已有备份正在进行
+	ErrBackupRunning = errors.New("a database backup is already running")
+)
+
+// backupMu 保证同一时间只有一个备份在进行
+var backupMu sync.Mutex
+
+// InitBackupScheduler 启动后台任务，按 backup.interval_hours 定期备份数据库到指定后端。
+// 下次备份时间根据最近一次成功的备份计算，重启不会打乱备份周期
+func InitBackupScheduler(storageManager *manager.StorageManager) {
+	cfg := config.Cfg.Backup
+	if cfg.Backend == "" || cfg.IntervalHours <= 0 {
+		return
+	}
+	if cfg.EncryptionKey == "" {
+		log.Printf("Database backups to backend %q are disabled: backup.encryption_key is not set.", cfg.Backend)
+		return
+	}
+	interval := time.Duration(cfg.IntervalHours) * time.Hour
+	go func() {
+		var lastFailure time.Time
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			if time.Since(lastFailure) < backupRetryDelay || !backupDue(interval) {
+				continue
+			}
+			if _, err := RunDatabaseBackup(storageManager); err != nil && !errors.Is(err, ErrBackupRunning) {
+				lastFailure = time.Now()
+				log.Printf("Scheduled database backup failed: %v", err)
+				NotifyAdmin(NotificationBackupFailed, fmt.Sprintf("数据库定时备份失败: %v", err))
+			}
+		}
+	}()
+	log.Printf("Database backups to backend %q scheduled every %d hour(s), keeping %d.", cfg.Backend, cfg.IntervalHours, cfg.Retention)
+}
+
+// backupDue 距离最近一次成功的备份是否已超过间隔
+func backupDue(interval time.Duration) bool {
+	var last database.DatabaseBackup
+	err := database.DB.Order("created_at desc").Limit(1).Find(&last).Error
+	if err != nil {
+		log.Printf("Failed to load last database backup: %v", err)
+		return false
+	}
+	return last.ID == 0 || time.Since(last.CreatedAt) >= interval
+}
+
+// ListDatabaseBackups 返回后端上现有的数据库备份，最新的在前
+func ListDatabaseBackups() ([]database.DatabaseBackup, error) {
+	var backups []database.DatabaseBackup
+	err := database.DB.Order("created_at desc").Find(&backups).Error
+	return backups, err
+}
+
+// RunDatabaseBackup 导出数据库并以 gzip 压缩、用 backup.encryption_key 加密后上传到 backup.backend，随后按 backup.retention 清理旧备份。
+// SQLite 使用 VACUUM INTO 生成一致的快照，MySQL 调用 mysqldump。
+// 备份中有登录凭据、Token 和后端密钥，未配置加密密钥时拒绝备份；文件名带随机部分，无法按时间猜出
+func RunDatabaseBackup(storageManager *manager.StorageManager) (*database.DatabaseBackup, error) {
+	if !backupMu.TryLock() {
+		return nil, ErrBackupRunning
+	}
+	defer backupMu.Unlock()
+
+	cfg := config.Cfg.Backup
+	if cfg.Backend == "" {
+		return nil, ErrBackupNotConfigured
+	}
+	if cfg.EncryptionKey == "" {
+		return nil, fmt.Errorf("%w: backup.encryption_key is required", ErrBackupNotConfigured)
+	}
+	var backend database.Backend
+	if err := database.DB.Where("name = ?", cfg.Backend).First(&backend).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: backend %q not found", ErrBackupNotConfigured, cfg.Backend)
+		}
+		return nil, err
+	}
+	uploader, found := storageManager.Get(backend.ID)
+	if !found {
+		return nil, fmt.Errorf("%w: backend %q is not loaded", ErrBackupNotConfigured, cfg.Backend)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "imgbed-backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ext := ".sql.gz"
+	if isSQLite() {
+		ext = ".db.gz"
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	filename := "imgbed-backup-" + time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix) + ext + ".enc"
+	dump := filepath.Join(tmpDir, "dump"+ext)
+	if err := dumpDatabase(tmpDir, dump); err != nil {
+		return nil, fmt.Errorf("failed to dump database: %w", err)
+	}
+	archive := filepath.Join(tmpDir, filename)
+	if err := encryptBackupFile(dump, archive, cfg.EncryptionKey); err != nil {
+		return nil, fmt.Errorf("failed to encrypt backup: %w", err)
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	result, err := uploader.UploadFromFile(archive, filename)
+	recordStorageOperation(OperationUpload, backend.ID, filename, start, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload backup to backend %q: %w", backend.Name, err)
+	}
+	finalURL, deleteIdentifier := parseUploadResult(result, uploader.Type())
+	if deleteIdentifier == "" {
+		deleteIdentifier = filename
+	}
+	backup := database.DatabaseBackup{
+		BackendID:        backend.ID,
+		Filename:         filename,
+		URL:              finalURL,
+		DeleteIdentifier: deleteIdentifier,
+		Size:             info.Size(),
+	}
+	if err := database.DB.Create(&backup).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("Database backup %s (%d bytes) uploaded to backend %q.", filename, info.Size(), backend.Name)
+
+	pruneDatabaseBackups(cfg.Retention, storageManager)
+	return &backup, nil
+}
+
+// dumpDatabase 把当前数据库导出为 gzip 压缩文件
+func dumpDatabase(tmpDir, archive string) error {
+	out, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+
+	if isSQLite() {
+		snapshot := filepath.Join(tmpDir, "snapshot.db")
+		if err := database.DB.Exec("VACUUM INTO ?", snapshot).Error; err != nil {
+			return err
+		}
+		src, err := os.Open(snapshot)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		if _, err := io.Copy(gz, src); err != nil {
+			return err
+		}
+	} else {
+		args, env, err := mysqlCommandArgs(config.Cfg.Backup.MysqldumpCommand, config.Cfg.Database.DSN)
+		if err != nil {
+			return err
+		}
+		var stderr strings.Builder
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = env
+		cmd.Stdout = gz
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// mysqlCommandArgs 在 mysqldump / mysql 命令后追加 DSN 中的连接参数和数据库名。
+// 密码通过 MYSQL_PWD 环境变量传递，不会出现在进程列表中
+func mysqlCommandArgs(command, dsn string) ([]string, []string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, nil, errors.New("MySQL command is not configured")
+	}
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid MySQL DSN: %w", err)
+	}
+	if cfg.User != "" {
+		args = append(args, "--user="+cfg.User)
+	}
+	if cfg.Net == "unix" {
+		args = append(args, "--socket="+cfg.Addr)
+	} else if host, port, err := net.SplitHostPort(cfg.Addr); err == nil {
+		args = append(args, "--host="+host, "--port="+port)
+	} else if cfg.Addr != "" {
+		args = append(args, "--host="+cfg.Addr)
+	}
+	args = append(args, cfg.DBName)
+
+	env := os.Environ()
+	if cfg.Passwd != "" {
+		env = append(env, "MYSQL_PWD="+cfg.Passwd)
+	}
+	return args, env, nil
+}
+
+// pruneDatabaseBackups 只保留最新的 retention 个备份，删除更早的备份文件和记录
+func pruneDatabaseBackups(retention int, storageManager *manager.StorageManager) {
+	if retention <= 0 {
+		return
+	}
+	backups, err := ListDatabaseBackups()
+	if err != nil {
+		log.Printf("Failed to load old database backups: %v", err)
+		return
+	}
+	if len(backups) <= retention {
+		return
+	}
+	for _, backup := range backups[retention:] {
+		uploader, found := storageManager.Get(backup.BackendID)
+		if !found {
+			log.Printf("Backend %d of old database backup %s is not loaded, keeping it.", backup.BackendID, backup.Filename)
+			continue
+		}
+		start := time.Now()
+		err := uploader.Delete(backup.DeleteIdentifier)
+		recordStorageOperation(OperationDelete, backup.BackendID, backup.DeleteIdentifier, start, err)
+		if err != nil {
+			log.Printf("Failed to delete old database backup %s: %v", backup.Filename, err)
+			continue
+		}
+		database.DB.Delete(&backup)
+		log.Printf("Old database backup %s deleted.", backup.Filename)
+	}
+}
+
+// RestoreDatabaseBackup 用备份文件覆盖当前数据库，source 可以是本地路径或 http(s) 地址。
+// 加密的备份使用 backup.encryption_key 解密，加密功能之前的备份直接解压。
+// 必须在服务停止时执行 (命令行 "restore")；SQLite 原数据库文件会保留为 .before-restore
+func RestoreDatabaseBackup(source string, cfg config.DatabaseConfig) error {
+	src, err := openBackupSource(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	plain, err := openBackupPlaintext(src, config.Cfg.Backup.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(plain)
+	if err != nil {
+		if errors.Is(err, ErrBackupDecrypt) {
+			return err
+		}
+		return fmt.Errorf("backup is not a gzip archive: %w", err)
+	}
+	defer gz.Close()
+
+	switch cfg.Driver {
+	case "", database.DriverSQLite:
+		return restoreSQLite(gz, database.SQLiteFilePath(cfg.DSN))
+	case database.DriverMySQL:
+		args, env, err := mysqlCommandArgs(config.Cfg.Backup.MysqlCommand, cfg.DSN)
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = env
+		cmd.Stdin = gz
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("mysql restore failed: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+}
+
+// openBackupSource 打开本地备份文件，或下载远程备份
+func openBackupSource(source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+	resp, err := http.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download backup: HTTP %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// restoreSQLite 先解压到临时文件，成功后再替换数据库文件。原数据库连同 WAL 文件一起改名保留，共享内存文件直接删除
+func restoreSQLite(src io.Reader, dbPath string) error {
+	tmpPath := dbPath + ".restoring"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	for _, suffix := range []string{"", "-wal"} {
+		if err := os.Rename(dbPath+suffix, dbPath+".before-restore"+suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := os.Remove(dbPath + "-shm"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(tmpPath, dbPath)
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/util"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// bandwidthFlushInterval 缓冲的流量统计写入数据库的间隔
+	bandwidthFlushInterval = 30 * time.Second
+	// maxBandwidthStatsDays 流量统计最多查询的天数
+	maxBandwidthStatsDays = 366
+)
+
+// 流量统计的分组方式
+const (
+	BandwidthByBackend = "backend"
+	BandwidthByUser    = "user"
+	BandwidthByDay     = "day"
+)
+
+// bandwidthKey 缓冲中按图片记录，写入时再换算成图片所有者
+type bandwidthKey struct {
+	day       string
+	backendID uint
+	imageID   uint
+}
+
+type bandwidthCounter struct {
+	requests  int64
+	bytes     int64
+	redirects int64
+}
+
+var (
+	bandwidthBufferMu sync.Mutex
+	bandwidthBuffer   = make(map[bandwidthKey]*bandwidthCounter)
+)
+
+// BandwidthStat 一组流量统计的汇总
+type BandwidthStat struct {
+	Day                    string `json:"day,omitempty"`
+	BackendID              uint   `json:"backend_id,omitempty"`
+	BackendName            string `json:"backend_name,omitempty"`
+	UserID                 uint   `json:"user_id,omitempty"`
+	Username               string `json:"username,omitempty"`
+	Requests               int64  `json:"requests"`
+	BytesServed            int64  `json:"bytes_served"`
+	Redirects              int64  `json:"redirects"`
+	EstimatedRedirectBytes int64  `json:"estimated_redirect_bytes"`
+}
+
+// InitBandwidthAccounting 启动后台任务，定期把缓冲的流量统计批量写入数据库
+func InitBandwidthAccounting() {
+	go func() {
+		ticker := time.NewTicker(bandwidthFlushInterval)
+		for range ticker.C {
+			FlushBandwidthUsage()
+		}
+	}()
+}
+
+// RecordBandwidth 记录一次图片请求的流量：redirect 为 true 表示跳转到了后端，否则 bytes 为本服务器实际输出的字节数
+func RecordBandwidth(location *database.StorageLocation, bytes int64, redirect bool) {
+	key := bandwidthKey{day: time.Now().Format("2006-01-02"), backendID: location.BackendID, imageID: location.ImageID}
+	bandwidthBufferMu.Lock()
+	defer bandwidthBufferMu.Unlock()
+	counter, ok := bandwidthBuffer[key]
+	if !ok {
+		counter = &bandwidthCounter{}
+		bandwidthBuffer[key] = counter
+	}
+	if redirect {
+		counter.redirects++
+		return
+	}
+	counter.requests++
+	if bytes > 0 {
+		counter.bytes += bytes
+	}
+}
+
+// FlushBandwidthUsage 把缓冲的流量统计按 (日期, 后端, 图片所有者) 汇总后写入数据库
+func FlushBandwidthUsage() {
+	bandwidthBufferMu.Lock()
+	batch := bandwidthBuffer
+	bandwidthBuffer = make(map[bandwidthKey]*bandwidthCounter)
+	bandwidthBufferMu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	imageIDs := make([]uint, 0, len(batch))
+	seen := make(map[uint]bool, len(batch))
+	for key := range batch {
+		if !seen[key.imageID] {
+			seen[key.imageID] = true
+			imageIDs = append(imageIDs, key.imageID)
+		}
+	}
+	var images []database.Image
+	if err := database.DB.Select("id", "user_id", "file_size").Where("id IN ?", imageIDs).Find(&images).Error; err != nil {
+		log.Printf("Failed to load images for bandwidth accounting, will retry: %v", err)
+		requeueBandwidth(batch)
+		return
+	}
+	byID := make(map[uint]database.Image, len(images))
+	for _, image := range images {
+		byID[image.ID] = image
+	}
+
+	type usageKey struct {
+		day       string
+		backendID uint
+		userID    uint
+	}
+	rows := make(map[usageKey]*database.BandwidthUsage)
+	for key, counter := range batch {
+		image := byID[key.imageID]
+		uk := usageKey{day: key.day, backendID: key.backendID, userID: image.UserID}
+		row, ok := rows[uk]
+		if !ok {
+			row = &database.BandwidthUsage{Day: key.day, BackendID: key.backendID, UserID: image.UserID}
+			rows[uk] = row
+		}
+		row.Requests += counter.requests
+		row.BytesServed += counter.bytes
+		row.Redirects += counter.redirects
+		row.EstimatedRedirectBytes += counter.redirects * image.FileSize
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "day"}, {Name: "backend_id"}, {Name: "user_id"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"requests":                 gorm.Expr("bandwidth_usages.requests + ?", row.Requests),
+					"bytes_served":             gorm.Expr("bandwidth_usages.bytes_served + ?", row.BytesServed),
+					"redirects":                gorm.Expr("bandwidth_usages.redirects + ?", row.Redirects),
+					"estimated_redirect_bytes": gorm.Expr("bandwidth_usages.estimated_redirect_bytes + ?", row.EstimatedRedirectBytes),
+				}),
+			}).Create(row).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to flush bandwidth usage, will retry: %v", err)
+		requeueBandwidth(batch)
+	}
+}
+
+// requeueBandwidth 把写入失败的流量统计合并回缓冲
+func requeueBandwidth(batch map[bandwidthKey]*bandwidthCounter) {
+	bandwidthBufferMu.Lock()
+	defer bandwidthBufferMu.Unlock()
+	for key, failed := range batch {
+		counter, ok := bandwidthBuffer[key]
+		if !ok {
+			bandwidthBuffer[key] = failed
+			continue
+		}
+		counter.requests += failed.requests
+		counter.bytes += failed.bytes
+		counter.redirects += failed.redirects
+	}
+}
+
+// GetBandwidthStats 汇总最近 days 天的流量统计；普通用户只能看到自己图片的流量
+func GetBandwidthStats(days int, groupBy string, userID uint, userRole string) ([]BandwidthStat, error) {
+	if days < 1 || days > maxBandwidthStatsDays {
+		days = 30
+	}
+	since := time.Now().AddDate(0, 0, -(days - 1)).Format("2006-01-02")
+	sums := "SUM(bandwidth_usages.requests) AS requests, SUM(bandwidth_usages.bytes_served) AS bytes_served, " +
+		"SUM(bandwidth_usages.redirects) AS redirects, SUM(bandwidth_usages.estimated_redirect_bytes) AS estimated_redirect_bytes"
+
+	query := database.DB.Table("bandwidth_usages").Where("bandwidth_usages.day >= ?", since)
+	if !CanAccessAllImages(userRole) {
+		query = query.Where("bandwidth_usages.user_id = ?", userID)
+	}
+	switch groupBy {
+	case "", BandwidthByBackend:
+		query = query.Select("bandwidth_usages.backend_id, backends.name AS backend_name, " + sums).
+			Joins("LEFT JOIN backends ON backends.id = bandwidth_usages.backend_id").
+			Group("bandwidth_usages.backend_id, backends.name")
+	case BandwidthByUser:
+		query = query.Select("bandwidth_usages.user_id, users.username, " + sums).
+			Joins("LEFT JOIN users ON users.id = bandwidth_usages.user_id").
+			Group("bandwidth_usages.user_id, users.username")
+	case BandwidthByDay:
+		query = query.Select("bandwidth_usages.day, " + sums).Group("bandwidth_usages.day")
+	default:
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("Invalid group: %s", groupBy)}
+	}
+	if groupBy == BandwidthByDay {
+		query = query.Order("bandwidth_usages.day asc")
+	} else {
+		query = query.Order("SUM(bandwidth_usages.bytes_served) + SUM(bandwidth_usages.estimated_redirect_bytes) desc")
+	}
+
+	stats := []BandwidthStat{}
+	err := query.Scan(&stats).Error
+	return stats, err
+}
+
+// GetTodayBytesServed 返回今天由本服务器输出的字节数，普通用户只统计自己的图片 (不含尚未写入的缓冲)
+func GetTodayBytesServed(userID uint, userRole string) int64 {
+	query := database.DB.Model(&database.BandwidthUsage{}).Where("day = ?", time.Now().Format("2006-01-02"))
+	if !CanAccessAllImages(userRole) {
+		query = query.Where("user_id = ?", userID)
+	}
+	var total int64
+	query.Select("IFNULL(SUM(bytes_served), 0)").Row().Scan(&total)
+	return total
+}
+
+// 迁移/补传任务的带宽限制器：一个全局限制器，以及每个目标后端一个限制器
+// 所有并发任务共享这些限制器，因此限制的是总带宽而不是单个任务的带宽
+var (
+	migrationLimiter        = util.NewBandwidthLimiter(0)
+	backendMigrationLimiter = make(map[uint]*util.BandwidthLimiter)
+	backendLimiterMu        sync.Mutex
+)
+
+// throttleMigration 为迁移/补传上传的数据流加上全局和目标后端的带宽限制
+// 限速值在每个文件开始时读取，修改设置后无需重启即可生效
+func throttleMigration(r io.Reader, backendID uint) io.Reader {
+	migrationLimiter.SetRate(GetMigrationBandwidthLimit())
+
+	var backend database.Backend
+	var backendRate int64
+	if err := database.DB.Select("id", "bandwidth_limit").First(&backend, backendID).Error; err == nil {
+		backendRate = backend.BandwidthLimit
+	}
+
+	backendLimiterMu.Lock()
+	limiter, ok := backendMigrationLimiter[backendID]
+	if !ok {
+		limiter = util.NewBandwidthLimiter(backendRate)
+		backendMigrationLimiter[backendID] = limiter
+	}
+	backendLimiterMu.Unlock()
+	limiter.SetRate(backendRate)
+
+	return util.NewThrottledReader(r, migrationLimiter, limiter)
+}
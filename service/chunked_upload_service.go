@@ -0,0 +1,272 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+	"yanshu-imgbed/util"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// chunkUploadDir 分片文件的保存目录
+	chunkUploadDir = "data/chunks"
+	// 分片大小的默认值和允许范围
+	defaultChunkSize = 5 * 1024 * 1024
+	minChunkSize     = 256 * 1024
+	maxChunkSize     = 64 * 1024 * 1024
+	// uploadSessionTTL 会话从创建起的有效期
+	uploadSessionTTL = 24 * time.Hour
+)
+
+// 分片上传相关错误，均应作为客户端错误返回
+var (
+	ErrUploadSessionNotFound = errors.New("upload session not found or expired")
+	ErrInvalidChunk          = errors.New("invalid chunk index or size")
+	ErrUploadIncomplete      = errors.New("not all chunks have been uploaded")
+)
+
+// UploadSessionStatus 分片上传会话的当前状态，客户端据此决定需要续传哪些分片
+type UploadSessionStatus struct {
+	UploadID       string    `json:"upload_id"`
+	Filename       string    `json:"filename"`
+	Size           int64     `json:"size"`
+	ChunkSize      int64     `json:"chunk_size"`
+	TotalChunks    int       `json:"total_chunks"`
+	ReceivedChunks []int     `json:"received_chunks"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// InitChunkedUploads 启动后台任务，定期清理过期的分片上传会话
+func InitChunkedUploads() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		for range ticker.C {
+			cleanupExpiredUploadSessions()
+		}
+	}()
+}
+
+// CreateUploadSession 创建一个分片上传会话
+func CreateUploadSession(userID uint, filename string, size, chunkSize int64) (*UploadSessionStatus, error) {
+	if filename == "" || size <= 0 {
+		return nil, &UploadRejectedError{Reason: "filename and a positive size are required"}
+	}
+	maxUploadMB := GetMaxUploadMB()
+	if size > int64(maxUploadMB)*1024*1024 {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("File size exceeds the limit of %dMB", maxUploadMB)}
+	}
+	if chunkSize == 0 {
+		chunkSize = defaultChunkSize
+	}
+	if chunkSize < minChunkSize || chunkSize > maxChunkSize {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("chunk_size must be between %d and %d bytes", minChunkSize, maxChunkSize)}
+	}
+
+	session := database.UploadSession{
+		UploadID:    uuid.New().String(),
+		UserID:      userID,
+		Filename:    filepath.Base(filename),
+		Size:        size,
+		ChunkSize:   chunkSize,
+		TotalChunks: int((size + chunkSize - 1) / chunkSize),
+		ExpiresAt:   time.Now().Add(uploadSessionTTL),
+	}
+	if err := os.MkdirAll(sessionDir(session.UploadID), 0755); err != nil {
+		return nil, err
+	}
+	if err := database.DB.Create(&session).Error; err != nil {
+		os.RemoveAll(sessionDir(session.UploadID))
+		return nil, err
+	}
+	return sessionStatus(&session), nil
+}
+
+// GetUploadSessionStatus 返回会话状态和已接收的分片
+func GetUploadSessionStatus(uploadID string, userID uint) (*UploadSessionStatus, error) {
+	session, err := loadUploadSession(uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return sessionStatus(session), nil
+}
+
+// SaveUploadChunk 保存一个分片，重复上传同一分片会覆盖之前的内容
+func SaveUploadChunk(uploadID string, userID uint, index int, data io.Reader) error {
+	session, err := loadUploadSession(uploadID, userID)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= session.TotalChunks {
+		return ErrInvalidChunk
+	}
+	expected := session.ChunkSize
+	if index == session.TotalChunks-1 {
+		expected = session.Size - int64(index)*session.ChunkSize
+	}
+
+	// 先写临时文件，大小校验通过后再重命名，中断的写入不会被当作已接收
+	path := chunkPath(uploadID, index)
+	tmp, err := os.CreateTemp(sessionDir(uploadID), "chunk-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	written, err := io.Copy(tmp, io.LimitReader(data, expected+1))
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	if written != expected {
+		return ErrInvalidChunk
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CompleteUploadSession 合并所有分片并交给常规上传流程处理 (去重、处理、分发)
+func CompleteUploadSession(uploadID string, userID uint, targetBackendIDs []uint, opts UploadOptions, storageManager *manager.StorageManager) (*database.Image, error) {
+	session, err := loadUploadSession(uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if received := receivedChunks(session); len(received) != session.TotalChunks {
+		return nil, ErrUploadIncomplete
+	}
+
+	assembled := filepath.Join(sessionDir(uploadID), "assembled")
+	if err := assembleChunks(session, assembled); err != nil {
+		return nil, fmt.Errorf("failed to assemble chunks: %w", err)
+	}
+
+	file, cleanup, err := util.NewFileHeaderFromFile(session.Filename, "", assembled)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	image, err := UploadImage(file, userID, targetBackendIDs, opts, storageManager)
+	if err != nil {
+		var rejected *UploadRejectedError
+		if errors.As(err, &rejected) {
+			// 文件本身不符合策略，重试也不会成功，直接丢弃会话
+			removeUploadSession(session)
+		}
+		return nil, err
+	}
+	removeUploadSession(session)
+	return image, nil
+}
+
+// AbortUploadSession 取消会话并删除已上传的分片
+func AbortUploadSession(uploadID string, userID uint) error {
+	session, err := loadUploadSession(uploadID, userID)
+	if err != nil {
+		return err
+	}
+	removeUploadSession(session)
+	return nil
+}
+
+func loadUploadSession(uploadID string, userID uint) (*database.UploadSession, error) {
+	var session database.UploadSession
+	err := database.DB.Where("upload_id = ? AND user_id = ? AND expires_at > ?", uploadID, userID, time.Now()).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func sessionStatus(session *database.UploadSession) *UploadSessionStatus {
+	return &UploadSessionStatus{
+		UploadID:       session.UploadID,
+		Filename:       session.Filename,
+		Size:           session.Size,
+		ChunkSize:      session.ChunkSize,
+		TotalChunks:    session.TotalChunks,
+		ReceivedChunks: receivedChunks(session),
+		ExpiresAt:      session.ExpiresAt,
+	}
+}
+
+// receivedChunks 列出磁盘上已完整接收的分片序号
+func receivedChunks(session *database.UploadSession) []int {
+	entries, err := os.ReadDir(sessionDir(session.UploadID))
+	if err != nil {
+		return []int{}
+	}
+	received := []int{}
+	for _, entry := range entries {
+		index, err := strconv.Atoi(entry.Name())
+		if err == nil && index >= 0 && index < session.TotalChunks {
+			received = append(received, index)
+		}
+	}
+	sort.Ints(received)
+	return received
+}
+
+func assembleChunks(session *database.UploadSession, dst string) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for i := 0; i < session.TotalChunks; i++ {
+		if err := appendFile(out, chunkPath(session.UploadID, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendFile(dst io.Writer, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func removeUploadSession(session *database.UploadSession) {
+	if err := os.RemoveAll(sessionDir(session.UploadID)); err != nil {
+		log.Printf("Failed to remove chunks of upload session %s: %v", session.UploadID, err)
+	}
+	database.DB.Delete(session)
+}
+
+func cleanupExpiredUploadSessions() {
+	var expired []database.UploadSession
+	if err := database.DB.Where("expires_at <= ?", time.Now()).Find(&expired).Error; err != nil {
+		log.Printf("Failed to load expired upload sessions: %v", err)
+		return
+	}
+	for i := range expired {
+		removeUploadSession(&expired[i])
+	}
+	if len(expired) > 0 {
+		log.Printf("Cleaned up %d expired upload session(s).", len(expired))
+	}
+}
+
+func sessionDir(uploadID string) string {
+	return filepath.Join(chunkUploadDir, uploadID)
+}
+
+func chunkPath(uploadID string, index int) string {
+	return filepath.Join(sessionDir(uploadID), strconv.Itoa(index))
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/util"
+
+	"gorm.io/gorm"
+)
+
+// diffImageMaxSize 差异图的最大宽高
+const diffImageMaxSize = 512
+
+// ErrImageNotDecodable 图片格式无法解码 (例如 SVG、WebP)，不能计算相似度
+var ErrImageNotDecodable = errors.New("image format cannot be decoded for comparison")
+
+// ImageComparison 两张图片的相似度比较结果
+type ImageComparison struct {
+	ImageA     string  `json:"image_a"`
+	ImageB     string  `json:"image_b"`
+	HashA      string  `json:"hash_a"`
+	HashB      string  `json:"hash_b"`
+	Distance   int     `json:"distance"`   // pHash 汉明距离，0-64
+	Similarity float64 `json:"similarity"` // 1 - distance/64
+	DiffImage  string  `json:"diff_image,omitempty"`
+}
+
+// CompareImages 计算两张图片的 pHash 距离，withDiff 为 true 时附带 PNG 格式的差异图 (data URL)
+// 普通用户只能比较自己的图片
+func CompareImages(uuidA, uuidB string, userID uint, userRole string, withDiff bool) (*ImageComparison, error) {
+	imgA, err := loadComparableImage(uuidA, userID, userRole)
+	if err != nil {
+		return nil, err
+	}
+	imgB, err := loadComparableImage(uuidB, userID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	hashA, hashB := util.PerceptualHash(imgA), util.PerceptualHash(imgB)
+	distance := util.HammingDistance(hashA, hashB)
+	result := &ImageComparison{
+		ImageA:     uuidA,
+		ImageB:     uuidB,
+		HashA:      fmt.Sprintf("%016x", hashA),
+		HashB:      fmt.Sprintf("%016x", hashB),
+		Distance:   distance,
+		Similarity: 1 - float64(distance)/64,
+	}
+
+	if withDiff {
+		b := imgA.Bounds()
+		w, h := util.FitDimensions(b.Dx(), b.Dy(), diffImageMaxSize, diffImageMaxSize)
+		data, contentType, err := util.EncodeImage(util.DiffImage(imgA, imgB, w, h), "png", 0)
+		if err != nil {
+			return nil, err
+		}
+		result.DiffImage = "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	return result, nil
+}
+
+// loadComparableImage 读取并解码图片内容
+func loadComparableImage(imageUUID string, userID uint, userRole string) (image.Image, error) {
+	var record database.Image
+	query := database.DB.Preload("StorageLocations").Where("uuid = ?", imageUUID)
+	if !CanAccessAllImages(userRole) {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w or permission denied", ErrImageNotFound)
+		}
+		return nil, err
+	}
+
+	rc, err := OpenImageContent(&record)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := util.DecodeImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotDecodable, imageUUID)
+	}
+	return img, nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/util"
+)
+
+// locationStatusError 远程存储位置返回了非 200 的状态码
+type locationStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *locationStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s", e.StatusCode, e.URL)
+}
+
+// openLocationContent 打开单个存储位置上的文件内容
+func openLocationContent(loc database.StorageLocation) (io.ReadCloser, error) {
+	if loc.StorageType == "local" {
+		parsedURL, err := url.Parse(loc.URL)
+		if err != nil {
+			return nil, err
+		}
+		return os.Open("." + parsedURL.Path)
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(loc.URL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &locationStatusError{URL: loc.URL, StatusCode: resp.StatusCode}
+	}
+	return resp.Body, nil
+}
+
+// OpenImageContent 依次尝试图片的各个活跃存储位置，返回第一个可读取的文件内容
+// 本地存储优先，避免不必要的网络请求
+func OpenImageContent(image *database.Image) (io.ReadCloser, error) {
+	var remote []database.StorageLocation
+	for _, loc := range image.StorageLocations {
+		if !loc.IsActive {
+			continue
+		}
+		if loc.StorageType != "local" {
+			remote = append(remote, loc)
+			continue
+		}
+		if rc, err := openLocationContent(loc); err == nil {
+			return rc, nil
+		}
+	}
+	for _, loc := range remote {
+		if rc, err := openLocationContent(loc); err == nil {
+			return rc, nil
+		}
+	}
+	return nil, errors.New("no readable storage location for this image")
+}
+
+// ImageViewPath 返回图片的访问路径，扩展名取自实际的 Content-Type
+// contentType 为空时返回不带扩展名的 /i/<uuid>，由服务端按记录的类型输出；路径包含 server.base_path
+func ImageViewPath(imageUUID, contentType string) string {
+	if contentType == "" {
+		return config.WithBasePath("/i/" + imageUUID)
+	}
+	ext := util.ExtensionForMIME(contentType)
+	if ext == "" {
+		ext = "jpg"
+	}
+	return config.WithBasePath(fmt.Sprintf("/image/%s.%s", imageUUID, ext))
+}
+
+// ServeMeta 由本服务器输出图片 (本地文件或代理) 时用于缓存校验的元数据
+type ServeMeta struct {
+	UUID        string
+	ContentType string
+	// ETag 取自文件 MD5，同一 UUID 的内容不会变化
+	ETag         string
+	LastModified time.Time
+}
+
+// GetServeMeta 查询存储位置所属图片的缓存校验元数据
+func GetServeMeta(loc *database.StorageLocation) (*ServeMeta, error) {
+	var image database.Image
+	if err := database.DB.Select("uuid", "content_type", "md5", "sha256", "created_at").First(&image, loc.ImageID).Error; err != nil {
+		return nil, err
+	}
+	meta := &ServeMeta{UUID: image.UUID, ContentType: image.ContentType, LastModified: image.CreatedAt}
+	hash := image.MD5
+	if hash == "" {
+		hash = image.SHA256
+	}
+	if hash != "" {
+		meta.ETag = `"` + hash + `"`
+	}
+	return meta, nil
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"log"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// dailyStatsRefreshInterval 重新汇总当天统计的间隔
+	dailyStatsRefreshInterval = 15 * time.Minute
+	// maxDailyStatsDays 首次汇总时最多回溯的天数，也是查询的最大天数
+	maxDailyStatsDays = 366
+)
+
+// DailyBackendTrend 某个后端某一天的统计，附带后端名称
+type DailyBackendTrend struct {
+	Day         string `json:"day"`
+	BackendID   uint   `json:"backend_id"`
+	BackendName string `json:"backend_name"`
+	Locations   int64  `json:"locations"`
+	Bytes       int64  `json:"bytes"`
+	Requests    int64  `json:"requests"`
+	BytesServed int64  `json:"bytes_served"`
+	Redirects   int64  `json:"redirects"`
+}
+
+// DailyTrend 全站某一天的汇总统计
+type DailyTrend struct {
+	Day         string `json:"day"`
+	Uploads     int64  `json:"uploads"`
+	UploadBytes int64  `json:"upload_bytes"`
+	Views       int64  `json:"views"`
+	Requests    int64  `json:"requests"`
+	BytesServed int64  `json:"bytes_served"`
+}
+
+// DailyTrends 仪表盘趋势图使用的每日汇总
+type DailyTrends struct {
+	Days int `json:"days"`
+	// Daily 每天一项，按日期升序，尚未汇总的日期不包含在内
+	Daily    []DailyTrend        `json:"daily"`
+	Backends []DailyBackendTrend `json:"backends"`
+	// UpdatedAt 最近一次汇总的时间，今天的数据最多落后 dailyStatsRefreshInterval
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// InitDailyStats 启动后台任务，先补齐缺失日期的汇总，之后定期刷新昨天和今天的统计
+func InitDailyStats() {
+	go func() {
+		RefreshDailyStats()
+		ticker := time.NewTicker(dailyStatsRefreshInterval)
+		for range ticker.C {
+			RefreshDailyStats()
+		}
+	}()
+}
+
+// RefreshDailyStats 从最近一次汇总的日期 (至少从昨天) 起重新汇总到今天。
+// 昨天总是重算一次，保证跨过零点后才写入的访问和流量缓冲也被计入
+func RefreshDailyStats() {
+	today := dayStart(time.Now())
+	start := today.AddDate(0, 0, -1)
+
+	var last database.DailyStat
+	if err := database.DB.Order("day desc").Limit(1).Find(&last).Error; err != nil {
+		log.Printf("Failed to load daily statistics: %v", err)
+		return
+	}
+	if last.Day != "" {
+		if day, err := time.ParseInLocation(usageDayFormat, last.Day, time.Local); err == nil && day.Before(start) {
+			start = day
+		}
+	} else if first, ok := firstActivityDay(); ok {
+		// 首次运行，从最早的上传开始补齐
+		start = first
+	}
+	if earliest := today.AddDate(0, 0, -(maxDailyStatsDays - 1)); start.Before(earliest) {
+		start = earliest
+	}
+
+	for day := start; !day.After(today); day = day.AddDate(0, 0, 1) {
+		if err := aggregateDailyStats(day); err != nil {
+			log.Printf("Failed to aggregate statistics for %s: %v", day.Format(usageDayFormat), err)
+			return
+		}
+	}
+}
+
+// firstActivityDay 返回最早一张图片的上传日期
+func firstActivityDay() (time.Time, bool) {
+	var first database.Image
+	if err := database.DB.Select("created_at").Order("created_at asc").Limit(1).Find(&first).Error; err != nil || first.CreatedAt.IsZero() {
+		return time.Time{}, false
+	}
+	return dayStart(first.CreatedAt.In(time.Local)), true
+}
+
+// dayStart 返回 t 所在日期的本地零点
+func dayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// aggregateDailyStats 汇总某一天的上传、访问和流量，覆盖该日期已有的汇总。
+// 上传按本地零点之间的时间范围查询，不依赖数据库对 DATE() 的时区处理
+func aggregateDailyStats(start time.Time) error {
+	end := start.AddDate(0, 0, 1)
+	day := start.Format(usageDayFormat)
+	stat := database.DailyStat{Day: day}
+
+	err := database.DB.Model(&database.Image{}).
+		Select("COUNT(*), COALESCE(SUM(file_size), 0)").
+		Where("created_at >= ? AND created_at < ?", start, end).
+		Row().Scan(&stat.Uploads, &stat.UploadBytes)
+	if err != nil {
+		return err
+	}
+	err = database.DB.Model(&database.ImageDailyView{}).
+		Select("COALESCE(SUM(views), 0)").
+		Where("day = ?", day).
+		Row().Scan(&stat.Views)
+	if err != nil {
+		return err
+	}
+
+	var locations []database.DailyBackendStat
+	err = database.DB.Table("storage_locations").
+		Select("storage_locations.backend_id, COUNT(*) AS locations, COALESCE(SUM(images.file_size), 0) AS bytes").
+		Joins("JOIN images ON images.id = storage_locations.image_id").
+		Where("storage_locations.created_at >= ? AND storage_locations.created_at < ?", start, end).
+		Group("storage_locations.backend_id").
+		Scan(&locations).Error
+	if err != nil {
+		return err
+	}
+	var traffic []database.DailyBackendStat
+	err = database.DB.Model(&database.BandwidthUsage{}).
+		Select("backend_id, SUM(requests) AS requests, SUM(bytes_served) AS bytes_served, SUM(redirects) AS redirects").
+		Where("day = ?", day).
+		Group("backend_id").
+		Scan(&traffic).Error
+	if err != nil {
+		return err
+	}
+
+	byBackend := make(map[uint]*database.DailyBackendStat)
+	backendRow := func(backendID uint) *database.DailyBackendStat {
+		row, ok := byBackend[backendID]
+		if !ok {
+			row = &database.DailyBackendStat{Day: day, BackendID: backendID}
+			byBackend[backendID] = row
+		}
+		return row
+	}
+	for _, l := range locations {
+		row := backendRow(l.BackendID)
+		row.Locations = l.Locations
+		row.Bytes = l.Bytes
+	}
+	for _, t := range traffic {
+		stat.Requests += t.Requests + t.Redirects
+		stat.BytesServed += t.BytesServed
+		row := backendRow(t.BackendID)
+		row.Requests = t.Requests
+		row.BytesServed = t.BytesServed
+		row.Redirects = t.Redirects
+	}
+	backendStats := make([]database.DailyBackendStat, 0, len(byBackend))
+	for _, row := range byBackend {
+		backendStats = append(backendStats, *row)
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&stat).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("day = ?", day).Delete(&database.DailyBackendStat{}).Error; err != nil {
+			return err
+		}
+		if len(backendStats) == 0 {
+			return nil
+		}
+		return tx.Create(&backendStats).Error
+	})
+}
+
+// GetDailyTrends 返回最近 days 天 (1-366，默认 30) 的每日汇总，只读取汇总表，不扫描图片表
+func GetDailyTrends(days int) (*DailyTrends, error) {
+	if days < 1 || days > maxDailyStatsDays {
+		days = 30
+	}
+	since := usageSince(days)
+	trends := &DailyTrends{Days: days, Daily: []DailyTrend{}, Backends: []DailyBackendTrend{}}
+
+	var stats []database.DailyStat
+	if err := database.DB.Where("day >= ?", since).Order("day asc").Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	for _, stat := range stats {
+		trends.Daily = append(trends.Daily, DailyTrend{
+			Day:         stat.Day,
+			Uploads:     stat.Uploads,
+			UploadBytes: stat.UploadBytes,
+			Views:       stat.Views,
+			Requests:    stat.Requests,
+			BytesServed: stat.BytesServed,
+		})
+		if trends.UpdatedAt == nil || stat.UpdatedAt.After(*trends.UpdatedAt) {
+			updatedAt := stat.UpdatedAt
+			trends.UpdatedAt = &updatedAt
+		}
+	}
+
+	err := database.DB.Table("daily_backend_stats").
+		Select("daily_backend_stats.*, backends.name AS backend_name").
+		Joins("LEFT JOIN backends ON backends.id = daily_backend_stats.backend_id").
+		Where("daily_backend_stats.day >= ?", since).
+		Order("daily_backend_stats.day asc, daily_backend_stats.backend_id asc").
+		Scan(&trends.Backends).Error
+	if err != nil {
+		return nil, err
+	}
+	return trends, nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrDailyUploadLimit 已达到当天的上传次数或上传量上限
+var ErrDailyUploadLimit = errors.New("daily upload limit reached")
+
+// DailyUploadLimit 每天的上传次数和上传量 (MB) 上限，0 表示不限制
+type DailyUploadLimit struct {
+	Uploads int64 `json:"uploads"`
+	MB      int64 `json:"mb"`
+}
+
+// DailyUploadStatus 用户当天的上传量和适用的上限
+type DailyUploadStatus struct {
+	Uploads int64            `json:"uploads"`
+	Bytes   int64            `json:"bytes"`
+	Limit   DailyUploadLimit `json:"limit"`
+}
+
+// dailyUploadTotals 统计当天的上传量，tokenID 不为 0 时只统计该 Token
+func dailyUploadTotals(userID, tokenID uint, day string) (uploads, bytes int64, err error) {
+	var totals struct {
+		Uploads int64
+		Bytes   int64
+	}
+	query := database.DB.Model(&database.DailyUploadUsage{}).
+		Select("COALESCE(SUM(uploads), 0) AS uploads, COALESCE(SUM(bytes), 0) AS bytes").
+		Where("user_id = ? AND day = ?", userID, day)
+	if tokenID != 0 {
+		query = query.Where("token_id = ?", tokenID)
+	}
+	err = query.Scan(&totals).Error
+	return totals.Uploads, totals.Bytes, err
+}
+
+// exceedsDailyLimit 判断再上传一次 (大约 incomingBytes 字节) 是否会超出上限
+func exceedsDailyLimit(limit DailyUploadLimit, uploads, bytes, incomingBytes int64) error {
+	if limit.Uploads > 0 && uploads+1 > limit.Uploads {
+		return fmt.Errorf("%w: at most %d uploads per day", ErrDailyUploadLimit, limit.Uploads)
+	}
+	if limit.MB > 0 && bytes+incomingBytes > limit.MB*1024*1024 {
+		return fmt.Errorf("%w: at most %d MB per day (%d MB used)", ErrDailyUploadLimit, limit.MB, bytes/1024/1024)
+	}
+	return nil
+}
+
+// CheckDailyUploadLimit 检查用户 (以及所用的 API Token) 当天是否还能再上传。
+// incomingBytes 是本次上传的预估大小，未知时传 0；上限按上传前的用量判断，最后一次上传可能略超出上传量上限
+func CheckDailyUploadLimit(userID uint, token *database.APIToken, incomingBytes int64) error {
+	day := time.Now().Format(usageDayFormat)
+	if limit := GetDailyUploadLimit(); limit.Uploads > 0 || limit.MB > 0 {
+		uploads, bytes, err := dailyUploadTotals(userID, 0, day)
+		if err != nil {
+			return fmt.Errorf("failed to check daily upload limit: %w", err)
+		}
+		if err := exceedsDailyLimit(limit, uploads, bytes, incomingBytes); err != nil {
+			emitQuotaWebhook("daily", userID, err.Error())
+			return err
+		}
+	}
+	// Token 的上限在用户上限之外额外生效，不能用来绕过用户的上限
+	if token != nil && (token.DailyUploadLimit > 0 || token.DailyUploadMB > 0) {
+		uploads, bytes, err := dailyUploadTotals(userID, token.ID, day)
+		if err != nil {
+			return fmt.Errorf("failed to check daily upload limit: %w", err)
+		}
+		limit := DailyUploadLimit{Uploads: token.DailyUploadLimit, MB: token.DailyUploadMB}
+		if err := exceedsDailyLimit(limit, uploads, bytes, incomingBytes); err != nil {
+			err = fmt.Errorf("%w for this API token", err)
+			emitQuotaWebhook("daily", userID, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordDailyUpload 累加用户当天的上传次数和字节数，统计失败只记录日志
+func RecordDailyUpload(userID, tokenID uint, bytes int64) {
+	usage := database.DailyUploadUsage{
+		UserID:  userID,
+		TokenID: tokenID,
+		Day:     time.Now().Format(usageDayFormat),
+		Uploads: 1,
+		Bytes:   bytes,
+	}
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "token_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"uploads":    gorm.Expr("uploads + 1"),
+			"bytes":      gorm.Expr("bytes + ?", bytes),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&usage).Error
+	if err != nil {
+		log.Printf("Failed to record daily upload of user %d: %v", userID, err)
+	}
+}
+
+// GetDailyUploadStatus 返回用户当天 (所有上传方式合计) 的上传量和系统设置的上限
+func GetDailyUploadStatus(userID uint) (*DailyUploadStatus, error) {
+	uploads, bytes, err := dailyUploadTotals(userID, 0, time.Now().Format(usageDayFormat))
+	if err != nil {
+		return nil, err
+	}
+	return &DailyUploadStatus{Uploads: uploads, Bytes: bytes, Limit: GetDailyUploadLimit()}, nil
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+	"yanshu-imgbed/storage"
+
+	"gorm.io/gorm"
+)
+
+// 下线后端的模式
+const (
+	DecommissionMigrate = "migrate" // 所有存储位置都复制到目标后端
+	DecommissionDrop    = "drop"    // 直接移除有其他副本的存储位置，只复制孤立 (唯一副本) 的图片
+)
+
+// 下线后端的参数错误
+var (
+	ErrInvalidDecommissionMode   = errors.New("mode must be 'migrate' or 'drop'")
+	ErrDecommissionTargetInvalid = errors.New("target backend must be a different, initialized backend")
+)
+
+// DecommissionRequest 下线后端的参数
+type DecommissionRequest struct {
+	Mode            string `json:"mode" binding:"required"`
+	TargetBackendID uint   `json:"target_backend_id"` // migrate 模式必填；drop 模式下用于接收孤立图片，不填时孤立图片会阻止删除
+	DeleteFiles     bool   `json:"delete_files"`      // 存储位置全部移除后是否删除该后端上的物理文件
+}
+
+// migratedLocation 已复制到目标后端的文件，同一个物理文件被多条记录共享时只复制一次
+type migratedLocation struct {
+	url              string
+	deleteIdentifier string
+	storageType      string
+}
+
+// DecommissionBackend 分两阶段下线后端，返回后台任务 ID
+// 第一阶段按模式迁移或移除该后端的所有存储位置，第二阶段在没有剩余存储位置时删除后端；
+// 有存储位置处理失败时保留后端，任务标记为失败，修复后可以重新执行
+func DecommissionBackend(backendID uint, req DecommissionRequest, storageManager *manager.StorageManager) (string, error) {
+	if req.Mode != DecommissionMigrate && req.Mode != DecommissionDrop {
+		return "", ErrInvalidDecommissionMode
+	}
+	var backend database.Backend
+	if err := database.DB.First(&backend, backendID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrBackendNotFound
+		}
+		return "", err
+	}
+	if req.TargetBackendID != 0 || req.Mode == DecommissionMigrate {
+		// 试运行后端不保存文件，不能作为迁移目标
+		target, found := storageManager.Get(req.TargetBackendID)
+		if !found || req.TargetBackendID == backendID || target.Type() == storage.DryRunType {
+			return "", ErrDecommissionTargetInvalid
+		}
+	}
+
+	var locationIDs []uint
+	if err := database.DB.Model(&database.StorageLocation{}).Where("backend_id = ?", backendID).Pluck("id", &locationIDs).Error; err != nil {
+		return "", err
+	}
+
+	task := newTask(fmt.Sprintf("Decommission Backend (%s, %s)", backend.Name, req.Mode), len(locationIDs))
+	go func() {
+		migrated := make(map[string]migratedLocation)
+		var removed []database.StorageLocation
+		var failed int
+		for i, id := range locationIDs {
+			loc, err := decommissionLocation(id, req, migrated, storageManager)
+			if err != nil {
+				failed++
+				log.Printf("[Task %s] Failed to decommission storage location %d: %v", task.ID, id, err)
+				recordTaskItemError(task, fmt.Sprintf("storage location %d", id), err)
+			} else if loc != nil {
+				removed = append(removed, *loc)
+			}
+			updateTask(task, func(t *Task) { t.Progress = i + 1 })
+		}
+
+		var remaining int64
+		database.DB.Model(&database.StorageLocation{}).Where("backend_id = ?", backendID).Count(&remaining)
+		if remaining > 0 {
+			updateTask(task, func(t *Task) {
+				t.Status = "failed"
+				t.Message = fmt.Sprintf("%d storage locations remain (%d failed), backend was kept", remaining, failed)
+			})
+			return
+		}
+
+		if req.DeleteFiles {
+			deletePhysicalFiles(uniqueLocations(removed), storageManager)
+		}
+		if err := database.DB.Delete(&database.Backend{}, backendID).Error; err != nil {
+			updateTask(task, func(t *Task) {
+				t.Status = "failed"
+				t.Message = "All storage locations were moved but deleting the backend failed"
+			})
+			return
+		}
+		if err := storageManager.Refresh(); err != nil {
+			log.Printf("[Task %s] Failed to refresh storage manager: %v", task.ID, err)
+		}
+		updateTask(task, func(t *Task) {
+			t.Status = "completed"
+			t.Message = fmt.Sprintf("Backend deleted, %d storage locations processed", len(locationIDs))
+		})
+	}()
+	return task.ID, nil
+}
+
+// decommissionLocation 处理单个存储位置，成功移除时返回被删除的记录
+func decommissionLocation(locationID uint, req DecommissionRequest, migrated map[string]migratedLocation, storageManager *manager.StorageManager) (*database.StorageLocation, error) {
+	var loc database.StorageLocation
+	if err := database.DB.First(&loc, locationID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil // 已被其他操作删除
+		}
+		return nil, err
+	}
+	var image database.Image
+	if err := database.DB.Preload("StorageLocations").First(&image, loc.ImageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 图片已不存在，存储位置是残留数据
+			return &loc, database.DB.Delete(&loc).Error
+		}
+		return nil, err
+	}
+
+	var hasOtherCopy, hasTargetCopy bool
+	for _, other := range image.StorageLocations {
+		if other.ID == loc.ID || !other.IsActive {
+			continue
+		}
+		hasOtherCopy = true
+		if other.BackendID == req.TargetBackendID {
+			hasTargetCopy = true
+		}
+	}
+
+	needsCopy := !hasTargetCopy && (req.Mode == DecommissionMigrate || !hasOtherCopy)
+	if needsCopy {
+		if req.TargetBackendID == 0 {
+			return nil, fmt.Errorf("image %s has no other copy and no target backend was given", image.UUID)
+		}
+		target, err := copyLocationToBackend(&image, loc, req.TargetBackendID, migrated, storageManager)
+		if err != nil {
+			return nil, err
+		}
+		newLoc := database.StorageLocation{
+			ImageID:          image.ID,
+			BackendID:        req.TargetBackendID,
+			StorageType:      target.storageType,
+			URL:              target.url,
+			DeleteIdentifier: target.deleteIdentifier,
+			IsActive:         true,
+		}
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newLoc).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&loc).Error
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &loc, nil
+	}
+
+	if err := database.DB.Delete(&loc).Error; err != nil {
+		return nil, err
+	}
+	return &loc, nil
+}
+
+// copyLocationToBackend 把存储位置上的文件复制到目标后端
+func copyLocationToBackend(image *database.Image, loc database.StorageLocation, targetBackendID uint, migrated map[string]migratedLocation, storageManager *manager.StorageManager) (migratedLocation, error) {
+	if done, ok := migrated[loc.URL]; ok {
+		return done, nil
+	}
+	uploader, found := storageManager.Get(targetBackendID)
+	if !found {
+		return migratedLocation{}, fmt.Errorf("target backend %d is not available", targetBackendID)
+	}
+
+	src, err := openLocationContent(loc)
+	if err != nil {
+		return migratedLocation{}, fmt.Errorf("failed to read source file: %w", err)
+	}
+	defer src.Close()
+
+	key := storageKeyFor(image)
+	header := &multipart.FileHeader{Filename: image.OriginalFilename, Size: image.FileSize}
+	start := time.Now()
+	result, err := uploader.Upload(header, key, throttleMigration(src, targetBackendID))
+	recordStorageOperation(OperationBackfill, targetBackendID, key, start, err)
+	if err != nil {
+		return migratedLocation{}, fmt.Errorf("upload to target backend failed: %w", err)
+	}
+
+	finalURL, deleteIdentifier := parseUploadResult(result, uploader.Type())
+	done := migratedLocation{url: finalURL, deleteIdentifier: deleteIdentifier, storageType: uploader.Type()}
+	migrated[loc.URL] = done
+	return done, nil
+}
+
+// uniqueLocations 按 URL 去重，共享同一物理文件的记录只删除一次
+func uniqueLocations(locations []database.StorageLocation) []database.StorageLocation {
+	seen := make(map[string]bool)
+	var result []database.StorageLocation
+	for _, loc := range locations {
+		if seen[loc.URL] {
+			continue
+		}
+		seen[loc.URL] = true
+		result = append(result, loc)
+	}
+	return result
+}
@@ -0,0 +1,346 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrPendingDeletionNotFound 待删除记录不存在
+	ErrPendingDeletionNotFound = errors.New("pending deletion not found")
+	// ErrFailedDeletionNotFound 删除失败记录不存在
+	ErrFailedDeletionNotFound = errors.New("failed deletion not found")
+)
+
+const (
+	// failedDeletionBatch 每轮最多重试的删除数
+	failedDeletionBatch = 50
+	// failedDeletionMaxBackoff 删除重试的最长间隔，失败的删除不会被自动放弃
+	failedDeletionMaxBackoff = 24 * time.Hour
+)
+
+// deletionRelations 删除图片时一并移除的关联数据，取消删除时据此恢复
+// 相册归属保存在图片记录的 AlbumID 中，随图片快照一起恢复
+type deletionRelations struct {
+	Tags          []database.ImageTag       `json:"tags,omitempty"`
+	Slugs         []database.ImageSlug      `json:"slugs,omitempty"`
+	ShareLinks    []shareLinkSnapshot       `json:"share_links,omitempty"`
+	DailyViews    []database.ImageDailyView `json:"daily_views,omitempty"`
+	CoverAlbumIDs []uint                    `json:"cover_album_ids,omitempty"`
+}
+
+// shareLinkSnapshot ShareLink 的 PasswordHash 不参与 JSON 序列化，快照中单独保存
+type shareLinkSnapshot struct {
+	database.ShareLink
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// schedulePhysicalDeletion 在删除元数据的事务 tx 中记录一条延迟删除，需要在移除关联数据之前调用
+func schedulePhysicalDeletion(tx *gorm.DB, image *database.Image, graceHours int) error {
+	snapshot, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	relations, err := snapshotRelations(tx, image)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot image relations: %w", err)
+	}
+	pending := database.PendingDeletion{
+		ImageUUID: image.UUID,
+		UserID:    image.UserID,
+		Snapshot:  snapshot,
+		Relations: relations,
+		ExecuteAt: time.Now().Add(time.Duration(graceHours) * time.Hour),
+	}
+	if err := tx.Create(&pending).Error; err != nil {
+		return err
+	}
+	log.Printf("Physical deletion of image %s scheduled at %s.", image.UUID, pending.ExecuteAt.Format(time.RFC3339))
+	return nil
+}
+
+// snapshotRelations 读取图片当前的标签、短链接、分享链接、访问统计和以它为封面的相册
+func snapshotRelations(tx *gorm.DB, image *database.Image) ([]byte, error) {
+	var relations deletionRelations
+	if err := tx.Where("image_id = ?", image.ID).Find(&relations.Tags).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("image_id = ?", image.ID).Find(&relations.Slugs).Error; err != nil {
+		return nil, err
+	}
+	var links []database.ShareLink
+	if err := tx.Where("image_id = ?", image.ID).Find(&links).Error; err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		relations.ShareLinks = append(relations.ShareLinks, shareLinkSnapshot{ShareLink: link, PasswordHash: link.PasswordHash})
+	}
+	if err := tx.Where("image_id = ?", image.ID).Find(&relations.DailyViews).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&database.Album{}).Where("cover_uuid = ?", image.UUID).Pluck("id", &relations.CoverAlbumIDs).Error; err != nil {
+		return nil, err
+	}
+	return json.Marshal(relations)
+}
+
+// restoreRelations 在取消删除的事务 tx 中恢复关联数据
+// 宽限期内可能已有变化：已删除的标签不再恢复，被其他图片占用的短链接跳过，已换了封面的相册保持不变
+func restoreRelations(tx *gorm.DB, image *database.Image, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	var relations deletionRelations
+	if err := json.Unmarshal(data, &relations); err != nil {
+		return fmt.Errorf("invalid relations snapshot: %w", err)
+	}
+
+	if len(relations.Tags) > 0 {
+		tagIDs := make([]uint, 0, len(relations.Tags))
+		for _, tag := range relations.Tags {
+			tagIDs = append(tagIDs, tag.TagID)
+		}
+		var existing []uint
+		if err := tx.Model(&database.Tag{}).Where("id IN ?", tagIDs).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		alive := make(map[uint]bool, len(existing))
+		for _, id := range existing {
+			alive[id] = true
+		}
+		var tags []database.ImageTag
+		for _, tag := range relations.Tags {
+			if alive[tag.TagID] {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tags).Error; err != nil {
+				return fmt.Errorf("failed to restore tags: %w", err)
+			}
+		}
+	}
+
+	for _, slug := range relations.Slugs {
+		slug.ID = 0
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&slug).Error; err != nil {
+			return fmt.Errorf("failed to restore slug: %w", err)
+		}
+	}
+
+	for _, snapshot := range relations.ShareLinks {
+		link := snapshot.ShareLink
+		link.ID = 0
+		link.PasswordHash = snapshot.PasswordHash
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&link).Error; err != nil {
+			return fmt.Errorf("failed to restore share link: %w", err)
+		}
+	}
+
+	if len(relations.DailyViews) > 0 {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&relations.DailyViews).Error; err != nil {
+			return fmt.Errorf("failed to restore daily views: %w", err)
+		}
+	}
+
+	if len(relations.CoverAlbumIDs) > 0 {
+		if err := tx.Model(&database.Album{}).Where("id IN ? AND (cover_uuid = '' OR cover_uuid IS NULL)", relations.CoverAlbumIDs).
+			Update("cover_uuid", image.UUID).Error; err != nil {
+			return fmt.Errorf("failed to restore album covers: %w", err)
+		}
+	}
+	return nil
+}
+
+// InitDeletionScheduler 启动后台任务，定期执行到期的物理删除
+func InitDeletionScheduler(storageManager *manager.StorageManager) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			processDueDeletions(storageManager)
+			retryFailedDeletions(storageManager)
+		}
+	}()
+}
+
+// processDueDeletions 删除所有宽限期已过的物理文件
+func processDueDeletions(storageManager *manager.StorageManager) {
+	var due []database.PendingDeletion
+	if err := database.DB.Where("execute_at <= ?", time.Now()).Find(&due).Error; err != nil {
+		log.Printf("Failed to load pending deletions: %v", err)
+		return
+	}
+
+	for _, pending := range due {
+		var image database.Image
+		if err := json.Unmarshal(pending.Snapshot, &image); err != nil {
+			log.Printf("Invalid snapshot for pending deletion %d: %v. Discarding.", pending.ID, err)
+		} else {
+			deletePhysicalFiles(image.StorageLocations, storageManager)
+		}
+		database.DB.Delete(&pending)
+	}
+}
+
+// recordFailedDeletion 记录一次失败的物理删除，等待后台重试
+func recordFailedDeletion(location database.StorageLocation, deleteID string, cause error) {
+	failed := database.FailedDeletion{
+		BackendID:        location.BackendID,
+		StorageType:      location.StorageType,
+		URL:              location.URL,
+		DeleteIdentifier: deleteID,
+		Attempts:         1,
+		LastError:        cause.Error(),
+		NextRunAt:        time.Now().Add(failedDeletionBackoff(1)),
+	}
+	if err := database.DB.Create(&failed).Error; err != nil {
+		log.Printf("Failed to queue retry for deletion of %s: %v", location.URL, err)
+	}
+}
+
+// failedDeletionBackoff 第 attempts 次失败后的重试间隔：从 1 分钟开始翻倍，最长 24 小时
+func failedDeletionBackoff(attempts int) time.Duration {
+	if attempts > 12 {
+		return failedDeletionMaxBackoff
+	}
+	backoff := time.Duration(1<<(attempts-1)) * time.Minute
+	if backoff > failedDeletionMaxBackoff {
+		backoff = failedDeletionMaxBackoff
+	}
+	return backoff
+}
+
+// retryFailedDeletions 重试到期的失败删除
+func retryFailedDeletions(storageManager *manager.StorageManager) {
+	var due []database.FailedDeletion
+	if err := database.DB.Where("next_run_at <= ?", time.Now()).Order("next_run_at asc").Limit(failedDeletionBatch).Find(&due).Error; err != nil {
+		log.Printf("Failed to load failed deletions: %v", err)
+		return
+	}
+	for i := range due {
+		runFailedDeletion(&due[i], storageManager)
+	}
+}
+
+// runFailedDeletion 执行一次删除重试：成功时移除记录，失败时增加次数并重新排期
+func runFailedDeletion(failed *database.FailedDeletion, storageManager *manager.StorageManager) error {
+	var err error
+	uploader, found := storageManager.Get(failed.BackendID)
+	if !found {
+		err = fmt.Errorf("backend %d is not loaded", failed.BackendID)
+	} else {
+		start := time.Now()
+		err = uploader.Delete(failed.DeleteIdentifier)
+		recordStorageOperation(OperationDelete, failed.BackendID, failed.DeleteIdentifier, start, err)
+	}
+	if err == nil {
+		log.Printf("Retried deletion of %s succeeded after %d failed attempt(s)", failed.URL, failed.Attempts)
+		return database.DB.Delete(failed).Error
+	}
+
+	failed.Attempts++
+	failed.LastError = err.Error()
+	failed.NextRunAt = time.Now().Add(failedDeletionBackoff(failed.Attempts))
+	log.Printf("Retry of deletion %d (%s) failed (attempt %d), next retry at %s: %v", failed.ID, failed.URL, failed.Attempts, failed.NextRunAt.Format(time.RFC3339), err)
+	if saveErr := database.DB.Save(failed).Error; saveErr != nil {
+		log.Printf("Failed to reschedule deletion %d: %v", failed.ID, saveErr)
+	}
+	return err
+}
+
+// ListFailedDeletions 列出所有等待重试的失败删除，最早需要重试的在前
+func ListFailedDeletions() ([]database.FailedDeletion, error) {
+	var failed []database.FailedDeletion
+	err := database.DB.Order("next_run_at asc").Find(&failed).Error
+	return failed, err
+}
+
+// RetryFailedDeletion 立即重试一条失败删除，返回本次重试的错误；仍然失败时记录保留并重新排期
+func RetryFailedDeletion(id uint, storageManager *manager.StorageManager) error {
+	var failed database.FailedDeletion
+	if err := database.DB.First(&failed, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrFailedDeletionNotFound
+		}
+		return err
+	}
+	return runFailedDeletion(&failed, storageManager)
+}
+
+// DiscardFailedDeletion 放弃重试一条失败删除 (例如文件已手动清理或后端已废弃)
+func DiscardFailedDeletion(id uint) error {
+	result := database.DB.Delete(&database.FailedDeletion{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrFailedDeletionNotFound
+	}
+	return nil
+}
+
+// ListPendingDeletions 列出所有等待执行的物理删除
+func ListPendingDeletions() ([]database.PendingDeletion, error) {
+	var pending []database.PendingDeletion
+	err := database.DB.Order("execute_at asc").Find(&pending).Error
+	return pending, err
+}
+
+// CancelPendingDeletion 取消一条延迟删除，并从快照中恢复图片元数据及其标签、短链接、分享链接等关联数据
+func CancelPendingDeletion(id uint) (*database.Image, error) {
+	var pending database.PendingDeletion
+	if err := database.DB.First(&pending, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPendingDeletionNotFound
+		}
+		return nil, err
+	}
+
+	var image database.Image
+	if err := json.Unmarshal(pending.Snapshot, &image); err != nil {
+		return nil, fmt.Errorf("invalid deletion snapshot: %w", err)
+	}
+	locations := image.StorageLocations
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// 宽限期内相册可能已被删除，此时图片不再归属任何相册
+		if image.AlbumID != nil {
+			var count int64
+			if err := tx.Model(&database.Album{}).Where("id = ?", *image.AlbumID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				image.AlbumID = nil
+			}
+		}
+		if err := tx.Omit(clause.Associations).Create(&image).Error; err != nil {
+			return fmt.Errorf("failed to restore image record: %w", err)
+		}
+		if len(locations) > 0 {
+			if err := tx.Omit(clause.Associations).Create(&locations).Error; err != nil {
+				return fmt.Errorf("failed to restore storage locations: %w", err)
+			}
+		}
+		if err := restoreRelations(tx, &image, pending.Relations); err != nil {
+			return err
+		}
+		return tx.Delete(&pending).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if image.AllowRandom {
+		go UpdateRandomImageCache()
+	}
+	image.StorageLocations = locations
+	return &image, nil
+}
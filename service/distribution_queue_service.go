@@ -0,0 +1,159 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"sort"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+	"yanshu-imgbed/storage"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// distributionQueueBatch 每轮处理的队列任务数
+	distributionQueueBatch = 20
+	// distributionQueueMaxAttempts 超过该次数仍失败的任务会被放弃
+	distributionQueueMaxAttempts = 10
+	// distributionQueueMaxBackoff 失败重试的最长间隔
+	distributionQueueMaxBackoff = time.Hour
+)
+
+// distributeAsync 异步分发：按优先级上传到第一个成功的后端后立即返回，其余后端写入持久化队列由后台补传
+func distributeAsync(file *multipart.FileHeader, image *database.Image, backends []database.Backend, storageManager *manager.StorageManager) []error {
+	sorted := append([]database.Backend(nil), backends...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	var failures []error
+	for i, backend := range sorted {
+		errs := distributeToBackends(file, image.StorageKey, image.ID, []database.Backend{backend}, OperationUpload, storageManager)
+		if len(errs) > 0 {
+			failures = append(failures, errs...)
+			continue
+		}
+		enqueueDistribution(image.ID, sorted[i+1:])
+		return failures
+	}
+	return failures
+}
+
+// enqueueDistribution 为图片登记等待补传的后端
+func enqueueDistribution(imageID uint, backends []database.Backend) {
+	if len(backends) == 0 {
+		return
+	}
+	jobs := make([]database.PendingDistribution, 0, len(backends))
+	for _, b := range backends {
+		jobs = append(jobs, database.PendingDistribution{ImageID: imageID, BackendID: b.ID, NextRunAt: time.Now()})
+	}
+	if err := database.DB.Create(&jobs).Error; err != nil {
+		log.Printf("Failed to queue distribution of image %d to %d backend(s): %v", imageID, len(jobs), err)
+	}
+}
+
+// InitDistributionQueue 启动后台任务，定期把队列中的图片补传到剩余后端
+func InitDistributionQueue(storageManager *manager.StorageManager) {
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		for range ticker.C {
+			processDistributionQueue(storageManager)
+		}
+	}()
+}
+
+// processDistributionQueue 处理到期的补传任务，失败时按指数退避重新排期
+func processDistributionQueue(storageManager *manager.StorageManager) {
+	var jobs []database.PendingDistribution
+	if err := database.DB.Where("next_run_at <= ?", time.Now()).Order("next_run_at asc").Limit(distributionQueueBatch).Find(&jobs).Error; err != nil {
+		log.Printf("Failed to load distribution queue: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		err := runDistributionJob(job, storageManager)
+		if err == nil {
+			database.DB.Delete(&job)
+			continue
+		}
+
+		job.Attempts++
+		job.LastError = err.Error()
+		if job.Attempts >= distributionQueueMaxAttempts {
+			log.Printf("Giving up distributing image %d to backend %d after %d attempts: %v", job.ImageID, job.BackendID, job.Attempts, err)
+			database.DB.Delete(&job)
+			continue
+		}
+		backoff := time.Duration(1<<job.Attempts) * 30 * time.Second
+		if backoff > distributionQueueMaxBackoff {
+			backoff = distributionQueueMaxBackoff
+		}
+		job.NextRunAt = time.Now().Add(backoff)
+		log.Printf("Distribution of image %d to backend %d failed (attempt %d), retrying at %s: %v", job.ImageID, job.BackendID, job.Attempts, job.NextRunAt.Format(time.RFC3339), err)
+		database.DB.Save(&job)
+	}
+}
+
+// runDistributionJob 从图片已有的存储位置读取文件并上传到目标后端
+func runDistributionJob(job database.PendingDistribution, storageManager *manager.StorageManager) error {
+	var image database.Image
+	if err := database.DB.Preload("StorageLocations").First(&image, job.ImageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // 图片已删除，任务作废
+		}
+		return err
+	}
+	for _, loc := range image.StorageLocations {
+		if loc.BackendID == job.BackendID {
+			return nil // 已存在于目标后端
+		}
+	}
+
+	uploader, found := storageManager.Get(job.BackendID)
+	if !found {
+		return fmt.Errorf("backend %d is not available", job.BackendID)
+	}
+	src, err := OpenImageContent(&image)
+	if err != nil {
+		return fmt.Errorf("failed to read source file: %w", err)
+	}
+	defer src.Close()
+
+	key := storageKeyFor(&image)
+	header := &multipart.FileHeader{Filename: image.OriginalFilename, Size: image.FileSize}
+	start := time.Now()
+	result, err := uploader.Upload(header, key, src)
+	recordStorageOperation(OperationBackfill, job.BackendID, key, start, err)
+	if err != nil {
+		return err
+	}
+
+	finalURL, deleteIdentifier := parseUploadResult(result, uploader.Type())
+	location := database.StorageLocation{
+		ImageID:          image.ID,
+		BackendID:        job.BackendID,
+		StorageType:      uploader.Type(),
+		URL:              finalURL,
+		DeleteIdentifier: deleteIdentifier,
+		IsActive:         uploader.Type() != storage.DryRunType,
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// 上传期间图片可能已被删除，此时不能留下指向不存在图片的存储位置
+		var count int64
+		if err := tx.Model(&database.Image{}).Where("id = ?", image.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(&location).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		deletePhysicalFiles([]database.StorageLocation{location}, storageManager)
+		return nil
+	}
+	return err
+}
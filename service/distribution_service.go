@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"sync"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+	"yanshu-imgbed/storage"
+)
+
+var (
+	// distributionSlots 限制同时进行的后端上传数，所有上传共用
+	distributionSlots     chan struct{}
+	distributionSlotsOnce sync.Once
+)
+
+// acquireDistributionSlot 占用一个上传名额，ctx 结束时放弃等待
+func acquireDistributionSlot(ctx context.Context) error {
+	distributionSlotsOnce.Do(func() {
+		workers := config.Cfg.Distribution.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		distributionSlots = make(chan struct{}, workers)
+	})
+	select {
+	case distributionSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseDistributionSlot() {
+	<-distributionSlots
+}
+
+// distributeToBackends 将文件上传到给定后端，operation 决定操作日志中记录的类型 (首次上传或补传)
+// 并发数受 distribution.workers 限制，每个后端失败后按指数退避重试，整个分发受 distribution.timeout_seconds 限制
+// 返回各个失败后端的最终错误
+func distributeToBackends(file *multipart.FileHeader, uniqueFilename string, imageID uint, backends []database.Backend, operation string, storageManager *manager.StorageManager) []error {
+	cfg := config.Cfg.Distribution
+	ctx := context.Background()
+	if cfg.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []error
+	)
+	for _, backend := range backends {
+		wg.Add(1)
+		go func(b database.Backend) {
+			defer wg.Done()
+			if err := uploadToBackendWithRetry(ctx, file, uniqueFilename, imageID, b, operation, storageManager); err != nil {
+				log.Printf("Giving up on backend %s (ID: %d) for %s: %v", b.Name, b.ID, uniqueFilename, err)
+				mu.Lock()
+				failures = append(failures, fmt.Errorf("backend %s: %w", b.Name, err))
+				mu.Unlock()
+			}
+		}(backend)
+	}
+	wg.Wait()
+	return failures
+}
+
+// uploadToBackendWithRetry 上传到单个后端，失败后按指数退避重试，成功时写入存储位置记录
+func uploadToBackendWithRetry(ctx context.Context, file *multipart.FileHeader, uniqueFilename string, imageID uint, b database.Backend, operation string, storageManager *manager.StorageManager) error {
+	uploader, found := storageManager.Get(b.ID)
+	if !found {
+		return errors.New("uploader not found")
+	}
+
+	cfg := config.Cfg.Distribution
+	backoff := time.Duration(cfg.BackoffMs) * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt <= cfg.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying upload to %s in %s (attempt %d/%d): %v", b.Name, backoff, attempt+1, cfg.Retries+1, lastErr)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("%w (last error: %v)", ctx.Err(), lastErr)
+			}
+			backoff *= 2
+		}
+
+		result, err := uploadOnce(ctx, file, uniqueFilename, b.ID, uploader, operation)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				return err
+			}
+			continue
+		}
+
+		finalURL, deleteIdentifier := parseUploadResult(result, uploader.Type())
+		location := database.StorageLocation{
+			ImageID:          imageID,
+			BackendID:        b.ID,
+			StorageType:      uploader.Type(),
+			URL:              finalURL,
+			DeleteIdentifier: deleteIdentifier,
+			IsActive:         uploader.Type() != storage.DryRunType,
+		}
+		if err := database.DB.Create(&location).Error; err != nil {
+			return fmt.Errorf("failed to record storage location: %w", err)
+		}
+		log.Printf("Successfully uploaded to backend: %s, URL: %s", b.Name, finalURL)
+		return nil
+	}
+	return lastErr
+}
+
+// uploadOnce 在一个上传名额内执行一次上传
+// Uploader 接口不支持取消，超时后不再等待结果；迟到的上传结果会被丢弃，对象可能残留在后端
+func uploadOnce(ctx context.Context, file *multipart.FileHeader, uniqueFilename string, backendID uint, uploader storage.Uploader, operation string) (string, error) {
+	if err := acquireDistributionSlot(ctx); err != nil {
+		return "", err
+	}
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer releaseDistributionSlot()
+		fileReader, err := file.Open()
+		if err != nil {
+			done <- outcome{err: fmt.Errorf("failed to open file: %w", err)}
+			return
+		}
+		defer fileReader.Close()
+
+		start := time.Now()
+		result, err := uploader.Upload(file, uniqueFilename, fileReader)
+		recordStorageOperation(operation, backendID, uniqueFilename, start, err)
+		done <- outcome{result: result, err: err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+)
+
+// ErrDuplicateGroupNotFound 指定的内容没有重复的图片记录
+var ErrDuplicateGroupNotFound = errors.New("no duplicate images found for this content")
+
+// DuplicateLocation 重复图片的一个存储位置
+type DuplicateLocation struct {
+	BackendID uint   `json:"backend_id"`
+	URL       string `json:"url"`
+	IsActive  bool   `json:"is_active"`
+}
+
+// DuplicateImage 重复组中的一条图片记录
+type DuplicateImage struct {
+	UUID      string              `json:"uuid"`
+	UserID    uint                `json:"user_id"`
+	Username  string              `json:"username"`
+	Filename  string              `json:"filename"`
+	CreatedAt time.Time           `json:"created_at"`
+	Locations []DuplicateLocation `json:"locations"`
+}
+
+// DuplicateGroup 内容相同 (SHA-256，旧数据按 MD5) 的一组图片记录
+type DuplicateGroup struct {
+	// ContentKey 分组依据，SHA-256 或旧数据的 MD5
+	ContentKey string           `json:"content_key"`
+	Images     []DuplicateImage `json:"images"`
+	// CrossUser 组内图片属于不同用户 (正常的秒传共享，仅供核对)
+	CrossUser bool `json:"cross_user"`
+	// Divergent 组内图片指向的有效物理文件不一致，删除其中一条可能留下孤立文件或误删
+	Divergent bool `json:"divergent"`
+}
+
+// DuplicateReport 重复图片报告的一页
+type DuplicateReport struct {
+	Total    int64            `json:"total"` // 重复组总数
+	Page     int              `json:"page"`
+	PageSize int              `json:"pageSize"`
+	Groups   []DuplicateGroup `json:"groups"`
+}
+
+// contentKeyExpr 图片内容的分组键：优先 SHA-256，引入 SHA-256 之前的旧数据使用 MD5
+const contentKeyExpr = "COALESCE(NULLIF(sha256, ''), md5)"
+
+// FindDuplicateImages 分页列出内容相同的图片记录组；divergentOnly 为 true 时只返回存储位置不一致的组
+func FindDuplicateImages(page, pageSize int, divergentOnly bool) (*DuplicateReport, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 50
+	}
+
+	groupQuery := database.DB.Model(&database.Image{}).
+		Select(contentKeyExpr + " AS content_key").
+		Group(contentKeyExpr).
+		Having("COUNT(*) > 1")
+
+	var keys []string
+	if divergentOnly {
+		// 是否一致需要比较存储位置，无法在 SQL 中判断，先取出全部重复组再分页
+		if err := groupQuery.Order("content_key").Pluck("content_key", &keys).Error; err != nil {
+			return nil, err
+		}
+		groups, err := loadDuplicateGroups(keys)
+		if err != nil {
+			return nil, err
+		}
+		var divergent []DuplicateGroup
+		for _, g := range groups {
+			if g.Divergent {
+				divergent = append(divergent, g)
+			}
+		}
+		report := &DuplicateReport{Total: int64(len(divergent)), Page: page, PageSize: pageSize, Groups: []DuplicateGroup{}}
+		if start := (page - 1) * pageSize; start < len(divergent) {
+			end := start + pageSize
+			if end > len(divergent) {
+				end = len(divergent)
+			}
+			report.Groups = divergent[start:end]
+		}
+		return report, nil
+	}
+
+	var total int64
+	if err := database.DB.Table("(?) AS dup", groupQuery).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	if err := groupQuery.Order("content_key").Limit(pageSize).Offset((page-1)*pageSize).Pluck("content_key", &keys).Error; err != nil {
+		return nil, err
+	}
+	groups, err := loadDuplicateGroups(keys)
+	if err != nil {
+		return nil, err
+	}
+	return &DuplicateReport{Total: total, Page: page, PageSize: pageSize, Groups: groups}, nil
+}
+
+// loadDuplicateGroups 加载各内容键下的图片记录和存储位置
+func loadDuplicateGroups(keys []string) ([]DuplicateGroup, error) {
+	groups := make([]DuplicateGroup, 0, len(keys))
+	if len(keys) == 0 {
+		return groups, nil
+	}
+
+	var images []database.Image
+	if err := database.DB.Preload("StorageLocations").
+		Where(contentKeyExpr+" IN ?", keys).
+		Order("created_at asc").Find(&images).Error; err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]uint, 0, len(images))
+	for _, image := range images {
+		userIDs = append(userIDs, image.UserID)
+	}
+	var users []database.User
+	if err := database.DB.Select("id", "username").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	usernames := make(map[uint]string, len(users))
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+	}
+
+	byKey := make(map[string][]database.Image, len(keys))
+	for _, image := range images {
+		key := image.SHA256
+		if key == "" {
+			key = image.MD5
+		}
+		byKey[key] = append(byKey[key], image)
+	}
+
+	for _, key := range keys {
+		members := byKey[key]
+		group := DuplicateGroup{ContentKey: key, Images: make([]DuplicateImage, 0, len(members))}
+		var firstFiles string
+		for i, image := range members {
+			entry := DuplicateImage{
+				UUID:      image.UUID,
+				UserID:    image.UserID,
+				Username:  usernames[image.UserID],
+				Filename:  image.OriginalFilename,
+				CreatedAt: image.CreatedAt,
+				Locations: make([]DuplicateLocation, 0, len(image.StorageLocations)),
+			}
+			for _, loc := range image.StorageLocations {
+				entry.Locations = append(entry.Locations, DuplicateLocation{BackendID: loc.BackendID, URL: loc.URL, IsActive: loc.IsActive})
+			}
+			group.Images = append(group.Images, entry)
+
+			if image.UserID != members[0].UserID {
+				group.CrossUser = true
+			}
+			files := activeFileSet(image.StorageLocations)
+			if i == 0 {
+				firstFiles = files
+			} else if files != firstFiles {
+				group.Divergent = true
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// activeFileSet 把有效存储位置归一成可比较的字符串 (按后端和地址排序)
+func activeFileSet(locations []database.StorageLocation) string {
+	var files []string
+	for _, loc := range locations {
+		if loc.IsActive {
+			files = append(files, fmt.Sprintf("%d|%s", loc.BackendID, loc.URL))
+		}
+	}
+	sort.Strings(files)
+	return strings.Join(files, "\n")
+}
+
+// loadDuplicateGroupImages 加载某个内容键下的所有图片记录
+func loadDuplicateGroupImages(contentKey string) ([]database.Image, error) {
+	var images []database.Image
+	if err := database.DB.Preload("StorageLocations").
+		Where(contentKeyExpr+" = ?", contentKey).
+		Order("created_at asc").Find(&images).Error; err != nil {
+		return nil, err
+	}
+	if len(images) < 2 {
+		return nil, ErrDuplicateGroupNotFound
+	}
+	return images, nil
+}
+
+// SyncDuplicateLocations 让组内每条图片记录都引用全部有效的物理文件，消除存储位置不一致
+// 返回新建的存储位置数
+func SyncDuplicateLocations(contentKey string) (int, error) {
+	images, err := loadDuplicateGroupImages(contentKey)
+	if err != nil {
+		return 0, err
+	}
+
+	union := make(map[string]database.StorageLocation)
+	for _, image := range images {
+		for _, loc := range image.StorageLocations {
+			if loc.IsActive {
+				union[fmt.Sprintf("%d|%s", loc.BackendID, loc.URL)] = loc
+			}
+		}
+	}
+
+	var created []database.StorageLocation
+	for _, image := range images {
+		have := make(map[string]bool, len(image.StorageLocations))
+		for _, loc := range image.StorageLocations {
+			have[fmt.Sprintf("%d|%s", loc.BackendID, loc.URL)] = true
+		}
+		for key, loc := range union {
+			if have[key] {
+				continue
+			}
+			created = append(created, database.StorageLocation{
+				ImageID:          image.ID,
+				BackendID:        loc.BackendID,
+				StorageType:      loc.StorageType,
+				URL:              loc.URL,
+				DeleteIdentifier: loc.DeleteIdentifier,
+				IsActive:         true,
+			})
+		}
+	}
+	if len(created) == 0 {
+		return 0, nil
+	}
+	if err := database.DB.Create(&created).Error; err != nil {
+		return 0, err
+	}
+	log.Printf("Synced duplicate group %s: %d storage location(s) added.", contentKey, len(created))
+	return len(created), nil
+}
+
+// CleanupDuplicateImages 删除组内除 keepUUID 以外的图片记录
+// 删除前先同步存储位置，保证保留的记录引用所有物理文件；物理文件仍被保留的记录引用，不会被删除
+func CleanupDuplicateImages(contentKey, keepUUID string, adminID uint, storageManager *manager.StorageManager) ([]string, error) {
+	images, err := loadDuplicateGroupImages(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, image := range images {
+		if image.UUID == keepUUID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, &UploadRejectedError{Reason: "keep_uuid is not part of this duplicate group"}
+	}
+
+	if _, err := SyncDuplicateLocations(contentKey); err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, image := range images {
+		if image.UUID == keepUUID {
+			continue
+		}
+		if err := DeleteImage(image.UUID, adminID, AdminRoleName, storageManager); err != nil {
+			return removed, fmt.Errorf("failed to delete duplicate %s: %w", image.UUID, err)
+		}
+		removed = append(removed, image.UUID)
+	}
+	log.Printf("Cleaned up duplicate group %s: kept %s, removed %d record(s).", contentKey, keepUUID, len(removed))
+	return removed, nil
+}
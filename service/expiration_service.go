@@ -0,0 +1,113 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+)
+
+// ErrImageExpired 图片设置了有效期且已经过期
+var ErrImageExpired = errors.New("image has expired")
+
+// maxExpiresIn 上传时允许设置的最长有效期
+const maxExpiresIn = 365 * 24 * time.Hour
+
+// ParseExpiresIn 解析上传参数 expires_in，返回过期时间，空字符串表示永不过期
+// 支持纯数字 (秒) 和带单位的时长，例如 "30m"、"12h"、"7d"
+func ParseExpiresIn(value string) (*time.Time, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return nil, nil
+	}
+
+	var duration time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		duration = time.Duration(seconds) * time.Second
+	} else if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return nil, &UploadRejectedError{Reason: fmt.Sprintf("Invalid expires_in '%s'", value)}
+		}
+		duration = time.Duration(n) * 24 * time.Hour
+	} else if duration, err = time.ParseDuration(value); err != nil {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("Invalid expires_in '%s'", value)}
+	}
+
+	if duration < time.Minute {
+		return nil, &UploadRejectedError{Reason: "expires_in must be at least one minute"}
+	}
+	if duration > maxExpiresIn {
+		return nil, &UploadRejectedError{Reason: "expires_in must not exceed 365 days"}
+	}
+	expiresAt := time.Now().Add(duration)
+	return &expiresAt, nil
+}
+
+// applyDuplicateExpiry 同一用户重复上传时以本次的有效期为准，未设置有效期的上传会让图片转为永久保存
+func applyDuplicateExpiry(image *database.Image, opts UploadOptions) {
+	image.ExpiresAt = opts.ExpiresAt
+	database.DB.Model(image).Update("expires_at", opts.ExpiresAt)
+}
+
+// isExpired 判断图片是否已过有效期
+func isExpired(image *database.Image) bool {
+	return image.ExpiresAt != nil && !image.ExpiresAt.After(time.Now())
+}
+
+// checkServable 检查图片当前是否可以对外访问
+func checkServable(image *database.Image) error {
+	if image.ModerationStatus == ModerationQuarantined {
+		return ErrImageQuarantined
+	}
+	if isExpired(image) {
+		return ErrImageExpired
+	}
+	return nil
+}
+
+// missingImageError 图片记录不存在时区分是已过期被清理还是从未存在
+func missingImageError(imageUUID string) error {
+	var count int64
+	database.DB.Model(&database.ExpiredImage{}).Where("uuid = ?", imageUUID).Count(&count)
+	if count > 0 {
+		return ErrImageExpired
+	}
+	return ErrImageNotFound
+}
+
+// InitExpirationScheduler 启动后台任务，定期删除已过期的图片
+func InitExpirationScheduler(storageManager *manager.StorageManager) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			processExpiredImages(storageManager)
+		}
+	}()
+}
+
+// processExpiredImages 删除所有已过期的图片并记录墓碑，之后访问返回 410 而不是 404
+func processExpiredImages(storageManager *manager.StorageManager) {
+	var expired []database.Image
+	if err := database.DB.Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).Find(&expired).Error; err != nil {
+		log.Printf("Failed to load expired images: %v", err)
+		return
+	}
+
+	for _, image := range expired {
+		err := DeleteImage(image.UUID, image.UserID, AdminRoleName, storageManager)
+		if err != nil && !errors.Is(err, ErrImageNotFound) {
+			log.Printf("Failed to delete expired image %s: %v", image.UUID, err)
+			continue
+		}
+		tombstone := database.ExpiredImage{UUID: image.UUID, ExpiredAt: *image.ExpiresAt}
+		if err := database.DB.Where("uuid = ?", image.UUID).FirstOrCreate(&tombstone).Error; err != nil {
+			log.Printf("Failed to record expiry of image %s: %v", image.UUID, err)
+		}
+		log.Printf("Expired image %s deleted.", image.UUID)
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+)
+
+// maxFailoverNotifyUUIDs 通知中列出的图片数量上限
+const maxFailoverNotifyUUIDs = 20
+
+// failoverDeadLocations 为失败次数达到阈值的存储位置所属图片补传副本，并把结果汇总成一条管理员通知
+// 补传数量按 min_replicas 计算，至少补一份来代替失效的副本；没有可用来源的图片只通知不补传
+func failoverDeadLocations(dead []database.StorageLocation, storageManager *manager.StorageManager) {
+	maxFailures := GetRetryCount()
+	want := GetMinReplicas()
+	if want < 1 {
+		want = 1
+	}
+	eligible := eligibleReplicaBackends(storageManager)
+
+	var queued, alreadyQueued, insufficient int
+	var noSource, failedOver []string
+	seen := make(map[uint]bool, len(dead))
+	for _, loc := range dead {
+		if seen[loc.ImageID] {
+			continue
+		}
+		seen[loc.ImageID] = true
+
+		var image database.Image
+		if err := database.DB.Preload("StorageLocations").Select("id", "uuid").First(&image, loc.ImageID).Error; err != nil {
+			continue
+		}
+
+		healthy := 0
+		usedSet := make(map[uint]bool, len(image.StorageLocations))
+		for _, l := range image.StorageLocations {
+			// 已有存储位置 (包括失效的) 的后端不再补传，避免同一后端出现重复记录
+			usedSet[l.BackendID] = true
+			if l.IsActive && l.FailureCount < maxFailures {
+				healthy++
+			}
+		}
+		if healthy == 0 {
+			noSource = append(noSource, image.UUID)
+			continue
+		}
+
+		var pending int64
+		database.DB.Model(&database.PendingDistribution{}).Where("image_id = ?", image.ID).Count(&pending)
+		if pending > 0 {
+			alreadyQueued++
+			continue
+		}
+
+		missing := want - healthy
+		if missing < 1 {
+			missing = 1
+		}
+		var targets []database.Backend
+		for _, b := range eligible {
+			if len(targets) == missing {
+				break
+			}
+			if !usedSet[b.ID] && !isBreakerOpen(b.ID) {
+				targets = append(targets, b)
+			}
+		}
+		if len(targets) == 0 {
+			insufficient++
+			continue
+		}
+		enqueueDistribution(image.ID, targets)
+		queued += len(targets)
+		failedOver = append(failedOver, image.UUID)
+	}
+
+	log.Printf("Failover: %d storage location(s) exceeded the failure threshold, %d backfill job(s) queued, %d image(s) without a healthy copy.",
+		len(dead), queued, len(noSource))
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "%d storage location(s) exceeded the failure threshold. %d backfill job(s) queued for %d image(s).", len(dead), queued, len(failedOver))
+	if alreadyQueued > 0 {
+		fmt.Fprintf(&msg, " %d image(s) already had backfills pending.", alreadyQueued)
+	}
+	if insufficient > 0 {
+		fmt.Fprintf(&msg, " %d image(s) have no other healthy backend to copy to.", insufficient)
+	}
+	if len(noSource) > 0 {
+		fmt.Fprintf(&msg, " %d image(s) have no healthy copy left: %s", len(noSource), joinLimited(noSource, maxFailoverNotifyUUIDs))
+	}
+	if len(failedOver) > 0 {
+		fmt.Fprintf(&msg, " Backfilled: %s", joinLimited(failedOver, maxFailoverNotifyUUIDs))
+	}
+	NotifyAdmin(NotificationLocationFailover, msg.String())
+}
+
+// joinLimited 用逗号连接最多 limit 项，超出部分以数量表示
+func joinLimited(items []string, limit int) string {
+	if len(items) <= limit {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:limit], ", "), len(items)-limit)
+}
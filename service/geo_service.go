@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+)
+
+// GeoRule 来自指定国家/地区的访客优先使用的后端，Countries 中的 "*" 匹配所有访客
+type GeoRule struct {
+	Countries []string `json:"countries"`
+	// Backends 优先使用的后端 ID，这些后端之间仍按访问策略选择
+	Backends []uint `json:"backends"`
+}
+
+// geoRange 一个 IP 段及其国家代码
+type geoRange struct {
+	prefix  netip.Prefix
+	country string
+}
+
+// geoRanges 按起始地址排序的 IP 段，启动时载入后只读
+var geoRanges []geoRange
+
+// InitGeoIP 载入配置的 IP 段归属地文件，文件中的 IP 段不应重叠
+func InitGeoIP() {
+	path := config.Cfg.GeoIP.CIDRFile
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to open GeoIP CIDR file, country lookup by IP disabled: %v", err)
+		return
+	}
+	defer f.Close()
+
+	var ranges []geoRange
+	skipped := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cidr, country, ok := strings.Cut(line, ",")
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if !ok || err != nil || country == "" {
+			skipped++
+			continue
+		}
+		ranges = append(ranges, geoRange{prefix: prefix.Masked(), country: country})
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Failed to read GeoIP CIDR file, country lookup by IP disabled: %v", err)
+		return
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].prefix.Addr().Less(ranges[j].prefix.Addr()) })
+	geoRanges = ranges
+	log.Printf("GeoIP loaded: %d range(s) from %s (%d invalid line(s) skipped)", len(ranges), path, skipped)
+}
+
+// LookupCountry 按 IP 段归属地文件查询 IP 所在的国家代码，查不到时返回空字符串
+func LookupCountry(ip string) string {
+	if len(geoRanges) == 0 {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	// i 之前的都是起始地址不大于 addr 的段，只有最后一个可能包含 addr
+	i := sort.Search(len(geoRanges), func(i int) bool { return addr.Less(geoRanges[i].prefix.Addr()) })
+	if i > 0 && geoRanges[i-1].prefix.Contains(addr) {
+		return geoRanges[i-1].country
+	}
+	return ""
+}
+
+// VisitorCountry 返回访客的国家代码：优先使用 CDN/反向代理写入的请求头，其次按 IP 查询
+func VisitorCountry(header http.Header, clientIP string) string {
+	if name := config.Cfg.GeoIP.CountryHeader; name != "" {
+		// Cloudflare 用 XX 表示未知，T1 表示 Tor
+		if v := strings.ToUpper(strings.TrimSpace(header.Get(name))); len(v) == 2 && v != "XX" && v != "T1" {
+			return v
+		}
+	}
+	return LookupCountry(clientIP)
+}
+
+// matchGeoRule 返回第一条匹配访客国家的规则，国家未知时只匹配 "*"
+func matchGeoRule(rules []GeoRule, country string) *GeoRule {
+	for i := range rules {
+		for _, c := range rules[i].Countries {
+			if c == "*" || (country != "" && c == country) {
+				return &rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// preferGeoBackends 把规则中的后端上的存储位置移到最前面，两部分内部保持访问策略排好的顺序，其余位置作为后备
+func preferGeoBackends(locations []database.StorageLocation, country string) {
+	rule := matchGeoRule(GetGeoRules(), country)
+	if rule == nil || len(rule.Backends) == 0 {
+		return
+	}
+	preferred := make(map[uint]bool, len(rule.Backends))
+	for _, id := range rule.Backends {
+		preferred[id] = true
+	}
+	sort.SliceStable(locations, func(i, j int) bool {
+		return preferred[locations[i].BackendID] && !preferred[locations[j].BackendID]
+	})
+}
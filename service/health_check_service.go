@@ -0,0 +1,266 @@
+package service
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+
+	"gorm.io/gorm"
+)
+
+// healthCheckWorkers 每轮检查同时进行的探测数
+const healthCheckWorkers = 8
+
+// backendBreaker 单个后端的熔断状态，由后台探测结果驱动
+type backendBreaker struct {
+	consecutiveFailures int
+	openUntil           time.Time
+	lastCheckedAt       time.Time
+	lastOK              bool
+	lastLatencyMs       int64
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = make(map[uint]*backendBreaker)
+)
+
+// BreakerState 后端熔断状态的快照
+type BreakerState struct {
+	CircuitOpen         bool       `json:"circuit_open"`
+	OpenUntil           *time.Time `json:"open_until"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastCheckedAt       *time.Time `json:"last_checked_at"`
+	LastCheckOK         bool       `json:"last_check_ok"`
+	LastLatencyMs       int64      `json:"last_latency_ms"`
+}
+
+// InitHealthChecker 启动后台健康检查：定期探测各后端和存储位置，记录结果并维护熔断状态
+func InitHealthChecker(storageManager *manager.StorageManager) {
+	interval := config.Cfg.HealthCheck.IntervalSeconds
+	if interval <= 0 {
+		log.Println("Background health check disabled")
+		return
+	}
+	go func() {
+		RunHealthCheck(storageManager)
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		for range ticker.C {
+			RunHealthCheck(storageManager)
+		}
+	}()
+}
+
+// RunHealthCheck 执行一轮检查：先探测每个后端最近的对象以维护熔断状态 (冷却结束的后端借此恢复)，再分批检查较久未检查的存储位置
+// 本轮失败次数达到阈值的存储位置会自动补传到其他后端
+func RunHealthCheck(storageManager *manager.StorageManager) {
+	var (
+		deadMu sync.Mutex
+		dead   []database.StorageLocation
+	)
+	check := func(loc *database.StorageLocation, backendProbe bool) {
+		if checkAndRecordLocation(loc, backendProbe) {
+			deadMu.Lock()
+			dead = append(dead, *loc)
+			deadMu.Unlock()
+		}
+	}
+	defer func() {
+		if len(dead) > 0 {
+			failoverDeadLocations(dead, storageManager)
+		}
+	}()
+
+	var backendIDs []uint
+	if err := database.DB.Model(&database.Backend{}).Pluck("id", &backendIDs).Error; err != nil {
+		log.Printf("Health check: failed to list backends: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, id := range backendIDs {
+		wg.Add(1)
+		go func(backendID uint) {
+			defer wg.Done()
+			var loc database.StorageLocation
+			if err := database.DB.Where("backend_id = ? AND is_active = ?", backendID, true).Order("id desc").First(&loc).Error; err != nil {
+				return
+			}
+			check(&loc, true)
+		}(id)
+	}
+	wg.Wait()
+
+	batchSize := config.Cfg.HealthCheck.BatchSize
+	if batchSize <= 0 {
+		batchSize = 200
+	}
+	staleBefore := time.Now().Add(-time.Duration(config.Cfg.HealthCheck.RecheckMinutes) * time.Minute)
+	query := database.DB.Where("is_active = ? AND (last_checked_at IS NULL OR last_checked_at < ?)", true, staleBefore)
+	if open := openBreakerBackends(); len(open) > 0 {
+		// 熔断中的后端只由上面的探测决定何时恢复，不再逐个检查其存储位置
+		query = query.Where("backend_id NOT IN ?", open)
+	}
+	var locations []database.StorageLocation
+	if err := query.Order("last_checked_at asc").Limit(batchSize).Find(&locations).Error; err != nil {
+		log.Printf("Health check: failed to load storage locations: %v", err)
+		return
+	}
+
+	jobs := make(chan *database.StorageLocation)
+	for i := 0; i < healthCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for loc := range jobs {
+				check(loc, false)
+			}
+		}()
+	}
+	for i := range locations {
+		jobs <- &locations[i]
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// checkAndRecordLocation 探测一个存储位置，更新其失败次数、检查时间和耗时，返回本次失败是否让失败次数刚好达到阈值
+// backendProbe 为 true 时结果同时计入所在后端的熔断状态；单个文件丢失不应让整个后端熔断，所以逐个检查的结果不计入
+func checkAndRecordLocation(loc *database.StorageLocation, backendProbe bool) bool {
+	start := time.Now()
+	ok := checkLocationHealth(loc)
+	latency := time.Since(start).Milliseconds()
+	if backendProbe {
+		recordBreakerResult(loc.BackendID, ok, latency)
+	}
+
+	failureCount := gorm.Expr("0")
+	if !ok {
+		failureCount = gorm.Expr("failure_count + 1")
+	}
+	// UpdateColumns 不修改 updated_at，健康检查不应触发热备同步
+	err := database.DB.Model(&database.StorageLocation{}).Where("id = ?", loc.ID).UpdateColumns(map[string]interface{}{
+		"failure_count":   failureCount,
+		"last_checked_at": start,
+		"last_latency_ms": latency,
+	}).Error
+	if err != nil {
+		log.Printf("Health check: failed to record result for location %d: %v", loc.ID, err)
+		return false
+	}
+	maxFailures := GetRetryCount()
+	return !ok && maxFailures > 0 && loc.FailureCount+1 == maxFailures
+}
+
+// checkLocationHealth 检查存储位置是否可访问：本地文件检查是否存在，远程地址发送 HEAD 请求
+func checkLocationHealth(loc *database.StorageLocation) bool {
+	if loc.StorageType == "local" {
+		parsedURL, err := url.Parse(loc.URL)
+		if err != nil {
+			return false
+		}
+		_, err = os.Stat("." + parsedURL.Path)
+		return err == nil
+	}
+	return checkURLHealth(loc.URL)
+}
+
+// recordBreakerResult 记录一次探测结果，连续失败达到阈值时熔断该后端
+func recordBreakerResult(backendID uint, ok bool, latencyMs int64) {
+	now := time.Now()
+	breakersMu.Lock()
+	b, exists := breakers[backendID]
+	if !exists {
+		b = &backendBreaker{}
+		breakers[backendID] = b
+	}
+	b.lastCheckedAt = now
+	b.lastOK = ok
+	b.lastLatencyMs = latencyMs
+	if ok {
+		if !b.openUntil.IsZero() {
+			log.Printf("Backend %d recovered, circuit closed", backendID)
+		}
+		b.consecutiveFailures = 0
+		b.openUntil = time.Time{}
+		breakersMu.Unlock()
+		return
+	}
+	b.consecutiveFailures++
+	threshold := config.Cfg.HealthCheck.BreakerThreshold
+	if threshold > 0 && b.consecutiveFailures >= threshold && now.After(b.openUntil) {
+		b.openUntil = now.Add(time.Duration(config.Cfg.HealthCheck.BreakerCooldownSeconds) * time.Second)
+		log.Printf("Backend %d failed %d consecutive health checks, circuit open until %s", backendID, b.consecutiveFailures, b.openUntil.Format(time.RFC3339))
+		failures, openUntil := b.consecutiveFailures, b.openUntil
+		breakersMu.Unlock()
+		// 在锁外写入推送记录，避免阻塞访问图片时的熔断检查
+		emitBackendFailedWebhook(backendID, failures, openUntil)
+		return
+	}
+	breakersMu.Unlock()
+}
+
+// isBreakerOpen 判断后端是否处于熔断冷却期
+func isBreakerOpen(backendID uint) bool {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[backendID]
+	return ok && time.Now().Before(b.openUntil)
+}
+
+// openBreakerBackends 返回处于熔断冷却期的后端 ID
+func openBreakerBackends() []uint {
+	now := time.Now()
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	var ids []uint
+	for id, b := range breakers {
+		if now.Before(b.openUntil) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// GetBreakerState 返回后端当前的熔断状态，尚未探测过时返回零值
+func GetBreakerState(backendID uint) BreakerState {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[backendID]
+	if !ok {
+		return BreakerState{}
+	}
+	state := BreakerState{
+		CircuitOpen:         time.Now().Before(b.openUntil),
+		ConsecutiveFailures: b.consecutiveFailures,
+		LastCheckOK:         b.lastOK,
+		LastLatencyMs:       b.lastLatencyMs,
+	}
+	if state.CircuitOpen {
+		openUntil := b.openUntil
+		state.OpenUntil = &openUntil
+	}
+	if !b.lastCheckedAt.IsZero() {
+		lastCheckedAt := b.lastCheckedAt
+		state.LastCheckedAt = &lastCheckedAt
+	}
+	return state
+}
+
+// deferOpenBreakers 把熔断中的后端上的存储位置移到最后，其余保持原有顺序
+func deferOpenBreakers(locations []database.StorageLocation) {
+	open := make(map[uint]bool)
+	for _, loc := range locations {
+		if _, seen := open[loc.BackendID]; !seen {
+			open[loc.BackendID] = isBreakerOpen(loc.BackendID)
+		}
+	}
+	sort.SliceStable(locations, func(i, j int) bool {
+		return !open[locations[i].BackendID] && open[locations[j].BackendID]
+	})
+}
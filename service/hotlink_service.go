@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/util"
+)
+
+// 防盗链模式
+const (
+	HotlinkOff       = "off"
+	HotlinkWhitelist = "whitelist" // 只允许列表中的来源
+	HotlinkBlacklist = "blacklist" // 拒绝列表中的来源
+)
+
+// 不允许的来源的处理方式
+const (
+	HotlinkForbid      = "forbid"
+	HotlinkPlaceholder = "placeholder"
+	HotlinkWatermark   = "watermark"
+)
+
+// defaultHotlinkWatermarkText 未配置水印文字时盗链图片上绘制的文字
+const defaultHotlinkWatermarkText = "HOTLINK"
+
+// maxHotlinkWatermarkBytes 盗链水印只处理不超过该大小的图片，避免被盗链的大图拖垮服务器
+const maxHotlinkWatermarkBytes = 20 * 1024 * 1024
+
+// ErrHotlinkNotWatermarkable 图片格式或大小不支持实时加水印
+var ErrHotlinkNotWatermarkable = errors.New("image cannot be watermarked")
+
+// CheckHotlink 判断请求来源是否允许访问图片，允许时返回空字符串，否则返回应采取的处理方式
+// referer 为空时使用 origin；与本站 (requestHost) 同源的请求总是允许
+func CheckHotlink(referer, origin, requestHost string) string {
+	settings := GetHotlinkSettings()
+	if settings.Mode == HotlinkOff || settings.Mode == "" {
+		return ""
+	}
+
+	source := referer
+	if source == "" {
+		source = origin
+	}
+	host := sourceHost(source)
+	if host == "" {
+		if settings.AllowEmpty {
+			return ""
+		}
+		return hotlinkAction(settings)
+	}
+	if self := stripPort(requestHost); self != "" && strings.EqualFold(host, self) {
+		return ""
+	}
+
+	matched := false
+	for _, pattern := range settings.Domains {
+		if matchHotlinkDomain(host, pattern) {
+			matched = true
+			break
+		}
+	}
+	if matched == (settings.Mode == HotlinkWhitelist) {
+		return ""
+	}
+	return hotlinkAction(settings)
+}
+
+// hotlinkAction 返回实际采取的处理方式，占位图未配置时退回 403
+func hotlinkAction(settings HotlinkSettings) string {
+	if settings.Action == HotlinkPlaceholder && settings.PlaceholderURL == "" {
+		return HotlinkForbid
+	}
+	if settings.Action == "" {
+		return HotlinkForbid
+	}
+	return settings.Action
+}
+
+// sourceHost 从 Referer/Origin 中取出小写的主机名，无法解析时返回空字符串
+func sourceHost(source string) string {
+	if source == "" || source == "null" {
+		return ""
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func stripPort(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(hostport)
+}
+
+// matchHotlinkDomain 判断主机名是否匹配域名规则，"*.example.com" 同时匹配 example.com 本身
+func matchHotlinkDomain(host, pattern string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		base := pattern[2:]
+		return host == base || strings.HasSuffix(host, "."+base)
+	}
+	return host == pattern
+}
+
+// RenderHotlinkWatermark 读取图片并实时绘制水印，返回编码后的内容和类型
+// 使用全站水印设置的样式 (不受 watermark_enabled 和最小尺寸限制)，只支持 JPEG/PNG/GIF (取第一帧)
+func RenderHotlinkWatermark(imageUUID string) ([]byte, string, error) {
+	var img database.Image
+	if err := database.DB.Preload("StorageLocations").Where("uuid = ?", imageUUID).First(&img).Error; err != nil {
+		return nil, "", err
+	}
+	if img.FileSize > maxHotlinkWatermarkBytes {
+		return nil, "", ErrHotlinkNotWatermarkable
+	}
+	switch img.ContentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, "", ErrHotlinkNotWatermarkable
+	}
+
+	rc, err := OpenImageContent(&img)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxHotlinkWatermarkBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxHotlinkWatermarkBytes {
+		return nil, "", ErrHotlinkNotWatermarkable
+	}
+	decoded, format, err := util.DecodeImage(data)
+	if err != nil {
+		return nil, "", ErrHotlinkNotWatermarkable
+	}
+
+	settings := GetWatermarkSettings()
+	settings.MinWidth, settings.MinHeight = 0, 0
+	if settings.Type != "image" && settings.Text == "" {
+		settings.Text = defaultHotlinkWatermarkText
+	}
+	marked, _, err := applyWatermark(decoded, settings)
+	if err != nil {
+		return nil, "", err
+	}
+	if format != "png" {
+		format = "jpeg"
+	}
+	return util.EncodeImage(marked, format, util.DefaultJPEGQuality)
+}
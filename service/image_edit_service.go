@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// maxFilenameLength 与 Image.OriginalFilename 的列宽一致
+	maxFilenameLength = 255
+	// maxAltTextLength 与 Image.AltText 的列宽一致
+	maxAltTextLength = 500
+	// maxDescriptionLength 描述的最大字符数
+	maxDescriptionLength = 5000
+)
+
+// ImageEditInput 修改图片信息的参数，nil 表示该字段不修改
+type ImageEditInput struct {
+	OriginalFilename *string `json:"original_filename"`
+	Description      *string `json:"description"`
+	AltText          *string `json:"alt_text"`
+	// Tags 替换图片的全部标签，空数组表示清空
+	Tags *[]string `json:"tags"`
+}
+
+// validate 规范化并校验修改参数，返回需要更新的列
+func (in *ImageEditInput) validate() (map[string]interface{}, error) {
+	updates := make(map[string]interface{})
+	if in.OriginalFilename != nil {
+		name := strings.TrimSpace(*in.OriginalFilename)
+		if name == "" {
+			return nil, &UploadRejectedError{Reason: "original_filename must not be empty"}
+		}
+		if len(name) > maxFilenameLength {
+			return nil, &UploadRejectedError{Reason: fmt.Sprintf("original_filename must not exceed %d bytes", maxFilenameLength)}
+		}
+		if strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, 0) {
+			return nil, &UploadRejectedError{Reason: "original_filename must not contain path separators"}
+		}
+		updates["original_filename"] = name
+	}
+	if in.Description != nil {
+		if utf8.RuneCountInString(*in.Description) > maxDescriptionLength {
+			return nil, &UploadRejectedError{Reason: fmt.Sprintf("description must not exceed %d characters", maxDescriptionLength)}
+		}
+		updates["description"] = strings.TrimSpace(*in.Description)
+	}
+	if in.AltText != nil {
+		alt := strings.TrimSpace(*in.AltText)
+		if len(alt) > maxAltTextLength {
+			return nil, &UploadRejectedError{Reason: fmt.Sprintf("alt_text must not exceed %d bytes", maxAltTextLength)}
+		}
+		updates["alt_text"] = alt
+	}
+	if in.Tags != nil && len(*in.Tags) > 0 {
+		tags, err := NormalizeTags(*in.Tags)
+		if err != nil {
+			return nil, err
+		}
+		*in.Tags = tags
+	}
+	return updates, nil
+}
+
+// UpdateImageInfo 修改图片的文件名、描述、替代文本和标签，普通用户只能修改自己的图片
+func UpdateImageInfo(imageUUID string, in ImageEditInput, userID uint, userRole string) (*database.Image, error) {
+	var image database.Image
+	query := database.DB.Where("uuid = ?", imageUUID)
+	if !CanAccessAllImages(userRole) {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+
+	updates, err := in.validate()
+	if err != nil {
+		return nil, err
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(&image).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if in.Tags != nil {
+			return replaceImageTags(tx, image.ID, *in.Tags)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.DB.Preload("Tags").First(&image, image.ID).Error; err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
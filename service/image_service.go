@@ -1,12 +1,13 @@
 package service
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"log"
 	"math/rand"
 	"mime/multipart"
@@ -16,6 +17,7 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,36 +31,42 @@ import (
 )
 
 // ListImagesResponse is the new structure for paginated image lists.
+// In cursor mode Page is 0, Total is only set when requested and NextCursor is empty on the last page.
 type ListImagesResponse struct {
-	Total    int64            `json:"total"`
-	Page     int              `json:"page"`
-	PageSize int              `json:"pageSize"`
-	Images   []database.Image `json:"images"`
+	Total      *int64           `json:"total,omitempty"`
+	Page       int              `json:"page"`
+	PageSize   int              `json:"pageSize"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+	Images     []database.Image `json:"images"`
 }
 
+// ErrRandomPoolForbidden is returned when a regular user tries to add images to the random pool
+// while the allow_user_random_pool setting is disabled.
+var ErrRandomPoolForbidden = errors.New("permission denied: adding images to the random pool is restricted to administrators")
+
+// ErrImageNotFound is returned when an image does not exist or is not visible to the caller.
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrNoHealthyLocation is returned when none of an image's storage locations can currently serve it.
+var ErrNoHealthyLocation = errors.New("no available storage locations for this image")
+
+// ErrLocationsUnreachable is returned when an image has usable locations but none of them passed the health check.
+var ErrLocationsUnreachable = errors.New("all available storage locations are currently unreachable")
+
+// ErrNotImageOwner is returned when a regular user's batch operation includes images they do not own.
+var ErrNotImageOwner = errors.New("permission denied: you do not own all the selected images")
+
 var (
-	tasks            = make(map[string]*Task)
-	taskMu           sync.Mutex
 	randomImageUUIDs []string
 	cacheMutex       sync.RWMutex
 )
 
-type Task struct {
-	ID        string    `json:"id"`
-	Type      string    `json:"type"`
-	Status    string    `json:"status"` // "running", "completed", "failed"
-	Progress  int       `json:"progress"`
-	Total     int       `json:"total"`
-	Message   string    `json:"message"`
-	CreatedAt time.Time `json:"created_at"`
-}
-
 // ToggleImageRandomStatus toggles the AllowRandom status for a single image.
 func ToggleImageRandomStatus(imageUUID string) (*database.Image, error) {
 	var image database.Image
 	if err := database.DB.Where("uuid = ?", imageUUID).First(&image).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("image not found")
+			return nil, ErrImageNotFound
 		}
 		return nil, err
 	}
@@ -74,39 +82,65 @@ func ToggleImageRandomStatus(imageUUID string) (*database.Image, error) {
 	return &image, nil
 }
 
-func getImageDimensions(file *multipart.FileHeader) (int, int, error) {
-	src, err := file.Open()
-	if err != nil {
-		return 0, 0, err
+// ToggleImageRandomStatusForUser toggles the AllowRandom status for an image owned by a regular user.
+// Adding to the pool requires the allow_user_random_pool setting; removing is always allowed.
+func ToggleImageRandomStatusForUser(imageUUID string, userID uint) (*database.Image, error) {
+	var image database.Image
+	if err := database.DB.Where("uuid = ? AND user_id = ?", imageUUID, userID).First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w or permission denied", ErrImageNotFound)
+		}
+		return nil, err
+	}
+	if !image.AllowRandom && !GetAllowUserRandomPool() {
+		return nil, ErrRandomPoolForbidden
 	}
-	defer src.Close()
+	return ToggleImageRandomStatus(imageUUID)
+}
 
-	if _, err := src.Seek(0, 0); err != nil {
-		return 0, 0, fmt.Errorf("failed to seek file start: %w", err)
+// UploadImage handles the entire image upload flow, including deduplication,
+// and emits the image.uploaded webhook event on success.
+func UploadImage(file *multipart.FileHeader, userID uint, targetBackendIDs []uint, opts UploadOptions, storageManager *manager.StorageManager) (*database.Image, error) {
+	image, err := uploadImage(file, userID, targetBackendIDs, opts, storageManager)
+	if err == nil {
+		emitImageWebhook(WebhookImageUploaded, image)
 	}
+	return image, err
+}
 
-	config, _, err := image.DecodeConfig(src)
+func uploadImage(file *multipart.FileHeader, userID uint, targetBackendIDs []uint, opts UploadOptions, storageManager *manager.StorageManager) (*database.Image, error) {
+	opts.originalSize = file.Size
+	if err := checkStorageQuota(userID, file.Size); err != nil {
+		return nil, err
+	}
+	file, err := processUploadFile(file, userID, &opts)
 	if err != nil {
-		log.Printf("Could not decode image config for %s: %v. This might be an SVG or other format.", file.Filename, err)
-		return 0, 0, nil
+		return nil, err
+	}
+
+	if opts.moderation, err = moderateUpload(file); err != nil {
+		return nil, err
 	}
-	return config.Width, config.Height, nil
-}
 
-// UploadImage handles the entire image upload flow, including deduplication.
-func UploadImage(file *multipart.FileHeader, userID uint, targetBackendIDs []uint, storageManager *manager.StorageManager) (*database.Image, error) {
-	fileMD5, err := util.CalculateFileMD5(file)
+	digest, err := util.DigestFile(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to calculate file MD5: %w", err)
+		return nil, fmt.Errorf("failed to hash uploaded file: %w", err)
 	}
 
 	var existingImageForUser database.Image
 	err = database.DB.Preload("StorageLocations.Backend").
-		Where("md5 = ? AND user_id = ?", fileMD5, userID).
+		Where("user_id = ?", userID).Where(sameContentQuery(digest)).
 		First(&existingImageForUser).Error
 
 	if err == nil {
-		log.Printf("Duplicate image for user %d (MD5: %s). Backfilling.", userID, fileMD5)
+		log.Printf("Duplicate image for user %d (SHA256: %s). Backfilling.", userID, digest.SHA256)
+		if len(opts.Annotations) > 0 {
+			// 重复上传时合并注释而不是覆盖，保留之前记录的来源信息
+			existingImageForUser.Annotations = opts.mergedAnnotationsJSON(existingImageForUser.Annotations)
+			database.DB.Model(&existingImageForUser).Update("annotations", existingImageForUser.Annotations)
+		}
+		applyDuplicateExpiry(&existingImageForUser, opts)
+		applyDuplicateAlbum(&existingImageForUser, opts)
 		return handleDuplicateImage(&existingImageForUser, file, targetBackendIDs, storageManager)
 	}
 
@@ -116,29 +150,31 @@ func UploadImage(file *multipart.FileHeader, userID uint, targetBackendIDs []uin
 
 	var existingImageForOtherUser database.Image
 	err = database.DB.Preload("StorageLocations.Backend").
-		Where("md5 = ?", fileMD5).
+		Where(sameContentQuery(digest)).
 		First(&existingImageForOtherUser).Error
 
 	if err == nil {
-		log.Printf("Image exists from another user (MD5: %s). Creating new metadata reference for user %d.", fileMD5, userID)
-		return handleSharedImage(file, userID, fileMD5, &existingImageForOtherUser)
+		log.Printf("Image exists from another user (SHA256: %s). Creating new metadata reference for user %d.", digest.SHA256, userID)
+		return handleSharedImage(file, userID, digest, opts, &existingImageForOtherUser)
 	}
 
 	if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, fmt.Errorf("database error during global duplicate check: %w", err)
 	}
 
-	log.Printf("New image for the system (MD5: %s). Starting fresh upload for user %d.", fileMD5, userID)
-	return handleNewImage(file, userID, fileMD5, targetBackendIDs, storageManager)
+	log.Printf("New image for the system (SHA256: %s). Starting fresh upload for user %d.", digest.SHA256, userID)
+	return handleNewImage(file, userID, digest, opts, targetBackendIDs, storageManager)
 }
 
-// handleNewImage uploads a completely new file and creates all records.
-func handleNewImage(file *multipart.FileHeader, userID uint, fileMD5 string, targetBackendIDs []uint, storageManager *manager.StorageManager) (*database.Image, error) {
-	width, height, err := getImageDimensions(file)
-	if err != nil {
-		log.Printf("Could not get image dimensions for %s: %v. Proceeding with 0x0.", file.Filename, err)
-	}
+// sameContentQuery 构造按文件内容查找图片的条件
+// 以 SHA-256 为准；引入 SHA-256 之前的旧记录没有该值，只能退回按 MD5 匹配
+func sameContentQuery(digest *util.FileDigest) *gorm.DB {
+	return database.DB.Where("sha256 = ?", digest.SHA256).
+		Or("(sha256 IS NULL OR sha256 = '') AND md5 = ?", digest.MD5)
+}
 
+// handleNewImage uploads a completely new file and creates all records.
+func handleNewImage(file *multipart.FileHeader, userID uint, digest *util.FileDigest, opts UploadOptions, targetBackendIDs []uint, storageManager *manager.StorageManager) (*database.Image, error) {
 	var activeBackends []database.Backend
 	query := database.DB.Where("allow_upload = ?", true)
 	if len(targetBackendIDs) > 0 {
@@ -152,26 +188,41 @@ func handleNewImage(file *multipart.FileHeader, userID uint, fileMD5 string, tar
 	}
 
 	image := &database.Image{
-		UUID:             uuid.New().String(),
-		MD5:              fileMD5,
-		OriginalFilename: file.Filename,
-		FileSize:         file.Size,
-		ContentType:      file.Header.Get("Content-Type"),
-		Width:            width,
-		Height:           height,
-		UserID:           userID,
+		UUID:                uuid.New().String(),
+		MD5:                 digest.MD5,
+		SHA256:              digest.SHA256,
+		OriginalFilename:    file.Filename,
+		FileSize:            file.Size,
+		OriginalSize:        opts.originalSize,
+		ContentType:         file.Header.Get("Content-Type"),
+		Width:               digest.Width,
+		Height:              digest.Height,
+		UserID:              userID,
+		Annotations:         opts.annotationsJSON(),
+		Folder:              opts.Folder,
+		OriginalContentType: opts.originalContentType,
+		ModerationStatus:    opts.moderation.Status,
+		ModerationScore:     opts.moderation.Score,
+		ExpiresAt:           opts.ExpiresAt,
+		AlbumID:             opts.AlbumID,
+		Visibility:          opts.Visibility,
 	}
-	if err := database.DB.Create(&image).Error; err != nil {
+	applyUploaderInfo(image, opts)
+	if err := createImageWithStorageKey(image, opts.FilenameStrategy, digest); err != nil {
 		return nil, fmt.Errorf("failed to create image record: %w", err)
 	}
 
-	uniqueFilename := fmt.Sprintf("%s%s", image.UUID, filepath.Ext(file.Filename))
-	distributeToBackends(file, uniqueFilename, image.ID, activeBackends, storageManager)
+	var failures []error
+	if GetAsyncDistribution() && len(activeBackends) > 1 {
+		failures = distributeAsync(file, image, activeBackends, storageManager)
+	} else {
+		failures = distributeToBackends(file, image.StorageKey, image.ID, activeBackends, OperationUpload, storageManager)
+	}
 
 	database.DB.Preload("StorageLocations.Backend").First(&image, image.ID)
 	if len(image.StorageLocations) == 0 {
 		database.DB.Delete(&image)
-		return nil, errors.New("upload failed on all active backends")
+		return nil, fmt.Errorf("upload failed on all active backends: %w", errors.Join(failures...))
 	}
 
 	return image, nil
@@ -203,33 +254,46 @@ func handleDuplicateImage(existingImage *database.Image, file *multipart.FileHea
 		return existingImage, nil
 	}
 
-	uniqueFilename := fmt.Sprintf("%s%s", existingImage.UUID, filepath.Ext(file.Filename))
-	distributeToBackends(file, uniqueFilename, existingImage.ID, backendsToBackfill, storageManager)
+	distributeToBackends(file, storageKeyFor(existingImage), existingImage.ID, backendsToBackfill, OperationBackfill, storageManager)
 
 	database.DB.Preload("StorageLocations.Backend").First(&existingImage, existingImage.ID)
 	return existingImage, nil
 }
 
 // handleSharedImage creates a new Image metadata record for a user, linking to existing physical files.
-func handleSharedImage(file *multipart.FileHeader, userID uint, fileMD5 string, existingImage *database.Image) (*database.Image, error) {
-	width, height, err := getImageDimensions(file)
-	if err != nil {
-		log.Printf("Could not get image dimensions for shared image %s: %v. Using existing.", file.Filename, err)
-		width = existingImage.Width
-		height = existingImage.Height
+func handleSharedImage(file *multipart.FileHeader, userID uint, digest *util.FileDigest, opts UploadOptions, existingImage *database.Image) (*database.Image, error) {
+	width, height := digest.Width, digest.Height
+	if width == 0 && height == 0 {
+		width, height = existingImage.Width, existingImage.Height
 	}
 
 	// Create a new image record for the new user. This will now succeed due to the composite unique index.
 	image := &database.Image{
-		UUID:             uuid.New().String(),
-		MD5:              fileMD5,
-		OriginalFilename: file.Filename,
-		FileSize:         file.Size,
-		ContentType:      file.Header.Get("Content-Type"),
-		Width:            width,
-		Height:           height,
-		UserID:           userID,
+		UUID:                uuid.New().String(),
+		MD5:                 digest.MD5,
+		SHA256:              digest.SHA256,
+		OriginalFilename:    file.Filename,
+		FileSize:            file.Size,
+		OriginalSize:        opts.originalSize,
+		ContentType:         file.Header.Get("Content-Type"),
+		Width:               width,
+		Height:              height,
+		UserID:              userID,
+		Annotations:         opts.annotationsJSON(),
+		Folder:              opts.Folder,
+		OriginalContentType: opts.originalContentType,
+		ModerationStatus:    opts.moderation.Status,
+		ModerationScore:     opts.moderation.Score,
+		ExpiresAt:           opts.ExpiresAt,
+		AlbumID:             opts.AlbumID,
+		Visibility:          opts.Visibility,
 	}
+	applyUploaderInfo(image, opts)
+	return linkSharedImage(image, existingImage)
+}
+
+// linkSharedImage creates the image record and storage locations pointing to the existing image's physical files.
+func linkSharedImage(image *database.Image, existingImage *database.Image) (*database.Image, error) {
 	if err := database.DB.Create(&image).Error; err != nil {
 		return nil, fmt.Errorf("failed to create shared image record: %w", err)
 	}
@@ -260,112 +324,125 @@ func handleSharedImage(file *multipart.FileHeader, userID uint, fileMD5 string,
 	return image, nil
 }
 
-func distributeToBackends(file *multipart.FileHeader, uniqueFilename string, imageID uint, backends []database.Backend, storageManager *manager.StorageManager) {
-	var wg sync.WaitGroup
-	for _, backend := range backends {
-		wg.Add(1)
-		go func(b database.Backend) {
-			defer wg.Done()
-			uploader, found := storageManager.Get(b.ID)
-			if !found {
-				log.Printf("Uploader not found for backend %s (ID: %d), skipping.", b.Name, b.ID)
-				return
-			}
-
-			fileReader, err := file.Open()
-			if err != nil {
-				log.Printf("Failed to open file for backend %s: %v", b.Name, err)
-				return
-			}
-			defer fileReader.Close()
-
-			uploadResultURL, err := uploader.Upload(file, uniqueFilename, fileReader)
-			if err != nil {
-				log.Printf("Failed to upload to %s (type: %s): %v", b.Name, uploader.Type(), err)
-				return
-			}
-
-			finalURL, deleteIdentifier := parseUploadResult(uploadResultURL, uploader.Type())
-			location := database.StorageLocation{
-				ImageID:          imageID,
-				BackendID:        b.ID,
-				StorageType:      uploader.Type(),
-				URL:              finalURL,
-				DeleteIdentifier: deleteIdentifier,
-				IsActive:         true,
-			}
-			database.DB.Create(&location)
-			log.Printf("Successfully uploaded to backend: %s, URL: %s", b.Name, finalURL)
-		}(backend)
-	}
-	wg.Wait()
-}
-
 // DeleteImage deletes an image and its stored files from all backends.
 func DeleteImage(imageUUID string, userID uint, userRole string, storageManager *manager.StorageManager) error {
 	var image database.Image
 	query := database.DB.Preload("StorageLocations").Where("uuid = ?", imageUUID)
-	if userRole != "admin" {
+	if !CanAccessAllImages(userRole) {
 		query = query.Where("user_id = ?", userID)
 	}
 	err := query.First(&image).Error
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return errors.New("image not found or permission denied")
+			return fmt.Errorf("%w or permission denied", ErrImageNotFound)
 		}
 		return err
 	}
 
 	var count int64
 	database.DB.Model(&database.Image{}).Where("md5 = ? AND id != ?", image.MD5, image.ID).Count(&count)
-
-	if count == 0 {
-		var wg sync.WaitGroup
-		for _, loc := range image.StorageLocations {
-			wg.Add(1)
-			go func(location database.StorageLocation) {
-				defer wg.Done()
-				uploader, found := storageManager.Get(location.BackendID)
-				if !found {
-					log.Printf("Uploader for BackendID %d not found, cannot delete file at %s", location.BackendID, location.URL)
-					return
-				}
-				deleteID := location.DeleteIdentifier
-				if location.StorageType == "local" {
-					if parsedURL, err := url.Parse(location.URL); err == nil {
-						deleteID = path.Base(parsedURL.Path)
-					}
-				}
-				if err := uploader.Delete(deleteID); err != nil {
-					log.Printf("Failed to delete file from %s (URL: %s): %v", location.StorageType, location.URL, err)
-				} else {
-					log.Printf("Successfully deleted file from %s (URL: %s)", location.StorageType, location.URL)
-				}
-			}(loc)
-		}
-		wg.Wait()
-	} else {
+	deleteFiles := count == 0
+	if !deleteFiles {
 		log.Printf("Skipping physical file deletion for MD5 %s as it is referenced by other records.", image.MD5)
 	}
+	graceHours := GetDeleteGraceHours()
 
-	return database.DB.Transaction(func(tx *gorm.DB) error {
+	// 待删除记录与元数据在同一事务中写入，事务失败时不会留下会误删文件的计划
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if deleteFiles && graceHours > 0 {
+			if err := schedulePhysicalDeletion(tx, &image, graceHours); err != nil {
+				return fmt.Errorf("failed to schedule physical deletion: %w", err)
+			}
+		}
 		if err := tx.Delete(&database.StorageLocation{}, "image_id = ?", image.ID).Error; err != nil {
 			return err
 		}
+		if err := tx.Delete(&database.ImageMetadataCache{}, "image_id = ?", image.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&database.PendingDistribution{}, "image_id = ?", image.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&database.ImageTag{}, "image_id = ?", image.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&database.ShareLink{}, "image_id = ?", image.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&database.ImageDailyView{}, "image_id = ?", image.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&database.ImageSlug{}, "image_id = ?", image.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&database.Album{}).Where("cover_uuid = ?", image.UUID).Update("cover_uuid", "").Error; err != nil {
+			return err
+		}
 		return tx.Delete(&image).Error
 	})
+	if err != nil {
+		return err
+	}
+
+	if deleteFiles && graceHours <= 0 {
+		deletePhysicalFiles(image.StorageLocations, storageManager)
+	}
+	removePosterCache(image.UUID)
+	removeProxyCache(image.UUID)
+	emitImageWebhook(WebhookImageDeleted, &image)
+	return nil
 }
 
-func GetHealthyStorageLocation(imageUUID string) (*database.StorageLocation, error) {
+// deletePhysicalFiles 并发地从各个后端删除物理文件
+func deletePhysicalFiles(locations []database.StorageLocation, storageManager *manager.StorageManager) {
+	var wg sync.WaitGroup
+	for _, loc := range locations {
+		wg.Add(1)
+		go func(location database.StorageLocation) {
+			defer wg.Done()
+			uploader, found := storageManager.Get(location.BackendID)
+			deleteID := location.DeleteIdentifier
+			// 本地上传的文件都在存储目录顶层，只有原地导入的文件会带有子目录形式的删除标识
+			if location.StorageType == "local" && deleteID == "" {
+				if parsedURL, err := url.Parse(location.URL); err == nil {
+					deleteID = path.Base(parsedURL.Path)
+				}
+			}
+			if !found {
+				log.Printf("Uploader for BackendID %d not found, queued deletion of %s for retry", location.BackendID, location.URL)
+				recordFailedDeletion(location, deleteID, fmt.Errorf("backend %d is not loaded", location.BackendID))
+				return
+			}
+			start := time.Now()
+			err := uploader.Delete(deleteID)
+			recordStorageOperation(OperationDelete, location.BackendID, deleteID, start, err)
+			if err != nil {
+				log.Printf("Failed to delete file from %s (URL: %s), queued for retry: %v", location.StorageType, location.URL, err)
+				recordFailedDeletion(location, deleteID, err)
+			} else {
+				log.Printf("Successfully deleted file from %s (URL: %s)", location.StorageType, location.URL)
+			}
+		}(loc)
+	}
+	wg.Wait()
+}
+
+func GetHealthyStorageLocation(imageUUID string, viewer ImageViewer) (*database.StorageLocation, error) {
 	var image database.Image
 	err := database.DB.Preload("StorageLocations.Backend").Where("uuid = ?", imageUUID).First(&image).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("image not found")
+			return nil, missingImageError(imageUUID)
 		}
 		return nil, err
 	}
+	if err := checkServable(&image); err != nil {
+		return nil, err
+	}
+	if err := checkImageAccess(&image, viewer); err != nil {
+		return nil, err
+	}
 
 	maxFailures := GetRetryCount()
 	accessPolicy := GetAccessPolicy()
@@ -379,72 +456,301 @@ func GetHealthyStorageLocation(imageUUID string) (*database.StorageLocation, err
 	}
 
 	if len(availableLocations) == 0 {
-		return nil, errors.New("no available storage locations for this image")
+		return nil, ErrNoHealthyLocation
 	}
 
 	if accessPolicy == "priority" {
 		sort.Slice(availableLocations, func(i, j int) bool {
 			return availableLocations[i].Backend.Priority < availableLocations[j].Backend.Priority
 		})
+	} else if accessPolicy == "weighted" {
+		weightedShuffle(availableLocations)
 	} else {
 		rand.Seed(time.Now().UnixNano())
 		rand.Shuffle(len(availableLocations), func(i, j int) {
 			availableLocations[i], availableLocations[j] = availableLocations[j], availableLocations[i]
 		})
 	}
+	preferGeoBackends(availableLocations, viewer.Country)
+
+	// 健康探测和失败计数由后台健康检查完成，这里只参考缓存的状态：熔断中的后端排到最后，仅在没有其他选择时使用
+	deferOpenBreakers(availableLocations)
 
-	// --- 已修改：为无限重试模式增加特殊处理 ---
+	// 无限重试模式下信任链接，不检查本地文件，直接返回第一个
 	if maxFailures == 0 {
-		// 在无限重试模式下，我们信任链接，不进行健康检查，直接返回第一个
-		if len(availableLocations) > 0 {
-			return &availableLocations[0], nil
-		}
-		// 如果没有可用的（比如都被手动禁用了），则继续执行到最后的错误返回
+		return &availableLocations[0], nil
 	}
 
-	// 对于有限重试模式，执行健康检查
 	for i := range availableLocations {
 		loc := &availableLocations[i]
-		isHealthy := false
-		if loc.StorageType == "local" {
-			if parsedURL, err := url.Parse(loc.URL); err == nil {
-				if _, err := os.Stat("." + parsedURL.Path); err == nil {
-					isHealthy = true
-				}
-			}
-		} else {
-			isHealthy = checkURLHealth(loc.URL)
+		// 本地文件检查开销很小，仍在请求时确认文件存在
+		if loc.StorageType == "local" && !checkLocationHealth(loc) {
+			continue
 		}
+		return loc, nil
+	}
+
+	return nil, ErrLocationsUnreachable
+}
+
+// localUploadsDir 本地存储的文件目录，由 /uploads 路由对外提供
+const localUploadsDir = "uploads"
+
+// GetLocalUploadPath 按 /uploads 下的文件名找到所属图片，做与 /image 相同的签名、过期、隔离和可见性检查，返回文件在磁盘上的路径
+// 没有登记为存储位置的文件 (临时文件、备份等) 一律视为不存在；
+// 秒传和跨用户去重会让多张图片共用同一个文件，只要其中一张允许访问即可
+func GetLocalUploadPath(name, expiresParam, sig string, viewer ImageViewer) (string, error) {
+	relativeURL := "/" + localUploadsDir + "/" + name
+	var images []database.Image
+	// 旧数据的 URL 是带域名的完整地址，按后缀匹配
+	owners := database.DB.Model(&database.StorageLocation{}).Select("image_id").
+		Where("storage_type = ?", "local").
+		Where(`url = ? OR url LIKE ? ESCAPE '!'`, relativeURL, "%"+escapeLike(relativeURL))
+	err := database.DB.Where("id IN (?)", owners).Find(&images).Error
+	if err != nil {
+		return "", err
+	}
+	if len(images) == 0 {
+		return "", ErrImageNotFound
+	}
 
-		if isHealthy {
-			if loc.FailureCount > 0 {
-				go func(locationID uint) {
-					database.DB.Model(&database.StorageLocation{}).Where("id = ?", locationID).Update("failure_count", 0)
-				}(loc.ID)
+	var denied error
+	for i := range images {
+		image := &images[i]
+		if err := CheckImageSignature(image.UUID, expiresParam, sig); err != nil {
+			denied = err
+			continue
+		}
+		if err := checkServable(image); err != nil {
+			denied = err
+			continue
+		}
+		imageViewer := viewer
+		imageViewer.Signed = sig != ""
+		if err := checkImageAccess(image, imageViewer); err != nil {
+			denied = err
+			continue
+		}
+		return filepath.Join(localUploadsDir, filepath.FromSlash(name)), nil
+	}
+	return "", denied
+}
+
+// weightedShuffle 按后端权重对存储位置做加权随机排序：每次按剩余位置的权重比例抽出下一个
+// 权重为 0 的位置不参与抽取，随机排在最后作为后备
+func weightedShuffle(locations []database.StorageLocation) {
+	rand.Shuffle(len(locations), func(i, j int) {
+		locations[i], locations[j] = locations[j], locations[i]
+	})
+	for i := range locations {
+		total := 0
+		for _, loc := range locations[i:] {
+			if loc.Backend.Weight > 0 {
+				total += loc.Backend.Weight
 			}
-			return loc, nil
-		} else {
-			go func(locationID uint) {
-				database.DB.Model(&database.StorageLocation{}).Where("id = ?", locationID).Update("failure_count", gorm.Expr("failure_count + 1"))
-			}(loc.ID)
 		}
+		if total == 0 {
+			return
+		}
+		pick := rand.Intn(total)
+		for j := i; j < len(locations); j++ {
+			if w := locations[j].Backend.Weight; w > 0 {
+				if pick < w {
+					locations[i], locations[j] = locations[j], locations[i]
+					break
+				}
+				pick -= w
+			}
+		}
+	}
+}
+
+// ImageFilter 图片列表的筛选条件，零值表示不筛选
+type ImageFilter struct {
+	Keyword string
+	Folder  *string
+	// AlbumID 为 0 时列出不在任何相册中的图片
+	AlbumID *uint
+	// Tags 图片必须同时带有的标签 (已规范化)
+	Tags []string
+	// CreatedFrom / CreatedTo 上传时间范围 (含两端)
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// MinSize / MaxSize 文件大小范围 (字节)，0 表示不限制
+	MinSize int64
+	MaxSize int64
+	// ContentType 完整的 MIME 类型 (如 image/png)，或以 / 结尾的前缀 (如 image/)
+	ContentType string
+	// MinWidth / MaxWidth / MinHeight / MaxHeight 尺寸范围 (像素)，0 表示不限制
+	MinWidth  int
+	MaxWidth  int
+	MinHeight int
+	MaxHeight int
+	// Orientation 方向：landscape、portrait 或 square
+	Orientation string
+	// BackendID 只列出在该后端上有有效存储位置的图片
+	BackendID   uint
+	AllowRandom *bool
+	// UserID 按上传者筛选，只对管理员生效
+	UserID uint
+	// Sort 排序字段：created_at (默认)、size、name、width、height、pixels、views
+	Sort string
+	// Order 排序方向：desc (默认) 或 asc
+	Order    string
+	Page     int
+	PageSize int
+	// Cursor 非 nil 时使用游标分页 (按 created_at + id 定位，忽略 Page)，空字符串表示第一页。
+	// 大量图片时比偏移分页快得多，只支持按上传时间排序
+	Cursor *string
+	// WithTotal 游标分页时是否统计总数，偏移分页总是统计
+	WithTotal bool
+}
+
+// encodeImageCursor 把一页最后一张图片的位置编码为不透明的游标
+func encodeImageCursor(image database.Image) string {
+	raw := fmt.Sprintf("%d:%d", image.CreatedAt.UnixNano(), image.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeImageCursor 解析 encodeImageCursor 生成的游标
+func decodeImageCursor(cursor string) (time.Time, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	nanos, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return time.Time{}, 0, errors.New("malformed cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	imageID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, err
 	}
+	return time.Unix(0, n), uint(imageID), nil
+}
+
+// imageSortColumns 允许的排序字段及对应的 SQL 表达式
+var imageSortColumns = map[string]string{
+	"created_at": "created_at",
+	"size":       "file_size",
+	"name":       "original_filename",
+	"width":      "width",
+	"height":     "height",
+	"pixels":     "width * height",
+	"views":      "view_count",
+}
 
-	return nil, errors.New("all available storage locations are currently unreachable")
+// orderClause 校验排序参数并返回 ORDER BY 子句，相同值按 ID 保持稳定顺序
+func (f ImageFilter) orderClause() (string, error) {
+	sort := f.Sort
+	if sort == "" {
+		sort = "created_at"
+	}
+	column, ok := imageSortColumns[sort]
+	if !ok {
+		return "", &UploadRejectedError{Reason: fmt.Sprintf("Invalid sort field: %s", f.Sort)}
+	}
+	order := strings.ToLower(f.Order)
+	switch order {
+	case "":
+		order = "desc"
+	case "asc", "desc":
+	default:
+		return "", &UploadRejectedError{Reason: fmt.Sprintf("Invalid order: %s", f.Order)}
+	}
+	return fmt.Sprintf("%s %s, id %s", column, order, order), nil
 }
 
-func ListImages(userID uint, userRole string, keyword string, page int, pageSize int) (*ListImagesResponse, error) {
+func ListImages(userID uint, userRole string, filter ImageFilter) (*ListImagesResponse, error) {
 	var images []database.Image
 	var total int64
+	page, pageSize := filter.Page, filter.PageSize
 
-	query := database.DB.Model(&database.Image{}).Preload("StorageLocations").Order("created_at desc")
+	orderBy, err := filter.orderClause()
+	if err != nil {
+		return nil, err
+	}
+	query := database.DB.Model(&database.Image{}).Preload("StorageLocations").Preload("Tags").Order(orderBy)
 
-	if userRole != "admin" {
+	if !CanAccessAllImages(userRole) {
 		query = query.Where("user_id = ?", userID)
+	} else if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+
+	if filter.Keyword != "" {
+		query = query.Where("(original_filename LIKE ? OR annotations LIKE ?)", "%"+filter.Keyword+"%", "%"+filter.Keyword+"%")
+	}
+	if filter.CreatedFrom != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		query = query.Where("created_at <= ?", *filter.CreatedTo)
+	}
+	if filter.MinSize > 0 {
+		query = query.Where("file_size >= ?", filter.MinSize)
+	}
+	if filter.MaxSize > 0 {
+		query = query.Where("file_size <= ?", filter.MaxSize)
+	}
+	if filter.ContentType != "" {
+		if strings.HasSuffix(filter.ContentType, "/") {
+			query = query.Where(`content_type LIKE ? ESCAPE '!'`, escapeLike(filter.ContentType)+"%")
+		} else {
+			query = query.Where("content_type = ?", filter.ContentType)
+		}
+	}
+	for column, bound := range map[string][2]int{
+		"width":  {filter.MinWidth, filter.MaxWidth},
+		"height": {filter.MinHeight, filter.MaxHeight},
+	} {
+		if bound[0] > 0 {
+			query = query.Where(column+" >= ?", bound[0])
+		}
+		if bound[1] > 0 {
+			query = query.Where(column+" <= ?", bound[1])
+		}
+	}
+	switch filter.Orientation {
+	case "":
+	case "landscape":
+		query = query.Where("width > height")
+	case "portrait":
+		query = query.Where("width < height")
+	case "square":
+		query = query.Where("width = height AND width > 0")
+	default:
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("Invalid orientation: %s", filter.Orientation)}
+	}
+	if filter.BackendID != 0 {
+		onBackend := database.DB.Model(&database.StorageLocation{}).Select("image_id").
+			Where("backend_id = ? AND is_active = ?", filter.BackendID, true)
+		query = query.Where("id IN (?)", onBackend)
+	}
+	if filter.AllowRandom != nil {
+		query = query.Where("allow_random = ?", *filter.AllowRandom)
+	}
+	if filter.Folder != nil {
+		query = query.Where("folder = ?", *filter.Folder)
+	}
+	if filter.AlbumID != nil {
+		// album_id=0 列出不在任何相册中的图片
+		if *filter.AlbumID == 0 {
+			query = query.Where("album_id IS NULL")
+		} else {
+			query = query.Where("album_id = ?", *filter.AlbumID)
+		}
+	}
+	if len(filter.Tags) > 0 {
+		query = withAllTags(query, filter.Tags)
 	}
 
-	if keyword != "" {
-		query = query.Where("original_filename LIKE ?", "%"+keyword+"%")
+	if filter.Cursor != nil {
+		return listImagesByCursor(query, filter)
 	}
 
 	if err := query.Count(&total).Error; err != nil {
@@ -457,24 +763,78 @@ func ListImages(userID uint, userRole string, keyword string, page int, pageSize
 	}
 
 	return &ListImagesResponse{
-		Total:    total,
+		Total:    &total,
 		Page:     page,
 		PageSize: pageSize,
 		Images:   images,
 	}, nil
 }
 
+// listImagesByCursor 游标分页：从游标位置之后取 PageSize 张，多取一张判断是否还有下一页
+func listImagesByCursor(query *gorm.DB, filter ImageFilter) (*ListImagesResponse, error) {
+	if filter.Sort != "" && filter.Sort != "created_at" {
+		return nil, &UploadRejectedError{Reason: "Cursor pagination only supports sorting by created_at"}
+	}
+	if filter.PageSize <= 0 {
+		return nil, &UploadRejectedError{Reason: "Invalid pageSize"}
+	}
+	response := &ListImagesResponse{PageSize: filter.PageSize}
+	if filter.WithTotal {
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return nil, err
+		}
+		response.Total = &total
+	}
+
+	if *filter.Cursor != "" {
+		createdAt, id, err := decodeImageCursor(*filter.Cursor)
+		if err != nil {
+			return nil, &UploadRejectedError{Reason: "Invalid cursor"}
+		}
+		op := "<"
+		if strings.ToLower(filter.Order) == "asc" {
+			op = ">"
+		}
+		query = query.Where(fmt.Sprintf("(created_at %s ? OR (created_at = ? AND id %s ?))", op, op), createdAt, createdAt, id)
+	}
+
+	var images []database.Image
+	if err := query.Limit(filter.PageSize + 1).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	if len(images) > filter.PageSize {
+		images = images[:filter.PageSize]
+		response.NextCursor = encodeImageCursor(images[len(images)-1])
+	}
+	response.Images = images
+	return response, nil
+}
+
 // BatchBackfillImagesForUser starts a backfill task, ensuring the user owns all images.
 func BatchBackfillImagesForUser(imageUUIDs []string, backendID uint, userID uint, storageManager *manager.StorageManager) (string, error) {
 	var count int64
 	database.DB.Model(&database.Image{}).Where("uuid IN ? AND user_id = ?", imageUUIDs, userID).Count(&count)
 	if count != int64(len(imageUUIDs)) {
-		return "", errors.New("permission denied: you do not own all the selected images")
+		return "", ErrNotImageOwner
 	}
 
 	return BatchBackfillToBackend(imageUUIDs, backendID, storageManager)
 }
 
+// BatchSetRandomStatusForUser sets the random status for images owned by a regular user.
+func BatchSetRandomStatusForUser(imageUUIDs []string, userID uint, allowRandom bool) error {
+	if allowRandom && !GetAllowUserRandomPool() {
+		return ErrRandomPoolForbidden
+	}
+	var count int64
+	database.DB.Model(&database.Image{}).Where("uuid IN ? AND user_id = ?", imageUUIDs, userID).Count(&count)
+	if count != int64(len(imageUUIDs)) {
+		return ErrNotImageOwner
+	}
+	return BatchSetRandomStatus(imageUUIDs, allowRandom)
+}
+
 func BatchSetRandomStatus(imageUUIDs []string, allowRandom bool) error {
 	if err := database.DB.Model(&database.Image{}).Where("uuid IN ?", imageUUIDs).Update("allow_random", allowRandom).Error; err != nil {
 		return err
@@ -487,7 +847,7 @@ func BatchDeleteImagesForUser(imageUUIDs []string, userID uint, storageManager *
 	var count int64
 	database.DB.Model(&database.Image{}).Where("uuid IN ? AND user_id = ?", imageUUIDs, userID).Count(&count)
 	if count != int64(len(imageUUIDs)) {
-		return "", errors.New("permission denied: you do not own all the selected images")
+		return "", ErrNotImageOwner
 	}
 
 	// Pass "user" role to ensure underlying functions respect user-level constraints
@@ -495,49 +855,33 @@ func BatchDeleteImagesForUser(imageUUIDs []string, userID uint, storageManager *
 }
 
 func BatchDeleteImages(imageUUIDs []string, userID uint, userRole string, storageManager *manager.StorageManager) (string, error) {
-	taskID := uuid.New().String()
-	task := &Task{
-		ID: taskID, Type: "Batch Delete", Status: "running",
-		Total: len(imageUUIDs), CreatedAt: time.Now(),
-	}
-	taskMu.Lock()
-	tasks[taskID] = task
-	taskMu.Unlock()
+	task := newTask("Batch Delete", len(imageUUIDs))
 
 	go func() {
 		for i, uuid := range imageUUIDs {
 			if err := DeleteImage(uuid, userID, userRole, storageManager); err != nil {
 				log.Printf("Batch delete error for UUID %s: %v", uuid, err)
+				recordTaskItemError(task, uuid, err)
 			}
-			taskMu.Lock()
-			tasks[taskID].Progress = i + 1
-			taskMu.Unlock()
+			updateTask(task, func(t *Task) { t.Progress = i + 1 })
 		}
-		taskMu.Lock()
-		tasks[taskID].Status = "completed"
-		taskMu.Unlock()
+		updateTask(task, func(t *Task) { t.Status = "completed" })
 	}()
 
-	return taskID, nil
+	return task.ID, nil
 }
 
 func BatchBackfillToBackend(imageUUIDs []string, backendID uint, storageManager *manager.StorageManager) (string, error) {
-	taskID := uuid.New().String()
-	task := &Task{
-		ID: taskID, Type: "Batch Backfill", Status: "running",
-		Total: len(imageUUIDs), CreatedAt: time.Now(),
-	}
-	taskMu.Lock()
-	tasks[taskID] = task
-	taskMu.Unlock()
+	task := newTask("Batch Backfill", len(imageUUIDs))
+	taskID := task.ID
 
 	go func() {
 		targetUploader, found := storageManager.Get(backendID)
 		if !found {
-			taskMu.Lock()
-			task.Status = "failed"
-			task.Message = "Target backend not found"
-			taskMu.Unlock()
+			updateTask(task, func(t *Task) {
+				t.Status = "failed"
+				t.Message = "Target backend not found"
+			})
 			return
 		}
 
@@ -566,22 +910,31 @@ func BatchBackfillToBackend(imageUUIDs []string, backendID uint, storageManager
 							break
 						}
 					}
+					// 本地文件缺失时退回到从任一可用的远程存储位置下载
 					if localPath != "" {
-						if err := backfillFromLocalFile(&image, localPath, backendID, targetUploader); err != nil {
-							log.Printf("[Task %s] Backfill FAILED for %s: %v", taskID, uuid, err)
+						if _, err := os.Stat(localPath); err != nil {
+							localPath = ""
 						}
 					}
+					var err error
+					if localPath != "" {
+						err = backfillFromLocalFile(&image, localPath, backendID, targetUploader)
+					} else {
+						err = backfillFromRemote(&image, backendID, targetUploader)
+					}
+					if err != nil {
+						log.Printf("[Task %s] Backfill FAILED for %s: %v", taskID, uuid, err)
+						recordTaskItemError(task, uuid, err)
+					} else {
+						updateTask(task, func(t *Task) { t.ProcessedBytes += image.FileSize })
+					}
 				}
 			}()
 
-			taskMu.Lock()
-			tasks[taskID].Progress = i + 1
-			taskMu.Unlock()
+			updateTask(task, func(t *Task) { t.Progress = i + 1 })
 		}
 
-		taskMu.Lock()
-		tasks[taskID].Status = "completed"
-		taskMu.Unlock()
+		updateTask(task, func(t *Task) { t.Status = "completed" })
 	}()
 
 	return taskID, nil
@@ -601,8 +954,10 @@ func backfillFromLocalFile(image *database.Image, localPath string, targetBacken
 		Size:     fileInfo.Size(),
 	}
 
-	uniqueFilename := fmt.Sprintf("%s%s", image.UUID, filepath.Ext(image.OriginalFilename))
-	uploadResultURL, err := targetUploader.Upload(tempHeader, uniqueFilename, file)
+	uniqueFilename := storageKeyFor(image)
+	start := time.Now()
+	uploadResultURL, err := targetUploader.Upload(tempHeader, uniqueFilename, throttleMigration(file, targetBackendID))
+	recordStorageOperation(OperationBackfill, targetBackendID, uniqueFilename, start, err)
 	if err != nil {
 		return fmt.Errorf("upload failed: %w", err)
 	}
@@ -614,11 +969,36 @@ func backfillFromLocalFile(image *database.Image, localPath string, targetBacken
 		StorageType:      targetUploader.Type(),
 		URL:              finalURL,
 		DeleteIdentifier: deleteIdentifier,
-		IsActive:         true,
+		IsActive:         targetUploader.Type() != storage.DryRunType,
 	}
 	return database.DB.Create(&location).Error
 }
 
+// backfillFromRemote 从图片的远程存储位置流式下载到临时文件，再上传到目标后端
+// 先落盘是因为部分后端 (如 OSS 分片上传) 需要知道文件大小并可重复读取
+func backfillFromRemote(image *database.Image, targetBackendID uint, targetUploader storage.Uploader) error {
+	rc, err := OpenImageContent(image)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "imgbed-backfill-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, rc)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download image from remote storage: %w", err)
+	}
+	return backfillFromLocalFile(image, tmp.Name(), targetBackendID, targetUploader)
+}
+
 func parseUploadResult(result, uploaderType string) (string, string) {
 	finalURL := result
 	deleteIdentifier := ""
@@ -642,16 +1022,6 @@ func parseUploadResult(result, uploaderType string) (string, string) {
 	return finalURL, deleteIdentifier
 }
 
-func GetTasks() []*Task {
-	taskMu.Lock()
-	defer taskMu.Unlock()
-	taskList := make([]*Task, 0, len(tasks))
-	for _, task := range tasks {
-		taskList = append(taskList, task)
-	}
-	return taskList
-}
-
 func checkURLHealth(url string) bool {
 	client := http.Client{Timeout: 5 * time.Second}
 	req, err := http.NewRequest("HEAD", url, nil)
@@ -683,7 +1053,10 @@ func InitRandomImageCache() {
 
 func UpdateRandomImageCache() {
 	var uuids []string
-	database.DB.Model(&database.Image{}).Where("allow_random = ?", true).Pluck("uuid", &uuids)
+	database.DB.Model(&database.Image{}).
+		Where("allow_random = ? AND (moderation_status IS NULL OR moderation_status <> ?)", true, ModerationQuarantined).
+		Where("visibility <> ?", VisibilityPrivate).
+		Pluck("uuid", &uuids)
 	cacheMutex.Lock()
 	randomImageUUIDs = uuids
 	cacheMutex.Unlock()
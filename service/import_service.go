@@ -0,0 +1,250 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+	"yanshu-imgbed/storage"
+	"yanshu-imgbed/util"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 本地目录导入的参数错误
+var (
+	ErrImportPathInvalid       = errors.New("import path is not a readable directory")
+	ErrImportOutsideLocalStore = errors.New("in-place import requires the directory to be inside a local backend's storage path")
+)
+
+// errImportDuplicate 原地导入时该用户已有相同文件，跳过
+var errImportDuplicate = errors.New("duplicate image")
+
+// LocalImportRequest 从服务器目录导入图片的参数
+type LocalImportRequest struct {
+	Path       string `json:"path" binding:"required"`
+	UserID     uint   `json:"user_id"`     // 图片归属的用户，为 0 时归属发起导入的管理员
+	BackendIDs []uint `json:"backend_ids"` // 复制模式下上传到的后端，为空时使用所有允许上传的后端
+	InPlace    bool   `json:"in_place"`    // 为 true 时不复制文件，直接登记到所在的本地后端
+	Folder     string `json:"folder"`
+}
+
+// localImportTarget 原地导入时文件所在的本地后端
+type localImportTarget struct {
+	backendID   uint
+	storagePath string // 本地后端存储目录的绝对路径
+	urlPrefix   string // 与 LocalUploader 返回的相对 URL 一致，例如 "/uploads"
+}
+
+// ImportLocalDirectory 扫描服务器上的目录并把其中的图片导入数据库，返回后台任务 ID
+// 复制模式走正常上传流程 (去重、审核)，但不加水印、不压缩，保持原文件不变；
+// 原地模式要求目录位于某个本地后端的存储目录下，只登记元数据和存储位置，不复制文件
+func ImportLocalDirectory(req LocalImportRequest, storageManager *manager.StorageManager) (string, error) {
+	root, err := filepath.Abs(req.Path)
+	if err != nil {
+		return "", ErrImportPathInvalid
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return "", ErrImportPathInvalid
+	}
+	folder, err := NormalizeFolder(req.Folder)
+	if err != nil {
+		return "", &UploadRejectedError{Reason: err.Error()}
+	}
+
+	var target *localImportTarget
+	if req.InPlace {
+		if target, err = findLocalImportTarget(root, storageManager); err != nil {
+			return "", err
+		}
+	}
+
+	var files []string
+	err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Skipping unreadable path %s during import: %v", path, err)
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && !strings.HasPrefix(d.Name(), ".") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to scan import directory: %w", err)
+	}
+
+	task := newTask(fmt.Sprintf("Import Local Directory (%s)", root), len(files))
+	go func() {
+		var imported, skipped, failed int
+		for i, path := range files {
+			var err error
+			if target != nil {
+				err = registerLocalFile(path, req.UserID, folder, target)
+			} else {
+				err = copyLocalFile(path, req.UserID, folder, req.BackendIDs, storageManager)
+			}
+			var rejected *UploadRejectedError
+			switch {
+			case err == nil:
+				imported++
+				if info, statErr := os.Stat(path); statErr == nil {
+					updateTask(task, func(t *Task) { t.ProcessedBytes += info.Size() })
+				}
+			case errors.As(err, &rejected), errors.Is(err, errImportDuplicate):
+				skipped++
+			default:
+				failed++
+				log.Printf("[Task %s] Failed to import %s: %v", task.ID, path, err)
+				recordTaskItemError(task, path, err)
+			}
+			updateTask(task, func(t *Task) { t.Progress = i + 1 })
+		}
+		updateTask(task, func(t *Task) {
+			t.Status = "completed"
+			t.Message = fmt.Sprintf("Imported %d, skipped %d, failed %d", imported, skipped, failed)
+		})
+	}()
+	return task.ID, nil
+}
+
+// findLocalImportTarget 找到包含 root 目录的本地后端
+func findLocalImportTarget(root string, storageManager *manager.StorageManager) (*localImportTarget, error) {
+	targets, err := localBackendTargets(storageManager)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		rel, err := filepath.Rel(target.storagePath, root)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return &target, nil
+	}
+	return nil, ErrImportOutsideLocalStore
+}
+
+// localBackendTargets 列出所有已加载的本地后端及其存储目录
+func localBackendTargets(storageManager *manager.StorageManager) ([]localImportTarget, error) {
+	var backends []database.Backend
+	if err := database.DB.Where("type = ?", "local").Find(&backends).Error; err != nil {
+		return nil, err
+	}
+	var targets []localImportTarget
+	for _, backend := range backends {
+		uploader, found := storageManager.Get(backend.ID)
+		if !found {
+			continue
+		}
+		local, ok := uploader.(*storage.LocalUploader)
+		if !ok {
+			continue
+		}
+		storagePath, err := filepath.Abs(local.StoragePath)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, localImportTarget{
+			backendID:   backend.ID,
+			storagePath: storagePath,
+			urlPrefix:   "/" + filepath.Base(local.StoragePath),
+		})
+	}
+	return targets, nil
+}
+
+// copyLocalFile 通过正常上传流程导入单个文件
+func copyLocalFile(path string, userID uint, folder string, backendIDs []uint, storageManager *manager.StorageManager) error {
+	fileType, err := util.SniffLocalFile(path)
+	if err != nil {
+		return err
+	}
+	if fileType == nil {
+		return &UploadRejectedError{Reason: "Unrecognized or unsupported file type"}
+	}
+
+	file, cleanup, err := util.NewFileHeaderFromFile(filepath.Base(path), fileType.MIME, path)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	disabled := false
+	opts := UploadOptions{Watermark: &disabled, Compress: &disabled, Folder: folder}
+	_, err = UploadImage(file, userID, backendIDs, opts, storageManager)
+	return err
+}
+
+// registerLocalFile 将已在本地后端目录中的文件登记为图片，不复制文件
+// 该用户已有相同内容的图片时跳过
+func registerLocalFile(path string, userID uint, folder string, target *localImportTarget) error {
+	fileType, err := util.SniffLocalFile(path)
+	if err != nil {
+		return err
+	}
+	if fileType == nil {
+		return &UploadRejectedError{Reason: "Unrecognized or unsupported file type"}
+	}
+	if !IsFileTypeAllowed(fileType.Ext) {
+		return &UploadRejectedError{Reason: fmt.Sprintf("File type '%s' is not allowed", fileType.Ext)}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	digest, err := util.DigestLocalFile(path)
+	if err != nil {
+		return err
+	}
+	var count int64
+	database.DB.Model(&database.Image{}).Where("user_id = ?", userID).Where(sameContentQuery(digest)).Count(&count)
+	if count > 0 {
+		return errImportDuplicate
+	}
+
+	rel, err := filepath.Rel(target.storagePath, path)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		image := &database.Image{
+			UUID:             uuid.New().String(),
+			MD5:              digest.MD5,
+			SHA256:           digest.SHA256,
+			OriginalFilename: filepath.Base(path),
+			FileSize:         info.Size(),
+			OriginalSize:     info.Size(),
+			ContentType:      fileType.MIME,
+			Width:            digest.Width,
+			Height:           digest.Height,
+			UserID:           userID,
+			Folder:           folder,
+		}
+		if err := tx.Create(image).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.StorageLocation{
+			ImageID:          image.ID,
+			BackendID:        target.backendID,
+			StorageType:      "local",
+			URL:              (&url.URL{Path: target.urlPrefix + "/" + rel}).EscapedPath(),
+			DeleteIdentifier: rel,
+			IsActive:         true,
+		}).Error
+	})
+}
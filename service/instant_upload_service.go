@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"yanshu-imgbed/database"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 秒传的错误
+var (
+	ErrInstantUploadDisabled = errors.New("instant upload is disabled")
+	ErrInstantUploadMiss     = errors.New("no stored file matches this hash, upload the file instead")
+)
+
+// InstantUploadRequest 按哈希秒传的参数
+// SHA256 是服务端存储的文件内容哈希 (经过压缩、水印等处理后)，Size 必须与已存储的文件大小一致
+type InstantUploadRequest struct {
+	SHA256   string
+	Size     int64
+	Filename string
+}
+
+// InstantUpload 按哈希秒传：服务器上已有相同内容的文件时，直接为用户创建引用这些文件的记录，不传输文件
+// 没有匹配的文件时返回 ErrInstantUploadMiss，客户端应改为正常上传
+func InstantUpload(req InstantUploadRequest, userID uint, opts UploadOptions) (*database.Image, error) {
+	if !GetInstantUploadEnabled() {
+		return nil, ErrInstantUploadDisabled
+	}
+	hash := strings.ToLower(strings.TrimSpace(req.SHA256))
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+		return nil, &UploadRejectedError{Reason: "sha256 must be a 64-character hex string"}
+	}
+	if req.Size <= 0 {
+		return nil, &UploadRejectedError{Reason: "size is required"}
+	}
+
+	var existingImageForUser database.Image
+	err := database.DB.Preload("StorageLocations.Backend").
+		Where("sha256 = ? AND file_size = ? AND user_id = ?", hash, req.Size, userID).
+		First(&existingImageForUser).Error
+	if err == nil {
+		if len(opts.Annotations) > 0 {
+			existingImageForUser.Annotations = opts.mergedAnnotationsJSON(existingImageForUser.Annotations)
+			database.DB.Model(&existingImageForUser).Update("annotations", existingImageForUser.Annotations)
+		}
+		applyDuplicateExpiry(&existingImageForUser, opts)
+		applyDuplicateAlbum(&existingImageForUser, opts)
+		emitImageWebhook(WebhookImageUploaded, &existingImageForUser)
+		return &existingImageForUser, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("database error during user duplicate check: %w", err)
+	}
+
+	if err := checkStorageQuota(userID, req.Size); err != nil {
+		return nil, err
+	}
+
+	// 被隔离的图片不能通过秒传重新获得，否则等于绕过审核；
+	// 私有和已过期的图片也不参与匹配，否则知道哈希的人就能取得别人的私有文件
+	var existingImage database.Image
+	err = database.DB.Preload("StorageLocations", "is_active = ?", true).
+		Where("sha256 = ? AND file_size = ?", hash, req.Size).
+		Where("moderation_status IS NULL OR moderation_status <> ?", ModerationQuarantined).
+		Where("images.visibility IS NULL OR images.visibility <> ?", VisibilityPrivate).
+		Where("images.expires_at IS NULL OR images.expires_at > ?", time.Now()).
+		Joins("JOIN storage_locations ON storage_locations.image_id = images.id AND storage_locations.is_active = ?", true).
+		First(&existingImage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInstantUploadMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error during global duplicate check: %w", err)
+	}
+
+	filename := strings.TrimSpace(req.Filename)
+	if filename == "" {
+		filename = existingImage.OriginalFilename
+	}
+	log.Printf("Instant upload for user %d (SHA256: %s), linking to image %s.", userID, hash, existingImage.UUID)
+	image := &database.Image{
+		UUID:                uuid.New().String(),
+		MD5:                 existingImage.MD5,
+		SHA256:              existingImage.SHA256,
+		OriginalFilename:    filename,
+		FileSize:            existingImage.FileSize,
+		OriginalSize:        existingImage.FileSize,
+		ContentType:         existingImage.ContentType,
+		OriginalContentType: existingImage.OriginalContentType,
+		Width:               existingImage.Width,
+		Height:              existingImage.Height,
+		UserID:              userID,
+		Annotations:         opts.annotationsJSON(),
+		Folder:              opts.Folder,
+		ModerationStatus:    existingImage.ModerationStatus,
+		ModerationScore:     existingImage.ModerationScore,
+		ExpiresAt:           opts.ExpiresAt,
+		AlbumID:             opts.AlbumID,
+		Visibility:          opts.Visibility,
+	}
+	applyUploaderInfo(image, opts)
+	linked, err := linkSharedImage(image, &existingImage)
+	if err == nil {
+		emitImageWebhook(WebhookImageUploaded, linked)
+	}
+	return linked, err
+}
@@ -0,0 +1,339 @@
+package service
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+// 完整性校验的方式
+const (
+	IntegrityModeQuick = "quick" // 本地文件比较大小，远程地址发送 HEAD 比较 Content-Length
+	IntegrityModeFull  = "full"  // 下载文件，校验大小和 MD5
+)
+
+// 存储位置校验发现的问题
+const (
+	IntegrityMissing      = "missing"       // 文件不存在 (本地文件缺失或远程返回 404/410)
+	IntegritySizeMismatch = "size_mismatch" // 大小与记录不一致
+	IntegrityHashMismatch = "hash_mismatch" // MD5 与记录不一致
+	IntegrityUnreachable  = "unreachable"   // 请求失败或返回其他状态码，无法判断，不会停用
+)
+
+const (
+	// integrityCheckWorkers 同时校验的存储位置数
+	integrityCheckWorkers = 4
+	// maxIntegrityReportIssues 报告中列出的问题数量上限
+	maxIntegrityReportIssues = 500
+)
+
+// ErrIntegrityCheckRunning 已有完整性校验在进行
+var ErrIntegrityCheckRunning = errors.New("an integrity check is already running")
+
+// ErrInvalidIntegrityMode 校验方式不是 quick 或 full
+var ErrInvalidIntegrityMode = errors.New("invalid integrity check mode, expected quick or full")
+
+// IntegrityCheckRequest 完整性校验的参数
+type IntegrityCheckRequest struct {
+	// BackendID 只校验该后端上的存储位置，0 表示所有后端
+	BackendID uint `json:"backend_id"`
+	// Mode quick (默认) 或 full
+	Mode string `json:"mode"`
+	// ReportOnly 为 true 时只生成报告，不停用有问题的存储位置
+	ReportOnly bool `json:"report_only"`
+}
+
+// IntegrityIssue 一个有问题的存储位置
+type IntegrityIssue struct {
+	LocationID uint   `json:"location_id"`
+	ImageUUID  string `json:"image_uuid"`
+	BackendID  uint   `json:"backend_id"`
+	URL        string `json:"url"`
+	Problem    string `json:"problem"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+// IntegrityReport 完整性校验的结果，校验进行中时反映当前进度
+type IntegrityReport struct {
+	TaskID     string                `json:"task_id"`
+	Request    IntegrityCheckRequest `json:"request"`
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt *time.Time            `json:"finished_at,omitempty"`
+	Checked    int                   `json:"checked"`
+	OK         int                   `json:"ok"`
+	Missing    int                   `json:"missing"`
+	Corrupt    int                   `json:"corrupt"` // 大小或 MD5 不一致
+	// Unreachable 无法判断的存储位置，保持原状，可稍后重新校验
+	Unreachable int `json:"unreachable"`
+	// Deactivated 本次被停用的存储位置数，缺少的副本由副本数检查自动补传
+	Deactivated int              `json:"deactivated"`
+	Issues      []IntegrityIssue `json:"issues"`
+	// Truncated 问题数超过上限，Issues 只列出了前面一部分
+	Truncated bool `json:"truncated"`
+}
+
+var (
+	lastIntegrityReport   *IntegrityReport
+	lastIntegrityReportMu sync.RWMutex
+	// integrityCheckMu 同一时间只允许一个完整性校验
+	integrityCheckMu sync.Mutex
+)
+
+// integrityExpectation 存储位置应当满足的大小和 MD5
+type integrityExpectation struct {
+	UUID     string
+	MD5      string
+	FileSize int64
+}
+
+// GetIntegrityReport 返回最近一次 (或正在进行的) 完整性校验的报告，从未运行过时返回 nil
+func GetIntegrityReport() *IntegrityReport {
+	lastIntegrityReportMu.RLock()
+	defer lastIntegrityReportMu.RUnlock()
+	if lastIntegrityReport == nil {
+		return nil
+	}
+	report := *lastIntegrityReport
+	report.Issues = append([]IntegrityIssue{}, lastIntegrityReport.Issues...)
+	return &report
+}
+
+// StartIntegrityCheck 启动后台任务，逐个校验有效存储位置上的文件是否存在且与记录一致，
+// 缺失或损坏的存储位置会被停用 (ReportOnly 时除外)，结果通过 GetIntegrityReport 查看
+func StartIntegrityCheck(req IntegrityCheckRequest) (string, error) {
+	switch req.Mode {
+	case "":
+		req.Mode = IntegrityModeQuick
+	case IntegrityModeQuick, IntegrityModeFull:
+	default:
+		return "", ErrInvalidIntegrityMode
+	}
+	if req.BackendID != 0 {
+		if err := database.DB.First(&database.Backend{}, req.BackendID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", ErrBackendNotFound
+			}
+			return "", err
+		}
+	}
+	if !integrityCheckMu.TryLock() {
+		return "", ErrIntegrityCheckRunning
+	}
+
+	query := database.DB.Model(&database.StorageLocation{}).Where("is_active = ?", true)
+	if req.BackendID != 0 {
+		query = query.Where("backend_id = ?", req.BackendID)
+	}
+	var locationIDs []uint
+	if err := query.Order("id asc").Pluck("id", &locationIDs).Error; err != nil {
+		integrityCheckMu.Unlock()
+		return "", err
+	}
+
+	task := newTask(fmt.Sprintf("Integrity Check (%s)", req.Mode), len(locationIDs))
+	report := &IntegrityReport{TaskID: task.ID, Request: req, StartedAt: time.Now(), Issues: []IntegrityIssue{}}
+	lastIntegrityReportMu.Lock()
+	lastIntegrityReport = report
+	lastIntegrityReportMu.Unlock()
+
+	go func() {
+		defer integrityCheckMu.Unlock()
+		for start := 0; start < len(locationIDs); start += locationBatchSize {
+			end := min(start+locationBatchSize, len(locationIDs))
+			if err := checkLocationBatch(locationIDs[start:end], req, report); err != nil {
+				log.Printf("Integrity check aborted: %v", err)
+				updateTask(task, func(t *Task) {
+					t.Status = "failed"
+					t.Message = err.Error()
+				})
+				finishIntegrityReport(report)
+				return
+			}
+			updateTask(task, func(t *Task) { t.Progress = end })
+		}
+		finishIntegrityReport(report)
+		lastIntegrityReportMu.RLock()
+		message := fmt.Sprintf("Checked %d location(s): %d missing, %d corrupt, %d unreachable, %d deactivated",
+			report.Checked, report.Missing, report.Corrupt, report.Unreachable, report.Deactivated)
+		lastIntegrityReportMu.RUnlock()
+		log.Printf("Integrity check finished. %s.", message)
+		updateTask(task, func(t *Task) {
+			t.Status = "completed"
+			t.Message = message
+		})
+	}()
+	return task.ID, nil
+}
+
+// finishIntegrityReport 记录校验结束时间
+func finishIntegrityReport(report *IntegrityReport) {
+	now := time.Now()
+	lastIntegrityReportMu.Lock()
+	report.FinishedAt = &now
+	lastIntegrityReportMu.Unlock()
+}
+
+// checkLocationBatch 并发校验一批存储位置并把结果计入报告
+func checkLocationBatch(ids []uint, req IntegrityCheckRequest, report *IntegrityReport) error {
+	var locations []database.StorageLocation
+	if err := database.DB.Where("id IN ?", ids).Find(&locations).Error; err != nil {
+		return err
+	}
+	imageIDs := make([]uint, 0, len(locations))
+	for _, loc := range locations {
+		imageIDs = append(imageIDs, loc.ImageID)
+	}
+	var images []database.Image
+	if err := database.DB.Select("id", "uuid", "md5", "file_size").Where("id IN ?", imageIDs).Find(&images).Error; err != nil {
+		return err
+	}
+	expected := make(map[uint]integrityExpectation, len(images))
+	for _, image := range images {
+		expected[image.ID] = integrityExpectation{UUID: image.UUID, MD5: image.MD5, FileSize: image.FileSize}
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan database.StorageLocation)
+	for i := 0; i < integrityCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for loc := range jobs {
+				want := expected[loc.ImageID]
+				problem, detail := verifyLocation(loc, want, req.Mode)
+				recordIntegrityResult(loc, want.UUID, problem, detail, req.ReportOnly, report)
+			}
+		}()
+	}
+	for _, loc := range locations {
+		jobs <- loc
+	}
+	close(jobs)
+	wg.Wait()
+	return nil
+}
+
+// recordIntegrityResult 把单个存储位置的校验结果计入报告，确认缺失或损坏时停用该存储位置
+func recordIntegrityResult(loc database.StorageLocation, imageUUID, problem, detail string, reportOnly bool, report *IntegrityReport) {
+	deactivated := false
+	if problem != "" && problem != IntegrityUnreachable && !reportOnly {
+		if err := database.DB.Model(&database.StorageLocation{}).Where("id = ?", loc.ID).Update("is_active", false).Error; err != nil {
+			log.Printf("Integrity check: failed to deactivate location %d: %v", loc.ID, err)
+		} else {
+			deactivated = true
+			log.Printf("Integrity check: location %d of image %s deactivated (%s).", loc.ID, imageUUID, problem)
+		}
+	}
+
+	lastIntegrityReportMu.Lock()
+	defer lastIntegrityReportMu.Unlock()
+	report.Checked++
+	switch problem {
+	case "":
+		report.OK++
+		return
+	case IntegrityMissing:
+		report.Missing++
+	case IntegrityUnreachable:
+		report.Unreachable++
+	default:
+		report.Corrupt++
+	}
+	if deactivated {
+		report.Deactivated++
+	}
+	if len(report.Issues) >= maxIntegrityReportIssues {
+		report.Truncated = true
+		return
+	}
+	report.Issues = append(report.Issues, IntegrityIssue{
+		LocationID: loc.ID, ImageUUID: imageUUID, BackendID: loc.BackendID,
+		URL: loc.URL, Problem: problem, Detail: detail,
+	})
+}
+
+// verifyLocation 校验一个存储位置，返回发现的问题 (没有问题时为空) 和说明
+func verifyLocation(loc database.StorageLocation, want integrityExpectation, mode string) (string, string) {
+	if mode == IntegrityModeQuick {
+		size, problem, detail := statLocation(loc)
+		if problem != "" {
+			return problem, detail
+		}
+		// 远程没有返回 Content-Length 时只能确认文件存在
+		if size >= 0 && want.FileSize > 0 && size != want.FileSize {
+			return IntegritySizeMismatch, fmt.Sprintf("expected %d bytes, found %d", want.FileSize, size)
+		}
+		return "", ""
+	}
+
+	rc, err := openLocationContent(loc)
+	if err != nil {
+		return classifyLocationError(err)
+	}
+	defer rc.Close()
+	hash := md5.New()
+	size, err := io.Copy(hash, rc)
+	if err != nil {
+		return IntegrityUnreachable, err.Error()
+	}
+	if want.FileSize > 0 && size != want.FileSize {
+		return IntegritySizeMismatch, fmt.Sprintf("expected %d bytes, found %d", want.FileSize, size)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); want.MD5 != "" && sum != want.MD5 {
+		return IntegrityHashMismatch, fmt.Sprintf("expected MD5 %s, found %s", want.MD5, sum)
+	}
+	return "", ""
+}
+
+// statLocation 获取存储位置上文件的大小：本地文件读取文件信息，远程地址发送 HEAD 请求，大小未知时返回 -1
+func statLocation(loc database.StorageLocation) (int64, string, string) {
+	if loc.StorageType == "local" {
+		parsedURL, err := url.Parse(loc.URL)
+		if err != nil {
+			return 0, IntegrityMissing, err.Error()
+		}
+		info, err := os.Stat("." + parsedURL.Path)
+		if err != nil {
+			problem, detail := classifyLocationError(err)
+			return 0, problem, detail
+		}
+		return info.Size(), "", ""
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Head(loc.URL)
+	if err != nil {
+		return 0, IntegrityUnreachable, err.Error()
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return 0, IntegrityMissing, resp.Status
+	case resp.StatusCode >= 400:
+		return 0, IntegrityUnreachable, resp.Status
+	}
+	return resp.ContentLength, "", ""
+}
+
+// classifyLocationError 区分文件确实不存在和暂时无法访问
+func classifyLocationError(err error) (string, string) {
+	if errors.Is(err, os.ErrNotExist) {
+		return IntegrityMissing, err.Error()
+	}
+	var statusErr *locationStatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone) {
+		return IntegrityMissing, err.Error()
+	}
+	return IntegrityUnreachable, err.Error()
+}
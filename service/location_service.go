@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+// locationBatchSize 批量更新存储位置时每批处理的记录数
+const locationBatchSize = 500
+
+// ErrBackendNotFound 存储后端不存在
+var ErrBackendNotFound = errors.New("backend not found")
+
+// BatchSetBackendLocationsStatus 启动一个后台任务，批量启用/禁用某个后端下的所有存储位置
+func BatchSetBackendLocationsStatus(backendID uint, isActive bool) (string, error) {
+	var backend database.Backend
+	if err := database.DB.First(&backend, backendID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrBackendNotFound
+		}
+		return "", err
+	}
+
+	var locationIDs []uint
+	if err := database.DB.Model(&database.StorageLocation{}).Where("backend_id = ? AND is_active = ?", backendID, !isActive).Pluck("id", &locationIDs).Error; err != nil {
+		return "", err
+	}
+
+	action := "Deactivate"
+	if isActive {
+		action = "Activate"
+	}
+	task := newTask(fmt.Sprintf("%s Locations (%s)", action, backend.Name), len(locationIDs))
+
+	go func() {
+		for start := 0; start < len(locationIDs); start += locationBatchSize {
+			end := start + locationBatchSize
+			if end > len(locationIDs) {
+				end = len(locationIDs)
+			}
+			if err := database.DB.Model(&database.StorageLocation{}).Where("id IN ?", locationIDs[start:end]).Update("is_active", isActive).Error; err != nil {
+				log.Printf("[Task %s] Failed to update storage locations: %v", task.ID, err)
+				updateTask(task, func(t *Task) {
+					t.Status = "failed"
+					t.Message = "Failed to update storage locations"
+				})
+				return
+			}
+			updateTask(task, func(t *Task) { t.Progress = end })
+		}
+		updateTask(task, func(t *Task) { t.Status = "completed" })
+	}()
+
+	return task.ID, nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"yanshu-imgbed/config"
+)
+
+// 支持的验证码服务商
+const (
+	CaptchaHCaptcha  = "hcaptcha"
+	CaptchaTurnstile = "turnstile"
+)
+
+var captchaVerifyURLs = map[string]string{
+	CaptchaHCaptcha:  "https://api.hcaptcha.com/siteverify",
+	CaptchaTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// ErrCaptchaFailed 验证码缺失或校验未通过
+var ErrCaptchaFailed = errors.New("验证码校验失败")
+
+// LoginLockedError 用户名或 IP 因连续登录失败被暂时锁定
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("登录失败次数过多，请在 %d 分钟后再试", int(e.RetryAfter.Minutes())+1)
+}
+
+// CaptchaInfo 登录页渲染验证码需要的公开信息
+type CaptchaInfo struct {
+	Provider string `json:"provider"`
+	SiteKey  string `json:"site_key"`
+}
+
+// loginAttempts 某个用户名或 IP 在统计窗口内的失败次数
+type loginAttempts struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+var (
+	loginFailures   = make(map[string]*loginAttempts)
+	loginFailuresMu sync.Mutex
+)
+
+var captchaClient = &http.Client{Timeout: 10 * time.Second}
+
+// loginKeys 同时按用户名和 IP 统计，分别防御针对单个账户和来自单个 IP 的尝试
+func loginKeys(username, ip string) []string {
+	return []string{"user:" + strings.ToLower(strings.TrimSpace(username)), "ip:" + ip}
+}
+
+// activeAttempts 返回 key 在统计窗口内的记录，过期的记录会被清除；调用方需持有锁
+func activeAttempts(key string, window time.Duration, now time.Time) *loginAttempts {
+	a, ok := loginFailures[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(a.last) > window && now.After(a.lockedUntil) {
+		delete(loginFailures, key)
+		return nil
+	}
+	return a
+}
+
+// CheckLoginLockout 用户名或 IP 处于锁定期时返回 *LoginLockedError
+func CheckLoginLockout(username, ip string) error {
+	settings := GetLoginSecuritySettings()
+	if settings.LockoutThreshold <= 0 {
+		return nil
+	}
+	window := time.Duration(settings.LockoutMinutes) * time.Minute
+	now := time.Now()
+
+	loginFailuresMu.Lock()
+	defer loginFailuresMu.Unlock()
+	var wait time.Duration
+	for _, key := range loginKeys(username, ip) {
+		if a := activeAttempts(key, window, now); a != nil && a.lockedUntil.After(now) {
+			if d := a.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > 0 {
+		return &LoginLockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordLoginFailure 记录一次失败的登录，达到阈值时锁定用户名或 IP
+func RecordLoginFailure(username, ip string) {
+	settings := GetLoginSecuritySettings()
+	window := time.Duration(settings.LockoutMinutes) * time.Minute
+	now := time.Now()
+
+	loginFailuresMu.Lock()
+	defer loginFailuresMu.Unlock()
+	if len(loginFailures) > 10000 {
+		for key := range loginFailures {
+			activeAttempts(key, window, now)
+		}
+	}
+	for _, key := range loginKeys(username, ip) {
+		a := activeAttempts(key, window, now)
+		if a == nil {
+			a = &loginAttempts{}
+			loginFailures[key] = a
+		}
+		a.failures++
+		a.last = now
+		if settings.LockoutThreshold > 0 && a.failures >= settings.LockoutThreshold {
+			a.lockedUntil = now.Add(window)
+			log.Printf("Login locked for %s after %d failed attempts", key, a.failures)
+		}
+	}
+}
+
+// ResetLoginFailures 登录成功后清除该用户名的失败记录。
+// IP 的记录保留到窗口结束，避免攻击者用自己的账户登录来重置计数
+func ResetLoginFailures(username string) {
+	loginFailuresMu.Lock()
+	defer loginFailuresMu.Unlock()
+	delete(loginFailures, loginKeys(username, "")[0])
+}
+
+// captchaEnabled 服务商和服务端密钥都配置后才启用验证码
+func captchaEnabled(settings LoginSecuritySettings) bool {
+	return settings.CaptchaProvider != "" && settings.CaptchaSiteKey != "" && config.Cfg.Captcha.SecretKey != ""
+}
+
+// GetCaptchaInfo 返回登录页需要的验证码配置，未启用时返回 nil
+func GetCaptchaInfo() *CaptchaInfo {
+	settings := GetLoginSecuritySettings()
+	if !captchaEnabled(settings) {
+		return nil
+	}
+	return &CaptchaInfo{Provider: settings.CaptchaProvider, SiteKey: settings.CaptchaSiteKey}
+}
+
+// CaptchaRequiredForLogin 判断本次登录是否需要验证码：用户名或 IP 的失败次数达到 captcha_after_failures
+func CaptchaRequiredForLogin(username, ip string) bool {
+	settings := GetLoginSecuritySettings()
+	if !captchaEnabled(settings) {
+		return false
+	}
+	if settings.CaptchaAfterFailures == 0 {
+		return true
+	}
+	window := time.Duration(settings.LockoutMinutes) * time.Minute
+	now := time.Now()
+
+	loginFailuresMu.Lock()
+	defer loginFailuresMu.Unlock()
+	for _, key := range loginKeys(username, ip) {
+		if a := activeAttempts(key, window, now); a != nil && a.failures >= settings.CaptchaAfterFailures {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyCaptcha 向验证码服务商校验客户端提交的 token
+func VerifyCaptcha(token, ip string) error {
+	settings := GetLoginSecuritySettings()
+	if strings.TrimSpace(token) == "" {
+		return ErrCaptchaFailed
+	}
+	form := url.Values{
+		"secret":   {config.Cfg.Captcha.SecretKey},
+		"response": {token},
+		"remoteip": {ip},
+	}
+	if settings.CaptchaProvider == CaptchaHCaptcha {
+		form.Set("sitekey", settings.CaptchaSiteKey)
+	}
+	resp, err := captchaClient.PostForm(captchaVerifyURLs[settings.CaptchaProvider], form)
+	if err != nil {
+		return fmt.Errorf("captcha verification request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode captcha verification response: %w", err)
+	}
+	if !result.Success {
+		log.Printf("Captcha verification failed: %v", result.ErrorCodes)
+		return ErrCaptchaFailed
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+)
+
+// ErrMaintenanceUnsupported 当前数据库不支持该维护操作
+var ErrMaintenanceUnsupported = errors.New("this maintenance operation is only supported on SQLite")
+
+// DatabaseInfo 描述数据库的大小和页信息
+type DatabaseInfo struct {
+	Dialect      string `json:"dialect"`
+	FileSize     int64  `json:"file_size"`
+	PageSize     int64  `json:"page_size"`
+	PageCount    int64  `json:"page_count"`
+	FreelistSize int64  `json:"freelist_count"`
+}
+
+// isSQLite 判断当前连接的是否为 SQLite
+func isSQLite() bool {
+	return database.DB.Dialector.Name() == "sqlite"
+}
+
+// GetDatabaseInfo 返回数据库文件大小及 SQLite 页统计；MySQL 只返回数据和索引占用的空间
+func GetDatabaseInfo() (*DatabaseInfo, error) {
+	info := &DatabaseInfo{Dialect: database.DB.Dialector.Name()}
+	if !isSQLite() {
+		err := database.DB.Raw("SELECT COALESCE(SUM(data_length + index_length), 0) FROM information_schema.tables WHERE table_schema = DATABASE()").
+			Scan(&info.FileSize).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to read database size: %w", err)
+		}
+		return info, nil
+	}
+
+	path := database.SQLiteFilePath(config.Cfg.Database.DSN)
+	if fileInfo, err := os.Stat(path); err == nil {
+		info.FileSize = fileInfo.Size()
+	}
+	// WAL 模式下尚未合并到主文件的写入保存在 -wal 文件中
+	if fileInfo, err := os.Stat(path + "-wal"); err == nil {
+		info.FileSize += fileInfo.Size()
+	}
+
+	if err := database.DB.Raw("PRAGMA page_size").Scan(&info.PageSize).Error; err != nil {
+		return nil, fmt.Errorf("failed to read page_size: %w", err)
+	}
+	if err := database.DB.Raw("PRAGMA page_count").Scan(&info.PageCount).Error; err != nil {
+		return nil, fmt.Errorf("failed to read page_count: %w", err)
+	}
+	if err := database.DB.Raw("PRAGMA freelist_count").Scan(&info.FreelistSize).Error; err != nil {
+		return nil, fmt.Errorf("failed to read freelist_count: %w", err)
+	}
+	return info, nil
+}
+
+// VacuumDatabase 执行 VACUUM 以回收空闲页
+func VacuumDatabase() error {
+	if !isSQLite() {
+		return ErrMaintenanceUnsupported
+	}
+	log.Println("Running VACUUM on database...")
+	return database.DB.Exec("VACUUM").Error
+}
+
+// AnalyzeDatabase 执行 ANALYZE 以更新查询规划器的统计信息，MySQL 下对所有表执行 ANALYZE TABLE
+func AnalyzeDatabase() error {
+	log.Println("Running ANALYZE on database...")
+	if isSQLite() {
+		return database.DB.Exec("ANALYZE").Error
+	}
+	tables, err := database.DB.Migrator().GetTables()
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	for i, table := range tables {
+		tables[i] = "`" + table + "`"
+	}
+	return database.DB.Exec("ANALYZE TABLE " + strings.Join(tables, ", ")).Error
+}
+
+// CheckDatabaseIntegrity 执行 PRAGMA integrity_check 并返回结果
+// 数据库完好时结果为 ["ok"]
+func CheckDatabaseIntegrity() ([]string, error) {
+	if !isSQLite() {
+		return nil, ErrMaintenanceUnsupported
+	}
+	var results []string
+	if err := database.DB.Raw("PRAGMA integrity_check").Scan(&results).Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
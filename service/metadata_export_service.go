@@ -0,0 +1,293 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"yanshu-imgbed/database"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MetadataExportVersion 导出文件的格式版本，导入时只接受相同版本
+const MetadataExportVersion = 1
+
+// backendSecretKeys 后端配置中的凭据字段，不导出密钥时会被移除
+var backendSecretKeys = []string{"token", "accessKeyId", "accessKeySecret"}
+
+// ErrUnsupportedExportVersion 导入文件的格式版本不受支持
+var ErrUnsupportedExportVersion = errors.New("unsupported metadata export version")
+
+// MetadataExport 完整的元数据导出，用于迁移到新服务器或灾难恢复。
+// 只包含元数据，图片文件仍保存在各个后端中，导入后通过存储位置继续访问
+type MetadataExport struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	// WithSecrets 为 false 时后端凭据和用户密码哈希已被移除
+	WithSecrets      bool                       `json:"with_secrets"`
+	Backends         []database.Backend         `json:"backends"`
+	Users            []database.User            `json:"users"`
+	Images           []database.Image           `json:"images"`
+	StorageLocations []database.StorageLocation `json:"storage_locations"`
+}
+
+// MetadataImportResult 导入的统计结果
+type MetadataImportResult struct {
+	BackendsCreated  int `json:"backends_created"`
+	BackendsMatched  int `json:"backends_matched"` // 同名后端已存在，沿用现有配置
+	UsersCreated     int `json:"users_created"`
+	UsersMatched     int `json:"users_matched"` // 同名用户已存在，沿用现有账户
+	ImagesCreated    int `json:"images_created"`
+	ImagesSkipped    int `json:"images_skipped"` // UUID 已存在或所有者已有相同文件
+	LocationsCreated int `json:"locations_created"`
+	// UsersWithoutPassword 导入时没有密码哈希的用户，需要管理员重置密码后才能登录
+	UsersWithoutPassword []string `json:"users_without_password"`
+}
+
+// ExportMetadata 导出后端、用户、图片和存储位置。withSecrets 为 false 时移除后端凭据和用户密码哈希
+func ExportMetadata(withSecrets bool) (*MetadataExport, error) {
+	export := &MetadataExport{Version: MetadataExportVersion, ExportedAt: time.Now(), WithSecrets: withSecrets}
+	if err := database.DB.Order("id asc").Find(&export.Backends).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Order("id asc").Find(&export.Users).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Order("id asc").Find(&export.Images).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Order("id asc").Find(&export.StorageLocations).Error; err != nil {
+		return nil, err
+	}
+	if !withSecrets {
+		for i := range export.Backends {
+			export.Backends[i].Config = redactBackendConfig(export.Backends[i].Config)
+		}
+		for i := range export.Users {
+			export.Users[i].Password = ""
+		}
+	}
+	return export, nil
+}
+
+// redactBackendConfig 移除后端配置中的凭据字段，配置无法解析时整体清空
+func redactBackendConfig(raw []byte) []byte {
+	var config map[string]interface{}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return []byte("{}")
+	}
+	for _, key := range backendSecretKeys {
+		delete(config, key)
+	}
+	redacted, _ := json.Marshal(config)
+	return redacted
+}
+
+// WriteImagesCSV 以 CSV 格式导出图片列表，每行一张图片，存储位置以 "后端名=URL" 的形式用空格分隔
+func WriteImagesCSV(w io.Writer) error {
+	var images []database.Image
+	if err := database.DB.Preload("StorageLocations.Backend").Order("id asc").Find(&images).Error; err != nil {
+		return err
+	}
+	var users []database.User
+	if err := database.DB.Select("id", "username").Find(&users).Error; err != nil {
+		return err
+	}
+	usernames := make(map[uint]string, len(users))
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+	}
+
+	writer := csv.NewWriter(w)
+	header := []string{"uuid", "original_filename", "username", "content_type", "file_size", "width", "height",
+		"md5", "sha256", "visibility", "folder", "created_at", "locations"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, image := range images {
+		locations := make([]string, 0, len(image.StorageLocations))
+		for _, loc := range image.StorageLocations {
+			locations = append(locations, loc.Backend.Name+"="+loc.URL)
+		}
+		record := []string{
+			image.UUID, image.OriginalFilename, usernames[image.UserID], image.ContentType,
+			strconv.FormatInt(image.FileSize, 10), strconv.Itoa(image.Width), strconv.Itoa(image.Height),
+			image.MD5, image.SHA256, image.Visibility, image.Folder, image.CreatedAt.Format(time.RFC3339),
+			strings.Join(locations, " "),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ImportMetadata 把导出的元数据导入当前实例，整个导入在一个事务中完成。
+// 后端和用户按名称匹配，已存在的沿用现有记录；图片保留原 UUID，已存在的跳过，因此可以重复导入
+func ImportMetadata(export *MetadataExport) (*MetadataImportResult, error) {
+	if export.Version != MetadataExportVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedExportVersion, export.Version)
+	}
+	result := &MetadataImportResult{UsersWithoutPassword: []string{}}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		backendIDs, err := importBackends(tx, export.Backends, result)
+		if err != nil {
+			return err
+		}
+		userIDs, err := importUsers(tx, export.Users, result)
+		if err != nil {
+			return err
+		}
+
+		locationsByImage := make(map[uint][]database.StorageLocation)
+		for _, loc := range export.StorageLocations {
+			locationsByImage[loc.ImageID] = append(locationsByImage[loc.ImageID], loc)
+		}
+		for _, image := range export.Images {
+			oldID := image.ID
+			userID, ok := userIDs[image.UserID]
+			if !ok {
+				return fmt.Errorf("image %s references unknown user %d", image.UUID, image.UserID)
+			}
+			var count int64
+			if err := tx.Model(&database.Image{}).
+				Where("uuid = ? OR (md5 = ? AND user_id = ?)", image.UUID, image.MD5, userID).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				result.ImagesSkipped++
+				continue
+			}
+
+			// 相册、标签和访问记录不在导出范围内
+			image.ID = 0
+			image.UserID = userID
+			image.AlbumID = nil
+			image.LastServedBackendID = nil
+			image.Tags = nil
+			image.StorageLocations = nil
+			if err := tx.Omit(clause.Associations).Create(&image).Error; err != nil {
+				return fmt.Errorf("failed to import image %s: %w", image.UUID, err)
+			}
+			result.ImagesCreated++
+
+			for _, loc := range locationsByImage[oldID] {
+				backendID, ok := backendIDs[loc.BackendID]
+				if !ok {
+					return fmt.Errorf("storage location of image %s references unknown backend %d", image.UUID, loc.BackendID)
+				}
+				active := loc.IsActive
+				loc.ID = 0
+				loc.ImageID = image.ID
+				loc.BackendID = backendID
+				loc.Backend = database.Backend{}
+				if err := tx.Omit(clause.Associations).Create(&loc).Error; err != nil {
+					return fmt.Errorf("failed to import storage location of image %s: %w", image.UUID, err)
+				}
+				if !active {
+					if err := tx.Model(&loc).Update("is_active", false).Error; err != nil {
+						return err
+					}
+				}
+				result.LocationsCreated++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	InitSuspendedUsers()
+	go UpdateRandomImageCache()
+	log.Printf("Imported metadata: %d backend(s), %d user(s), %d image(s) created, %d image(s) skipped.",
+		result.BackendsCreated, result.UsersCreated, result.ImagesCreated, result.ImagesSkipped)
+	return result, nil
+}
+
+// importBackends 按名称匹配或创建后端，返回导出文件中的 ID 到当前 ID 的映射
+func importBackends(tx *gorm.DB, backends []database.Backend, result *MetadataImportResult) (map[uint]uint, error) {
+	ids := make(map[uint]uint, len(backends))
+	for _, backend := range backends {
+		var existing database.Backend
+		err := tx.Select("id").Where("name = ?", backend.Name).First(&existing).Error
+		if err == nil {
+			ids[backend.ID] = existing.ID
+			result.BackendsMatched++
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		oldID := backend.ID
+		// 这些字段的数据库默认值为 true，创建时会忽略 false，需要单独写回
+		flags := map[string]interface{}{"allow_upload": backend.AllowUpload, "allow_redirect": backend.AllowRedirect}
+		backend.ID = 0
+		if err := tx.Create(&backend).Error; err != nil {
+			return nil, fmt.Errorf("failed to import backend %q: %w", backend.Name, err)
+		}
+		if err := tx.Model(&backend).Updates(flags).Error; err != nil {
+			return nil, err
+		}
+		ids[oldID] = backend.ID
+		result.BackendsCreated++
+	}
+	return ids, nil
+}
+
+// importUsers 按用户名匹配或创建用户，返回导出文件中的 ID 到当前 ID 的映射。
+// 没有密码哈希的用户设置一个随机密码，需要管理员重置后才能登录
+func importUsers(tx *gorm.DB, users []database.User, result *MetadataImportResult) (map[uint]uint, error) {
+	ids := make(map[uint]uint, len(users))
+	for _, user := range users {
+		var existing database.User
+		err := tx.Select("id").Where("username = ?", user.Username).First(&existing).Error
+		if err == nil {
+			ids[user.ID] = existing.ID
+			result.UsersMatched++
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if user.Password == "" {
+			buf := make([]byte, 16)
+			if _, err := rand.Read(buf); err != nil {
+				return nil, err
+			}
+			hashed, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(buf)), bcrypt.DefaultCost)
+			if err != nil {
+				return nil, err
+			}
+			user.Password = string(hashed)
+			result.UsersWithoutPassword = append(result.UsersWithoutPassword, user.Username)
+		}
+		oldID := user.ID
+		active := user.IsActive
+		user.ID = 0
+		user.APITokens = nil
+		if err := tx.Omit(clause.Associations).Create(&user).Error; err != nil {
+			return nil, fmt.Errorf("failed to import user %q: %w", user.Username, err)
+		}
+		if !active {
+			if err := tx.Model(&user).Update("is_active", false).Error; err != nil {
+				return nil, err
+			}
+		}
+		ids[oldID] = user.ID
+		result.UsersCreated++
+	}
+	return ids, nil
+}
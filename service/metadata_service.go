@@ -0,0 +1,90 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/util"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ImageMetadataResponse 图片详情页展示的完整技术信息
+type ImageMetadataResponse struct {
+	UUID        string `json:"uuid"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	FileSize    int64  `json:"file_size"`
+	*util.ImageMetadata
+}
+
+// GetImageMetadata 返回图片的 EXIF/ICC/XMP 元数据
+// 首次请求时读取文件解析并缓存到数据库，之后直接读取缓存
+func GetImageMetadata(imageUUID string, userID uint, userRole string) (*ImageMetadataResponse, error) {
+	var image database.Image
+	query := database.DB.Preload("StorageLocations").Where("uuid = ?", imageUUID)
+	if !CanAccessAllImages(userRole) {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w or permission denied", ErrImageNotFound)
+		}
+		return nil, err
+	}
+
+	meta, err := loadImageMetadata(&image)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageMetadataResponse{
+		UUID:          image.UUID,
+		ContentType:   image.ContentType,
+		Width:         image.Width,
+		Height:        image.Height,
+		FileSize:      image.FileSize,
+		ImageMetadata: meta,
+	}, nil
+}
+
+func loadImageMetadata(image *database.Image) (*util.ImageMetadata, error) {
+	var cached database.ImageMetadataCache
+	err := database.DB.Where("image_id = ?", image.ID).First(&cached).Error
+	if err == nil {
+		var meta util.ImageMetadata
+		if err := json.Unmarshal(cached.Data, &meta); err == nil {
+			return &meta, nil
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	rc, err := OpenImageContent(image)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	meta := util.ParseImageMetadata(data)
+
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	// 并发请求可能同时解析同一张图片，冲突时以后写入的结果为准
+	entry := database.ImageMetadataCache{ImageID: image.ID, Data: encoded}
+	if err := database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "image_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "updated_at"}),
+	}).Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
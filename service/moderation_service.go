@@ -0,0 +1,151 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+
+	"gorm.io/gorm"
+)
+
+// 审核状态
+const (
+	ModerationFlagged     = "flagged"     // 超过阈值，正常提供访问，等待管理员复核
+	ModerationQuarantined = "quarantined" // 超过阈值，复核前不对外提供访问
+	ModerationApproved    = "approved"    // 管理员复核通过
+)
+
+// ErrImageQuarantined 图片处于隔离状态，等待审核
+var ErrImageQuarantined = errors.New("image is quarantined pending moderation review")
+
+// moderationResult 审核服务的判定结果
+type moderationResult struct {
+	Status string
+	Score  float64
+}
+
+// moderateUpload 将上传文件发送到审核服务，根据阈值返回审核结果
+// 审核服务不可用时放行上传，避免外部服务故障影响上传
+func moderateUpload(file *multipart.FileHeader) (*moderationResult, error) {
+	settings := GetModerationSettings()
+	if !settings.Enabled || settings.Endpoint == "" {
+		return &moderationResult{}, nil
+	}
+
+	score, err := requestModerationScore(file, settings)
+	if err != nil {
+		log.Printf("Moderation request for %s failed: %v. Allowing upload.", file.Filename, err)
+		return &moderationResult{}, nil
+	}
+	if score < settings.Threshold {
+		return &moderationResult{Score: score}, nil
+	}
+
+	switch settings.Action {
+	case "reject":
+		return nil, &UploadRejectedError{Reason: "Image was rejected by content moderation"}
+	case "quarantine":
+		return &moderationResult{Status: ModerationQuarantined, Score: score}, nil
+	default:
+		return &moderationResult{Status: ModerationFlagged, Score: score}, nil
+	}
+}
+
+// requestModerationScore 以 multipart 表单 (字段名 file) 提交图片
+// 响应需为 JSON，取 "score" 或 "scores" 对象中的最大值作为分数
+func requestModerationScore(file *multipart.FileHeader, settings ModerationSettings) (float64, error) {
+	src, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", file.Filename)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(part, src); err != nil {
+		return 0, err
+	}
+	writer.Close()
+
+	req, err := http.NewRequest("POST", settings.Endpoint, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	client := &http.Client{Timeout: time.Duration(settings.TimeoutSeconds) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("moderation endpoint returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Score  *float64           `json:"score"`
+		Scores map[string]float64 `json:"scores"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode moderation response: %w", err)
+	}
+	if result.Score != nil {
+		return *result.Score, nil
+	}
+	if len(result.Scores) == 0 {
+		return 0, errors.New("moderation response contains no score")
+	}
+	max := 0.0
+	for _, s := range result.Scores {
+		if s > max {
+			max = s
+		}
+	}
+	return max, nil
+}
+
+// ListModerationQueue 列出等待复核的图片
+func ListModerationQueue() ([]database.Image, error) {
+	var images []database.Image
+	err := database.DB.Where("moderation_status IN ?", []string{ModerationFlagged, ModerationQuarantined}).
+		Order("moderation_score desc, created_at asc").Find(&images).Error
+	return images, err
+}
+
+// ApproveModeratedImage 复核通过，解除隔离
+func ApproveModeratedImage(imageUUID string) (*database.Image, error) {
+	var image database.Image
+	if err := database.DB.Where("uuid = ?", imageUUID).First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	wasQuarantined := image.ModerationStatus == ModerationQuarantined
+	image.ModerationStatus = ModerationApproved
+	if err := database.DB.Model(&image).Update("moderation_status", image.ModerationStatus).Error; err != nil {
+		return nil, err
+	}
+	if wasQuarantined && image.AllowRandom {
+		go UpdateRandomImageCache()
+	}
+	return &image, nil
+}
+
+// RejectModeratedImage 复核不通过，删除图片及其文件
+func RejectModeratedImage(imageUUID string, userID uint, storageManager *manager.StorageManager) error {
+	return DeleteImage(imageUUID, userID, AdminRoleName, storageManager)
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"log"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm/clause"
+)
+
+// 管理员通知的类型
+const (
+	NotificationLocationFailover = "location_failover"
+	NotificationBackupFailed     = "backup_failed"
+)
+
+// NotificationPage 管理员通知的分页结果
+type NotificationPage struct {
+	Items  []database.AdminNotification `json:"items"`
+	Total  int64                        `json:"total"`
+	Unread int64                        `json:"unread"`
+}
+
+// NotifyAdmin 记录一条管理员通知，写入失败只记日志
+func NotifyAdmin(kind, message string) {
+	if err := database.DB.Create(&database.AdminNotification{Type: kind, Message: message}).Error; err != nil {
+		log.Printf("Failed to save admin notification (%s: %s): %v", kind, message, err)
+	}
+}
+
+// ListAdminNotifications 分页列出管理员通知，最新的在前
+func ListAdminNotifications(unreadOnly bool, page, pageSize int) (*NotificationPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	query := database.DB.Model(&database.AdminNotification{})
+	if unreadOnly {
+		query = query.Where(clause.Eq{Column: "read", Value: false})
+	}
+	result := &NotificationPage{Items: []database.AdminNotification{}}
+	if err := query.Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+	if err := query.Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&result.Items).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Model(&database.AdminNotification{}).Where(clause.Eq{Column: "read", Value: false}).Count(&result.Unread).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// MarkNotificationsRead 把指定的通知标记为已读，ids 为空时标记全部
+func MarkNotificationsRead(ids []uint) error {
+	query := database.DB.Model(&database.AdminNotification{}).Where(clause.Eq{Column: "read", Value: false})
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	return query.Update("read", true).Error
+}
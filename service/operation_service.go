@@ -0,0 +1,99 @@
+package service
+
+import (
+	"log"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm/clause"
+)
+
+// 存储操作类型
+const (
+	OperationUpload   = "upload"
+	OperationDelete   = "delete"
+	OperationBackfill = "backfill"
+)
+
+// recordStorageOperation 记录一次物理存储操作的结果和耗时
+func recordStorageOperation(operation string, backendID uint, key string, start time.Time, opErr error) {
+	op := database.StorageOperation{
+		BackendID:  backendID,
+		Operation:  operation,
+		Key:        key,
+		Success:    opErr == nil,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if opErr != nil {
+		op.Error = opErr.Error()
+	}
+	if err := database.DB.Create(&op).Error; err != nil {
+		log.Printf("Failed to record storage operation: %v", err)
+	}
+}
+
+// OperationFilter 操作日志的查询条件
+type OperationFilter struct {
+	BackendID uint
+	Operation string
+	Success   *bool
+	Keyword   string
+	From      *time.Time
+	To        *time.Time
+	Page      int
+	PageSize  int
+}
+
+// ListOperationsResponse 分页的操作日志
+type ListOperationsResponse struct {
+	Total      int64                       `json:"total"`
+	Page       int                         `json:"page"`
+	PageSize   int                         `json:"pageSize"`
+	Operations []database.StorageOperation `json:"operations"`
+}
+
+// ListStorageOperations 按条件分页查询操作日志
+func ListStorageOperations(filter OperationFilter) (*ListOperationsResponse, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 500 {
+		filter.PageSize = 50
+	}
+
+	query := database.DB.Model(&database.StorageOperation{})
+	if filter.BackendID != 0 {
+		query = query.Where("backend_id = ?", filter.BackendID)
+	}
+	if filter.Operation != "" {
+		query = query.Where("operation = ?", filter.Operation)
+	}
+	if filter.Success != nil {
+		query = query.Where("success = ?", *filter.Success)
+	}
+	if filter.Keyword != "" {
+		query = query.Where(clause.Like{Column: "key", Value: "%" + filter.Keyword + "%"})
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at <= ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var operations []database.StorageOperation
+	offset := (filter.Page - 1) * filter.PageSize
+	if err := query.Order("created_at desc").Limit(filter.PageSize).Offset(offset).Find(&operations).Error; err != nil {
+		return nil, err
+	}
+	return &ListOperationsResponse{
+		Total:      total,
+		Page:       filter.Page,
+		PageSize:   filter.PageSize,
+		Operations: operations,
+	}, nil
+}
@@ -0,0 +1,389 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+
+	"gorm.io/gorm"
+)
+
+// 孤儿文件的处理方式
+const (
+	OrphanActionReport = "report" // 只生成报告
+	OrphanActionAdopt  = "adopt"  // 原地登记为图片，归属 UserID
+	OrphanActionDelete = "delete" // 从磁盘删除
+)
+
+const (
+	// maxOrphanReportItems 报告中列出的孤儿文件和缺失文件数量上限
+	maxOrphanReportItems = 500
+	// orphanMinAge 最近修改过的文件可能属于正在进行的上传 (文件已写入、存储位置尚未创建)，不视为孤儿
+	orphanMinAge = 10 * time.Minute
+)
+
+// ErrOrphanScanRunning 已有孤儿文件扫描在进行
+var ErrOrphanScanRunning = errors.New("an orphan file scan is already running")
+
+// ErrInvalidOrphanAction 处理方式不是 report、adopt 或 delete
+var ErrInvalidOrphanAction = errors.New("invalid orphan action, expected report, adopt or delete")
+
+// OrphanScanRequest 孤儿文件扫描的参数
+type OrphanScanRequest struct {
+	// BackendID 只扫描该本地后端，0 表示所有本地后端
+	BackendID uint `json:"backend_id"`
+	// Action report (默认)、adopt 或 delete
+	Action string `json:"action"`
+	// UserID adopt 时图片归属的用户，为 0 时归属发起扫描的管理员
+	UserID uint `json:"user_id"`
+	// Folder adopt 时图片存放的文件夹
+	Folder string `json:"folder"`
+}
+
+// OrphanFile 存储目录中没有任何存储位置引用的文件
+type OrphanFile struct {
+	BackendID uint   `json:"backend_id"`
+	Path      string `json:"path"` // 相对于后端存储目录
+	Size      int64  `json:"size"`
+	// Result adopted、deleted、skipped 或 failed，只报告时为空
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// MissingLocalFile 指向不存在文件的本地存储位置
+type MissingLocalFile struct {
+	LocationID uint   `json:"location_id"`
+	ImageUUID  string `json:"image_uuid"`
+	BackendID  uint   `json:"backend_id"`
+	Path       string `json:"path"`
+	IsActive   bool   `json:"is_active"`
+}
+
+// OrphanReport 孤儿文件扫描的结果
+type OrphanReport struct {
+	TaskID       string             `json:"task_id"`
+	Request      OrphanScanRequest  `json:"request"`
+	StartedAt    time.Time          `json:"started_at"`
+	FinishedAt   *time.Time         `json:"finished_at,omitempty"`
+	ScannedFiles int                `json:"scanned_files"`
+	OrphanCount  int                `json:"orphan_count"`
+	OrphanBytes  int64              `json:"orphan_bytes"`
+	Adopted      int                `json:"adopted"`
+	Deleted      int                `json:"deleted"`
+	Orphans      []OrphanFile       `json:"orphans"`
+	MissingCount int                `json:"missing_count"`
+	Missing      []MissingLocalFile `json:"missing"`
+	// Truncated 孤儿文件或缺失文件超过上限，列表只包含前面一部分
+	Truncated bool `json:"truncated"`
+}
+
+var (
+	lastOrphanReport   *OrphanReport
+	lastOrphanReportMu sync.RWMutex
+	// orphanScanMu 同一时间只允许一个孤儿文件扫描
+	orphanScanMu sync.Mutex
+)
+
+// GetOrphanReport 返回最近一次 (或正在进行的) 扫描报告，从未运行过时返回 nil
+func GetOrphanReport() *OrphanReport {
+	lastOrphanReportMu.RLock()
+	defer lastOrphanReportMu.RUnlock()
+	if lastOrphanReport == nil {
+		return nil
+	}
+	report := *lastOrphanReport
+	report.Orphans = append([]OrphanFile{}, lastOrphanReport.Orphans...)
+	report.Missing = append([]MissingLocalFile{}, lastOrphanReport.Missing...)
+	return &report
+}
+
+// StartOrphanScan 启动后台任务，扫描本地后端的存储目录：找出没有存储位置引用的文件并按 Action 处理，
+// 同时列出指向不存在文件的存储位置 (只报告，停用交给完整性校验)
+func StartOrphanScan(req OrphanScanRequest, storageManager *manager.StorageManager) (string, error) {
+	switch req.Action {
+	case "":
+		req.Action = OrphanActionReport
+	case OrphanActionReport, OrphanActionAdopt, OrphanActionDelete:
+	default:
+		return "", ErrInvalidOrphanAction
+	}
+	folder, err := NormalizeFolder(req.Folder)
+	if err != nil {
+		return "", &UploadRejectedError{Reason: err.Error()}
+	}
+	req.Folder = folder
+
+	targets, err := localBackendTargets(storageManager)
+	if err != nil {
+		return "", err
+	}
+	if req.BackendID != 0 {
+		var selected []localImportTarget
+		for _, target := range targets {
+			if target.backendID == req.BackendID {
+				selected = append(selected, target)
+			}
+		}
+		if len(selected) == 0 {
+			return "", ErrBackendNotFound
+		}
+		targets = selected
+	}
+	if !orphanScanMu.TryLock() {
+		return "", ErrOrphanScanRunning
+	}
+
+	task := newTask(fmt.Sprintf("Orphan File Scan (%s)", req.Action), len(targets))
+	report := &OrphanReport{TaskID: task.ID, Request: req, StartedAt: time.Now(), Orphans: []OrphanFile{}, Missing: []MissingLocalFile{}}
+	lastOrphanReportMu.Lock()
+	lastOrphanReport = report
+	lastOrphanReportMu.Unlock()
+
+	go func() {
+		defer orphanScanMu.Unlock()
+		for i, target := range targets {
+			if err := scanLocalBackend(target, req, report); err != nil {
+				log.Printf("[Task %s] Orphan scan of backend %d failed: %v", task.ID, target.backendID, err)
+				recordTaskItemError(task, fmt.Sprintf("backend %d", target.backendID), err)
+				updateTask(task, func(t *Task) {
+					t.Status = "failed"
+					t.Message = err.Error()
+				})
+				finishOrphanReport(report)
+				return
+			}
+			updateTask(task, func(t *Task) { t.Progress = i + 1 })
+		}
+		finishOrphanReport(report)
+		lastOrphanReportMu.RLock()
+		message := fmt.Sprintf("Scanned %d file(s): %d orphan(s) (%d adopted, %d deleted), %d missing file(s)",
+			report.ScannedFiles, report.OrphanCount, report.Adopted, report.Deleted, report.MissingCount)
+		lastOrphanReportMu.RUnlock()
+		log.Printf("Orphan file scan finished. %s.", message)
+		updateTask(task, func(t *Task) {
+			t.Status = "completed"
+			t.Message = message
+		})
+	}()
+	return task.ID, nil
+}
+
+// finishOrphanReport 记录扫描结束时间
+func finishOrphanReport(report *OrphanReport) {
+	now := time.Now()
+	lastOrphanReportMu.Lock()
+	report.FinishedAt = &now
+	lastOrphanReportMu.Unlock()
+}
+
+// scanLocalBackend 扫描单个本地后端：先收集所有被引用的相对路径，再遍历存储目录
+func scanLocalBackend(target localImportTarget, req OrphanScanRequest, report *OrphanReport) error {
+	referenced, err := referencedLocalPaths(target)
+	if err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(target.storagePath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Skipping unreadable path %s during orphan scan: %v", path, err)
+			return nil
+		}
+		if d.IsDir() {
+			if path != target.storagePath && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(target.storagePath, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		lastOrphanReportMu.Lock()
+		report.ScannedFiles++
+		lastOrphanReportMu.Unlock()
+		if referenced[rel] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || time.Since(info.ModTime()) < orphanMinAge {
+			return nil
+		}
+		orphan := OrphanFile{BackendID: target.backendID, Path: rel, Size: info.Size()}
+		handleOrphanFile(path, &orphan, req, target)
+		recordOrphan(report, orphan)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", target.storagePath, err)
+	}
+	return reportMissingLocalFiles(target, report)
+}
+
+// handleOrphanFile 按请求的方式处理一个孤儿文件，结果写入 orphan.Result
+func handleOrphanFile(path string, orphan *OrphanFile, req OrphanScanRequest, target localImportTarget) {
+	var err error
+	switch req.Action {
+	case OrphanActionReport:
+		return
+	case OrphanActionAdopt:
+		err = registerLocalFile(path, req.UserID, req.Folder, &target)
+		orphan.Result = "adopted"
+	case OrphanActionDelete:
+		err = os.Remove(path)
+		orphan.Result = "deleted"
+	}
+	var rejected *UploadRejectedError
+	switch {
+	case err == nil:
+	case errors.As(err, &rejected):
+		orphan.Result, orphan.Error = "skipped", rejected.Reason
+	case errors.Is(err, errImportDuplicate):
+		orphan.Result, orphan.Error = "skipped", "duplicate of an existing image"
+	default:
+		orphan.Result, orphan.Error = "failed", err.Error()
+		log.Printf("Failed to %s orphan file %s: %v", req.Action, path, err)
+	}
+}
+
+// recordOrphan 把一个孤儿文件计入报告
+func recordOrphan(report *OrphanReport, orphan OrphanFile) {
+	lastOrphanReportMu.Lock()
+	defer lastOrphanReportMu.Unlock()
+	report.OrphanCount++
+	report.OrphanBytes += orphan.Size
+	switch orphan.Result {
+	case "adopted":
+		report.Adopted++
+	case "deleted":
+		report.Deleted++
+	}
+	if len(report.Orphans) >= maxOrphanReportItems {
+		report.Truncated = true
+		return
+	}
+	report.Orphans = append(report.Orphans, orphan)
+}
+
+// referencedLocalPaths 收集本地后端上仍被引用的文件 (相对路径)：
+// 所有存储位置 (含已停用的)、等待删除的图片快照和数据库备份
+func referencedLocalPaths(target localImportTarget) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	add := func(loc database.StorageLocation) {
+		if loc.DeleteIdentifier != "" {
+			referenced[loc.DeleteIdentifier] = true
+		}
+		if rel := localRelativePath(loc.URL, target.urlPrefix); rel != "" {
+			referenced[rel] = true
+		}
+	}
+
+	var locations []database.StorageLocation
+	err := database.DB.Select("id", "url", "delete_identifier").Where("backend_id = ?", target.backendID).
+		FindInBatches(&locations, locationBatchSize, func(tx *gorm.DB, batch int) error {
+			for _, loc := range locations {
+				add(loc)
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []database.PendingDeletion
+	if err := database.DB.Find(&pending).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range pending {
+		var image database.Image
+		if err := json.Unmarshal(p.Snapshot, &image); err != nil {
+			continue
+		}
+		for _, loc := range image.StorageLocations {
+			if loc.BackendID == target.backendID {
+				add(loc)
+			}
+		}
+	}
+
+	var backups []database.DatabaseBackup
+	if err := database.DB.Where("backend_id = ?", target.backendID).Find(&backups).Error; err != nil {
+		return nil, err
+	}
+	for _, backup := range backups {
+		referenced[backup.DeleteIdentifier] = true
+	}
+	return referenced, nil
+}
+
+// localRelativePath 把本地存储位置的 URL 转换为相对于存储目录的路径，不属于该目录时返回空
+func localRelativePath(rawURL, urlPrefix string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	rel, found := strings.CutPrefix(parsed.Path, urlPrefix+"/")
+	if !found {
+		return ""
+	}
+	return rel
+}
+
+// reportMissingLocalFiles 列出该后端上指向不存在文件的存储位置
+func reportMissingLocalFiles(target localImportTarget, report *OrphanReport) error {
+	var locations []database.StorageLocation
+	return database.DB.Select("id", "image_id", "url", "delete_identifier", "is_active").Where("backend_id = ?", target.backendID).
+		FindInBatches(&locations, locationBatchSize, func(tx *gorm.DB, batch int) error {
+			var missing []MissingLocalFile
+			imageIDs := make([]uint, 0)
+			for _, loc := range locations {
+				rel := loc.DeleteIdentifier
+				if rel == "" {
+					rel = localRelativePath(loc.URL, target.urlPrefix)
+				}
+				if rel == "" {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(target.storagePath, filepath.FromSlash(rel))); !os.IsNotExist(err) {
+					continue
+				}
+				missing = append(missing, MissingLocalFile{LocationID: loc.ID, BackendID: target.backendID, Path: rel, IsActive: loc.IsActive})
+				imageIDs = append(imageIDs, loc.ImageID)
+			}
+			if len(missing) == 0 {
+				return nil
+			}
+			var images []database.Image
+			database.DB.Select("id", "uuid").Where("id IN ?", imageIDs).Find(&images)
+			uuids := make(map[uint]string, len(images))
+			for _, image := range images {
+				uuids[image.ID] = image.UUID
+			}
+
+			lastOrphanReportMu.Lock()
+			defer lastOrphanReportMu.Unlock()
+			for i := range missing {
+				missing[i].ImageUUID = uuids[imageIDs[i]]
+				report.MissingCount++
+				if len(report.Missing) >= maxOrphanReportItems {
+					report.Truncated = true
+					continue
+				}
+				report.Missing = append(report.Missing, missing[i])
+			}
+			return nil
+		}).Error
+}
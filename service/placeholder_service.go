@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sync"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/util"
+)
+
+// maxPlaceholderBytes 占位图的大小上限，占位图每次都从内存输出，不宜过大
+const maxPlaceholderBytes = 1024 * 1024
+
+// ErrNoPlaceholder 尚未上传占位图
+var ErrNoPlaceholder = errors.New("no placeholder image has been uploaded")
+
+// placeholderTypes 允许作为占位图的类型；不允许 SVG，避免在图片地址上输出可执行脚本的内容
+var placeholderTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// placeholderImage 内存中缓存的占位图，loaded 为 false 时下次使用前从磁盘读取
+var placeholderImage struct {
+	mu          sync.Mutex
+	loaded      bool
+	data        []byte
+	contentType string
+}
+
+// PlaceholderInfo 占位图的状态
+type PlaceholderInfo struct {
+	Enabled     bool   `json:"enabled"`
+	Uploaded    bool   `json:"uploaded"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int    `json:"size"`
+}
+
+// SavePlaceholderImage 保存管理员上传的占位图，替换已有的文件
+func SavePlaceholderImage(file *multipart.FileHeader) (*PlaceholderInfo, error) {
+	if file.Size > maxPlaceholderBytes {
+		return nil, &UploadRejectedError{Reason: "Placeholder image must not exceed 1 MB"}
+	}
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, maxPlaceholderBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxPlaceholderBytes {
+		return nil, &UploadRejectedError{Reason: "Placeholder image must not exceed 1 MB"}
+	}
+	fileType := util.DetectFileType(data)
+	if fileType == nil || !placeholderTypes[fileType.MIME] {
+		return nil, &UploadRejectedError{Reason: "Placeholder must be a JPEG, PNG, GIF or WebP image"}
+	}
+
+	path := config.Cfg.Imaging.PlaceholderPath
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	placeholderImage.mu.Lock()
+	placeholderImage.loaded = true
+	placeholderImage.data = data
+	placeholderImage.contentType = fileType.MIME
+	placeholderImage.mu.Unlock()
+	return GetPlaceholderInfo(), nil
+}
+
+// RemovePlaceholderImage 删除占位图，之后缺失的图片重新返回 JSON 错误
+func RemovePlaceholderImage() error {
+	placeholderImage.mu.Lock()
+	defer placeholderImage.mu.Unlock()
+	if err := os.Remove(config.Cfg.Imaging.PlaceholderPath); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoPlaceholder
+		}
+		return err
+	}
+	placeholderImage.loaded = true
+	placeholderImage.data = nil
+	placeholderImage.contentType = ""
+	return nil
+}
+
+// loadPlaceholderImage 返回占位图内容，首次使用时从磁盘读取
+func loadPlaceholderImage() ([]byte, string) {
+	placeholderImage.mu.Lock()
+	defer placeholderImage.mu.Unlock()
+	if !placeholderImage.loaded {
+		placeholderImage.loaded = true
+		data, err := os.ReadFile(config.Cfg.Imaging.PlaceholderPath)
+		if err == nil && len(data) <= maxPlaceholderBytes {
+			if fileType := util.DetectFileType(data); fileType != nil && placeholderTypes[fileType.MIME] {
+				placeholderImage.data = data
+				placeholderImage.contentType = fileType.MIME
+			}
+		}
+	}
+	return placeholderImage.data, placeholderImage.contentType
+}
+
+// GetPlaceholderImage 在启用了占位图且已上传时返回其内容和类型
+func GetPlaceholderImage() ([]byte, string, bool) {
+	if !GetPlaceholderEnabled() {
+		return nil, "", false
+	}
+	data, contentType := loadPlaceholderImage()
+	return data, contentType, data != nil
+}
+
+// GetPlaceholderInfo 返回占位图的设置和上传状态
+func GetPlaceholderInfo() *PlaceholderInfo {
+	data, contentType := loadPlaceholderImage()
+	return &PlaceholderInfo{
+		Enabled:     GetPlaceholderEnabled(),
+		Uploaded:    data != nil,
+		ContentType: contentType,
+		Size:        len(data),
+	}
+}
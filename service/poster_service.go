@@ -0,0 +1,105 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"image/gif"
+	"log"
+	"os"
+	"path/filepath"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/util"
+
+	"gorm.io/gorm"
+)
+
+// posterMaxSize 预览图的最大宽高
+const posterMaxSize = 480
+
+// ErrNoPoster 图片不是动图，没有首帧预览图
+var ErrNoPoster = errors.New("image has no poster frame")
+
+// HasPoster 判断图片是否提供首帧预览图 (目前仅支持 GIF)
+func HasPoster(image *database.Image) bool {
+	return image.ContentType == "image/gif"
+}
+
+// PosterURL 返回图片首帧预览图的访问地址，非动图返回空字符串
+func PosterURL(image *database.Image) string {
+	if !HasPoster(image) {
+		return ""
+	}
+	return config.WithBasePath(fmt.Sprintf("/image/%s/poster", image.UUID))
+}
+
+// GetPosterPath 返回图片首帧预览图的本地路径，首次访问时提取并缓存
+func GetPosterPath(imageUUID string, viewer ImageViewer) (string, error) {
+	var image database.Image
+	if err := database.DB.Preload("StorageLocations").Where("uuid = ?", imageUUID).First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", missingImageError(imageUUID)
+		}
+		return "", err
+	}
+	if err := checkServable(&image); err != nil {
+		return "", err
+	}
+	if err := checkImageAccess(&image, viewer); err != nil {
+		return "", err
+	}
+	if !HasPoster(&image) {
+		return "", ErrNoPoster
+	}
+
+	path := posterCachePath(image.UUID)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := extractPoster(&image, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// extractPoster 解码动图的第一帧，缩小后保存为 JPEG
+func extractPoster(image *database.Image, path string) error {
+	rc, err := OpenImageContent(image)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// gif.Decode 只解码第一帧
+	frame, err := gif.Decode(rc)
+	if err != nil {
+		return fmt.Errorf("failed to decode GIF: %w", err)
+	}
+	b := frame.Bounds()
+	w, h := util.FitDimensions(b.Dx(), b.Dy(), posterMaxSize, posterMaxSize)
+	data, _, err := util.EncodeImage(util.ResizeImage(frame, w, h), "jpeg", util.DefaultJPEGQuality)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免并发请求读到写了一半的文件
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removePosterCache 删除图片的预览图缓存
+func removePosterCache(imageUUID string) {
+	if err := os.Remove(posterCachePath(imageUUID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove poster cache for %s: %v", imageUUID, err)
+	}
+}
+
+func posterCachePath(imageUUID string) string {
+	return filepath.Join(config.Cfg.Imaging.PosterCacheDir, imageUUID+".jpg")
+}
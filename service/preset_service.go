@@ -0,0 +1,179 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrPresetNotFound 上传预设不存在或不属于当前用户
+	ErrPresetNotFound = errors.New("upload preset not found")
+	// ErrPresetNameTaken 同一用户下已有同名预设
+	ErrPresetNameTaken = errors.New("an upload preset with this name already exists")
+)
+
+// maxPresetNameLength 与 UploadPreset.Name 的列宽一致
+const maxPresetNameLength = 100
+
+// UploadPresetInput 创建或更新上传预设的参数
+type UploadPresetInput struct {
+	Name             string `json:"name"`
+	BackendIDs       []uint `json:"backend_ids"`
+	Watermark        *bool  `json:"watermark"`
+	Compress         *bool  `json:"compress"`
+	Folder           string `json:"folder"`
+	FilenameStrategy string `json:"filename_strategy"`
+}
+
+// validate 规范化并校验预设参数
+func (in *UploadPresetInput) validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return &UploadRejectedError{Reason: "Preset name is required"}
+	}
+	if len(in.Name) > maxPresetNameLength {
+		return &UploadRejectedError{Reason: fmt.Sprintf("Preset name must not exceed %d characters", maxPresetNameLength)}
+	}
+	var err error
+	if in.Folder, err = NormalizeFolder(in.Folder); err != nil {
+		return err
+	}
+	if in.FilenameStrategy, err = NormalizeFilenameStrategy(in.FilenameStrategy); err != nil {
+		return err
+	}
+	return ValidateBackendIDs(in.BackendIDs)
+}
+
+// ValidateBackendIDs 检查绑定的后端是否都存在
+func ValidateBackendIDs(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var count int64
+	if err := database.DB.Model(&database.Backend{}).Where("id IN ?", ids).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count) != len(ids) {
+		return &UploadRejectedError{Reason: "Unknown backend ID in backend list"}
+	}
+	return nil
+}
+
+// apply 把参数写入预设记录
+func (in UploadPresetInput) apply(preset *database.UploadPreset) {
+	ids, _ := json.Marshal(in.BackendIDs)
+	preset.Name = in.Name
+	preset.BackendIDs = ids
+	preset.Watermark = in.Watermark
+	preset.Compress = in.Compress
+	preset.Folder = in.Folder
+	preset.FilenameStrategy = in.FilenameStrategy
+}
+
+// ListUploadPresets 列出用户的所有上传预设
+func ListUploadPresets(userID uint) ([]database.UploadPreset, error) {
+	var presets []database.UploadPreset
+	err := database.DB.Where("user_id = ?", userID).Order("name asc").Find(&presets).Error
+	return presets, err
+}
+
+// CreateUploadPreset 为用户创建上传预设
+func CreateUploadPreset(userID uint, in UploadPresetInput) (*database.UploadPreset, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	if err := checkPresetNameFree(userID, in.Name, 0); err != nil {
+		return nil, err
+	}
+	preset := database.UploadPreset{UserID: userID}
+	in.apply(&preset)
+	if err := database.DB.Create(&preset).Error; err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+// UpdateUploadPreset 更新用户自己的上传预设
+func UpdateUploadPreset(id, userID uint, in UploadPresetInput) (*database.UploadPreset, error) {
+	var preset database.UploadPreset
+	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&preset).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPresetNotFound
+		}
+		return nil, err
+	}
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	if err := checkPresetNameFree(userID, in.Name, preset.ID); err != nil {
+		return nil, err
+	}
+	in.apply(&preset)
+	// 显式保存所有列，否则取消水印/压缩设置 (nil) 不会写入
+	if err := database.DB.Select("*").Save(&preset).Error; err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+// DeleteUploadPreset 删除用户自己的上传预设
+func DeleteUploadPreset(id, userID uint) error {
+	result := database.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&database.UploadPreset{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPresetNotFound
+	}
+	return nil
+}
+
+// checkPresetNameFree 检查预设名在用户下是否可用，exceptID 为正在更新的预设
+func checkPresetNameFree(userID uint, name string, exceptID uint) error {
+	var count int64
+	if err := database.DB.Model(&database.UploadPreset{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, exceptID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrPresetNameTaken
+	}
+	return nil
+}
+
+// ApplyUploadPreset 按名称加载用户的预设，作为本次上传的默认值
+// 请求中显式传入的参数优先；返回的后端列表在请求没有指定后端时使用
+func ApplyUploadPreset(userID uint, name string, targetBackendIDs []uint, opts *UploadOptions) ([]uint, error) {
+	var preset database.UploadPreset
+	if err := database.DB.Where("user_id = ? AND name = ?", userID, strings.TrimSpace(name)).First(&preset).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrPresetNotFound, name)
+		}
+		return nil, err
+	}
+
+	if len(targetBackendIDs) == 0 && len(preset.BackendIDs) > 0 {
+		if err := json.Unmarshal(preset.BackendIDs, &targetBackendIDs); err != nil {
+			return nil, fmt.Errorf("invalid backend list in preset %s: %w", preset.Name, err)
+		}
+	}
+	if opts.Watermark == nil {
+		opts.Watermark = preset.Watermark
+	}
+	if opts.Compress == nil {
+		opts.Compress = preset.Compress
+	}
+	if opts.Folder == "" {
+		opts.Folder = preset.Folder
+	}
+	if opts.FilenameStrategy == "" {
+		opts.FilenameStrategy = preset.FilenameStrategy
+	}
+	return targetBackendIDs, nil
+}
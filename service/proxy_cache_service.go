@@ -0,0 +1,238 @@
+package service
+
+import (
+	"container/list"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"yanshu-imgbed/config"
+)
+
+// proxyCache 代理模式下远程图片的本地磁盘缓存，按 UUID 存放，总大小超出上限时淘汰最久未访问的文件
+type proxyCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	size     int64
+	lru      *list.List               // 最近访问的在前
+	entries  map[string]*list.Element // UUID -> *proxyCacheEntry
+}
+
+type proxyCacheEntry struct {
+	uuid string
+	size int64
+}
+
+// imageProxyCache 为 nil 表示未启用缓存
+var imageProxyCache *proxyCache
+
+// InitProxyCache 根据配置启用代理缓存，并载入缓存目录中已有的文件
+func InitProxyCache() {
+	maxMB := config.Cfg.Imaging.ProxyCacheMaxMB
+	if maxMB <= 0 {
+		return
+	}
+	cache := &proxyCache{
+		dir:      config.Cfg.Imaging.ProxyCacheDir,
+		maxBytes: int64(maxMB) * 1024 * 1024,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	if err := os.MkdirAll(cache.dir, 0755); err != nil {
+		log.Printf("Failed to create proxy cache directory, proxy cache disabled: %v", err)
+		return
+	}
+	cache.load()
+	imageProxyCache = cache
+	log.Printf("Proxy cache enabled: %d file(s), %d/%d bytes in %s", cache.lru.Len(), cache.size, cache.maxBytes, cache.dir)
+}
+
+// load 按修改时间把已有缓存文件放入 LRU 链表，并清理上次运行残留的临时文件
+func (pc *proxyCache) load() {
+	files, err := os.ReadDir(pc.dir)
+	if err != nil {
+		log.Printf("Failed to read proxy cache directory: %v", err)
+		return
+	}
+	var infos []os.FileInfo
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(f.Name(), ".tmp") {
+			os.Remove(filepath.Join(pc.dir, f.Name()))
+			continue
+		}
+		if info, err := f.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	// 最新的排在前面，与 LRU 链表的顺序一致
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().After(infos[j].ModTime()) })
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for _, info := range infos {
+		pc.entries[info.Name()] = pc.lru.PushBack(&proxyCacheEntry{uuid: info.Name(), size: info.Size()})
+		pc.size += info.Size()
+	}
+	pc.evictLocked()
+}
+
+func (pc *proxyCache) path(imageUUID string) string {
+	return filepath.Join(pc.dir, imageUUID)
+}
+
+// open 打开缓存的文件，命中时将其标记为最近访问
+func (pc *proxyCache) open(imageUUID string) (*os.File, int64, bool) {
+	pc.mu.Lock()
+	elem, ok := pc.entries[imageUUID]
+	var size int64
+	if ok {
+		pc.lru.MoveToFront(elem)
+		size = elem.Value.(*proxyCacheEntry).size
+	}
+	pc.mu.Unlock()
+	if !ok {
+		return nil, 0, false
+	}
+
+	f, err := os.Open(pc.path(imageUUID))
+	if err != nil {
+		// 文件被外部删除，移出索引
+		pc.remove(imageUUID)
+		return nil, 0, false
+	}
+	return f, size, true
+}
+
+// add 把写好的临时文件放入缓存
+func (pc *proxyCache) add(imageUUID, tmpPath string, size int64) {
+	if size > pc.maxBytes {
+		os.Remove(tmpPath)
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if err := os.Rename(tmpPath, pc.path(imageUUID)); err != nil {
+		log.Printf("Failed to store proxy cache for %s: %v", imageUUID, err)
+		os.Remove(tmpPath)
+		return
+	}
+	if elem, ok := pc.entries[imageUUID]; ok {
+		entry := elem.Value.(*proxyCacheEntry)
+		pc.size += size - entry.size
+		entry.size = size
+		pc.lru.MoveToFront(elem)
+	} else {
+		pc.entries[imageUUID] = pc.lru.PushFront(&proxyCacheEntry{uuid: imageUUID, size: size})
+		pc.size += size
+	}
+	pc.evictLocked()
+}
+
+// evictLocked 淘汰最久未访问的文件直到总大小不超过上限，调用方需持有锁
+func (pc *proxyCache) evictLocked() {
+	for pc.size > pc.maxBytes {
+		elem := pc.lru.Back()
+		if elem == nil {
+			return
+		}
+		pc.removeElementLocked(elem)
+	}
+}
+
+func (pc *proxyCache) remove(imageUUID string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if elem, ok := pc.entries[imageUUID]; ok {
+		pc.removeElementLocked(elem)
+	}
+}
+
+func (pc *proxyCache) removeElementLocked(elem *list.Element) {
+	entry := elem.Value.(*proxyCacheEntry)
+	pc.lru.Remove(elem)
+	delete(pc.entries, entry.uuid)
+	pc.size -= entry.size
+	if err := os.Remove(pc.path(entry.uuid)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove proxy cache for %s: %v", entry.uuid, err)
+	}
+}
+
+// cachingReader 把读取的内容同时写入临时文件，完整读完后再放入缓存；中途失败或客户端断开时丢弃
+type cachingReader struct {
+	body      io.ReadCloser
+	tmp       *os.File
+	imageUUID string
+	written   int64
+	expected  int64 // 后端声明的长度，未知时为 -1
+	complete  bool
+	failed    bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 && !r.failed {
+		if _, werr := r.tmp.Write(p[:n]); werr != nil {
+			r.failed = true
+		}
+		r.written += int64(n)
+		if r.written > imageProxyCache.maxBytes {
+			// 超过整个缓存的大小，不再写入
+			r.failed = true
+		}
+	}
+	if err == io.EOF {
+		r.complete = r.expected < 0 || r.written == r.expected
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	err := r.body.Close()
+	tmpPath := r.tmp.Name()
+	r.tmp.Close()
+	if r.complete && !r.failed {
+		imageProxyCache.add(r.imageUUID, tmpPath, r.written)
+	} else {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// cacheProxiedBody 在缓存启用时包装后端返回的内容，边转发边写入缓存
+func cacheProxiedBody(imageUUID string, body io.ReadCloser, contentLength int64) io.ReadCloser {
+	if imageProxyCache == nil || contentLength > imageProxyCache.maxBytes {
+		return body
+	}
+	tmp, err := os.CreateTemp(imageProxyCache.dir, imageUUID+"-*.tmp")
+	if err != nil {
+		log.Printf("Failed to create proxy cache file: %v", err)
+		return body
+	}
+	return &cachingReader{body: body, tmp: tmp, imageUUID: imageUUID, expected: contentLength}
+}
+
+// openCachedProxyImage 从缓存读取图片，未启用缓存或未命中时返回 false
+func openCachedProxyImage(imageUUID string) (io.ReadCloser, int64, bool) {
+	if imageProxyCache == nil {
+		return nil, 0, false
+	}
+	f, size, ok := imageProxyCache.open(imageUUID)
+	if !ok {
+		return nil, 0, false
+	}
+	return f, size, true
+}
+
+// removeProxyCache 删除图片的代理缓存
+func removeProxyCache(imageUUID string) {
+	if imageProxyCache != nil {
+		imageProxyCache.remove(imageUUID)
+	}
+}
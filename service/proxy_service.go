@@ -0,0 +1,97 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"yanshu-imgbed/database"
+)
+
+// proxyClient 代理读取后端文件使用的客户端；只限制建立连接和等待响应头的时间，大文件的传输时长不受限制
+var proxyClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// ProxiedImage 代理模式下由服务器从后端 (或本地缓存) 读取的图片内容
+// 命中缓存时 Body 是本地文件 (实现了 io.ReadSeeker)，可以直接按 Range 输出
+type ProxiedImage struct {
+	Body          io.ReadCloser
+	ContentType   string
+	ContentLength int64 // 未知时为 -1
+	CacheHit      bool  // 是否来自本地磁盘缓存
+	// StatusCode 后端返回的状态码：200，或请求了 Range 时的 206/416
+	StatusCode int
+	// ContentRange 后端返回部分内容时的 Content-Range
+	ContentRange string
+}
+
+// ProxyRequest 代理请求的参数，零值表示读取完整内容
+type ProxyRequest struct {
+	// Range 客户端请求的 Range 头，缓存未命中时转发给后端
+	Range string
+	// HeadOnly 只需要响应头 (HEAD 请求)，缓存未命中时读到后端的响应头就断开，不下载内容
+	// 不向后端发送 HEAD：预签名地址的签名通常只对 GET 有效
+	HeadOnly bool
+}
+
+// OpenProxiedImage 读取图片内容，供不能直接跳转的后端 (私有存储桶等) 由服务器中转
+// 启用了代理缓存时优先读取本地缓存，未命中时从远程存储位置下载并同时写入缓存；只请求部分内容时不写入缓存
+func OpenProxiedImage(loc *database.StorageLocation, meta *ServeMeta, req ProxyRequest) (*ProxiedImage, error) {
+	contentType := meta.ContentType
+	if body, size, ok := openCachedProxyImage(meta.UUID); ok {
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		return &ProxiedImage{Body: body, ContentType: contentType, ContentLength: size, CacheHit: true, StatusCode: http.StatusOK}, nil
+	}
+
+	upstreamReq, err := http.NewRequest(http.MethodGet, loc.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if req.Range != "" {
+		upstreamReq.Header.Set("Range", req.Range)
+	}
+	resp, err := proxyClient.Do(upstreamReq)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		if req.Range == "" {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status %d from backend %d", resp.StatusCode, loc.BackendID)
+		}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d from backend %d", resp.StatusCode, loc.BackendID)
+	}
+	if contentType == "" {
+		contentType = resp.Header.Get("Content-Type")
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	var body io.ReadCloser
+	switch {
+	case req.HeadOnly:
+		resp.Body.Close()
+		body = http.NoBody
+	case resp.StatusCode == http.StatusOK:
+		body = cacheProxiedBody(meta.UUID, resp.Body, resp.ContentLength)
+	default:
+		body = resp.Body
+	}
+	return &ProxiedImage{
+		Body:          body,
+		ContentType:   contentType,
+		ContentLength: resp.ContentLength,
+		StatusCode:    resp.StatusCode,
+		ContentRange:  resp.Header.Get("Content-Range"),
+	}, nil
+}
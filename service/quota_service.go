@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"yanshu-imgbed/database"
+)
+
+// StorageUsage 用户已用的存储空间和配额，Quota 为 0 表示不限制
+type StorageUsage struct {
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota"`
+}
+
+// GetStorageUsage 统计用户所有图片占用的空间
+// 共享同一物理文件的图片分别计入各自用户
+func GetStorageUsage(userID uint) (*StorageUsage, error) {
+	var user database.User
+	if err := database.DB.Select("id", "storage_quota_mb").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	usage := &StorageUsage{Quota: user.StorageQuotaMB * 1024 * 1024}
+	if err := database.DB.Model(&database.Image{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(file_size), 0)").Scan(&usage.Used).Error; err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// checkStorageQuota 检查再存入 size 字节是否会超出用户的存储配额
+func checkStorageQuota(userID uint, size int64) error {
+	usage, err := GetStorageUsage(userID)
+	if err != nil {
+		return fmt.Errorf("failed to check storage quota: %w", err)
+	}
+	if usage.Quota > 0 && usage.Used+size > usage.Quota {
+		rejected := &UploadRejectedError{Reason: fmt.Sprintf("Storage quota exceeded (%d of %d MB used)", usage.Used/1024/1024, usage.Quota/1024/1024)}
+		emitQuotaWebhook("storage", userID, rejected.Reason)
+		return rejected
+	}
+	return nil
+}
+
+// SetUserStorageQuota 设置用户的存储配额 (MB)，0 表示不限制 (管理员权限)
+func SetUserStorageQuota(userID uint, quotaMB int64) (*database.User, error) {
+	if quotaMB < 0 {
+		return nil, errors.New("quota must not be negative")
+	}
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	user.StorageQuotaMB = quotaMB
+	if err := database.DB.Model(&user).Update("storage_quota_mb", quotaMB).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"yanshu-imgbed/database"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrRegistrationClosed 管理员未开放自助注册
+	ErrRegistrationClosed = errors.New("注册未开放")
+	// ErrInviteRequired 开启了邀请制但请求未携带邀请码
+	ErrInviteRequired = errors.New("注册需要邀请码")
+	// ErrInviteInvalid 邀请码不存在、已撤销、已过期或次数已用完
+	ErrInviteInvalid = errors.New("邀请码无效或已失效")
+	// ErrUsernameTaken 用户名已被占用
+	ErrUsernameTaken = errors.New("用户名已存在")
+	// ErrInviteNotFound 要撤销的邀请码不存在
+	ErrInviteNotFound = errors.New("邀请码不存在")
+)
+
+// maxInviteBatch 单次最多生成的邀请码数量
+const maxInviteBatch = 100
+
+// minPasswordLength 自助注册时密码的最小长度
+const minPasswordLength = 8
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,50}$`)
+
+// RegistrationStatus 登录页用来决定是否显示注册表单
+type RegistrationStatus struct {
+	Enabled        bool `json:"enabled"`
+	InviteRequired bool `json:"invite_required"`
+}
+
+// InviteCodeInfo 返回给管理员的邀请码信息
+type InviteCodeInfo struct {
+	ID        uint       `json:"id"`
+	Code      string     `json:"code"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Revoked   bool       `json:"revoked"`
+	Note      string     `json:"note"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func inviteCodeInfo(invite database.InviteCode) InviteCodeInfo {
+	return InviteCodeInfo{
+		ID:        invite.ID,
+		Code:      invite.Code,
+		MaxUses:   invite.MaxUses,
+		Uses:      invite.Uses,
+		ExpiresAt: invite.ExpiresAt,
+		Revoked:   invite.Revoked,
+		Note:      invite.Note,
+		CreatedAt: invite.CreatedAt,
+	}
+}
+
+// GetRegistrationStatus 返回公开的注册状态，不暴露默认角色和配额
+func GetRegistrationStatus() RegistrationStatus {
+	reg := GetRegistrationSettings()
+	return RegistrationStatus{Enabled: reg.Enabled, InviteRequired: reg.Enabled && reg.InviteRequired}
+}
+
+// SelfRegister 访客自助注册。需要邀请码时，邀请码的消耗和用户的创建在同一事务内完成，
+// 用户名冲突等失败不会白白占用一次邀请码
+func SelfRegister(username, password, inviteCode string) (*database.User, error) {
+	reg := GetRegistrationSettings()
+	if !reg.Enabled {
+		return nil, ErrRegistrationClosed
+	}
+	username = strings.TrimSpace(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, &UploadRejectedError{Reason: "用户名只能包含字母、数字、'_'、'.'、'-'，长度 3-50"}
+	}
+	if len(password) < minPasswordLength {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("密码长度不能少于 %d 位", minPasswordLength)}
+	}
+	inviteCode = strings.TrimSpace(inviteCode)
+	if reg.InviteRequired && inviteCode == "" {
+		return nil, ErrInviteRequired
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	role := reg.DefaultRole
+	// 默认角色被删除或被授予了管理权限时退回普通用户，避免访客注册出管理员
+	if !RoleExists(role) || HasPermission(role, PermManageSystem) {
+		role = UserRoleName
+	}
+	user := database.User{
+		Username:       username,
+		Password:       string(hashedPassword),
+		Role:           role,
+		StorageQuotaMB: reg.DefaultQuotaMB,
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&database.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUsernameTaken
+		}
+		// 未开启邀请制时邀请码可以不填，填了就必须有效
+		if inviteCode != "" {
+			if err := consumeInviteCode(tx, inviteCode); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("注册用户失败: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// consumeInviteCode 用条件更新原子地占用一次邀请码，并发注册不会超出 MaxUses
+func consumeInviteCode(tx *gorm.DB, code string) error {
+	result := tx.Model(&database.InviteCode{}).
+		Where("code = ? AND revoked = ?", code, false).
+		Where("max_uses = 0 OR uses < max_uses").
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInviteInvalid
+	}
+	return nil
+}
+
+// CreateInviteCodes 批量生成邀请码 (管理员)，expiresIn 为空表示永不过期
+func CreateInviteCodes(adminID uint, count, maxUses int, expiresIn, note string) ([]InviteCodeInfo, error) {
+	if count <= 0 {
+		count = 1
+	}
+	if count > maxInviteBatch {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("一次最多生成 %d 个邀请码", maxInviteBatch)}
+	}
+	if maxUses < 0 {
+		return nil, &UploadRejectedError{Reason: "max_uses 不能为负数"}
+	}
+	expiresAt, err := ParseExpiresIn(expiresIn)
+	if err != nil {
+		return nil, &UploadRejectedError{Reason: err.Error()}
+	}
+
+	invites := make([]database.InviteCode, 0, count)
+	for i := 0; i < count; i++ {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		invites = append(invites, database.InviteCode{
+			Code:      hex.EncodeToString(buf),
+			CreatedBy: adminID,
+			MaxUses:   maxUses,
+			ExpiresAt: expiresAt,
+			Note:      strings.TrimSpace(note),
+		})
+	}
+	if err := database.DB.Create(&invites).Error; err != nil {
+		return nil, err
+	}
+	infos := make([]InviteCodeInfo, 0, len(invites))
+	for _, invite := range invites {
+		infos = append(infos, inviteCodeInfo(invite))
+	}
+	return infos, nil
+}
+
+// ListInviteCodes 列出所有邀请码，最新的在前
+func ListInviteCodes() ([]InviteCodeInfo, error) {
+	var invites []database.InviteCode
+	if err := database.DB.Order("id DESC").Find(&invites).Error; err != nil {
+		return nil, err
+	}
+	infos := make([]InviteCodeInfo, 0, len(invites))
+	for _, invite := range invites {
+		infos = append(infos, inviteCodeInfo(invite))
+	}
+	return infos, nil
+}
+
+// RevokeInviteCode 撤销邀请码，已注册的用户不受影响
+func RevokeInviteCode(id uint) error {
+	result := database.DB.Model(&database.InviteCode{}).Where("id = ?", id).Update("revoked", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
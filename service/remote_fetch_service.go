@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+	"yanshu-imgbed/util"
+)
+
+// remoteFetchTimeout 拉取远程图片的总超时时间
+const remoteFetchTimeout = 30 * time.Second
+
+// errBlockedAddress 目标地址属于内网或保留地址
+var errBlockedAddress = errors.New("destination address is not allowed")
+
+// remoteFetchClient 拉取远程图片专用的 HTTP 客户端
+// 在建立连接时检查实际连接的 IP，可以防止通过 DNS 重绑定或跳转访问内网地址
+var remoteFetchClient = &http.Client{
+	Timeout: remoteFetchTimeout,
+	Transport: &http.Transport{
+		Proxy: nil, // 不使用环境变量中的代理，否则连接检查只会看到代理地址
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || isBlockedIP(ip) {
+					return errBlockedAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.New("redirect to unsupported scheme")
+		}
+		return nil
+	},
+}
+
+// isBlockedIP 判断 IP 是否为回环、内网、链路本地等不允许访问的地址
+func isBlockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || isCGNAT(ip)
+}
+
+// isCGNAT 判断是否为运营商级 NAT 地址 (100.64.0.0/10)
+func isCGNAT(ip net.IP) bool {
+	ip4 := ip.To4()
+	return ip4 != nil && ip4[0] == 100 && ip4[1]&0xC0 == 64
+}
+
+// FetchRemoteImage 从远程地址下载图片，返回可以交给 UploadImage 的 FileHeader
+// 大小按最大上传限制截断，类型校验由上传流程完成
+func FetchRemoteImage(rawURL string) (*multipart.FileHeader, error) {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, &UploadRejectedError{Reason: "A valid http or https URL is required"}
+	}
+
+	resp, err := remoteFetchClient.Get(parsed.String())
+	if err != nil {
+		if errors.Is(err, errBlockedAddress) {
+			return nil, &UploadRejectedError{Reason: "The URL points to a private or reserved address"}
+		}
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("Failed to fetch remote image: %v", err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("Remote server returned status %d", resp.StatusCode)}
+	}
+
+	maxUploadMB := GetMaxUploadMB()
+	maxBytes := int64(maxUploadMB) * 1024 * 1024
+	if resp.ContentLength > maxBytes {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("File size exceeds the limit of %dMB", maxUploadMB)}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("Failed to read remote image: %v", err)}
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("File size exceeds the limit of %dMB", maxUploadMB)}
+	}
+
+	fileType := util.DetectFileType(data)
+	if fileType == nil {
+		return nil, &UploadRejectedError{Reason: "The URL does not point to a supported image"}
+	}
+	return util.NewFileHeader(remoteFilename(resp.Request.URL, fileType.Ext), fileType.MIME, data)
+}
+
+// remoteFilename 从最终的 URL 推断文件名，没有合适的扩展名时补上识别出的扩展名
+func remoteFilename(u *url.URL, ext string) string {
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || name == "" {
+		name = "remote-image"
+	}
+	if !strings.EqualFold(strings.TrimPrefix(path.Ext(name), "."), ext) {
+		name += "." + ext
+	}
+	return name
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+	"yanshu-imgbed/storage"
+)
+
+const (
+	// replicaCheckInterval 副本数检查的间隔
+	replicaCheckInterval = 10 * time.Minute
+	// replicaCheckBatch 每轮最多处理的副本不足图片数，其余留到下一轮
+	replicaCheckBatch = 500
+	// maxReplicaReportUUIDs 报告中列出的无法补齐图片的数量上限
+	maxReplicaReportUUIDs = 100
+)
+
+// ReplicaReport 最近一次副本数检查的结果
+type ReplicaReport struct {
+	RunAt       time.Time `json:"run_at"`
+	MinReplicas int       `json:"min_replicas"`
+	// UnderReplicated 有效存储位置少于 MinReplicas 的图片数 (本轮检查范围内)
+	UnderReplicated int `json:"under_replicated"`
+	// Queued 本轮新加入补传队列的任务数
+	Queued int `json:"queued"`
+	// AlreadyQueued 已有补传任务在排队、本轮跳过的图片数
+	AlreadyQueued int `json:"already_queued"`
+	// NoSource 没有任何有效存储位置、无法补传的图片
+	NoSource []string `json:"no_source"`
+	// InsufficientBackends 可用后端不够、补传后仍达不到副本数的图片数
+	InsufficientBackends int `json:"insufficient_backends"`
+}
+
+var (
+	lastReplicaReport   *ReplicaReport
+	lastReplicaReportMu sync.RWMutex
+	// replicaCheckMu 防止定时任务和手动触发的检查同时运行
+	replicaCheckMu sync.Mutex
+)
+
+// InitReplicaReconciler 启动后台任务，定期把副本不足的图片加入补传队列
+func InitReplicaReconciler(storageManager *manager.StorageManager) {
+	go func() {
+		ticker := time.NewTicker(replicaCheckInterval)
+		for range ticker.C {
+			if GetMinReplicas() > 0 {
+				ReconcileReplicas(storageManager)
+			}
+		}
+	}()
+}
+
+// GetReplicaReport 返回最近一次检查的结果，从未运行过时返回 nil
+func GetReplicaReport() *ReplicaReport {
+	lastReplicaReportMu.RLock()
+	defer lastReplicaReportMu.RUnlock()
+	return lastReplicaReport
+}
+
+// ReconcileReplicas 找出有效存储位置少于 min_replicas 的图片，按后端优先级把缺少的副本加入补传队列
+func ReconcileReplicas(storageManager *manager.StorageManager) *ReplicaReport {
+	replicaCheckMu.Lock()
+	defer replicaCheckMu.Unlock()
+
+	minReplicas := GetMinReplicas()
+	report := &ReplicaReport{RunAt: time.Now(), MinReplicas: minReplicas, NoSource: []string{}}
+	defer func() {
+		lastReplicaReportMu.Lock()
+		lastReplicaReport = report
+		lastReplicaReportMu.Unlock()
+	}()
+	if minReplicas <= 0 {
+		return report
+	}
+
+	var rows []struct {
+		ImageID uint
+		UUID    string
+		Active  int
+	}
+	err := database.DB.Model(&database.Image{}).
+		Select("images.id AS image_id, images.uuid AS uuid, COUNT(storage_locations.id) AS active").
+		Joins("LEFT JOIN storage_locations ON storage_locations.image_id = images.id AND storage_locations.is_active = ?", true).
+		Group("images.id, images.uuid").
+		Having("COUNT(storage_locations.id) < ?", minReplicas).
+		Limit(replicaCheckBatch).
+		Scan(&rows).Error
+	if err != nil {
+		log.Printf("Failed to find under-replicated images: %v", err)
+		return report
+	}
+	report.UnderReplicated = len(rows)
+
+	eligible := eligibleReplicaBackends(storageManager)
+	for _, row := range rows {
+		if row.Active == 0 {
+			if len(report.NoSource) < maxReplicaReportUUIDs {
+				report.NoSource = append(report.NoSource, row.UUID)
+			}
+			continue
+		}
+
+		var queued int64
+		database.DB.Model(&database.PendingDistribution{}).Where("image_id = ?", row.ImageID).Count(&queued)
+		if queued > 0 {
+			report.AlreadyQueued++
+			continue
+		}
+
+		// 已有存储位置 (包括失效的) 的后端不再补传，避免同一后端出现重复记录
+		var used []uint
+		database.DB.Model(&database.StorageLocation{}).Where("image_id = ?", row.ImageID).Pluck("backend_id", &used)
+		usedSet := make(map[uint]bool, len(used))
+		for _, id := range used {
+			usedSet[id] = true
+		}
+
+		missing := minReplicas - row.Active
+		var targets []database.Backend
+		for _, b := range eligible {
+			if len(targets) == missing {
+				break
+			}
+			if !usedSet[b.ID] {
+				targets = append(targets, b)
+			}
+		}
+		if len(targets) < missing {
+			report.InsufficientBackends++
+		}
+		enqueueDistribution(row.ImageID, targets)
+		report.Queued += len(targets)
+	}
+
+	log.Printf("Replica check: %d under-replicated image(s), %d backfill job(s) queued, %d without any active copy.",
+		report.UnderReplicated, report.Queued, len(report.NoSource))
+	return report
+}
+
+// eligibleReplicaBackends 返回可以接收补传副本的后端，按优先级排序
+func eligibleReplicaBackends(storageManager *manager.StorageManager) []database.Backend {
+	var backends []database.Backend
+	database.DB.Where("allow_upload = ?", true).Order("priority asc, id asc").Find(&backends)
+
+	eligible := make([]database.Backend, 0, len(backends))
+	for _, b := range backends {
+		uploader, found := storageManager.Get(b.ID)
+		if !found || uploader.Type() == storage.DryRunType {
+			continue
+		}
+		eligible = append(eligible, b)
+	}
+	return eligible
+}
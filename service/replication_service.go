@@ -0,0 +1,367 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+	"yanshu-imgbed/util"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// replicationBatchSize 镜像每次向主实例拉取的图片数量
+const replicationBatchSize = 100
+
+// replicationCursorKey 镜像记录同步进度的设置项
+const replicationCursorKey = "replication_cursor"
+
+// ReplicationImage 同步接口中传输的图片元数据
+type ReplicationImage struct {
+	ID                  uint           `json:"id"` // 主实例上的 ID，仅用于同步游标
+	UUID                string         `json:"uuid"`
+	MD5                 string         `json:"md5"`
+	SHA256              string         `json:"sha256"`
+	StorageKey          string         `json:"storage_key"`
+	OriginalFilename    string         `json:"original_filename"`
+	FileSize            int64          `json:"file_size"`
+	OriginalSize        int64          `json:"original_size"`
+	ContentType         string         `json:"content_type"`
+	OriginalContentType string         `json:"original_content_type"`
+	Width               int            `json:"width"`
+	Height              int            `json:"height"`
+	Username            string         `json:"username"`
+	AllowRandom         bool           `json:"allow_random"`
+	Annotations         datatypes.JSON `json:"annotations"`
+	ModerationStatus    string         `json:"moderation_status"`
+	ModerationScore     float64        `json:"moderation_score"`
+	Folder              string         `json:"folder"`
+	ExpiresAt           *time.Time     `json:"expires_at,omitempty"`
+	Visibility          string         `json:"visibility"`
+	Description         string         `json:"description"`
+	AltText             string         `json:"alt_text"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+}
+
+// ListReplicationChanges 返回游标 (since, sinceID) 之后新增或修改的图片元数据，按 (updated_at, id) 升序
+// 游标包含 ID，修改时间相同的多条记录跨批次时也不会被漏掉
+func ListReplicationChanges(since time.Time, sinceID uint, limit int) ([]ReplicationImage, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = replicationBatchSize
+	}
+	var images []database.Image
+	err := database.DB.Where("updated_at > ? OR (updated_at = ? AND id > ?)", since, since, sinceID).
+		Order("updated_at asc, id asc").Limit(limit).Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]uint, 0, len(images))
+	for _, image := range images {
+		userIDs = append(userIDs, image.UserID)
+	}
+	var users []database.User
+	database.DB.Select("id", "username").Where("id IN ?", userIDs).Find(&users)
+	usernames := make(map[uint]string, len(users))
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+	}
+
+	changes := make([]ReplicationImage, 0, len(images))
+	for _, image := range images {
+		changes = append(changes, ReplicationImage{
+			ID:                  image.ID,
+			UUID:                image.UUID,
+			MD5:                 image.MD5,
+			SHA256:              image.SHA256,
+			StorageKey:          image.StorageKey,
+			OriginalFilename:    image.OriginalFilename,
+			FileSize:            image.FileSize,
+			OriginalSize:        image.OriginalSize,
+			ContentType:         image.ContentType,
+			OriginalContentType: image.OriginalContentType,
+			Width:               image.Width,
+			Height:              image.Height,
+			Username:            usernames[image.UserID],
+			AllowRandom:         image.AllowRandom,
+			Annotations:         image.Annotations,
+			ModerationStatus:    image.ModerationStatus,
+			ModerationScore:     image.ModerationScore,
+			Folder:              image.Folder,
+			ExpiresAt:           image.ExpiresAt,
+			Visibility:          image.Visibility,
+			Description:         image.Description,
+			AltText:             image.AltText,
+			CreatedAt:           image.CreatedAt,
+			UpdatedAt:           image.UpdatedAt,
+		})
+	}
+	return changes, nil
+}
+
+// OpenReplicationFile 打开图片文件供镜像下载
+func OpenReplicationFile(imageUUID string) (io.ReadCloser, *database.Image, error) {
+	var image database.Image
+	if err := database.DB.Preload("StorageLocations").Where("uuid = ?", imageUUID).First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrImageNotFound
+		}
+		return nil, nil, err
+	}
+	rc, err := OpenImageContent(&image)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rc, &image, nil
+}
+
+// InitReplication 在镜像模式下启动定期从主实例拉取的后台任务
+func InitReplication(storageManager *manager.StorageManager) {
+	cfg := config.Cfg.Replication
+	if cfg.Mode != "mirror" {
+		return
+	}
+	if cfg.PrimaryURL == "" || cfg.Token == "" {
+		log.Println("Replication mode is 'mirror' but primary_url or token is missing. Replication disabled.")
+		return
+	}
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	log.Printf("Mirror replication enabled, pulling from %s every %s.", cfg.PrimaryURL, interval)
+	go func() {
+		for {
+			if err := syncFromPrimary(storageManager); err != nil {
+				log.Printf("Replication sync failed: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// replicationCursor 同步进度，(UpdatedAt, ID) 组成的键集游标
+type replicationCursor struct {
+	UpdatedAt time.Time
+	ID        uint
+}
+
+// loadReplicationCursor 读取保存的游标，格式为 "<RFC3339Nano>|<id>"
+// 兼容旧版本只保存时间的格式
+func loadReplicationCursor() replicationCursor {
+	var cursor replicationCursor
+	var setting database.Setting
+	if err := database.DB.Where(clause.Eq{Column: "key", Value: replicationCursorKey}).First(&setting).Error; err != nil {
+		return cursor
+	}
+	value, idPart, _ := strings.Cut(setting.Value, "|")
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		cursor.UpdatedAt = t
+	}
+	if id, err := strconv.ParseUint(idPart, 10, 64); err == nil {
+		cursor.ID = uint(id)
+	}
+	return cursor
+}
+
+func saveReplicationCursor(cursor replicationCursor) error {
+	return SaveSetting(replicationCursorKey, fmt.Sprintf("%s|%d", cursor.UpdatedAt.Format(time.RFC3339Nano), cursor.ID))
+}
+
+// syncFromPrimary 从主实例拉取自上次同步以来的所有变更
+func syncFromPrimary(storageManager *manager.StorageManager) error {
+	cursor := loadReplicationCursor()
+
+	for {
+		changes, err := fetchReplicationChanges(cursor)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if err := applyReplicationChange(change, storageManager); err != nil {
+				// 停在失败的记录上，下次从这里继续重试
+				return fmt.Errorf("failed to replicate image %s: %w", change.UUID, err)
+			}
+			cursor = replicationCursor{UpdatedAt: change.UpdatedAt, ID: change.ID}
+			if err := saveReplicationCursor(cursor); err != nil {
+				return err
+			}
+		}
+		if len(changes) < replicationBatchSize {
+			return nil
+		}
+	}
+}
+
+// replicationRequest 向主实例发送带同步密钥的 GET 请求
+func replicationRequest(path string, query url.Values) (*http.Response, error) {
+	cfg := config.Cfg.Replication
+	endpoint := strings.TrimRight(cfg.PrimaryURL, "/") + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Replication-Token", cfg.Token)
+
+	client := &http.Client{Timeout: 120 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("primary returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+func fetchReplicationChanges(cursor replicationCursor) ([]ReplicationImage, error) {
+	query := url.Values{}
+	query.Set("since", cursor.UpdatedAt.Format(time.RFC3339Nano))
+	query.Set("since_id", strconv.FormatUint(uint64(cursor.ID), 10))
+	query.Set("limit", fmt.Sprintf("%d", replicationBatchSize))
+	resp, err := replicationRequest("/api/replication/images", query)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var changes []ReplicationImage
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return nil, fmt.Errorf("failed to decode replication changes: %w", err)
+	}
+	return changes, nil
+}
+
+// applyReplicationChange 在本地创建或更新一张来自主实例的图片
+func applyReplicationChange(change ReplicationImage, storageManager *manager.StorageManager) error {
+	var existing database.Image
+	err := database.DB.Where("uuid = ?", change.UUID).First(&existing).Error
+	if err == nil {
+		// 审核状态一并同步，否则主实例隔离的图片会在镜像上继续公开提供
+		wasRandom := existing.AllowRandom
+		err := database.DB.Model(&existing).Updates(map[string]interface{}{
+			"allow_random":      change.AllowRandom,
+			"annotations":       change.Annotations,
+			"moderation_status": change.ModerationStatus,
+			"moderation_score":  change.ModerationScore,
+			"folder":            change.Folder,
+			"visibility":        change.Visibility,
+			"original_filename": change.OriginalFilename,
+			"description":       change.Description,
+			"alt_text":          change.AltText,
+		}).Error
+		if err == nil && (wasRandom || change.AllowRandom) {
+			go UpdateRandomImageCache()
+		}
+		return err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	userID, err := resolveReplicationUser(change.Username)
+	if err != nil {
+		return err
+	}
+
+	resp, err := replicationRequest("/api/replication/images/"+url.PathEscape(change.UUID)+"/file", nil)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	file, err := util.NewFileHeader(change.OriginalFilename, change.ContentType, data)
+	if err != nil {
+		return err
+	}
+	// 按实际收到的内容计算哈希，旧版本的主实例不会下发 SHA-256
+	digest, err := util.DigestFile(file)
+	if err != nil {
+		return err
+	}
+
+	var backends []database.Backend
+	if err := database.DB.Where("allow_upload = ?", true).Find(&backends).Error; err != nil {
+		return err
+	}
+	if len(backends) == 0 {
+		return errors.New("no active storage backends on mirror")
+	}
+
+	image := &database.Image{
+		UUID:                change.UUID,
+		MD5:                 digest.MD5,
+		SHA256:              digest.SHA256,
+		StorageKey:          change.StorageKey,
+		OriginalFilename:    change.OriginalFilename,
+		FileSize:            change.FileSize,
+		OriginalSize:        change.OriginalSize,
+		ContentType:         change.ContentType,
+		OriginalContentType: change.OriginalContentType,
+		Width:               change.Width,
+		Height:              change.Height,
+		UserID:              userID,
+		AllowRandom:         change.AllowRandom,
+		Annotations:         change.Annotations,
+		ModerationStatus:    change.ModerationStatus,
+		ModerationScore:     change.ModerationScore,
+		Folder:              change.Folder,
+		ExpiresAt:           change.ExpiresAt,
+		Visibility:          change.Visibility,
+		Description:         change.Description,
+		AltText:             change.AltText,
+	}
+	image.CreatedAt = change.CreatedAt
+	if err := database.DB.Create(image).Error; err != nil {
+		// 返回错误让游标停在这里，避免跳过一张从未复制成功的图片
+		return fmt.Errorf("failed to create image record: %w", err)
+	}
+
+	distributeToBackends(file, storageKeyFor(image), image.ID, backends, OperationUpload, storageManager)
+
+	var count int64
+	database.DB.Model(&database.StorageLocation{}).Where("image_id = ?", image.ID).Count(&count)
+	if count == 0 {
+		database.DB.Delete(image)
+		return errors.New("upload failed on all mirror backends")
+	}
+	if image.AllowRandom {
+		go UpdateRandomImageCache()
+	}
+	return nil
+}
+
+// resolveReplicationUser 按用户名映射到本地用户，找不到时归属到第一个管理员
+func resolveReplicationUser(username string) (uint, error) {
+	var user database.User
+	if username != "" {
+		if err := database.DB.Where("username = ?", username).First(&user).Error; err == nil {
+			return user.ID, nil
+		}
+	}
+	if err := database.DB.Where("role = ?", "admin").Order("id asc").First(&user).Error; err != nil {
+		return 0, errors.New("no local admin user to own replicated images")
+	}
+	return user.ID, nil
+}
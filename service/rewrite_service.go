@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+
+	"github.com/google/uuid"
+)
+
+// compiledRewriteRule 已编译的重写规则
+type compiledRewriteRule struct {
+	rule database.RewriteRule
+	re   *regexp.Regexp
+}
+
+var (
+	rewriteRules   []compiledRewriteRule
+	rewriteRulesMu sync.RWMutex
+)
+
+// InitRewriteRules 在程序启动时加载重写规则
+func InitRewriteRules() {
+	if err := ReloadRewriteRules(); err != nil {
+		log.Printf("Failed to load rewrite rules: %v", err)
+	}
+}
+
+// ReloadRewriteRules 从数据库重新加载并编译所有启用的重写规则
+func ReloadRewriteRules() error {
+	var rules []database.RewriteRule
+	if err := database.DB.Where("is_active = ?", true).Order("priority asc, id asc").Find(&rules).Error; err != nil {
+		return err
+	}
+
+	compiled := make([]compiledRewriteRule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			log.Printf("Invalid rewrite rule pattern (ID: %d): %v. Skipping.", rule.ID, err)
+			continue
+		}
+		compiled = append(compiled, compiledRewriteRule{rule: rule, re: re})
+	}
+
+	rewriteRulesMu.Lock()
+	rewriteRules = compiled
+	rewriteRulesMu.Unlock()
+	log.Printf("Rewrite rules reloaded. Loaded %d rule(s).", len(compiled))
+	return nil
+}
+
+// ResolveRewrite 按优先级匹配请求路径，返回应跳转到的地址
+// 展开后的目标如果是 UUID，则跳转到对应的图片链接
+func ResolveRewrite(requestPath string) (string, bool) {
+	rewriteRulesMu.RLock()
+	defer rewriteRulesMu.RUnlock()
+
+	for _, r := range rewriteRules {
+		match := r.re.FindStringSubmatchIndex(requestPath)
+		if match == nil {
+			continue
+		}
+		target := string(r.re.ExpandString(nil, r.rule.Target, requestPath, match))
+		if _, err := uuid.Parse(target); err == nil {
+			return ImageViewPath(target, ""), true
+		}
+		// 站内路径加上 server.base_path，完整 URL 原样返回
+		if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+			target = config.WithBasePath(target)
+		}
+		return target, true
+	}
+	return "", false
+}
+
+// ValidateRewriteRule 检查规则的正则表达式和目标是否有效
+func ValidateRewriteRule(rule *database.RewriteRule) error {
+	if rule.Pattern == "" || rule.Target == "" {
+		return errors.New("pattern and target are required")
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	return nil
+}
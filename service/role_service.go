@@ -0,0 +1,309 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+// 权限名称
+const (
+	// PermManageSystem 访问 /api/admin 下的管理接口
+	PermManageSystem = "system.manage"
+	// PermAllImages 查看和操作所有用户的图片，而不只是自己的
+	PermAllImages = "images.all"
+	// PermUpload 上传图片
+	PermUpload = "images.upload"
+	// PermBatchDelete 批量删除图片
+	PermBatchDelete = "images.batch_delete"
+	// PermManageTokens 创建和管理自己的 API Token
+	PermManageTokens = "tokens.manage"
+	// PermRandomPool 把图片加入或移出随机图库
+	PermRandomPool = "random.manage"
+)
+
+// AllPermissions 所有可分配的权限
+var AllPermissions = []string{PermManageSystem, PermAllImages, PermUpload, PermBatchDelete, PermManageTokens, PermRandomPool}
+
+// 内置角色，不能删除；admin 的权限固定为全部权限
+const (
+	AdminRoleName = "admin"
+	UserRoleName  = "user"
+)
+
+var (
+	// ErrRoleNotFound 角色不存在
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrRoleInUse 仍有用户属于该角色
+	ErrRoleInUse = errors.New("role is still assigned to users")
+	// ErrBuiltInRole 内置角色不能删除或改名
+	ErrBuiltInRole = errors.New("built-in roles cannot be deleted or renamed")
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,20}$`)
+
+// roleInfo 内存中缓存的角色权限
+type roleInfo struct {
+	permissions map[string]bool
+	backendIDs  []uint
+}
+
+var (
+	roles   map[string]roleInfo
+	rolesMu sync.RWMutex
+)
+
+// RoleInput 创建或修改角色的参数
+type RoleInput struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	BackendIDs  []uint   `json:"backend_ids"`
+}
+
+// InitRoles 在程序启动时创建缺失的内置角色并加载角色缓存
+func InitRoles() {
+	builtIns := []database.Role{
+		{Name: AdminRoleName, Description: "管理员，拥有全部权限", BuiltIn: true},
+		{Name: UserRoleName, Description: "普通用户", BuiltIn: true},
+	}
+	builtIns[0].Permissions, _ = json.Marshal(AllPermissions)
+	builtIns[1].Permissions, _ = json.Marshal([]string{PermUpload, PermBatchDelete, PermManageTokens, PermRandomPool})
+	for _, role := range builtIns {
+		if err := database.DB.Where("name = ?", role.Name).FirstOrCreate(&role).Error; err != nil {
+			log.Printf("Failed to create built-in role %q: %v", role.Name, err)
+		}
+	}
+	if err := ReloadRoles(); err != nil {
+		log.Printf("Failed to load roles: %v", err)
+	}
+}
+
+// ReloadRoles 从数据库重新加载所有角色的权限
+func ReloadRoles() error {
+	var list []database.Role
+	if err := database.DB.Find(&list).Error; err != nil {
+		return err
+	}
+	loaded := make(map[string]roleInfo, len(list))
+	for _, role := range list {
+		info := roleInfo{permissions: make(map[string]bool)}
+		var perms []string
+		if len(role.Permissions) > 0 {
+			if err := json.Unmarshal(role.Permissions, &perms); err != nil {
+				log.Printf("Ignoring unreadable permissions of role %q: %v", role.Name, err)
+			}
+		}
+		for _, perm := range perms {
+			info.permissions[perm] = true
+		}
+		if len(role.BackendIDs) > 0 {
+			if err := json.Unmarshal(role.BackendIDs, &info.backendIDs); err != nil {
+				log.Printf("Ignoring unreadable backend list of role %q: %v", role.Name, err)
+			}
+		}
+		loaded[role.Name] = info
+	}
+
+	rolesMu.Lock()
+	roles = loaded
+	rolesMu.Unlock()
+	return nil
+}
+
+// lookupRole 查找角色，未知的角色名 (例如引入角色表之前手工设置的) 按 user 角色处理
+func lookupRole(name string) (roleInfo, bool) {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	if info, ok := roles[name]; ok {
+		return info, true
+	}
+	info, ok := roles[UserRoleName]
+	return info, ok
+}
+
+// HasPermission 判断角色是否拥有指定权限。admin 始终拥有全部权限，
+// 这样后台任务以 "admin" 身份调用时不依赖角色缓存
+func HasPermission(roleName, perm string) bool {
+	if roleName == AdminRoleName {
+		return true
+	}
+	info, ok := lookupRole(roleName)
+	return ok && info.permissions[perm]
+}
+
+// CanAccessAllImages 判断角色能否查看和操作所有用户的图片
+func CanAccessAllImages(roleName string) bool {
+	return HasPermission(roleName, PermAllImages)
+}
+
+// RoleExists 判断角色是否已定义
+func RoleExists(name string) bool {
+	rolesMu.RLock()
+	defer rolesMu.RUnlock()
+	_, ok := roles[name]
+	return ok
+}
+
+// RestrictUploadBackends 按角色允许的后端限制上传目标：未指定目标时使用允许的全部后端，
+// 指定了不允许的后端时拒绝上传
+func RestrictUploadBackends(roleName string, targetBackendIDs []uint) ([]uint, error) {
+	if roleName == AdminRoleName {
+		return targetBackendIDs, nil
+	}
+	info, _ := lookupRole(roleName)
+	if len(info.backendIDs) == 0 {
+		return targetBackendIDs, nil
+	}
+	if len(targetBackendIDs) == 0 {
+		return append([]uint(nil), info.backendIDs...), nil
+	}
+	allowed := make(map[uint]bool, len(info.backendIDs))
+	for _, id := range info.backendIDs {
+		allowed[id] = true
+	}
+	for _, id := range targetBackendIDs {
+		if !allowed[id] {
+			return nil, &UploadRejectedError{Reason: fmt.Sprintf("Your role is not allowed to upload to backend %d", id)}
+		}
+	}
+	return targetBackendIDs, nil
+}
+
+// validate 校验角色参数并去重权限
+func (in *RoleInput) validate() error {
+	in.Name = strings.ToLower(strings.TrimSpace(in.Name))
+	if !roleNamePattern.MatchString(in.Name) {
+		return &UploadRejectedError{Reason: "Role name must be 1-20 characters of lowercase letters, digits, '-' or '_'"}
+	}
+	seen := make(map[string]bool, len(in.Permissions))
+	perms := make([]string, 0, len(in.Permissions))
+	for _, perm := range in.Permissions {
+		known := false
+		for _, p := range AllPermissions {
+			if p == perm {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return &UploadRejectedError{Reason: fmt.Sprintf("Unknown permission %q", perm)}
+		}
+		if !seen[perm] {
+			seen[perm] = true
+			perms = append(perms, perm)
+		}
+	}
+	in.Permissions = perms
+	return ValidateBackendIDs(in.BackendIDs)
+}
+
+// apply 把参数写入角色记录
+func (in RoleInput) apply(role *database.Role) {
+	role.Name = in.Name
+	role.Description = strings.TrimSpace(in.Description)
+	role.Permissions, _ = json.Marshal(in.Permissions)
+	role.BackendIDs = nil
+	if len(in.BackendIDs) > 0 {
+		role.BackendIDs, _ = json.Marshal(in.BackendIDs)
+	}
+}
+
+// ListRoles 列出所有角色
+func ListRoles() ([]database.Role, error) {
+	var list []database.Role
+	err := database.DB.Order("id asc").Find(&list).Error
+	return list, err
+}
+
+// CreateRole 新建角色
+func CreateRole(in RoleInput) (*database.Role, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	var count int64
+	database.DB.Model(&database.Role{}).Where("name = ?", in.Name).Count(&count)
+	if count > 0 {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("Role %q already exists", in.Name)}
+	}
+	var role database.Role
+	in.apply(&role)
+	if err := database.DB.Create(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, ReloadRoles()
+}
+
+// UpdateRole 修改角色的描述、权限和允许的后端。内置角色不能改名，admin 的权限保持为全部权限
+func UpdateRole(id uint, in RoleInput) (*database.Role, error) {
+	var role database.Role
+	if err := database.DB.First(&role, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	if role.BuiltIn && in.Name != role.Name {
+		return nil, ErrBuiltInRole
+	}
+	if role.Name == AdminRoleName {
+		in.Permissions = AllPermissions
+		in.BackendIDs = nil
+	}
+	if in.Name != role.Name {
+		var count int64
+		database.DB.Model(&database.Role{}).Where("name = ?", in.Name).Count(&count)
+		if count > 0 {
+			return nil, &UploadRejectedError{Reason: fmt.Sprintf("Role %q already exists", in.Name)}
+		}
+	}
+
+	oldName := role.Name
+	in.apply(&role)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("Name", "Description", "Permissions", "BackendIDs", "UpdatedAt").Save(&role).Error; err != nil {
+			return err
+		}
+		if oldName != role.Name {
+			return tx.Model(&database.User{}).Where("role = ?", oldName).Update("role", role.Name).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &role, ReloadRoles()
+}
+
+// DeleteRole 删除自定义角色，仍有用户属于该角色时拒绝删除
+func DeleteRole(id uint) error {
+	var role database.Role
+	if err := database.DB.First(&role, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+	if role.BuiltIn {
+		return ErrBuiltInRole
+	}
+	var users int64
+	database.DB.Model(&database.User{}).Where("role = ?", role.Name).Count(&users)
+	if users > 0 {
+		return ErrRoleInUse
+	}
+	if err := database.DB.Delete(&role).Error; err != nil {
+		return err
+	}
+	return ReloadRoles()
+}
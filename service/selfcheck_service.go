@@ -0,0 +1,163 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+	"yanshu-imgbed/storage"
+)
+
+// minJWTSecretLength JWT 密钥的最小长度
+const minJWTSecretLength = 32
+
+// 自检项的结果级别
+const (
+	CheckOK       = "ok"
+	CheckWarning  = "warning"
+	CheckCritical = "critical"
+)
+
+// SelfCheckResult 单个自检项的结果
+type SelfCheckResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// SelfCheckReport 启动自检报告
+type SelfCheckReport struct {
+	Results []SelfCheckResult `json:"results"`
+}
+
+// HasCritical 判断是否存在严重问题
+func (r *SelfCheckReport) HasCritical() bool {
+	for _, result := range r.Results {
+		if result.Status == CheckCritical {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *SelfCheckReport) add(name, status, format string, args ...interface{}) {
+	r.Results = append(r.Results, SelfCheckResult{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// Log 按级别输出自检报告
+func (r *SelfCheckReport) Log() {
+	log.Println("Startup self-check report:")
+	for _, result := range r.Results {
+		log.Printf("  [%-8s] %s: %s", result.Status, result.Name, result.Message)
+	}
+}
+
+// RunSelfCheck 检查数据库结构版本、本地存储目录可写、已启用后端可访问、JWT 密钥强度以及备份是否配置了加密密钥
+func RunSelfCheck(storageManager *manager.StorageManager) *SelfCheckReport {
+	report := &SelfCheckReport{}
+	checkSchemaVersion(report)
+	checkJWTSecret(report)
+	checkBackupEncryption(report)
+
+	var backends []database.Backend
+	if err := database.DB.Where("allow_upload = ? OR allow_redirect = ?", true, true).Order("priority asc").Find(&backends).Error; err != nil {
+		report.add("backends", CheckCritical, "failed to load backends: %v", err)
+		return report
+	}
+	if len(backends) == 0 {
+		report.add("backends", CheckWarning, "no enabled storage backends, uploads will fail")
+	}
+	checkLocalStorage(report, backends, storageManager)
+	checkBackendReachability(report, backends, storageManager)
+	return report
+}
+
+func checkSchemaVersion(report *SelfCheckReport) {
+	version, err := database.GetSchemaVersion()
+	switch {
+	case err != nil:
+		report.add("schema", CheckCritical, "failed to read schema version: %v", err)
+	case version != database.SchemaVersion:
+		report.add("schema", CheckCritical, "database schema version is %d, expected %d", version, database.SchemaVersion)
+	default:
+		report.add("schema", CheckOK, "schema version %d", version)
+	}
+}
+
+func checkJWTSecret(report *SelfCheckReport) {
+	secret := config.Cfg.JWT.Secret
+	switch {
+	case secret == config.DefaultJWTSecret:
+		report.add("jwt_secret", CheckCritical, "jwt.secret is the built-in default, anyone can forge login tokens")
+	case len(secret) < minJWTSecretLength:
+		report.add("jwt_secret", CheckCritical, "jwt.secret is only %d characters, use at least %d", len(secret), minJWTSecretLength)
+	default:
+		report.add("jwt_secret", CheckOK, "jwt.secret length %d", len(secret))
+	}
+}
+
+// checkBackupEncryption 配置了备份后端但没有加密密钥时，备份不会执行
+func checkBackupEncryption(report *SelfCheckReport) {
+	cfg := config.Cfg.Backup
+	switch {
+	case cfg.Backend == "":
+		return
+	case cfg.EncryptionKey == "":
+		report.add("backup", CheckWarning, "backup.encryption_key is empty, database backups to %q are disabled", cfg.Backend)
+	default:
+		report.add("backup", CheckOK, "backups to %q are encrypted", cfg.Backend)
+	}
+}
+
+// checkLocalStorage 在每个本地后端的存储目录中写入并删除一个临时文件
+func checkLocalStorage(report *SelfCheckReport, backends []database.Backend, storageManager *manager.StorageManager) {
+	for _, backend := range backends {
+		uploader, found := storageManager.Get(backend.ID)
+		if !found {
+			continue
+		}
+		local, ok := uploader.(*storage.LocalUploader)
+		if !ok {
+			continue
+		}
+		name := fmt.Sprintf("storage:%s", backend.Name)
+		probe, err := os.CreateTemp(local.StoragePath, ".selfcheck-*")
+		if err != nil {
+			report.add(name, CheckCritical, "storage path %s is not writable: %v", local.StoragePath, err)
+			continue
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+		abs, _ := filepath.Abs(local.StoragePath)
+		report.add(name, CheckOK, "storage path %s is writable", abs)
+	}
+}
+
+// checkBackendReachability 通过后端最近写入的对象检查可访问性，后端不可用只作为警告
+func checkBackendReachability(report *SelfCheckReport, backends []database.Backend, storageManager *manager.StorageManager) {
+	results := make([]SelfCheckResult, len(backends))
+	var wg sync.WaitGroup
+	for i, backend := range backends {
+		wg.Add(1)
+		go func(i int, b database.Backend) {
+			defer wg.Done()
+			result := SelfCheckResult{Name: fmt.Sprintf("backend:%s", b.Name)}
+			if _, found := storageManager.Get(b.ID); !found {
+				result.Status, result.Message = CheckWarning, "backend failed to initialize, check its configuration"
+			} else if reachable := probeBackend(b.ID); reachable == nil {
+				result.Status, result.Message = CheckOK, "initialized, no stored objects to probe yet"
+			} else if *reachable {
+				result.Status, result.Message = CheckOK, "latest stored object is reachable"
+			} else {
+				result.Status, result.Message = CheckWarning, "latest stored object is not reachable"
+			}
+			results[i] = result
+		}(i, backend)
+	}
+	wg.Wait()
+	report.Results = append(report.Results, results...)
+}
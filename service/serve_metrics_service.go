@@ -0,0 +1,114 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"yanshu-imgbed/database"
+)
+
+const (
+	// slowServeDecision 超过该耗时的跳转决策计为慢请求 (主要来自远程后端的健康检查)
+	slowServeDecision = 500 * time.Millisecond
+	// lastServedUpdateInterval 同一图片从同一后端访问时，最多每隔这么久写一次 last_served_at
+	lastServedUpdateInterval = time.Minute
+)
+
+// BackendServeMetrics 自启动以来 /image 请求落到某个后端的统计
+type BackendServeMetrics struct {
+	BackendID     uint    `json:"backend_id"`
+	BackendName   string  `json:"backend_name"`
+	Requests      int64   `json:"requests"`
+	SlowRequests  int64   `json:"slow_requests"`
+	AvgDecisionMs float64 `json:"avg_decision_ms"`
+	MaxDecisionMs float64 `json:"max_decision_ms"`
+}
+
+// ServeMetrics /image 路由的跳转决策统计
+type ServeMetrics struct {
+	Since           time.Time             `json:"since"`
+	AccessPolicy    string                `json:"access_policy"`
+	SlowThresholdMs int64                 `json:"slow_threshold_ms"`
+	Backends        []BackendServeMetrics `json:"backends"`
+}
+
+type serveCounter struct {
+	name     string
+	requests int64
+	slow     int64
+	total    time.Duration
+	max      time.Duration
+}
+
+var (
+	serveMetricsMu    sync.Mutex
+	serveMetrics      = make(map[uint]*serveCounter)
+	serveMetricsSince = time.Now()
+)
+
+// RecordServeDecision 记录一次 /image 请求选中的存储位置和决策耗时，并更新图片的最近访问后端
+func RecordServeDecision(location *database.StorageLocation, elapsed time.Duration) {
+	serveMetricsMu.Lock()
+	counter, ok := serveMetrics[location.BackendID]
+	if !ok {
+		counter = &serveCounter{}
+		serveMetrics[location.BackendID] = counter
+	}
+	counter.name = location.Backend.Name
+	counter.requests++
+	counter.total += elapsed
+	if elapsed > counter.max {
+		counter.max = elapsed
+	}
+	if elapsed >= slowServeDecision {
+		counter.slow++
+	}
+	serveMetricsMu.Unlock()
+
+	go recordLastServed(location.ImageID, location.BackendID)
+}
+
+// recordLastServed 更新图片的最近访问后端；后端未变化时按间隔节流，避免每次访问都写库
+func recordLastServed(imageID, backendID uint) {
+	now := time.Now()
+	database.DB.Model(&database.Image{}).
+		Where("id = ?", imageID).
+		Where("(last_served_backend_id IS NULL OR last_served_backend_id <> ? OR last_served_at IS NULL OR last_served_at < ?)",
+			backendID, now.Add(-lastServedUpdateInterval)).
+		Updates(map[string]interface{}{"last_served_backend_id": backendID, "last_served_at": now})
+}
+
+// GetServeMetrics 返回各后端的跳转统计，按请求数降序
+func GetServeMetrics() ServeMetrics {
+	serveMetricsMu.Lock()
+	defer serveMetricsMu.Unlock()
+
+	metrics := ServeMetrics{
+		Since:           serveMetricsSince,
+		AccessPolicy:    GetAccessPolicy(),
+		SlowThresholdMs: slowServeDecision.Milliseconds(),
+		Backends:        make([]BackendServeMetrics, 0, len(serveMetrics)),
+	}
+	for backendID, counter := range serveMetrics {
+		metrics.Backends = append(metrics.Backends, BackendServeMetrics{
+			BackendID:     backendID,
+			BackendName:   counter.name,
+			Requests:      counter.requests,
+			SlowRequests:  counter.slow,
+			AvgDecisionMs: float64(counter.total.Microseconds()) / float64(counter.requests) / 1000,
+			MaxDecisionMs: float64(counter.max.Microseconds()) / 1000,
+		})
+	}
+	sort.Slice(metrics.Backends, func(i, j int) bool {
+		return metrics.Backends[i].Requests > metrics.Backends[j].Requests
+	})
+	return metrics
+}
+
+// ResetServeMetrics 清空跳转统计，便于调整访问策略后重新观察
+func ResetServeMetrics() {
+	serveMetricsMu.Lock()
+	defer serveMetricsMu.Unlock()
+	serveMetrics = make(map[uint]*serveCounter)
+	serveMetricsSince = time.Now()
+}
@@ -1,10 +1,18 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"yanshu-imgbed/database"
+	"yanshu-imgbed/util"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // SettingsCache 用于在内存中缓存系统设置
@@ -12,6 +20,136 @@ type SettingsCache struct {
 	RetryCount   int
 	AccessPolicy string
 	MaxUploadMB  int
+	Watermark    WatermarkSettings
+	// DeleteGraceHours 物理删除的宽限期 (小时)，0 表示立即删除
+	DeleteGraceHours int
+	Compression      CompressionSettings
+	RandomAPI        RandomAPISettings
+	// DimensionLimits 按角色区分的尺寸限制，键 "" 为默认值
+	DimensionLimits map[string]DimensionLimit
+	// AllowedFileTypes 允许上传的文件类型 (按魔数识别的扩展名)
+	AllowedFileTypes []string
+	// AllowUserRandomPool 是否允许普通用户将自己的图片加入随机图池
+	AllowUserRandomPool bool
+	Moderation          ModerationSettings
+	// MigrationBandwidthLimit 所有迁移/补传任务共享的带宽上限 (字节/秒)，0 表示不限制
+	MigrationBandwidthLimit int64
+	// InstantUploadEnabled 是否允许按哈希秒传 (不传输文件，直接引用已有的相同文件)。
+	// 知道哈希和大小即可取得其他用户的文件，也能据此探测文件是否存在，因此默认关闭
+	InstantUploadEnabled bool
+	// AllowSearchIndexing 是否允许搜索引擎收录公开页面和图片
+	AllowSearchIndexing bool
+	// ReadOnlyMode 维护模式：图片照常访问，但拒绝上传、删除和其他修改 (设置和数据库维护除外)
+	ReadOnlyMode bool
+	// ReadOnlyMessage 维护模式下返回给客户端的提示
+	ReadOnlyMessage string
+	// UploaderInfoMode 上传者 IP/UA 的记录方式：full、anonymize、off
+	UploaderInfoMode string
+	// AsyncDistribution 上传到优先级最高的可用后端后立即返回，其余后端由后台队列补传
+	AsyncDistribution bool
+	// UploadRateLimitPerMinute 每个 IP 每分钟允许的上传请求数，0 表示不限制
+	UploadRateLimitPerMinute int
+	// ImageRateLimitPerMinute 每个 IP 每分钟允许的图片访问 (/image、/i) 请求数，0 表示不限制
+	ImageRateLimitPerMinute int
+	// LoginRateLimitPerMinute 每个 IP 每分钟允许的登录请求数，0 表示不限制
+	LoginRateLimitPerMinute int
+	// MinReplicas 每张图片至少应有的有效存储位置数，0 表示不检查
+	MinReplicas int
+	// RequireSignedURLs 开启后 /image 只接受带有效签名的请求，签名地址通过 /api/images/sign 获取
+	RequireSignedURLs bool
+	Hotlink           HotlinkSettings
+	// DailyViewStats 是否按天记录每张图片的访问次数 (访问排行按天统计时需要)
+	DailyViewStats bool
+	// GeoRules 按访客国家/地区优先使用的后端，按顺序匹配第一条
+	GeoRules []GeoRule
+	// PlaceholderEnabled 图片不存在或不可用时输出占位图 (带 404/503 状态码) 而不是 JSON 错误
+	PlaceholderEnabled bool
+	Registration       RegistrationSettings
+	// DailyUploadLimit 每个用户每天的上传次数和上传量上限，0 表示不限制
+	DailyUploadLimit DailyUploadLimit
+	LoginSecurity    LoginSecuritySettings
+}
+
+// LoginSecuritySettings 登录防暴力破解相关设置
+type LoginSecuritySettings struct {
+	LockoutThreshold     int    // 同一用户名或 IP 连续失败多少次后锁定，0 表示不锁定
+	LockoutMinutes       int    // 锁定时长，也是失败次数的统计窗口
+	CaptchaProvider      string // "hcaptcha"、"turnstile" 或空 (不启用)
+	CaptchaSiteKey       string
+	CaptchaAfterFailures int // 失败多少次后要求验证码，0 表示每次登录都需要
+}
+
+// RegistrationSettings 用户自助注册相关设置
+type RegistrationSettings struct {
+	Enabled        bool   // 是否开放 /auth/register
+	InviteRequired bool   // 注册时必须提供有效的邀请码
+	DefaultRole    string // 新用户的角色，不能是 admin
+	DefaultQuotaMB int64  // 新用户的存储配额 (MB)，0 表示不限制
+}
+
+// HotlinkSettings 防盗链相关设置，按 Referer (没有时按 Origin) 判断来源
+type HotlinkSettings struct {
+	Mode       string   // "off"、"whitelist" 或 "blacklist"
+	Domains    []string // 域名列表，"*.example.com" 匹配 example.com 及其所有子域名
+	AllowEmpty bool     // 是否放行没有来源的请求 (直接访问、部分 App 和阅读器)
+	// Action 不允许的来源如何处理："forbid" 返回 403，"placeholder" 跳转到占位图，"watermark" 返回加了水印的图片
+	Action         string
+	PlaceholderURL string
+}
+
+// ModerationSettings 内容审核相关设置
+type ModerationSettings struct {
+	Enabled        bool
+	Endpoint       string  // 审核服务地址
+	Threshold      float64 // 分数达到该值时触发 Action
+	Action         string  // "flag"、"quarantine" 或 "reject"
+	TimeoutSeconds int
+}
+
+// defaultAllowedFileTypes 未配置 allowed_file_types 时允许的文件类型
+// SVG 可以内嵌脚本，默认不允许，需要管理员显式加入白名单
+var defaultAllowedFileTypes = []string{"jpg", "png", "gif", "webp", "bmp", "ico", "heic", "heif"}
+
+// defaultReadOnlyMessage 未配置 readonly_message 时维护模式返回的提示
+const defaultReadOnlyMessage = "The site is in read-only maintenance mode; uploads and changes are temporarily disabled"
+
+// DimensionLimit 上传图片的最大尺寸限制
+type DimensionLimit struct {
+	MaxWidth  int    // 0 表示不限制
+	MaxHeight int    // 0 表示不限制
+	Policy    string // 超出限制时的处理方式："reject" 或 "downscale"
+	sizeSet   bool   // 是否显式配置了宽高，用于角色配置覆盖默认值
+}
+
+// Active 判断是否配置了任何尺寸限制
+func (l DimensionLimit) Active() bool {
+	return l.MaxWidth > 0 || l.MaxHeight > 0
+}
+
+// RandomAPISettings 随机图片 API 相关设置
+type RandomAPISettings struct {
+	Enabled            bool
+	RateLimitPerMinute int // 每个 IP 每分钟的请求上限，0 表示不限制
+	CacheSeconds       int // 跳转响应的缓存时间，0 表示不缓存
+}
+
+// CompressionSettings 服务端压缩相关设置
+type CompressionSettings struct {
+	Enabled  bool
+	Quality  int   // JPEG 质量 1-100
+	MaxBytes int64 // 压缩后的目标大小上限，0 表示不限制
+}
+
+// WatermarkSettings 水印相关设置
+type WatermarkSettings struct {
+	Enabled   bool
+	Type      string // "text" 或 "image"
+	Text      string
+	ImagePath string // PNG 水印图片在服务器上的路径
+	Position  string
+	Opacity   int // 0-100
+	MinWidth  int // 小于该宽度的图片不添加水印
+	MinHeight int // 小于该高度的图片不添加水印
 }
 
 var (
@@ -29,6 +167,42 @@ func InitSettings() {
 		RetryCount:   3, // 默认值
 		AccessPolicy: "random",
 		MaxUploadMB:  10,
+		Watermark: WatermarkSettings{
+			Type:     "text",
+			Position: "bottom-right",
+			Opacity:  50,
+		},
+		Compression: CompressionSettings{
+			Quality: 85,
+		},
+		RandomAPI: RandomAPISettings{
+			Enabled: true,
+		},
+		AllowedFileTypes:     defaultAllowedFileTypes,
+		InstantUploadEnabled: false,
+		AllowSearchIndexing:  true,
+		DailyViewStats:       true,
+		UploaderInfoMode:     UploaderInfoFull,
+		Moderation: ModerationSettings{
+			Threshold:      0.8,
+			Action:         "flag",
+			TimeoutSeconds: 10,
+		},
+		Hotlink: HotlinkSettings{
+			Mode:       HotlinkOff,
+			AllowEmpty: true,
+			Action:     HotlinkForbid,
+		},
+		LoginRateLimitPerMinute: 10,
+		LoginSecurity: LoginSecuritySettings{
+			LockoutThreshold:     5,
+			LockoutMinutes:       15,
+			CaptchaAfterFailures: 3,
+		},
+		Registration: RegistrationSettings{
+			InviteRequired: true,
+			DefaultRole:    "user",
+		},
 	}
 
 	if err := reloadSettings(); err != nil {
@@ -55,7 +229,7 @@ func reloadSettings() error {
 			AppSettings.RetryCount = rcInt
 		}
 	}
-	if apStr, ok := settingsMap["access_policy"]; ok && (apStr == "random" || apStr == "priority") {
+	if apStr, ok := settingsMap["access_policy"]; ok && (apStr == "random" || apStr == "priority" || apStr == "weighted") {
 		AppSettings.AccessPolicy = apStr
 	}
 	if muStr, ok := settingsMap["max_upload_mb"]; ok {
@@ -63,11 +237,401 @@ func reloadSettings() error {
 			AppSettings.MaxUploadMB = muInt
 		}
 	}
+	if dgStr, ok := settingsMap["delete_grace_hours"]; ok {
+		if dgInt, err := strconv.Atoi(dgStr); err == nil && dgInt >= 0 {
+			AppSettings.DeleteGraceHours = dgInt
+		}
+	}
+	if v, ok := settingsMap["migration_bandwidth_limit"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			AppSettings.MigrationBandwidthLimit = n
+		}
+	}
+	if v, ok := settingsMap["compression_enabled"]; ok {
+		AppSettings.Compression.Enabled = v == "true"
+	}
+	if v, ok := settingsMap["compression_quality"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 100 {
+			AppSettings.Compression.Quality = n
+		}
+	}
+	if v, ok := settingsMap["compression_max_kb"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			AppSettings.Compression.MaxBytes = n * 1024
+		}
+	}
+	if v, ok := settingsMap["random_api_enabled"]; ok {
+		AppSettings.RandomAPI.Enabled = v != "false"
+	}
+	if v, ok := settingsMap["random_rate_limit_per_minute"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			AppSettings.RandomAPI.RateLimitPerMinute = n
+		}
+	}
+	if v, ok := settingsMap["random_cache_seconds"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			AppSettings.RandomAPI.CacheSeconds = n
+		}
+	}
+	if v, ok := settingsMap["allowed_file_types"]; ok && strings.TrimSpace(v) != "" {
+		var types []string
+		for _, t := range strings.Split(v, ",") {
+			t = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), "."))
+			if t == "jpeg" {
+				t = "jpg"
+			}
+			if t != "" {
+				types = append(types, t)
+			}
+		}
+		AppSettings.AllowedFileTypes = types
+	}
+	if v, ok := settingsMap["allow_user_random_pool"]; ok {
+		AppSettings.AllowUserRandomPool = v == "true"
+	}
+	if v, ok := settingsMap["instant_upload_enabled"]; ok {
+		AppSettings.InstantUploadEnabled = v == "true"
+	}
+	if v, ok := settingsMap["allow_search_indexing"]; ok {
+		AppSettings.AllowSearchIndexing = v != "false"
+	}
+	if v, ok := settingsMap["readonly_mode"]; ok {
+		AppSettings.ReadOnlyMode = v == "true"
+	}
+	if v, ok := settingsMap["readonly_message"]; ok {
+		AppSettings.ReadOnlyMessage = strings.TrimSpace(v)
+	}
+	if v, ok := settingsMap["upload_rate_limit_per_minute"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			AppSettings.UploadRateLimitPerMinute = n
+		}
+	}
+	if v, ok := settingsMap["daily_upload_count_limit"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			AppSettings.DailyUploadLimit.Uploads = n
+		}
+	}
+	if v, ok := settingsMap["daily_upload_mb_limit"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			AppSettings.DailyUploadLimit.MB = n
+		}
+	}
+	if v, ok := settingsMap["image_rate_limit_per_minute"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			AppSettings.ImageRateLimitPerMinute = n
+		}
+	}
+	if v, ok := settingsMap["login_rate_limit_per_minute"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			AppSettings.LoginRateLimitPerMinute = n
+		}
+	}
+	if v, ok := settingsMap["require_signed_urls"]; ok {
+		AppSettings.RequireSignedURLs = v == "true"
+	}
+	if v, ok := settingsMap["daily_view_stats"]; ok {
+		AppSettings.DailyViewStats = v != "false"
+	}
+	if v, ok := settingsMap["placeholder_enabled"]; ok {
+		AppSettings.PlaceholderEnabled = v == "true"
+	}
+	if v, ok := settingsMap["geo_rules"]; ok {
+		if rules, err := parseGeoRules(v); err == nil {
+			AppSettings.GeoRules = rules
+		} else {
+			log.Printf("Ignoring invalid geo_rules setting: %v", err)
+		}
+	}
+	if v, ok := settingsMap["min_replicas"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			AppSettings.MinReplicas = n
+		}
+	}
+	if v, ok := settingsMap["async_distribution"]; ok {
+		AppSettings.AsyncDistribution = v == "true"
+	}
+	if v, ok := settingsMap["uploader_info_mode"]; ok {
+		switch v {
+		case UploaderInfoFull, UploaderInfoAnonymize, UploaderInfoOff:
+			AppSettings.UploaderInfoMode = v
+		}
+	}
+	loadDimensionLimits(settingsMap)
+	loadModerationSettings(settingsMap)
+	loadWatermarkSettings(settingsMap)
+	loadHotlinkSettings(settingsMap)
+	loadRegistrationSettings(settingsMap)
+	loadLoginSecuritySettings(settingsMap)
 	// 在此可以加载其他设置
 
 	return nil
 }
 
+// loadDimensionLimits 解析尺寸限制设置
+// 默认值使用 max_width / max_height / oversize_policy，
+// 角色专属的值使用 "_<role>" 后缀，例如 max_width_admin
+func loadDimensionLimits(settingsMap map[string]string) {
+	limits := make(map[string]DimensionLimit)
+	for key, value := range settingsMap {
+		var field, role string
+		switch {
+		case strings.HasPrefix(key, "max_width"):
+			field, role = "max_width", strings.TrimPrefix(strings.TrimPrefix(key, "max_width"), "_")
+		case strings.HasPrefix(key, "max_height"):
+			field, role = "max_height", strings.TrimPrefix(strings.TrimPrefix(key, "max_height"), "_")
+		case strings.HasPrefix(key, "oversize_policy"):
+			field, role = "oversize_policy", strings.TrimPrefix(strings.TrimPrefix(key, "oversize_policy"), "_")
+		default:
+			continue
+		}
+
+		limit := limits[role]
+		switch field {
+		case "max_width":
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				limit.MaxWidth = n
+				limit.sizeSet = true
+			}
+		case "max_height":
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				limit.MaxHeight = n
+				limit.sizeSet = true
+			}
+		case "oversize_policy":
+			if value == "reject" || value == "downscale" {
+				limit.Policy = value
+			}
+		}
+		limits[role] = limit
+	}
+	AppSettings.DimensionLimits = limits
+}
+
+// loadModerationSettings 从设置表中解析内容审核配置
+func loadModerationSettings(settingsMap map[string]string) {
+	m := &AppSettings.Moderation
+	if v, ok := settingsMap["moderation_enabled"]; ok {
+		m.Enabled = v == "true"
+	}
+	if v, ok := settingsMap["moderation_endpoint"]; ok {
+		m.Endpoint = strings.TrimSpace(v)
+	}
+	if v, ok := settingsMap["moderation_threshold"]; ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			m.Threshold = f
+		}
+	}
+	if v, ok := settingsMap["moderation_action"]; ok && (v == "flag" || v == "quarantine" || v == "reject") {
+		m.Action = v
+	}
+	if v, ok := settingsMap["moderation_timeout_seconds"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			m.TimeoutSeconds = n
+		}
+	}
+}
+
+// loadWatermarkSettings 从设置表中解析水印配置
+func loadWatermarkSettings(settingsMap map[string]string) {
+	wm := &AppSettings.Watermark
+	if v, ok := settingsMap["watermark_enabled"]; ok {
+		wm.Enabled = v == "true"
+	}
+	if v, ok := settingsMap["watermark_type"]; ok && (v == "text" || v == "image") {
+		wm.Type = v
+	}
+	if v, ok := settingsMap["watermark_text"]; ok {
+		wm.Text = v
+	}
+	if v, ok := settingsMap["watermark_image_path"]; ok {
+		wm.ImagePath = v
+	}
+	if v, ok := settingsMap["watermark_position"]; ok && v != "" {
+		wm.Position = v
+	}
+	if v, ok := settingsMap["watermark_opacity"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 100 {
+			wm.Opacity = n
+		}
+	}
+	if v, ok := settingsMap["watermark_min_width"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			wm.MinWidth = n
+		}
+	}
+	if v, ok := settingsMap["watermark_min_height"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			wm.MinHeight = n
+		}
+	}
+}
+
+// loadHotlinkSettings 从设置表中解析防盗链配置
+func loadHotlinkSettings(settingsMap map[string]string) {
+	hl := &AppSettings.Hotlink
+	if v, ok := settingsMap["hotlink_mode"]; ok && (v == HotlinkOff || v == HotlinkWhitelist || v == HotlinkBlacklist) {
+		hl.Mode = v
+	}
+	if v, ok := settingsMap["hotlink_domains"]; ok {
+		hl.Domains = nil
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+				hl.Domains = append(hl.Domains, d)
+			}
+		}
+	}
+	if v, ok := settingsMap["hotlink_allow_empty"]; ok {
+		hl.AllowEmpty = v != "false"
+	}
+	if v, ok := settingsMap["hotlink_action"]; ok && (v == HotlinkForbid || v == HotlinkPlaceholder || v == HotlinkWatermark) {
+		hl.Action = v
+	}
+	if v, ok := settingsMap["hotlink_placeholder_url"]; ok {
+		hl.PlaceholderURL = strings.TrimSpace(v)
+	}
+}
+
+// parseGeoRules 解析 geo_rules 设置 (JSON 数组)，国家代码统一转为大写
+func parseGeoRules(value string) ([]GeoRule, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var rules []GeoRule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if len(rules[i].Countries) == 0 {
+			return nil, fmt.Errorf("rule %d has no countries", i+1)
+		}
+		for j, c := range rules[i].Countries {
+			rules[i].Countries[j] = strings.ToUpper(strings.TrimSpace(c))
+		}
+	}
+	return rules, nil
+}
+
+func loadRegistrationSettings(settingsMap map[string]string) {
+	reg := &AppSettings.Registration
+	if v, ok := settingsMap["registration_enabled"]; ok {
+		reg.Enabled = v == "true"
+	}
+	if v, ok := settingsMap["registration_invite_required"]; ok {
+		reg.InviteRequired = v != "false"
+	}
+	if v, ok := settingsMap["registration_default_role"]; ok && v != "" && v != "admin" {
+		reg.DefaultRole = v
+	}
+	if v, ok := settingsMap["registration_default_quota_mb"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			reg.DefaultQuotaMB = n
+		}
+	}
+}
+
+func loadLoginSecuritySettings(settingsMap map[string]string) {
+	ls := &AppSettings.LoginSecurity
+	if v, ok := settingsMap["login_lockout_threshold"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			ls.LockoutThreshold = n
+		}
+	}
+	if v, ok := settingsMap["login_lockout_minutes"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			ls.LockoutMinutes = n
+		}
+	}
+	if v, ok := settingsMap["captcha_provider"]; ok && (v == "" || v == CaptchaHCaptcha || v == CaptchaTurnstile) {
+		ls.CaptchaProvider = v
+	}
+	if v, ok := settingsMap["captcha_site_key"]; ok {
+		ls.CaptchaSiteKey = strings.TrimSpace(v)
+	}
+	if v, ok := settingsMap["captcha_after_failures"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			ls.CaptchaAfterFailures = n
+		}
+	}
+}
+
+// SaveSetting 新增或更新一条设置，不会刷新内存缓存
+func SaveSetting(key, value string) error {
+	var existing database.Setting
+	if err := database.DB.Where(clause.Eq{Column: "key", Value: key}).First(&existing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return database.DB.Create(&database.Setting{Key: key, Value: value}).Error
+		}
+		return err
+	}
+	return database.DB.Model(&database.Setting{}).Where(clause.Eq{Column: "key", Value: key}).Update("value", value).Error
+}
+
+// rateLimitSettingKeys 各接口的每分钟限流设置
+var rateLimitSettingKeys = []string{
+	"upload_rate_limit_per_minute",
+	"random_rate_limit_per_minute",
+	"image_rate_limit_per_minute",
+	"login_rate_limit_per_minute",
+}
+
+// ValidateSettings 在保存前校验管理员提交的设置
+func ValidateSettings(settings map[string]string) error {
+	if _, ok := settings["schema_version"]; ok {
+		return errors.New("schema_version is managed by the database migration and cannot be changed")
+	}
+	for _, key := range rateLimitSettingKeys {
+		if v, ok := settings[key]; ok {
+			if n, err := strconv.Atoi(v); err != nil || n < 0 {
+				return fmt.Errorf("%s must be a non-negative integer", key)
+			}
+		}
+	}
+	if v, ok := settings["access_policy"]; ok && v != "random" && v != "priority" && v != "weighted" {
+		return errors.New("access_policy must be random, priority or weighted")
+	}
+	for _, key := range []string{"login_lockout_threshold", "captcha_after_failures"} {
+		if v, ok := settings[key]; ok {
+			if n, err := strconv.Atoi(v); err != nil || n < 0 {
+				return fmt.Errorf("%s must be a non-negative integer", key)
+			}
+		}
+	}
+	if v, ok := settings["login_lockout_minutes"]; ok {
+		if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+			return errors.New("login_lockout_minutes must be a positive integer")
+		}
+	}
+	if v, ok := settings["captcha_provider"]; ok && v != "" && v != CaptchaHCaptcha && v != CaptchaTurnstile {
+		return errors.New("captcha_provider must be empty, hcaptcha or turnstile")
+	}
+	for _, key := range []string{"daily_upload_count_limit", "daily_upload_mb_limit"} {
+		if v, ok := settings[key]; ok {
+			if n, err := strconv.ParseInt(v, 10, 64); err != nil || n < 0 {
+				return fmt.Errorf("%s must be a non-negative integer", key)
+			}
+		}
+	}
+	if v, ok := settings["registration_default_role"]; ok && (!RoleExists(v) || HasPermission(v, PermManageSystem)) {
+		return errors.New("registration_default_role must be an existing role without the system.manage permission")
+	}
+	if v, ok := settings["registration_default_quota_mb"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err != nil || n < 0 {
+			return errors.New("registration_default_quota_mb must be a non-negative integer")
+		}
+	}
+	if v, ok := settings["geo_rules"]; ok {
+		if _, err := parseGeoRules(v); err != nil {
+			return fmt.Errorf("invalid geo_rules: %v", err)
+		}
+	}
+	if text, ok := settings["watermark_text"]; ok {
+		if unsupported := util.UnsupportedWatermarkRunes(text); len(unsupported) > 0 {
+			return fmt.Errorf("watermark text contains characters the built-in font cannot render: %q (only ASCII letters, digits, common punctuation and © are supported)", string(unsupported))
+		}
+	}
+	return nil
+}
+
 // UpdateSettingsCache 用于在管理员更新设置后刷新内存缓存
 func UpdateSettingsCache() error {
 	settingsMu.Lock()
@@ -105,3 +669,301 @@ func GetMaxUploadMB() int {
 	}
 	return AppSettings.MaxUploadMB
 }
+
+// GetWatermarkSettings 从内存缓存中安全地获取水印设置
+func GetWatermarkSettings() WatermarkSettings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return WatermarkSettings{}
+	}
+	return AppSettings.Watermark
+}
+
+// GetDeleteGraceHours 从内存缓存中安全地获取物理删除宽限期
+func GetDeleteGraceHours() int {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return 0
+	}
+	return AppSettings.DeleteGraceHours
+}
+
+// GetMigrationBandwidthLimit 从内存缓存中安全地获取迁移任务的全局带宽上限
+func GetMigrationBandwidthLimit() int64 {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return 0
+	}
+	return AppSettings.MigrationBandwidthLimit
+}
+
+// GetCompressionSettings 从内存缓存中安全地获取压缩设置
+func GetCompressionSettings() CompressionSettings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return CompressionSettings{}
+	}
+	return AppSettings.Compression
+}
+
+// GetHotlinkSettings 从内存缓存中安全地获取防盗链设置
+func GetHotlinkSettings() HotlinkSettings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return HotlinkSettings{Mode: HotlinkOff}
+	}
+	return AppSettings.Hotlink
+}
+
+// GetDailyUploadLimit 从内存缓存中安全地获取每个用户每天的上传上限
+func GetDailyUploadLimit() DailyUploadLimit {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return DailyUploadLimit{}
+	}
+	return AppSettings.DailyUploadLimit
+}
+
+// GetLoginSecuritySettings 从内存缓存中安全地获取登录锁定和验证码设置
+func GetLoginSecuritySettings() LoginSecuritySettings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return LoginSecuritySettings{}
+	}
+	return AppSettings.LoginSecurity
+}
+
+// GetRegistrationSettings 从内存缓存中安全地获取自助注册设置
+func GetRegistrationSettings() RegistrationSettings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return RegistrationSettings{}
+	}
+	return AppSettings.Registration
+}
+
+// GetRandomAPISettings 从内存缓存中安全地获取随机图片 API 设置
+func GetRandomAPISettings() RandomAPISettings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return RandomAPISettings{Enabled: true}
+	}
+	return AppSettings.RandomAPI
+}
+
+// GetDimensionLimit 获取指定角色的尺寸限制，未单独配置的字段回退到默认值
+func GetDimensionLimit(role string) DimensionLimit {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return DimensionLimit{}
+	}
+	limit := AppSettings.DimensionLimits[""]
+	if roleLimit, ok := AppSettings.DimensionLimits[role]; ok && role != "" {
+		if roleLimit.sizeSet {
+			limit.MaxWidth = roleLimit.MaxWidth
+			limit.MaxHeight = roleLimit.MaxHeight
+		}
+		if roleLimit.Policy != "" {
+			limit.Policy = roleLimit.Policy
+		}
+	}
+	if limit.Policy == "" {
+		limit.Policy = "downscale"
+	}
+	return limit
+}
+
+// IsFileTypeAllowed 判断识别出的文件类型是否在白名单中
+func IsFileTypeAllowed(ext string) bool {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	allowed := defaultAllowedFileTypes
+	if AppSettings != nil {
+		allowed = AppSettings.AllowedFileTypes
+	}
+	for _, t := range allowed {
+		if t == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllowUserRandomPool 从内存缓存中安全地获取普通用户是否可以管理随机图池
+func GetAllowUserRandomPool() bool {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return false
+	}
+	return AppSettings.AllowUserRandomPool
+}
+
+// GetInstantUploadEnabled 从内存缓存中安全地获取是否允许按哈希秒传
+func GetInstantUploadEnabled() bool {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return false
+	}
+	return AppSettings.InstantUploadEnabled
+}
+
+// GetAllowSearchIndexing 从内存缓存中安全地获取是否允许搜索引擎收录
+func GetAllowSearchIndexing() bool {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return false
+	}
+	return AppSettings.AllowSearchIndexing
+}
+
+// GetReadOnlyMode 从内存缓存中安全地获取维护模式开关及提示信息
+func GetReadOnlyMode() (bool, string) {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return false, ""
+	}
+	message := AppSettings.ReadOnlyMessage
+	if message == "" {
+		message = defaultReadOnlyMessage
+	}
+	return AppSettings.ReadOnlyMode, message
+}
+
+// GetUploadRateLimitPerMinute 从内存缓存中安全地获取上传接口的每分钟请求上限
+func GetUploadRateLimitPerMinute() int {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return 0
+	}
+	return AppSettings.UploadRateLimitPerMinute
+}
+
+// GetImageRateLimitPerMinute 从内存缓存中安全地获取图片访问的每分钟请求上限
+func GetImageRateLimitPerMinute() int {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return 0
+	}
+	return AppSettings.ImageRateLimitPerMinute
+}
+
+// GetLoginRateLimitPerMinute 从内存缓存中安全地获取登录接口的每分钟请求上限
+func GetLoginRateLimitPerMinute() int {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return 10
+	}
+	return AppSettings.LoginRateLimitPerMinute
+}
+
+// GetRateLimitSettings 返回各接口当前生效的限流设置 (包括未保存到数据库的默认值)，键与设置项相同
+func GetRateLimitSettings() map[string]string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return map[string]string{}
+	}
+	return map[string]string{
+		"upload_rate_limit_per_minute": strconv.Itoa(AppSettings.UploadRateLimitPerMinute),
+		"random_rate_limit_per_minute": strconv.Itoa(AppSettings.RandomAPI.RateLimitPerMinute),
+		"image_rate_limit_per_minute":  strconv.Itoa(AppSettings.ImageRateLimitPerMinute),
+		"login_rate_limit_per_minute":  strconv.Itoa(AppSettings.LoginRateLimitPerMinute),
+	}
+}
+
+// GetRequireSignedURLs 从内存缓存中安全地获取图片访问是否必须携带签名
+func GetRequireSignedURLs() bool {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return false
+	}
+	return AppSettings.RequireSignedURLs
+}
+
+// GetDailyViewStats 从内存缓存中安全地获取是否按天记录访问次数
+func GetDailyViewStats() bool {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return true
+	}
+	return AppSettings.DailyViewStats
+}
+
+// GetGeoRules 从内存缓存中安全地获取按国家/地区选择后端的规则
+func GetGeoRules() []GeoRule {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return nil
+	}
+	return AppSettings.GeoRules
+}
+
+// GetPlaceholderEnabled 从内存缓存中安全地获取是否启用占位图
+func GetPlaceholderEnabled() bool {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return false
+	}
+	return AppSettings.PlaceholderEnabled
+}
+
+// GetMinReplicas 从内存缓存中安全地获取每张图片的最少副本数
+func GetMinReplicas() int {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return 0
+	}
+	return AppSettings.MinReplicas
+}
+
+// GetAsyncDistribution 从内存缓存中安全地获取是否启用异步分发
+func GetAsyncDistribution() bool {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return false
+	}
+	return AppSettings.AsyncDistribution
+}
+
+// GetUploaderInfoMode 从内存缓存中安全地获取上传者信息的记录方式
+func GetUploaderInfoMode() string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return UploaderInfoOff
+	}
+	return AppSettings.UploaderInfoMode
+}
+
+// GetModerationSettings 从内存缓存中安全地获取内容审核设置
+func GetModerationSettings() ModerationSettings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if AppSettings == nil {
+		return ModerationSettings{}
+	}
+	return AppSettings.Moderation
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrShareNotFound 分享链接不存在或不属于当前用户
+	ErrShareNotFound = errors.New("share link not found")
+	// ErrShareExpired 分享链接已过期
+	ErrShareExpired = errors.New("share link has expired")
+	// ErrSharePasswordRequired 分享链接设置了密码但请求没有提供
+	ErrSharePasswordRequired = errors.New("this share link requires a password")
+	// ErrSharePasswordInvalid 分享链接密码错误
+	ErrSharePasswordInvalid = errors.New("invalid share link password")
+)
+
+const (
+	// sharePasswordMaxFailures 统计窗口内允许的密码错误次数，超过后暂时锁定该分享链接
+	sharePasswordMaxFailures = 10
+	// sharePasswordWindow 统计密码错误次数的窗口，也是锁定的时长
+	sharePasswordWindow = 15 * time.Minute
+)
+
+// SharePasswordLockedError 分享链接因密码错误次数过多被暂时锁定
+type SharePasswordLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *SharePasswordLockedError) Error() string {
+	return fmt.Sprintf("too many wrong passwords for this share link, try again in %d minute(s)", int(e.RetryAfter.Minutes())+1)
+}
+
+// sharePasswordAttempts 某个分享链接在统计窗口内的密码错误次数
+type sharePasswordAttempts struct {
+	failures    int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+var (
+	// sharePasswordFailures 按分享链接 token 统计，不按 IP，防止换 IP 继续猜测
+	sharePasswordFailures   = make(map[string]*sharePasswordAttempts)
+	sharePasswordFailuresMu sync.Mutex
+)
+
+// ShareLinkInput 创建分享链接的参数，image_uuid 和 album_id 二选一
+type ShareLinkInput struct {
+	ImageUUID string `json:"image_uuid"`
+	AlbumID   uint   `json:"album_id"`
+	Password  string `json:"password"`
+	// ExpiresIn 有效期，格式与上传时的 expires_in 相同，为空表示永久有效
+	ExpiresIn string `json:"expires_in"`
+}
+
+// ShareLinkInfo 返回给所有者的分享链接信息
+type ShareLinkInfo struct {
+	database.ShareLink
+	URL         string `json:"url"`
+	HasPassword bool   `json:"has_password"`
+	ImageUUID   string `json:"image_uuid,omitempty"`
+}
+
+// CreateShareLink 为用户自己的图片或相册创建分享链接
+func CreateShareLink(userID uint, in ShareLinkInput) (*ShareLinkInfo, error) {
+	if (in.ImageUUID == "") == (in.AlbumID == 0) {
+		return nil, &UploadRejectedError{Reason: "Exactly one of image_uuid and album_id is required"}
+	}
+	expiresAt, err := ParseExpiresIn(in.ExpiresIn)
+	if err != nil {
+		return nil, err
+	}
+
+	link := database.ShareLink{UserID: userID, ExpiresAt: expiresAt}
+	if in.ImageUUID != "" {
+		var image database.Image
+		if err := database.DB.Select("id").Where("uuid = ? AND user_id = ?", in.ImageUUID, userID).First(&image).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrImageNotFound
+			}
+			return nil, err
+		}
+		link.ImageID = &image.ID
+	} else {
+		album, err := GetAlbum(in.AlbumID, userID)
+		if err != nil {
+			return nil, err
+		}
+		link.AlbumID = &album.ID
+	}
+	if in.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	link.Token = hex.EncodeToString(buf)
+	if err := database.DB.Create(&link).Error; err != nil {
+		return nil, err
+	}
+	return shareLinkInfo(link, in.ImageUUID), nil
+}
+
+// ListShareLinks 列出用户创建的所有分享链接，最新的在前
+func ListShareLinks(userID uint) ([]ShareLinkInfo, error) {
+	var links []database.ShareLink
+	if err := database.DB.Where("user_id = ?", userID).Order("created_at desc").Find(&links).Error; err != nil {
+		return nil, err
+	}
+
+	var imageIDs []uint
+	for _, link := range links {
+		if link.ImageID != nil {
+			imageIDs = append(imageIDs, *link.ImageID)
+		}
+	}
+	uuidByID := make(map[uint]string, len(imageIDs))
+	if len(imageIDs) > 0 {
+		var images []database.Image
+		if err := database.DB.Select("id", "uuid").Where("id IN ?", imageIDs).Find(&images).Error; err != nil {
+			return nil, err
+		}
+		for _, image := range images {
+			uuidByID[image.ID] = image.UUID
+		}
+	}
+
+	infos := make([]ShareLinkInfo, 0, len(links))
+	for _, link := range links {
+		imageUUID := ""
+		if link.ImageID != nil {
+			imageUUID = uuidByID[*link.ImageID]
+		}
+		infos = append(infos, *shareLinkInfo(link, imageUUID))
+	}
+	return infos, nil
+}
+
+// DeleteShareLink 删除用户自己的分享链接
+func DeleteShareLink(id, userID uint) error {
+	result := database.DB.Where("id = ? AND user_id = ?", id, userID).Delete(&database.ShareLink{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// ResolveShareLink 校验分享链接的有效期和密码，成功时记录一次访问
+func ResolveShareLink(token, password string) (*database.ShareLink, error) {
+	var link database.ShareLink
+	if err := database.DB.Where("token = ?", token).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+		return nil, ErrShareExpired
+	}
+	if link.PasswordHash != "" {
+		if password == "" {
+			return nil, ErrSharePasswordRequired
+		}
+		// 锁定期间不再比较密码，既阻止猜测也避免 bcrypt 消耗 CPU
+		if err := checkSharePasswordLockout(token); err != nil {
+			return nil, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			recordSharePasswordFailure(token)
+			return nil, ErrSharePasswordInvalid
+		}
+	}
+	database.DB.Model(&link).UpdateColumn("views", gorm.Expr("views + 1"))
+	return &link, nil
+}
+
+// checkSharePasswordLockout 分享链接处于锁定期时返回 *SharePasswordLockedError
+func checkSharePasswordLockout(token string) error {
+	sharePasswordFailuresMu.Lock()
+	defer sharePasswordFailuresMu.Unlock()
+	if a, ok := sharePasswordFailures[token]; ok {
+		if wait := time.Until(a.lockedUntil); wait > 0 {
+			return &SharePasswordLockedError{RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// recordSharePasswordFailure 记录一次密码错误，窗口内达到上限时锁定该分享链接
+func recordSharePasswordFailure(token string) {
+	now := time.Now()
+	sharePasswordFailuresMu.Lock()
+	defer sharePasswordFailuresMu.Unlock()
+	// 清理窗口已过且不在锁定期的记录，避免表无限增长
+	for key, a := range sharePasswordFailures {
+		if now.Sub(a.first) > sharePasswordWindow && now.After(a.lockedUntil) {
+			delete(sharePasswordFailures, key)
+		}
+	}
+	a, ok := sharePasswordFailures[token]
+	if !ok {
+		a = &sharePasswordAttempts{first: now}
+		sharePasswordFailures[token] = a
+	}
+	a.failures++
+	if a.failures >= sharePasswordMaxFailures {
+		a.lockedUntil = now.Add(sharePasswordWindow)
+		a.failures = 0
+		a.first = now
+	}
+}
+
+// SharedImageUUID 返回图片分享链接指向的图片 UUID
+func SharedImageUUID(link *database.ShareLink) (string, error) {
+	var image database.Image
+	if err := database.DB.Select("uuid").First(&image, *link.ImageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrShareNotFound
+		}
+		return "", err
+	}
+	return image.UUID, nil
+}
+
+// SharedAlbum 返回相册分享链接指向的相册
+func SharedAlbum(link *database.ShareLink) (*database.Album, error) {
+	var album database.Album
+	if err := database.DB.First(&album, *link.AlbumID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	return &album, nil
+}
+
+func shareLinkInfo(link database.ShareLink, imageUUID string) *ShareLinkInfo {
+	return &ShareLinkInfo{
+		ShareLink:   link,
+		URL:         config.WithBasePath("/s/" + link.Token),
+		HasPassword: link.PasswordHash != "",
+		ImageUUID:   imageUUID,
+	}
+}
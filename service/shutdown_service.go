@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// shutdownPollInterval 关闭服务时检查后台任务是否结束的间隔
+const shutdownPollInterval = 500 * time.Millisecond
+
+// Shutdown 在 HTTP 服务停止后调用：等待运行中的后台任务结束 (直到 ctx 到期)，
+// 把仍未结束的任务标记为中断，并写入缓冲中的访问次数和流量统计
+func Shutdown(ctx context.Context) {
+	if !waitForTasks(ctx) {
+		interruptRunningTasks()
+	}
+	FlushImageViews()
+	FlushBandwidthUsage()
+	log.Println("Buffered statistics flushed.")
+}
+
+// waitForTasks 等待所有后台任务结束，ctx 到期前仍有任务在运行时返回 false
+func waitForTasks(ctx context.Context) bool {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	logged := false
+	for {
+		running := runningTaskCount()
+		if running == 0 {
+			return true
+		}
+		if !logged {
+			log.Printf("Waiting for %d background task(s) to finish...", running)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// runningTaskCount 返回仍在运行的后台任务数量
+func runningTaskCount() int {
+	taskMu.Lock()
+	defer taskMu.Unlock()
+	count := 0
+	for _, task := range tasks {
+		if task.Status == "running" {
+			count++
+		}
+	}
+	return count
+}
+
+// interruptRunningTasks 把未能在关闭前完成的任务标记为失败并记录日志
+func interruptRunningTasks() {
+	taskMu.Lock()
+	var interrupted []*Task
+	for _, task := range tasks {
+		if task.Status == "running" {
+			interrupted = append(interrupted, task)
+		}
+	}
+	taskMu.Unlock()
+	for _, task := range interrupted {
+		updateTask(task, func(t *Task) {
+			t.Status = "failed"
+			t.Message = "服务关闭时任务尚未完成，已中断"
+		})
+		log.Printf("Task %s (%s) interrupted by shutdown at %d/%d.", task.ID, task.Type, task.Progress, task.Total)
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+)
+
+var (
+	// ErrSignatureRequired 开启 require_signed_urls 后访问图片没有携带签名
+	ErrSignatureRequired = errors.New("a signed URL is required to access this image")
+	// ErrSignatureInvalid 签名与图片或过期时间不匹配
+	ErrSignatureInvalid = errors.New("invalid image URL signature")
+	// ErrSignatureExpired 签名已过期
+	ErrSignatureExpired = errors.New("signed image URL has expired")
+)
+
+const (
+	// defaultSignedURLTTL 未指定 expires_in 时签名 URL 的有效期
+	defaultSignedURLTTL = time.Hour
+	// maxSignedURLTTL 签名 URL 的最长有效期
+	maxSignedURLTTL = 7 * 24 * time.Hour
+	// MaxSignBatch 一次最多签名的图片数
+	MaxSignBatch = 1000
+)
+
+// SignedURLs 批量签名的结果
+type SignedURLs struct {
+	ExpiresAt time.Time         `json:"expires_at"`
+	URLs      map[string]string `json:"urls"`    // UUID -> 带签名的访问地址
+	Missing   []string          `json:"missing"` // 不存在或无权访问的 UUID
+}
+
+// imageSignature 计算图片 UUID 在某个过期时间下的签名
+// 密钥由 JWT 密钥派生，加上前缀避免与其他用途的签名混用
+func imageSignature(uuid string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte("image-url:"+config.Cfg.JWT.Secret))
+	fmt.Fprintf(mac, "%s:%d", uuid, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedImagePath 返回带签名和过期时间的图片访问路径，contentType 决定扩展名 (见 ImageViewPath)
+func SignedImagePath(uuid, contentType string, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", imageSignature(uuid, expires))
+	return ImageViewPath(uuid, contentType) + "?" + query.Encode()
+}
+
+// CheckImageSignature 校验图片请求携带的签名
+// 没有携带签名时，只有开启 require_signed_urls 才拒绝访问
+func CheckImageSignature(uuid, expiresParam, sig string) error {
+	if expiresParam == "" && sig == "" {
+		if GetRequireSignedURLs() {
+			return ErrSignatureRequired
+		}
+		return nil
+	}
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	if !hmac.Equal([]byte(sig), []byte(imageSignature(uuid, expires))) {
+		return ErrSignatureInvalid
+	}
+	if time.Now().Unix() > expires {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+// SignImageURLs 为一批图片生成有效期为 ttl 的签名地址，普通用户只能签名自己的图片
+func SignImageURLs(imageUUIDs []string, ttl time.Duration, userID uint, userRole string) (*SignedURLs, error) {
+	if len(imageUUIDs) == 0 {
+		return nil, &UploadRejectedError{Reason: "uuids is required"}
+	}
+	if len(imageUUIDs) > MaxSignBatch {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("At most %d images can be signed per request", MaxSignBatch)}
+	}
+	if ttl <= 0 {
+		ttl = defaultSignedURLTTL
+	}
+	if ttl > maxSignedURLTTL {
+		return nil, &UploadRejectedError{Reason: "expires_in must not exceed 7 days"}
+	}
+
+	query := database.DB.Model(&database.Image{}).Where("uuid IN ?", imageUUIDs)
+	if !CanAccessAllImages(userRole) {
+		query = query.Where("user_id = ?", userID)
+	}
+	var found []database.Image
+	if err := query.Select("uuid", "content_type").Find(&found).Error; err != nil {
+		return nil, err
+	}
+
+	result := &SignedURLs{
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+		URLs:      make(map[string]string, len(found)),
+		Missing:   []string{},
+	}
+	for _, image := range found {
+		result.URLs[image.UUID] = SignedImagePath(image.UUID, image.ContentType, result.ExpiresAt)
+	}
+	for _, uuid := range imageUUIDs {
+		if _, ok := result.URLs[uuid]; !ok {
+			result.Missing = append(result.Missing, uuid)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,18 @@
+package service
+
+import "time"
+
+// SitemapEntry 站点地图中的一个地址
+type SitemapEntry struct {
+	Path    string // 站点内的路径，例如 "/"
+	LastMod time.Time
+}
+
+// ListSitemapEntries 返回可以被搜索引擎收录的公开页面，关闭收录时返回空列表
+// 图片本身不在站点地图中，只列出公开的页面
+func ListSitemapEntries() []SitemapEntry {
+	if !GetAllowSearchIndexing() {
+		return nil
+	}
+	return []SitemapEntry{{Path: "/"}}
+}
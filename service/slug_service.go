@@ -0,0 +1,136 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrSlugNotFound 短链接不存在
+	ErrSlugNotFound = errors.New("slug not found")
+	// ErrSlugTaken 短链接已被其他图片使用
+	ErrSlugTaken = errors.New("slug is already in use")
+)
+
+// slugPattern 短链接只允许小写字母、数字、- 和 _，以字母或数字开头
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ImageSlugInfo 返回给所有者的短链接信息
+type ImageSlugInfo struct {
+	Slug      string `json:"slug"`
+	ImageUUID string `json:"image_uuid"`
+	URL       string `json:"url"`
+}
+
+// NormalizeSlug 把短链接转为小写并校验格式
+func NormalizeSlug(slug string) (string, error) {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if !slugPattern.MatchString(slug) {
+		return "", &UploadRejectedError{Reason: "Slug must be 1-64 characters of lowercase letters, digits, '-' or '_', starting with a letter or digit"}
+	}
+	return slug, nil
+}
+
+// slugImage 查找要设置短链接的图片，普通用户只能操作自己的图片
+func slugImage(imageUUID string, userID uint, userRole string) (*database.Image, error) {
+	var image database.Image
+	if err := database.DB.Select("id", "uuid", "user_id").Where("uuid = ?", imageUUID).First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, err
+	}
+	if !CanAccessAllImages(userRole) && image.UserID != userID {
+		return nil, ErrNotImageOwner
+	}
+	return &image, nil
+}
+
+// SetImageSlug 为图片设置自定义短链接，图片已有短链接时替换为新的
+func SetImageSlug(imageUUID, slug string, userID uint, userRole string) (*ImageSlugInfo, error) {
+	slug, err := NormalizeSlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	image, err := slugImage(imageUUID, userID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var taken database.ImageSlug
+		if err := tx.Where("slug = ?", slug).First(&taken).Error; err == nil {
+			if taken.ImageID != image.ID {
+				return ErrSlugTaken
+			}
+			return nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var existing database.ImageSlug
+		if err := tx.Where("image_id = ?", image.ID).First(&existing).Error; err == nil {
+			return tx.Model(&existing).Update("slug", slug).Error
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return tx.Create(&database.ImageSlug{Slug: slug, ImageID: image.ID, UserID: image.UserID}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ImageSlugInfo{Slug: slug, ImageUUID: image.UUID, URL: config.WithBasePath("/p/" + slug)}, nil
+}
+
+// GetImageSlug 返回图片的短链接，没有设置时返回 ErrSlugNotFound
+func GetImageSlug(imageUUID string, userID uint, userRole string) (*ImageSlugInfo, error) {
+	image, err := slugImage(imageUUID, userID, userRole)
+	if err != nil {
+		return nil, err
+	}
+	var row database.ImageSlug
+	if err := database.DB.Where("image_id = ?", image.ID).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSlugNotFound
+		}
+		return nil, err
+	}
+	return &ImageSlugInfo{Slug: row.Slug, ImageUUID: image.UUID, URL: config.WithBasePath("/p/" + row.Slug)}, nil
+}
+
+// RemoveImageSlug 删除图片的短链接
+func RemoveImageSlug(imageUUID string, userID uint, userRole string) error {
+	image, err := slugImage(imageUUID, userID, userRole)
+	if err != nil {
+		return err
+	}
+	result := database.DB.Where("image_id = ?", image.ID).Delete(&database.ImageSlug{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSlugNotFound
+	}
+	return nil
+}
+
+// ResolveImageSlug 返回短链接对应的图片 UUID，大小写不敏感
+func ResolveImageSlug(slug string) (string, error) {
+	var image database.Image
+	err := database.DB.Model(&database.Image{}).Select("images.uuid").
+		Joins("JOIN image_slugs ON image_slugs.image_id = images.id").
+		Where("image_slugs.slug = ?", strings.ToLower(slug)).
+		First(&image).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrSlugNotFound
+		}
+		return "", err
+	}
+	return image.UUID, nil
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/util"
+)
+
+// 存储对象名 (后端中的文件名) 的生成策略
+const (
+	FilenameStrategyUUID     = "uuid"     // <uuid>.<ext>，默认
+	FilenameStrategyOriginal = "original" // 原始文件名
+	FilenameStrategyDate     = "date"     // <yyyymmdd>_<原始文件名>
+	FilenameStrategyHash     = "hash"     // <sha256>.<ext>
+)
+
+// maxStorageKeyStem 原始文件名主体部分的最大长度 (按字符计)
+const maxStorageKeyStem = 100
+
+// storageKeyMu 保证检查对象名冲突和写入图片记录之间不会被并发上传插入相同的名字
+var storageKeyMu sync.Mutex
+
+// NormalizeFilenameStrategy 校验文件名策略，空字符串表示使用默认值
+func NormalizeFilenameStrategy(strategy string) (string, error) {
+	strategy = strings.ToLower(strings.TrimSpace(strategy))
+	switch strategy {
+	case "", FilenameStrategyUUID, FilenameStrategyOriginal, FilenameStrategyDate, FilenameStrategyHash:
+		return strategy, nil
+	}
+	return "", &UploadRejectedError{Reason: fmt.Sprintf("Unknown filename strategy '%s'", strategy)}
+}
+
+// storageKeyFor 返回图片在后端中的对象名，引入文件名策略之前的图片使用 UUID 命名
+func storageKeyFor(image *database.Image) string {
+	if image.StorageKey != "" {
+		return image.StorageKey
+	}
+	return fmt.Sprintf("%s%s", image.UUID, filepath.Ext(image.OriginalFilename))
+}
+
+// createImageWithStorageKey 按策略为新图片生成不冲突的对象名并写入图片记录
+// 与已有图片的对象名冲突时在文件名后追加 UUID 前缀，避免覆盖后端中的其他文件
+func createImageWithStorageKey(image *database.Image, strategy string, digest *util.FileDigest) error {
+	ext := strings.ToLower(filepath.Ext(image.OriginalFilename))
+	var stem string
+	switch strategy {
+	case FilenameStrategyOriginal:
+		stem = sanitizeStorageStem(image.OriginalFilename)
+	case FilenameStrategyDate:
+		stem = time.Now().Format("20060102") + "_" + sanitizeStorageStem(image.OriginalFilename)
+	case FilenameStrategyHash:
+		stem = digest.SHA256
+	default:
+		stem = image.UUID
+	}
+
+	storageKeyMu.Lock()
+	defer storageKeyMu.Unlock()
+
+	image.StorageKey = stem + ext
+	if stem != image.UUID {
+		var count int64
+		database.DB.Model(&database.Image{}).Where("storage_key = ?", image.StorageKey).Count(&count)
+		if count > 0 {
+			image.StorageKey = fmt.Sprintf("%s-%s%s", stem, image.UUID[:8], ext)
+		}
+	}
+	return database.DB.Create(image).Error
+}
+
+// sanitizeStorageStem 去掉文件名的扩展名和路径，只保留字母、数字和 "-_."，其他字符替换为 "_"
+func sanitizeStorageStem(filename string) string {
+	base := filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	var b strings.Builder
+	count := 0
+	for _, r := range base {
+		if count >= maxStorageKeyStem {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+		count++
+	}
+	stem := strings.Trim(b.String(), ".")
+	if stem == "" {
+		stem = "image"
+	}
+	return stem
+}
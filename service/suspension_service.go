@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"yanshu-imgbed/database"
+)
+
+var (
+	// ErrUserSuspended 账户已被管理员停用
+	ErrUserSuspended = errors.New("账户已被停用")
+	// ErrSuspendSelf 管理员不能停用自己的账户
+	ErrSuspendSelf = errors.New("不能停用自己的账户")
+)
+
+// suspendedUsers 被停用用户的 ID，认证中间件每个请求都要检查，所以缓存在内存中
+var (
+	suspendedUsers   = make(map[uint]bool)
+	suspendedUsersMu sync.RWMutex
+)
+
+// InitSuspendedUsers 在程序启动时加载被停用的用户
+func InitSuspendedUsers() {
+	var ids []uint
+	if err := database.DB.Model(&database.User{}).Where("is_active = ?", false).Pluck("id", &ids).Error; err != nil {
+		log.Printf("Failed to load suspended users: %v", err)
+		return
+	}
+	suspendedUsersMu.Lock()
+	defer suspendedUsersMu.Unlock()
+	suspendedUsers = make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		suspendedUsers[id] = true
+	}
+}
+
+// IsUserSuspended 判断用户是否已被停用
+func IsUserSuspended(userID uint) bool {
+	suspendedUsersMu.RLock()
+	defer suspendedUsersMu.RUnlock()
+	return suspendedUsers[userID]
+}
+
+// SetUserActive 启用或停用用户 (管理员)。停用后该用户无法登录，已签发的 JWT 和 API Token 立即失效，
+// 其私有图片也不再对外提供
+func SetUserActive(userID, operatorID uint, active bool) (*database.User, error) {
+	if !active && userID == operatorID {
+		return nil, ErrSuspendSelf
+	}
+	var user database.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	user.IsActive = active
+	if err := database.DB.Model(&user).Update("is_active", active).Error; err != nil {
+		return nil, err
+	}
+
+	suspendedUsersMu.Lock()
+	if active {
+		delete(suspendedUsers, userID)
+	} else {
+		suspendedUsers[userID] = true
+	}
+	suspendedUsersMu.Unlock()
+	return &user, nil
+}
+
+// forgetSuspendedUser 用户被删除后移出停用缓存
+func forgetSuspendedUser(userID uint) {
+	suspendedUsersMu.Lock()
+	delete(suspendedUsers, userID)
+	suspendedUsersMu.Unlock()
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxTagLength 与 Tag.Name 的列宽一致
+	maxTagLength = 50
+	// maxTagsPerRequest 一次最多添加或移除的标签数
+	maxTagsPerRequest = 20
+	// maxTagSuggestions 标签自动补全最多返回的条数
+	maxTagSuggestions = 20
+)
+
+// TagSuggestion 标签自动补全的一项
+type TagSuggestion struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"` // 带有该标签的图片数 (普通用户只统计自己的图片)
+}
+
+// NormalizeTags 去掉首尾空白、统一为小写并去重，空标签会被忽略
+func NormalizeTags(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	var tags []string
+	for _, name := range names {
+		tag := strings.ToLower(strings.TrimSpace(name))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, &UploadRejectedError{Reason: fmt.Sprintf("Tag must not exceed %d characters: %s", maxTagLength, tag)}
+		}
+		if strings.ContainsAny(tag, ",/") {
+			return nil, &UploadRejectedError{Reason: fmt.Sprintf("Tag must not contain ',' or '/': %s", tag)}
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil, &UploadRejectedError{Reason: "At least one tag is required"}
+	}
+	if len(tags) > maxTagsPerRequest {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("At most %d tags can be changed at once", maxTagsPerRequest)}
+	}
+	return tags, nil
+}
+
+// taggableImageIDs 返回可以由该用户修改标签的图片 ID，普通用户只能修改自己的图片
+func taggableImageIDs(imageUUIDs []string, userID uint, userRole string) ([]uint, error) {
+	query := database.DB.Model(&database.Image{}).Where("uuid IN ?", imageUUIDs)
+	if !CanAccessAllImages(userRole) {
+		query = query.Where("user_id = ?", userID)
+	}
+	var ids []uint
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	if len(ids) != len(imageUUIDs) {
+		if !CanAccessAllImages(userRole) {
+			return nil, ErrNotImageOwner
+		}
+		return nil, ErrImageNotFound
+	}
+	return ids, nil
+}
+
+// AddImageTags 给一批图片添加标签，不存在的标签会自动创建
+func AddImageTags(imageUUIDs []string, names []string, userID uint, userRole string) error {
+	tags, err := NormalizeTags(names)
+	if err != nil {
+		return err
+	}
+	imageIDs, err := taggableImageIDs(imageUUIDs, userID, userRole)
+	if err != nil {
+		return err
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		tagIDs, err := ensureTags(tx, tags)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		links := make([]database.ImageTag, 0, len(imageIDs)*len(tagIDs))
+		for _, imageID := range imageIDs {
+			for _, tagID := range tagIDs {
+				links = append(links, database.ImageTag{ImageID: imageID, TagID: tagID, CreatedAt: now})
+			}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&links, 500).Error
+	})
+}
+
+// ensureTags 创建尚不存在的标签，返回所有标签的 ID
+func ensureTags(tx *gorm.DB, tags []string) ([]uint, error) {
+	records := make([]database.Tag, 0, len(tags))
+	for _, name := range tags {
+		records = append(records, database.Tag{Name: name})
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&records).Error; err != nil {
+		return nil, err
+	}
+	var tagIDs []uint
+	err := tx.Model(&database.Tag{}).Where("name IN ?", tags).Pluck("id", &tagIDs).Error
+	return tagIDs, err
+}
+
+// replaceImageTags 把图片的标签替换为 tags (已规范化)，tags 为空时清空
+func replaceImageTags(tx *gorm.DB, imageID uint, tags []string) error {
+	if err := tx.Where("image_id = ?", imageID).Delete(&database.ImageTag{}).Error; err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	tagIDs, err := ensureTags(tx, tags)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	links := make([]database.ImageTag, 0, len(tagIDs))
+	for _, tagID := range tagIDs {
+		links = append(links, database.ImageTag{ImageID: imageID, TagID: tagID, CreatedAt: now})
+	}
+	return tx.Create(&links).Error
+}
+
+// RemoveImageTags 从一批图片上移除标签
+func RemoveImageTags(imageUUIDs []string, names []string, userID uint, userRole string) error {
+	tags, err := NormalizeTags(names)
+	if err != nil {
+		return err
+	}
+	imageIDs, err := taggableImageIDs(imageUUIDs, userID, userRole)
+	if err != nil {
+		return err
+	}
+	tagIDs := database.DB.Model(&database.Tag{}).Select("id").Where("name IN ?", tags)
+	return database.DB.Where("image_id IN ? AND tag_id IN (?)", imageIDs, tagIDs).Delete(&database.ImageTag{}).Error
+}
+
+// SuggestTags 按前缀查找已使用的标签，按使用次数降序
+func SuggestTags(prefix string, userID uint, userRole string) ([]TagSuggestion, error) {
+	query := database.DB.Table("tags").
+		Select("tags.name AS name, COUNT(image_tags.image_id) AS count").
+		Joins("JOIN image_tags ON image_tags.tag_id = tags.id")
+	if !CanAccessAllImages(userRole) {
+		query = query.Joins("JOIN images ON images.id = image_tags.image_id").Where("images.user_id = ?", userID)
+	}
+	if prefix = strings.ToLower(strings.TrimSpace(prefix)); prefix != "" {
+		query = query.Where(`tags.name LIKE ? ESCAPE '!'`, escapeLike(prefix)+"%")
+	}
+
+	suggestions := []TagSuggestion{}
+	err := query.Group("tags.id, tags.name").Order("count desc, tags.name asc").Limit(maxTagSuggestions).Scan(&suggestions).Error
+	return suggestions, err
+}
+
+// withAllTags 限定查询只返回同时带有所有指定标签的图片
+func withAllTags(query *gorm.DB, tags []string) *gorm.DB {
+	matching := database.DB.Table("image_tags").
+		Select("image_tags.image_id").
+		Joins("JOIN tags ON tags.id = image_tags.tag_id").
+		Where("tags.name IN ?", tags).
+		Group("image_tags.image_id").
+		Having("COUNT(DISTINCT tags.id) = ?", len(tags))
+	return query.Where("images.id IN (?)", matching)
+}
+
+// GetRandomImageUUIDByTag 从随机图库中带有该标签的图片里随机选一张
+func GetRandomImageUUIDByTag(tag string) (string, error) {
+	query := database.DB.Model(&database.Image{}).
+		Where("allow_random = ? AND (moderation_status IS NULL OR moderation_status <> ?)", true, ModerationQuarantined).
+		Where("visibility <> ?", VisibilityPrivate)
+	var uuids []string
+	err := withAllTags(query, []string{strings.ToLower(strings.TrimSpace(tag))}).
+		Order("RANDOM()").Limit(1).Pluck("uuid", &uuids).Error
+	if err != nil {
+		return "", err
+	}
+	if len(uuids) == 0 {
+		return "", errors.New("no images with this tag available in the random pool")
+	}
+	return uuids[0], nil
+}
+
+// escapeLike 转义 LIKE 模式中的通配符，配合 ESCAPE '!' 使用。
+// 不用反斜杠作转义符，因为 MySQL 字符串字面量中的反斜杠本身也是转义符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"yanshu-imgbed/config"
+
+	"github.com/google/uuid"
+)
+
+var (
+	tasks  = make(map[string]*Task)
+	taskMu sync.Mutex
+	// taskSubscribers 订阅任务变化的连接 (SSE)，由 taskMu 保护
+	taskSubscribers = make(map[*TaskSubscription]bool)
+	// taskStreamsDone 服务关闭时关闭，通知所有订阅结束
+	taskStreamsDone      = make(chan struct{})
+	closeTaskStreamsOnce sync.Once
+)
+
+const (
+	// taskRateWindow 计算吞吐量和剩余时间时参考的最近进度时间窗口
+	taskRateWindow = time.Minute
+	// maxTaskItemErrors 每个任务保留的最近单项失败记录数
+	maxTaskItemErrors = 100
+)
+
+type Task struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"` // "running", "completed", "failed"
+	Progress   int        `json:"progress"`
+	Total      int        `json:"total"`
+	Message    string     `json:"message"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// ProcessedBytes 已传输的字节数，只有涉及文件传输的任务会填写
+	ProcessedBytes int64   `json:"processed_bytes"`
+	ItemsPerSec    float64 `json:"items_per_sec"`
+	BytesPerSec    float64 `json:"bytes_per_sec"`
+	// ETASeconds 按最近的处理速度估算的剩余秒数，速度未知时为空
+	ETASeconds *int64 `json:"eta_seconds,omitempty"`
+	// Errors 最近失败的条目 (最多 maxTaskItemErrors 条)，ErrorCount 为失败总数
+	Errors     []TaskItemError `json:"errors"`
+	ErrorCount int             `json:"error_count"`
+
+	history []taskSample // 最近的进度记录，用于计算速度
+}
+
+// TaskItemError 任务中单个条目的失败原因
+type TaskItemError struct {
+	Item  string    `json:"item"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// taskSample 某一时刻的任务进度
+type taskSample struct {
+	at       time.Time
+	progress int
+	bytes    int64
+}
+
+// TaskFilter 任务列表的筛选与分页参数
+type TaskFilter struct {
+	Status   string // 为空时不按状态筛选
+	Order    string // "asc" 按创建时间升序，其他值为降序
+	Page     int
+	PageSize int
+}
+
+// ListTasksResponse 分页的任务列表
+type ListTasksResponse struct {
+	Total    int     `json:"total"`
+	Page     int     `json:"page"`
+	PageSize int     `json:"pageSize"`
+	Tasks    []*Task `json:"tasks"`
+}
+
+// newTask 创建并登记一个运行中的后台任务
+func newTask(taskType string, total int) *Task {
+	task := &Task{
+		ID: uuid.New().String(), Type: taskType, Status: "running",
+		Total: total, CreatedAt: time.Now(), Errors: []TaskItemError{},
+	}
+	task.history = []taskSample{{at: task.CreatedAt}}
+	taskMu.Lock()
+	pruneTasksLocked()
+	tasks[task.ID] = task
+	notifyTaskSubscribersLocked(task.ID)
+	taskMu.Unlock()
+	return task
+}
+
+// updateTask 在持有锁的情况下修改任务状态，任务离开 running 状态时记录结束时间
+// 任务结束时发送 task.completed 事件
+func updateTask(task *Task, fn func(t *Task)) {
+	taskMu.Lock()
+	fn(task)
+	finished := task.Status != "running" && task.FinishedAt == nil
+	if finished {
+		now := time.Now()
+		task.FinishedAt = &now
+	}
+	task.recordProgressLocked()
+	notifyTaskSubscribersLocked(task.ID)
+	var data map[string]interface{}
+	if finished {
+		data = map[string]interface{}{
+			"id": task.ID, "type": task.Type, "status": task.Status, "message": task.Message,
+			"progress": task.Progress, "total": task.Total, "error_count": task.ErrorCount,
+			"created_at": task.CreatedAt, "finished_at": task.FinishedAt,
+		}
+	}
+	taskMu.Unlock()
+	if finished {
+		EmitWebhookEvent(WebhookTaskCompleted, data)
+	}
+}
+
+// recordTaskItemError 记录任务中某个条目的失败原因，只保留最近 maxTaskItemErrors 条。
+// 丢弃旧记录时只重新切片而不移动元素，已经取出的任务快照不受影响
+func recordTaskItemError(task *Task, item string, err error) {
+	updateTask(task, func(t *Task) {
+		t.ErrorCount++
+		if len(t.Errors) >= maxTaskItemErrors {
+			t.Errors = t.Errors[len(t.Errors)-maxTaskItemErrors+1:]
+		}
+		t.Errors = append(t.Errors, TaskItemError{Item: item, Error: err.Error(), Time: time.Now()})
+	})
+}
+
+// recordProgressLocked 记录一次进度并根据时间窗口内的变化更新速度和剩余时间，调用方需持有 taskMu
+func (t *Task) recordProgressLocked() {
+	if t.Status != "running" {
+		t.ItemsPerSec, t.BytesPerSec, t.ETASeconds = 0, 0, nil
+		t.history = nil
+		return
+	}
+
+	now := time.Now()
+	t.history = append(t.history, taskSample{at: now, progress: t.Progress, bytes: t.ProcessedBytes})
+	// 保留窗口内的记录，以及窗口外最近的一条作为起点
+	cutoff := now.Add(-taskRateWindow)
+	drop := 0
+	for drop < len(t.history)-2 && t.history[drop+1].at.Before(cutoff) {
+		drop++
+	}
+	t.history = t.history[drop:]
+
+	oldest := t.history[0]
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	t.ItemsPerSec = float64(t.Progress-oldest.progress) / elapsed
+	t.BytesPerSec = float64(t.ProcessedBytes-oldest.bytes) / elapsed
+	if t.ItemsPerSec > 0 && t.Total > t.Progress {
+		eta := int64(float64(t.Total-t.Progress) / t.ItemsPerSec)
+		t.ETASeconds = &eta
+	} else {
+		t.ETASeconds = nil
+	}
+}
+
+// ListTasks 按条件筛选任务并按创建时间排序分页
+// 返回的是任务的快照，避免调用方在序列化时与后台任务并发读写
+func ListTasks(filter TaskFilter) *ListTasksResponse {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 100 {
+		filter.PageSize = 20
+	}
+
+	taskMu.Lock()
+	pruneTasksLocked()
+	matched := make([]*Task, 0, len(tasks))
+	for _, task := range tasks {
+		if filter.Status != "" && task.Status != filter.Status {
+			continue
+		}
+		snapshot := *task
+		matched = append(matched, &snapshot)
+	}
+	taskMu.Unlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		if filter.Order == "asc" {
+			return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+		}
+		return matched[i].CreatedAt.After(matched[j].CreatedAt)
+	})
+
+	response := &ListTasksResponse{Total: len(matched), Page: filter.Page, PageSize: filter.PageSize, Tasks: []*Task{}}
+	start := (filter.Page - 1) * filter.PageSize
+	if start < len(matched) {
+		end := start + filter.PageSize
+		if end > len(matched) {
+			end = len(matched)
+		}
+		response.Tasks = matched[start:end]
+	}
+	return response
+}
+
+// GetTask 返回任务当前状态的快照
+func GetTask(id string) (Task, bool) {
+	taskMu.Lock()
+	defer taskMu.Unlock()
+	task, ok := tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	return *task, true
+}
+
+// TaskSubscription 订阅任务的创建和进度变化。C 收到通知后调用 Changed 取出变化的任务，
+// 多次变化会合并为一次通知，慢速的订阅者不会阻塞任务
+type TaskSubscription struct {
+	C      <-chan struct{}
+	notify chan struct{}
+	dirty  map[string]bool
+}
+
+// SubscribeTasks 开始订阅任务变化，使用完毕后必须调用 Close
+func SubscribeTasks() *TaskSubscription {
+	notify := make(chan struct{}, 1)
+	sub := &TaskSubscription{C: notify, notify: notify, dirty: make(map[string]bool)}
+	taskMu.Lock()
+	taskSubscribers[sub] = true
+	taskMu.Unlock()
+	return sub
+}
+
+// Changed 返回上次调用以来有变化的任务快照，按创建时间升序
+func (s *TaskSubscription) Changed() []Task {
+	taskMu.Lock()
+	changed := make([]Task, 0, len(s.dirty))
+	for id := range s.dirty {
+		if task, ok := tasks[id]; ok {
+			changed = append(changed, *task)
+		}
+	}
+	s.dirty = make(map[string]bool)
+	taskMu.Unlock()
+	sort.Slice(changed, func(i, j int) bool { return changed[i].CreatedAt.Before(changed[j].CreatedAt) })
+	return changed
+}
+
+// Close 取消订阅
+func (s *TaskSubscription) Close() {
+	taskMu.Lock()
+	delete(taskSubscribers, s)
+	taskMu.Unlock()
+}
+
+// notifyTaskSubscribersLocked 标记任务有变化并通知所有订阅者，调用方需持有 taskMu
+func notifyTaskSubscribersLocked(id string) {
+	for sub := range taskSubscribers {
+		sub.dirty[id] = true
+		select {
+		case sub.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// TaskStreamsDone 返回服务关闭时会被关闭的通道，长连接据此结束，避免拖住优雅关闭
+func TaskStreamsDone() <-chan struct{} {
+	return taskStreamsDone
+}
+
+// CloseTaskStreams 通知所有任务订阅连接结束，可重复调用
+func CloseTaskStreams() {
+	closeTaskStreamsOnce.Do(func() { close(taskStreamsDone) })
+}
+
+// pruneTasksLocked 清理结束时间超过保留期的任务，调用方需持有 taskMu
+func pruneTasksLocked() {
+	retention := time.Duration(config.Cfg.Tasks.RetentionHours) * time.Hour
+	if retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-retention)
+	for id, task := range tasks {
+		if task.FinishedAt != nil && task.FinishedAt.Before(cutoff) {
+			delete(tasks, id)
+		}
+	}
+}
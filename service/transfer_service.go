@@ -0,0 +1,87 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+// 转移操作的参数错误
+var (
+	ErrTargetUserNotFound = errors.New("target user not found")
+	ErrSameTransferUser   = errors.New("source and target user are the same")
+)
+
+// TransferResult 图片所有权转移的结果
+type TransferResult struct {
+	Transferred int      `json:"transferred"`
+	Skipped     []string `json:"skipped"` // 目标用户已拥有相同文件 (MD5) 的图片 UUID
+}
+
+// TransferImages 将选中的图片转移给另一个用户，存储位置保持不变
+// 目标用户已有相同 MD5 的图片会被跳过，以满足 (md5, user_id) 唯一约束
+func TransferImages(imageUUIDs []string, targetUserID uint) (*TransferResult, error) {
+	return transferImagesWhere(database.DB.Where("uuid IN ?", imageUUIDs), targetUserID)
+}
+
+// TransferAllImages 将某个用户的全部图片转移给另一个用户
+func TransferAllImages(fromUserID, targetUserID uint) (*TransferResult, error) {
+	if fromUserID == targetUserID {
+		return nil, ErrSameTransferUser
+	}
+	// 直接按 user_id 查询，避免大账号的 UUID 列表超出 SQLite 的参数数量限制
+	return transferImagesWhere(database.DB.Where("user_id = ?", fromUserID), targetUserID)
+}
+
+// transferImagesWhere 将 query 选中的图片转移给目标用户
+func transferImagesWhere(query *gorm.DB, targetUserID uint) (*TransferResult, error) {
+	var target database.User
+	if err := database.DB.First(&target, targetUserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTargetUserNotFound
+		}
+		return nil, err
+	}
+
+	var images []database.Image
+	if err := query.Select("id", "uuid", "md5", "user_id").Find(&images).Error; err != nil {
+		return nil, err
+	}
+
+	result := &TransferResult{Skipped: []string{}}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, image := range images {
+			if image.UserID == targetUserID {
+				continue
+			}
+			var count int64
+			if err := tx.Model(&database.Image{}).Where("md5 = ? AND user_id = ?", image.MD5, targetUserID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				result.Skipped = append(result.Skipped, image.UUID)
+				continue
+			}
+			// 相册属于原用户，转移后的图片移出相册
+			updates := map[string]interface{}{"user_id": targetUserID, "album_id": nil}
+			if err := tx.Model(&database.Image{}).Where("id = ?", image.ID).Updates(updates).Error; err != nil {
+				return fmt.Errorf("failed to transfer image %s: %w", image.UUID, err)
+			}
+			// 分享链接由原用户创建，随所有权一起失效
+			if err := tx.Where("image_id = ?", image.ID).Delete(&database.ShareLink{}).Error; err != nil {
+				return err
+			}
+			result.Transferred++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Transferred %d image(s) to user %d (%d skipped).", result.Transferred, targetUserID, len(result.Skipped))
+	return result, nil
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"yanshu-imgbed/config"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/util"
+
+	"gorm.io/datatypes"
+)
+
+// minCompressionQuality 为满足大小限制而降低 JPEG 质量时的下限
+const minCompressionQuality = 40
+
+// UploadOptions 单次上传请求携带的可选参数
+type UploadOptions struct {
+	// Watermark 覆盖本次上传是否添加水印，nil 表示按用户和系统设置决定
+	Watermark *bool
+	// Annotations 客户端附带的注释信息 (source_app、page_url、note)
+	Annotations map[string]string
+	// Folder 图片存放的文件夹
+	Folder string
+	// Compress 覆盖本次上传是否启用压缩，nil 表示按系统设置决定
+	Compress *bool
+	// FilenameStrategy 文件在后端中的命名方式，为空时使用 UUID
+	FilenameStrategy string
+	// ExpiresAt 图片的过期时间，nil 表示永久保存
+	ExpiresAt *time.Time
+	// AlbumID 图片加入的相册，nil 表示不加入相册；调用方需确认相册属于上传者
+	AlbumID *uint
+	// Visibility 新图片的可见性，空值表示公开
+	Visibility string
+	// ClientIP / UserAgent 上传请求的来源，是否记录由 uploader_info_mode 设置决定
+	ClientIP  string
+	UserAgent string
+	// Source 上传途径 (UploadSourceWeb 等)
+	Source string
+
+	originalSize        int64  // 处理前的原始文件大小，由 UploadImage 填充
+	originalContentType string // 发生格式转换时的原始类型
+	moderation          *moderationResult
+}
+
+// annotationsJSON 将注释序列化为 JSON，没有注释时返回 nil
+func (o UploadOptions) annotationsJSON() datatypes.JSON {
+	if len(o.Annotations) == 0 {
+		return nil
+	}
+	return marshalAnnotations(o.Annotations)
+}
+
+// mergedAnnotationsJSON 将本次上传的注释合并到已有注释上，同名字段以本次为准
+func (o UploadOptions) mergedAnnotationsJSON(existing datatypes.JSON) datatypes.JSON {
+	merged := make(map[string]string)
+	if len(existing) > 0 {
+		if err := json.Unmarshal(existing, &merged); err != nil {
+			log.Printf("Ignoring unreadable existing annotations: %v", err)
+		}
+	}
+	for k, v := range o.Annotations {
+		merged[k] = v
+	}
+	return marshalAnnotations(merged)
+}
+
+// marshalAnnotations 序列化注释，不转义 &、<、>，以便关键字搜索能按原文匹配
+func marshalAnnotations(annotations map[string]string) datatypes.JSON {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(annotations); err != nil {
+		return nil
+	}
+	return datatypes.JSON(bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+// maxFolderLength 文件夹路径的最大长度
+const maxFolderLength = 255
+
+// NormalizeFolder 规范化文件夹路径：去掉首尾的空白和斜杠，拒绝 "." 和 ".." 路径段
+func NormalizeFolder(folder string) (string, error) {
+	folder = strings.Trim(strings.TrimSpace(folder), "/")
+	if len(folder) > maxFolderLength {
+		return "", &UploadRejectedError{Reason: fmt.Sprintf("Folder must not exceed %d characters", maxFolderLength)}
+	}
+	for _, segment := range strings.Split(folder, "/") {
+		if folder != "" && (segment == "" || segment == "." || segment == "..") {
+			return "", &UploadRejectedError{Reason: "Invalid folder path"}
+		}
+	}
+	return folder, nil
+}
+
+// UploadRejectedError 表示上传因不符合策略 (尺寸、类型等) 被拒绝，应作为客户端错误返回
+type UploadRejectedError struct {
+	Reason string
+}
+
+func (e *UploadRejectedError) Error() string {
+	return e.Reason
+}
+
+// readFileHeader 读取上传文件的全部内容
+func readFileHeader(file *multipart.FileHeader) ([]byte, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return io.ReadAll(src)
+}
+
+// processUploadFile 在去重和分发之前对上传文件进行处理 (尺寸限制、添加水印、压缩)
+// 如果无需处理，原样返回传入的 FileHeader
+func processUploadFile(file *multipart.FileHeader, userID uint, opts *UploadOptions) (*multipart.FileHeader, error) {
+	fileType, err := validateFileType(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if fileType.Ext == "heic" || fileType.Ext == "heif" {
+		if file, err = convertHEIC(file); err != nil {
+			return nil, err
+		}
+		opts.originalContentType = fileType.MIME
+	}
+
+	var user database.User
+	database.DB.Select("id", "role", "watermark_disabled").First(&user, userID)
+
+	watermark := shouldWatermark(&user, *opts)
+	compression := GetCompressionSettings()
+	if opts.Compress != nil {
+		compression.Enabled = *opts.Compress
+	}
+	limit := GetDimensionLimit(user.Role)
+	if !watermark && !compression.Enabled && !limit.Active() {
+		return file, nil
+	}
+
+	data, err := readFileHeader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+
+	oversized := false
+	if limit.Active() {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			w, h := util.FitDimensions(cfg.Width, cfg.Height, limit.MaxWidth, limit.MaxHeight)
+			oversized = w != cfg.Width || h != cfg.Height
+			if oversized && limit.Policy == "reject" {
+				return nil, &UploadRejectedError{Reason: fmt.Sprintf("Image dimensions %dx%d exceed the limit of %dx%d", cfg.Width, cfg.Height, limit.MaxWidth, limit.MaxHeight)}
+			}
+		}
+	}
+
+	img, format, err := util.DecodeImage(data)
+	if err != nil || (format != "jpeg" && format != "png") {
+		if oversized {
+			return nil, &UploadRejectedError{Reason: "Image exceeds the maximum dimensions and its format cannot be downscaled"}
+		}
+		// 无法解码或不支持重新编码的格式 (如 GIF、SVG)，原样上传
+		return file, nil
+	}
+
+	modified := false
+	if oversized {
+		b := img.Bounds()
+		w, h := util.FitDimensions(b.Dx(), b.Dy(), limit.MaxWidth, limit.MaxHeight)
+		img = util.ResizeImage(img, w, h)
+		modified = true
+	}
+	if watermark {
+		processed, applied, err := applyWatermark(img, GetWatermarkSettings())
+		if err != nil {
+			log.Printf("Failed to apply watermark to %s: %v. Skipping watermark.", file.Filename, err)
+		} else if applied {
+			img = processed
+			modified = true
+		}
+	}
+
+	compress := compression.Enabled && format == "jpeg"
+	if !modified && !compress {
+		return file, nil
+	}
+
+	quality := util.DefaultJPEGQuality
+	if compress {
+		quality = compression.Quality
+	}
+	encoded, contentType, err := util.EncodeImage(img, format, quality)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode processed image: %w", err)
+	}
+	if compress && compression.MaxBytes > 0 {
+		// 逐步降低质量直到满足大小限制
+		for quality > minCompressionQuality && int64(len(encoded)) > compression.MaxBytes {
+			quality -= 10
+			if quality < minCompressionQuality {
+				quality = minCompressionQuality
+			}
+			if encoded, contentType, err = util.EncodeImage(img, format, quality); err != nil {
+				return nil, fmt.Errorf("failed to encode processed image: %w", err)
+			}
+		}
+	}
+
+	if !modified && len(encoded) >= len(data) {
+		// 压缩没有带来收益，保留原图
+		return file, nil
+	}
+	return util.NewFileHeader(file.Filename, contentType, encoded)
+}
+
+// validateFileType 通过魔数校验文件类型是否在白名单中，并用识别结果覆盖客户端声明的 Content-Type
+func validateFileType(file *multipart.FileHeader) (*util.FileType, error) {
+	fileType, err := util.SniffFileHeader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+	if fileType == nil {
+		return nil, &UploadRejectedError{Reason: "Unrecognized or unsupported file type"}
+	}
+	if !IsFileTypeAllowed(fileType.Ext) {
+		return nil, &UploadRejectedError{Reason: fmt.Sprintf("File type '%s' is not allowed", fileType.Ext)}
+	}
+	file.Header.Set("Content-Type", fileType.MIME)
+	return fileType, nil
+}
+
+// convertHEIC 将 HEIC/HEIF 上传转换为 JPEG，文件名扩展名随之改为 .jpg
+func convertHEIC(file *multipart.FileHeader) (*multipart.FileHeader, error) {
+	data, err := readFileHeader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploaded file: %w", err)
+	}
+	timeout := time.Duration(config.Cfg.Imaging.HeicTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	converted, err := util.ConvertHEICToJPEG(data, config.Cfg.Imaging.HeicConverter, timeout)
+	if err != nil {
+		log.Printf("Failed to convert HEIC upload %s: %v", file.Filename, err)
+		return nil, &UploadRejectedError{Reason: "HEIC/HEIF conversion is not available on this server"}
+	}
+	filename := strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename)) + ".jpg"
+	return util.NewFileHeader(filename, "image/jpeg", converted)
+}
+
+// shouldWatermark 判断本次上传是否需要添加水印
+// 优先级：请求参数 > 用户设置 > 系统设置
+func shouldWatermark(user *database.User, opts UploadOptions) bool {
+	settings := GetWatermarkSettings()
+	if settings.Type == "text" && (settings.Text == "" || len(util.UnsupportedWatermarkRunes(settings.Text)) > 0) {
+		// 无法渲染的文字只会产生空白水印，不值得解码和重新编码图片
+		return false
+	}
+	if settings.Type == "image" && settings.ImagePath == "" {
+		return false
+	}
+	if opts.Watermark != nil {
+		return *opts.Watermark
+	}
+	if !settings.Enabled {
+		return false
+	}
+	return !user.WatermarkDisabled
+}
+
+// applyWatermark 按设置在图片上绘制水印，图片小于最小尺寸时不处理
+func applyWatermark(img image.Image, settings WatermarkSettings) (image.Image, bool, error) {
+	b := img.Bounds()
+	if b.Dx() < settings.MinWidth || b.Dy() < settings.MinHeight {
+		return img, false, nil
+	}
+
+	var mark image.Image
+	switch settings.Type {
+	case "image":
+		f, err := os.Open(settings.ImagePath)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to open watermark image: %w", err)
+		}
+		defer f.Close()
+		mark, err = png.Decode(f)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decode watermark PNG: %w", err)
+		}
+	default:
+		// 文字宽度约占图片宽度的四分之一
+		textWidth := len([]rune(settings.Text)) * 6
+		scale := b.Dx() / 4 / textWidth
+		mark = util.RenderTextWatermark(settings.Text, scale)
+	}
+
+	mb := mark.Bounds()
+	if mb.Dx() > b.Dx() || mb.Dy() > b.Dy() {
+		return img, false, nil
+	}
+
+	dst := util.ToRGBA(img)
+	util.ApplyWatermark(dst, mark, settings.Position, settings.Opacity)
+	return dst, true, nil
+}
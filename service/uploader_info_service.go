@@ -0,0 +1,53 @@
+package service
+
+import (
+	"net"
+	"yanshu-imgbed/database"
+)
+
+// 上传者信息的记录方式
+const (
+	UploaderInfoFull      = "full"      // 记录完整 IP 和 User-Agent
+	UploaderInfoAnonymize = "anonymize" // IPv4 抹去最后一段，IPv6 只保留前 48 位
+	UploaderInfoOff       = "off"       // 不记录
+)
+
+// 图片的上传途径
+const (
+	UploadSourceWeb      = "web"       // 网页登录后上传
+	UploadSourceAPI      = "api"       // 通过 API Token 上传
+	UploadSourceURLFetch = "url-fetch" // 服务端抓取远程地址
+)
+
+// maxUserAgentLength 与 Image.UploaderUA 的列宽一致
+const maxUserAgentLength = 255
+
+// applyUploaderInfo 把上传途径写入图片记录，IP 和 User-Agent 按隐私设置决定是否记录
+func applyUploaderInfo(image *database.Image, opts UploadOptions) {
+	image.UploadSource = opts.Source
+	switch GetUploaderInfoMode() {
+	case UploaderInfoFull:
+		image.UploaderIP = opts.ClientIP
+	case UploaderInfoAnonymize:
+		image.UploaderIP = anonymizeIP(opts.ClientIP)
+	default:
+		return
+	}
+	ua := opts.UserAgent
+	if len(ua) > maxUserAgentLength {
+		ua = ua[:maxUserAgentLength]
+	}
+	image.UploaderUA = ua
+}
+
+// anonymizeIP 截断 IP 地址，保留网段用于识别滥用来源而不定位到具体用户
+func anonymizeIP(raw string) string {
+	ip := net.ParseIP(raw)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
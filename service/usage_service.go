@@ -0,0 +1,87 @@
+package service
+
+import (
+	"log"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// usageDayFormat API Token 用量按天统计时使用的日期格式
+const usageDayFormat = "2006-01-02"
+
+// TokenUsageSummary 管理员查看的 Token 用量汇总
+type TokenUsageSummary struct {
+	TokenID       uint   `json:"token_id"`
+	TokenName     string `json:"token_name"`
+	UserID        uint   `json:"user_id"`
+	Username      string `json:"username"`
+	Requests      int64  `json:"requests"`
+	BytesUploaded int64  `json:"bytes_uploaded"`
+}
+
+// RecordAPITokenUsage 累加 Token 当天的请求次数和上传字节数
+// 统计失败只记录日志，不影响请求本身
+func RecordAPITokenUsage(tokenID uint, bytesUploaded int64) {
+	usage := database.APITokenUsage{
+		TokenID:       tokenID,
+		Day:           time.Now().Format(usageDayFormat),
+		Requests:      1,
+		BytesUploaded: bytesUploaded,
+	}
+	err := database.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "token_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":       gorm.Expr("requests + 1"),
+			"bytes_uploaded": gorm.Expr("bytes_uploaded + ?", bytesUploaded),
+			"updated_at":     time.Now(),
+		}),
+	}).Create(&usage).Error
+	if err != nil {
+		log.Printf("Failed to record usage for API token %d: %v", tokenID, err)
+	}
+	// 使用 UpdateColumns，不把每次请求都算作对 Token 本身的修改
+	err = database.DB.Model(&database.APIToken{}).Where("id = ?", tokenID).UpdateColumns(map[string]interface{}{
+		"last_used_at": time.Now(),
+		"use_count":    gorm.Expr("use_count + 1"),
+	}).Error
+	if err != nil {
+		log.Printf("Failed to update last use of API token %d: %v", tokenID, err)
+	}
+}
+
+// GetAPITokenUsage 返回 Token 最近 days 天的逐日用量，按日期升序
+func GetAPITokenUsage(tokenID uint, days int) ([]database.APITokenUsage, error) {
+	var usage []database.APITokenUsage
+	err := database.DB.Where("token_id = ? AND day >= ?", tokenID, usageSince(days)).
+		Order("day asc").Find(&usage).Error
+	return usage, err
+}
+
+// ListAPITokenUsage 汇总所有 Token 最近 days 天的用量，按请求次数降序
+func ListAPITokenUsage(days int) ([]TokenUsageSummary, error) {
+	var summaries []TokenUsageSummary
+	err := database.DB.Table("api_token_usages").
+		Select("api_token_usages.token_id, api_tokens.name AS token_name, api_tokens.user_id, users.username, "+
+			"SUM(api_token_usages.requests) AS requests, SUM(api_token_usages.bytes_uploaded) AS bytes_uploaded").
+		Joins("JOIN api_tokens ON api_tokens.id = api_token_usages.token_id").
+		Joins("LEFT JOIN users ON users.id = api_tokens.user_id").
+		Where("api_token_usages.day >= ?", usageSince(days)).
+		Group("api_token_usages.token_id, api_tokens.name, api_tokens.user_id, users.username").
+		Order("requests desc").
+		Scan(&summaries).Error
+	return summaries, err
+}
+
+// usageSince 返回统计窗口的起始日期，days 限制在 1-366 之间
+func usageSince(days int) string {
+	if days < 1 {
+		days = 1
+	}
+	if days > 366 {
+		days = 366
+	}
+	return time.Now().AddDate(0, 0, -(days - 1)).Format(usageDayFormat)
+}
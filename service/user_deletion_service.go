@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"yanshu-imgbed/database"
+	"yanshu-imgbed/manager"
+
+	"gorm.io/gorm"
+)
+
+// 删除用户时对其图片的处理方式
+const (
+	DeleteUserPurgeImages    = "purge"    // 在后台任务中从所有后端删除图片
+	DeleteUserTransferImages = "transfer" // 把图片转移给另一个用户
+)
+
+var (
+	// ErrUserNotFound 要删除的用户不存在
+	ErrUserNotFound = errors.New("用户不存在")
+	// ErrDeleteUserImagesAction 用户还有图片，但没有指定 (或指定了无效的) 处理方式
+	ErrDeleteUserImagesAction = errors.New("该用户还有图片，请指定 images 为 'purge' 或 'transfer'")
+)
+
+// DeleteUserRequest 删除用户的参数，用户没有图片时可以省略
+type DeleteUserRequest struct {
+	Images string `json:"images"`
+	// TransferTo 接收图片的用户，images 为 transfer 时必填
+	TransferTo uint `json:"transfer_to"`
+}
+
+// DeleteUserResult 删除用户的结果
+type DeleteUserResult struct {
+	Images      int64 `json:"images"`
+	Transferred int   `json:"transferred"`
+	// TaskID 删除图片的后台任务，没有需要删除的图片时为空
+	TaskID string `json:"task_id,omitempty"`
+}
+
+// DeleteUser 删除用户 (管理员权限)。用户的图片按 req.Images 全部删除或转移给另一个用户，
+// 转移时目标用户已有相同文件的图片会被删除，物理文件由目标用户的图片继续引用，不会被清除
+func DeleteUser(userID uint, req DeleteUserRequest, storageManager *manager.StorageManager) (*DeleteUserResult, error) {
+	var user database.User
+	if err := database.DB.Select("id").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	result := &DeleteUserResult{}
+	if err := database.DB.Model(&database.Image{}).Where("user_id = ?", userID).Count(&result.Images).Error; err != nil {
+		return nil, err
+	}
+	var purgeUUIDs []string
+	if result.Images > 0 {
+		switch req.Images {
+		case DeleteUserPurgeImages:
+			if err := database.DB.Model(&database.Image{}).Where("user_id = ?", userID).Pluck("uuid", &purgeUUIDs).Error; err != nil {
+				return nil, err
+			}
+		case DeleteUserTransferImages:
+			transfer, err := TransferAllImages(userID, req.TransferTo)
+			if err != nil {
+				return nil, err
+			}
+			result.Transferred = transfer.Transferred
+			purgeUUIDs = transfer.Skipped
+		default:
+			return nil, ErrDeleteUserImagesAction
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&database.APIToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&database.UploadPreset{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&database.Image{}).Where("user_id = ? AND album_id IS NOT NULL", userID).Update("album_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&database.Album{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&database.ShareLink{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&database.User{}, userID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	forgetSuspendedUser(userID)
+
+	if len(purgeUUIDs) > 0 {
+		// 用户记录已删除，以 admin 身份删除图片，不再按所有者过滤
+		result.TaskID, _ = BatchDeleteImages(purgeUUIDs, userID, AdminRoleName, storageManager)
+	}
+	log.Printf("Deleted user %d: %d image(s), %d transferred, %d queued for deletion.", userID, result.Images, result.Transferred, len(purgeUUIDs))
+	return result, nil
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+// userStatsMostViewed 用户统计中访问排行返回的条数
+const userStatsMostViewed = 10
+
+// DailyUploadCount 某一天上传的图片数量和大小
+type DailyUploadCount struct {
+	Day     string `json:"day"`
+	Uploads int64  `json:"uploads"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// BackendStorageUsage 用户在某个后端上的存储位置数量和占用空间
+type BackendStorageUsage struct {
+	BackendID   uint   `json:"backend_id"`
+	BackendName string `json:"backend_name"`
+	Images      int64  `json:"images"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// DailyAPIRequests 某一天通过 API Token 发起的请求次数
+type DailyAPIRequests struct {
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"`
+}
+
+// UserStats 单个用户的活动和用量统计，用于用户仪表盘
+type UserStats struct {
+	UserID      uint  `json:"user_id"`
+	Days        int   `json:"days"`
+	TotalImages int64 `json:"total_images"`
+	TotalBytes  int64 `json:"total_bytes"`
+	// Uploads 统计区间内每天的上传量，只包含有上传的日期；已删除的图片不计入
+	Uploads     []DailyUploadCount    `json:"uploads"`
+	Backends    []BackendStorageUsage `json:"backends"`
+	MostViewed  []MostViewedImage     `json:"most_viewed"`
+	APIRequests int64                 `json:"api_requests"`
+	// APIRequestsDaily 统计区间内每天的 API Token 请求次数，已删除的 Token 不计入
+	APIRequestsDaily []DailyAPIRequests `json:"api_requests_daily"`
+}
+
+// GetUserStats 返回用户最近 days 天 (1-366，默认 30)的上传趋势、各后端的存储占用、访问最多的图片和 API 调用次数
+func GetUserStats(userID uint, days int) (*UserStats, error) {
+	var user database.User
+	if err := database.DB.Select("id").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if days < 1 || days > 366 {
+		days = 30
+	}
+	since := usageSince(days)
+	stats := &UserStats{
+		UserID:           userID,
+		Days:             days,
+		Uploads:          []DailyUploadCount{},
+		Backends:         []BackendStorageUsage{},
+		APIRequestsDaily: []DailyAPIRequests{},
+	}
+
+	err := database.DB.Model(&database.Image{}).
+		Select("COUNT(*) AS total_images, COALESCE(SUM(file_size), 0) AS total_bytes").
+		Where("user_id = ?", userID).
+		Row().Scan(&stats.TotalImages, &stats.TotalBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.Uploads, err = dailyUploadCounts(userID, since); err != nil {
+		return nil, err
+	}
+
+	// 同一张图片在每个后端各算一次
+	err = database.DB.Table("storage_locations").
+		Select("storage_locations.backend_id, backends.name AS backend_name, "+
+			"COUNT(*) AS images, COALESCE(SUM(images.file_size), 0) AS bytes").
+		Joins("JOIN images ON images.id = storage_locations.image_id").
+		Joins("LEFT JOIN backends ON backends.id = storage_locations.backend_id").
+		Where("images.user_id = ?", userID).
+		Group("storage_locations.backend_id, backends.name").
+		Order("bytes desc").
+		Scan(&stats.Backends).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.MostViewed, err = GetMostViewedImages(days, userStatsMostViewed, userID); err != nil {
+		return nil, err
+	}
+
+	err = database.DB.Table("api_token_usages").
+		Select("api_token_usages.day, SUM(api_token_usages.requests) AS requests").
+		Joins("JOIN api_tokens ON api_tokens.id = api_token_usages.token_id").
+		Where("api_tokens.user_id = ? AND api_token_usages.day >= ?", userID, since).
+		Group("api_token_usages.day").
+		Order("api_token_usages.day asc").
+		Scan(&stats.APIRequestsDaily).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, day := range stats.APIRequestsDaily {
+		stats.APIRequests += day.Requests
+	}
+	return stats, nil
+}
+
+// dailyUploadCounts 按服务器本地日期统计 since 以来每天上传的图片。
+// 在程序中分组而不是用 DATE(created_at)，SQLite 会把带时区的时间换算成 UTC 日期，MySQL 返回的又是日期类型
+func dailyUploadCounts(userID uint, since string) ([]DailyUploadCount, error) {
+	start, err := time.ParseInLocation(usageDayFormat, since, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	var images []database.Image
+	err = database.DB.Select("created_at", "file_size").
+		Where("user_id = ? AND created_at >= ?", userID, start).
+		Order("created_at asc").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := []DailyUploadCount{}
+	for _, image := range images {
+		day := image.CreatedAt.In(time.Local).Format(usageDayFormat)
+		if len(counts) == 0 || counts[len(counts)-1].Day != day {
+			counts = append(counts, DailyUploadCount{Day: day})
+		}
+		counts[len(counts)-1].Uploads++
+		counts[len(counts)-1].Bytes += image.FileSize
+	}
+	return counts, nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// viewFlushInterval 缓冲的访问次数写入数据库的间隔
+	viewFlushInterval = 10 * time.Second
+	// maxMostViewed 访问排行最多返回的条数
+	maxMostViewed = 100
+	// maxViewStatsDays 每日访问统计最多查询的天数
+	maxViewStatsDays = 365
+)
+
+// pendingViews 尚未写入数据库的访问次数
+type pendingViews struct {
+	count    int64
+	lastSeen time.Time
+	days     map[string]int64
+}
+
+var (
+	viewBufferMu sync.Mutex
+	viewBuffer   = make(map[uint]*pendingViews)
+)
+
+// MostViewedImage 访问排行中的一项
+type MostViewedImage struct {
+	UUID             string     `json:"uuid"`
+	OriginalFilename string     `json:"original_filename"`
+	UserID           uint       `json:"user_id"`
+	Views            int64      `json:"views"` // 统计区间内的访问次数
+	ViewCount        int64      `json:"view_count"`
+	LastViewedAt     *time.Time `json:"last_viewed_at"`
+}
+
+// DailyViewCount 某一天的访问次数
+type DailyViewCount struct {
+	Day   string `json:"day"`
+	Views int64  `json:"views"`
+}
+
+// InitViewCounter 启动后台任务，定期把缓冲的访问次数批量写入数据库
+func InitViewCounter() {
+	go func() {
+		ticker := time.NewTicker(viewFlushInterval)
+		for range ticker.C {
+			FlushImageViews()
+		}
+	}()
+}
+
+// RecordImageView 记录一次图片访问，只写入内存缓冲，不阻塞请求
+func RecordImageView(imageID uint) {
+	now := time.Now()
+	viewBufferMu.Lock()
+	defer viewBufferMu.Unlock()
+	pending, ok := viewBuffer[imageID]
+	if !ok {
+		pending = &pendingViews{}
+		viewBuffer[imageID] = pending
+	}
+	pending.count++
+	pending.lastSeen = now
+	if GetDailyViewStats() {
+		if pending.days == nil {
+			pending.days = make(map[string]int64)
+		}
+		pending.days[now.Format("2006-01-02")]++
+	}
+}
+
+// FlushImageViews 把缓冲的访问次数写入数据库，失败的部分放回缓冲等待下次重试
+func FlushImageViews() {
+	viewBufferMu.Lock()
+	batch := viewBuffer
+	viewBuffer = make(map[uint]*pendingViews)
+	viewBufferMu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for imageID, pending := range batch {
+			// UpdateColumns 不修改 updated_at，访问计数不应触发热备同步等基于更新时间的逻辑
+			result := tx.Model(&database.Image{}).Where("id = ?", imageID).UpdateColumns(map[string]interface{}{
+				"view_count":     gorm.Expr("view_count + ?", pending.count),
+				"last_viewed_at": pending.lastSeen,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				// 图片在写入前已被删除
+				continue
+			}
+			for day, views := range pending.days {
+				row := database.ImageDailyView{ImageID: imageID, Day: day, Views: views}
+				if err := tx.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "image_id"}, {Name: "day"}},
+					DoUpdates: clause.Assignments(map[string]interface{}{"views": gorm.Expr("image_daily_views.views + ?", views)}),
+				}).Create(&row).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to flush image view counts, will retry: %v", err)
+		requeueImageViews(batch)
+	}
+}
+
+// requeueImageViews 把写入失败的访问次数合并回缓冲
+func requeueImageViews(batch map[uint]*pendingViews) {
+	viewBufferMu.Lock()
+	defer viewBufferMu.Unlock()
+	for imageID, failed := range batch {
+		pending, ok := viewBuffer[imageID]
+		if !ok {
+			viewBuffer[imageID] = failed
+			continue
+		}
+		pending.count += failed.count
+		if failed.lastSeen.After(pending.lastSeen) {
+			pending.lastSeen = failed.lastSeen
+		}
+		for day, views := range failed.days {
+			if pending.days == nil {
+				pending.days = make(map[string]int64)
+			}
+			pending.days[day] += views
+		}
+	}
+}
+
+// GetMostViewedImages 返回访问最多的图片；days > 0 时按最近 days 天的每日统计排序，否则按累计访问次数。
+// userID 不为 0 时只统计该用户的图片
+func GetMostViewedImages(days, limit int, userID uint) ([]MostViewedImage, error) {
+	if limit < 1 || limit > maxMostViewed {
+		limit = 20
+	}
+	if days > maxViewStatsDays {
+		days = maxViewStatsDays
+	}
+
+	results := []MostViewedImage{}
+	query := database.DB.Model(&database.Image{})
+	if userID != 0 {
+		query = query.Where("images.user_id = ?", userID)
+	}
+	if days > 0 {
+		since := time.Now().AddDate(0, 0, -(days - 1)).Format("2006-01-02")
+		recent := database.DB.Model(&database.ImageDailyView{}).
+			Select("image_id, SUM(views) AS views").
+			Where("day >= ?", since).
+			Group("image_id")
+		query = query.Select("images.uuid, images.original_filename, images.user_id, images.view_count, images.last_viewed_at, recent.views AS views").
+			Joins("JOIN (?) AS recent ON recent.image_id = images.id", recent).
+			Order("recent.views desc")
+	} else {
+		query = query.Select("images.uuid, images.original_filename, images.user_id, images.view_count, images.last_viewed_at, images.view_count AS views").
+			Where("images.view_count > 0").
+			Order("images.view_count desc")
+	}
+	err := query.Order("images.id desc").Limit(limit).Scan(&results).Error
+	return results, err
+}
+
+// GetImageDailyViews 返回图片最近 days 天中有访问的日期及次数，按日期升序
+func GetImageDailyViews(imageID uint, days int) ([]DailyViewCount, error) {
+	if days < 1 || days > maxViewStatsDays {
+		days = 30
+	}
+	since := time.Now().AddDate(0, 0, -(days - 1)).Format("2006-01-02")
+	counts := []DailyViewCount{}
+	err := database.DB.Model(&database.ImageDailyView{}).
+		Select("day, views").
+		Where("image_id = ? AND day >= ?", imageID, since).
+		Order("day asc").Scan(&counts).Error
+	return counts, err
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"yanshu-imgbed/database"
+)
+
+// 图片和相册的可见性
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// ErrImagePrivate 私有图片只能由所有者、管理员、有效签名或分享链接访问
+var ErrImagePrivate = errors.New("this image is private")
+
+// ImageViewer 访问 /image 的请求方，未登录时 UserID 为 0
+type ImageViewer struct {
+	UserID uint
+	Role   string
+	// Signed 请求携带了有效签名，或来自有效的分享链接
+	Signed bool
+	// Country 访客的国家代码，未知时为空，用于按 geo_rules 选择后端
+	Country string
+}
+
+// NormalizeVisibility 校验可见性参数，空值按公开处理
+func NormalizeVisibility(value string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(value)); v {
+	case "", VisibilityPublic:
+		return VisibilityPublic, nil
+	case VisibilityPrivate:
+		return VisibilityPrivate, nil
+	default:
+		return "", &UploadRejectedError{Reason: "Visibility must be public or private"}
+	}
+}
+
+// checkImageAccess 检查请求方能否访问该图片
+func checkImageAccess(image *database.Image, viewer ImageViewer) error {
+	if image.Visibility != VisibilityPrivate || CanAccessAllImages(viewer.Role) {
+		return nil
+	}
+	// 被停用用户的私有图片不再对外提供，签名地址也一并失效
+	if IsUserSuspended(image.UserID) {
+		return ErrImagePrivate
+	}
+	if viewer.Signed {
+		return nil
+	}
+	if viewer.UserID != 0 && viewer.UserID == image.UserID {
+		return nil
+	}
+	return ErrImagePrivate
+}
+
+// SetImageVisibility 修改一批图片的可见性，普通用户只能修改自己的图片
+func SetImageVisibility(imageUUIDs []string, visibility string, userID uint, userRole string) error {
+	visibility, err := NormalizeVisibility(visibility)
+	if err != nil {
+		return err
+	}
+	query := database.DB.Model(&database.Image{}).Where("uuid IN ?", imageUUIDs)
+	if !CanAccessAllImages(userRole) {
+		var count int64
+		database.DB.Model(&database.Image{}).Where("uuid IN ? AND user_id = ?", imageUUIDs, userID).Count(&count)
+		if count != int64(len(imageUUIDs)) {
+			return ErrNotImageOwner
+		}
+	}
+	if err := query.Update("visibility", visibility).Error; err != nil {
+		return err
+	}
+	// 私有图片不能出现在随机图库中
+	go UpdateRandomImageCache()
+	return nil
+}
@@ -0,0 +1,605 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"yanshu-imgbed/database"
+
+	"gorm.io/gorm"
+)
+
+// Webhook 事件名称
+const (
+	WebhookImageUploaded = "image.uploaded"
+	WebhookImageDeleted  = "image.deleted"
+	// WebhookBackendFailed 后端连续健康检查失败而被熔断
+	WebhookBackendFailed = "backend.failed"
+	// WebhookQuotaExceeded 上传因超出存储配额或每日上传上限被拒绝
+	WebhookQuotaExceeded = "quota.exceeded"
+	// WebhookTaskCompleted 后台任务结束 (成功或失败)
+	WebhookTaskCompleted = "task.completed"
+	// WebhookPing 管理员手动发送的测试事件，不受事件筛选影响
+	WebhookPing = "ping"
+)
+
+// AllWebhookEvents 可以订阅的事件
+var AllWebhookEvents = []string{WebhookImageUploaded, WebhookImageDeleted, WebhookBackendFailed, WebhookQuotaExceeded, WebhookTaskCompleted}
+
+// 推送记录的状态
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliverySucceeded = "succeeded"
+	webhookDeliveryFailed    = "failed"
+)
+
+const (
+	// webhookTimeout 单次推送的超时时间
+	webhookTimeout = 10 * time.Second
+	// webhookMaxAttempts 推送失败后最多尝试的次数，用尽后标记为 failed
+	webhookMaxAttempts = 8
+	// webhookMaxBackoff 重试的最长间隔
+	webhookMaxBackoff = 6 * time.Hour
+	// webhookPollInterval 检查到期重试的间隔，新事件会立即唤醒推送协程
+	webhookPollInterval = 30 * time.Second
+	// webhookDeliveryBatch 每轮最多推送的记录数
+	webhookDeliveryBatch = 50
+	// webhookDeliveryWorkers 同时进行的推送数
+	webhookDeliveryWorkers = 4
+	// webhookDeliveryRetention 推送日志的保留时间
+	webhookDeliveryRetention = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrWebhookNotFound Webhook 不存在
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrWebhookDeliveryNotFound 推送记录不存在
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// activeWebhook 内存中缓存的启用的 Webhook
+type activeWebhook struct {
+	id     uint
+	events map[string]bool // 为空表示订阅全部事件
+}
+
+var (
+	activeWebhooks   []activeWebhook
+	activeWebhooksMu sync.RWMutex
+	// webhookWake 唤醒推送协程，有新事件时立即推送而不必等到下一轮
+	webhookWake = make(chan struct{}, 1)
+	// webhookClient 推送使用的 HTTP 客户端，不跟随跳转，3xx 视为失败
+	webhookClient = &http.Client{
+		Timeout: webhookTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+)
+
+// WebhookInput 创建或修改 Webhook 的参数
+type WebhookInput struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret 为空时创建随机密钥；修改时省略表示保持不变，空字符串表示重新生成
+	Secret   *string  `json:"secret"`
+	Events   []string `json:"events"`
+	IsActive *bool    `json:"is_active"`
+}
+
+// WebhookWithSecret 创建 Webhook 或更换密钥后返回，只有这时才能看到密钥
+type WebhookWithSecret struct {
+	database.Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookDeliveryPage 推送日志的分页结果
+type WebhookDeliveryPage struct {
+	Items []database.WebhookDelivery `json:"items"`
+	Total int64                      `json:"total"`
+}
+
+// webhookPayload 推送的请求体
+type webhookPayload struct {
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// InitWebhooks 加载启用的 Webhook 并启动推送协程：立即推送新事件，定期重试失败的推送并清理过期日志
+func InitWebhooks() {
+	if err := ReloadWebhooks(); err != nil {
+		log.Printf("Failed to load webhooks: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+		var lastPruned time.Time
+		for {
+			processDueWebhookDeliveries()
+			if time.Since(lastPruned) > time.Hour {
+				pruneWebhookDeliveries()
+				lastPruned = time.Now()
+			}
+			select {
+			case <-ticker.C:
+			case <-webhookWake:
+			}
+		}
+	}()
+}
+
+// ReloadWebhooks 从数据库重新加载启用的 Webhook 及其订阅的事件
+func ReloadWebhooks() error {
+	var hooks []database.Webhook
+	if err := database.DB.Where("is_active = ?", true).Order("id asc").Find(&hooks).Error; err != nil {
+		return err
+	}
+	loaded := make([]activeWebhook, 0, len(hooks))
+	for _, hook := range hooks {
+		active := activeWebhook{id: hook.ID, events: make(map[string]bool)}
+		for _, event := range webhookEvents(hook) {
+			active.events[event] = true
+		}
+		loaded = append(loaded, active)
+	}
+	activeWebhooksMu.Lock()
+	activeWebhooks = loaded
+	activeWebhooksMu.Unlock()
+	return nil
+}
+
+// webhookEvents 解析 Webhook 订阅的事件列表
+func webhookEvents(hook database.Webhook) []string {
+	var events []string
+	if len(hook.Events) > 0 {
+		_ = json.Unmarshal(hook.Events, &events)
+	}
+	return events
+}
+
+// EmitWebhookEvent 为订阅了该事件的 Webhook 各记录一次推送并唤醒推送协程。
+// 推送记录先写入数据库，服务重启后未完成的推送会继续重试；没有 Webhook 订阅时不做任何事
+func EmitWebhookEvent(event string, data interface{}) {
+	var targets []uint
+	activeWebhooksMu.RLock()
+	for _, hook := range activeWebhooks {
+		if len(hook.events) == 0 || hook.events[event] {
+			targets = append(targets, hook.id)
+		}
+	}
+	activeWebhooksMu.RUnlock()
+	if len(targets) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(webhookPayload{Event: event, Time: time.Now(), Data: data})
+	if err != nil {
+		log.Printf("Failed to encode webhook event %s: %v", event, err)
+		return
+	}
+	deliveries := make([]database.WebhookDelivery, 0, len(targets))
+	for _, id := range targets {
+		deliveries = append(deliveries, database.WebhookDelivery{
+			WebhookID: id,
+			Event:     event,
+			Payload:   payload,
+			Status:    webhookDeliveryPending,
+			NextRunAt: time.Now(),
+		})
+	}
+	if err := database.DB.Create(&deliveries).Error; err != nil {
+		log.Printf("Failed to queue webhook event %s: %v", event, err)
+		return
+	}
+	wakeWebhookWorker()
+}
+
+// emitImageWebhook 发送图片上传或删除事件
+func emitImageWebhook(event string, image *database.Image) {
+	EmitWebhookEvent(event, map[string]interface{}{
+		"uuid":              image.UUID,
+		"user_id":           image.UserID,
+		"original_filename": image.OriginalFilename,
+		"content_type":      image.ContentType,
+		"file_size":         image.FileSize,
+		"folder":            image.Folder,
+		"path":              ImageViewPath(image.UUID, image.ContentType),
+	})
+}
+
+// emitQuotaWebhook 发送上传被配额拒绝的事件，kind 为 storage (存储配额) 或 daily (每日上传上限)
+func emitQuotaWebhook(kind string, userID uint, reason string) {
+	EmitWebhookEvent(WebhookQuotaExceeded, map[string]interface{}{
+		"kind":    kind,
+		"user_id": userID,
+		"reason":  reason,
+	})
+}
+
+// emitBackendFailedWebhook 发送后端被熔断的事件
+func emitBackendFailedWebhook(backendID uint, failures int, openUntil time.Time) {
+	var backend database.Backend
+	database.DB.Select("id", "name", "type").First(&backend, backendID)
+	EmitWebhookEvent(WebhookBackendFailed, map[string]interface{}{
+		"backend_id":           backendID,
+		"backend_name":         backend.Name,
+		"backend_type":         backend.Type,
+		"consecutive_failures": failures,
+		"circuit_open_until":   openUntil,
+	})
+}
+
+// wakeWebhookWorker 通知推送协程处理到期的记录，协程已被唤醒时不重复通知
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// webhookBackoff 第 attempts 次失败后的重试间隔：从 30 秒开始翻倍，最长 webhookMaxBackoff
+func webhookBackoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return webhookMaxBackoff
+	}
+	backoff := time.Duration(1<<(attempts-1)) * 30 * time.Second
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+// processDueWebhookDeliveries 推送到期的记录，只在推送协程中调用，同一记录不会被并发推送
+func processDueWebhookDeliveries() {
+	var due []database.WebhookDelivery
+	err := database.DB.Where("status = ? AND next_run_at <= ?", webhookDeliveryPending, time.Now()).
+		Order("next_run_at asc").Limit(webhookDeliveryBatch).Find(&due).Error
+	if err != nil {
+		log.Printf("Failed to load due webhook deliveries: %v", err)
+		return
+	}
+
+	sem := make(chan struct{}, webhookDeliveryWorkers)
+	var wg sync.WaitGroup
+	for i := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(delivery *database.WebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			runWebhookDelivery(delivery)
+		}(&due[i])
+	}
+	wg.Wait()
+}
+
+// runWebhookDelivery 推送一次并记录结果，失败时安排下一次重试或在次数用尽后放弃
+func runWebhookDelivery(delivery *database.WebhookDelivery) {
+	var hook database.Webhook
+	err := database.DB.First(&hook, delivery.WebhookID).Error
+	if err == nil && !hook.IsActive {
+		err = errors.New("webhook is disabled")
+	}
+	if err != nil {
+		// Webhook 已删除或停用，不再重试
+		delivery.Status = webhookDeliveryFailed
+		delivery.LastError = err.Error()
+		saveWebhookDelivery(delivery)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode, err = sendWebhook(hook, delivery)
+	if err == nil {
+		now := time.Now()
+		delivery.Status = webhookDeliverySucceeded
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= webhookMaxAttempts {
+			delivery.Status = webhookDeliveryFailed
+			log.Printf("Webhook delivery %d (%s to %s) failed after %d attempts, giving up: %v", delivery.ID, delivery.Event, hook.URL, delivery.Attempts, err)
+		} else {
+			delivery.NextRunAt = time.Now().Add(webhookBackoff(delivery.Attempts))
+			log.Printf("Webhook delivery %d (%s to %s) failed (attempt %d), next retry at %s: %v", delivery.ID, delivery.Event, hook.URL, delivery.Attempts, delivery.NextRunAt.Format(time.RFC3339), err)
+		}
+	}
+	saveWebhookDelivery(delivery)
+}
+
+func saveWebhookDelivery(delivery *database.WebhookDelivery) {
+	if err := database.DB.Save(delivery).Error; err != nil {
+		log.Printf("Failed to save webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// sendWebhook 发送一次推送，返回 HTTP 状态码；非 2xx 响应视为失败。
+// X-Imgbed-Signature 为 "sha256=" 加上以 Secret 为密钥对请求体计算的 HMAC-SHA256 (十六进制)
+func sendWebhook(hook database.Webhook, delivery *database.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "yanshu-imgbed-webhook")
+	req.Header.Set("X-Imgbed-Event", delivery.Event)
+	req.Header.Set("X-Imgbed-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Imgbed-Signature", "sha256="+signWebhookPayload(hook.Secret, delivery.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// 读取少量响应内容用于错误信息，其余丢弃以便复用连接
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhookPayload 计算请求体的 HMAC-SHA256 签名
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// pruneWebhookDeliveries 删除超过保留时间且已经结束的推送记录
+func pruneWebhookDeliveries() {
+	cutoff := time.Now().Add(-webhookDeliveryRetention)
+	result := database.DB.Where("status <> ? AND created_at < ?", webhookDeliveryPending, cutoff).Delete(&database.WebhookDelivery{})
+	if result.Error != nil {
+		log.Printf("Failed to prune webhook deliveries: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("Pruned %d webhook delivery record(s).", result.RowsAffected)
+	}
+}
+
+// generateWebhookSecret 生成随机的签名密钥
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// validate 校验地址和事件名并去重事件
+func (in *WebhookInput) validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.URL = strings.TrimSpace(in.URL)
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &UploadRejectedError{Reason: "URL must be an absolute http or https address"}
+	}
+	if len(in.URL) > 512 {
+		return &UploadRejectedError{Reason: "URL must not exceed 512 characters"}
+	}
+	if len([]rune(in.Name)) > 100 {
+		return &UploadRejectedError{Reason: "Name must not exceed 100 characters"}
+	}
+	if in.Secret != nil && len(*in.Secret) > 128 {
+		return &UploadRejectedError{Reason: "Secret must not exceed 128 characters"}
+	}
+	seen := make(map[string]bool, len(in.Events))
+	events := make([]string, 0, len(in.Events))
+	for _, event := range in.Events {
+		known := false
+		for _, e := range AllWebhookEvents {
+			if e == event {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return &UploadRejectedError{Reason: fmt.Sprintf("Unknown event %q", event)}
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	in.Events = events
+	return nil
+}
+
+// apply 把参数写入 Webhook 记录，返回新设置的密钥 (未更换密钥时为空)
+func (in WebhookInput) apply(hook *database.Webhook) (string, error) {
+	hook.Name = in.Name
+	hook.URL = in.URL
+	hook.Events = nil
+	if len(in.Events) > 0 {
+		hook.Events, _ = json.Marshal(in.Events)
+	}
+	if in.IsActive != nil {
+		hook.IsActive = *in.IsActive
+	}
+	if in.Secret == nil && hook.Secret != "" {
+		return "", nil
+	}
+	secret := ""
+	if in.Secret != nil {
+		secret = *in.Secret
+	}
+	if secret == "" {
+		var err error
+		if secret, err = generateWebhookSecret(); err != nil {
+			return "", err
+		}
+	}
+	hook.Secret = secret
+	return secret, nil
+}
+
+// ListWebhooks 列出所有 Webhook
+func ListWebhooks() ([]database.Webhook, error) {
+	var list []database.Webhook
+	err := database.DB.Order("id asc").Find(&list).Error
+	return list, err
+}
+
+// CreateWebhook 新建 Webhook，返回结果中包含密钥
+func CreateWebhook(in WebhookInput) (*WebhookWithSecret, error) {
+	if err := in.validate(); err != nil {
+		return nil, err
+	}
+	hook := database.Webhook{IsActive: true}
+	secret, err := in.apply(&hook)
+	if err != nil {
+		return nil, err
+	}
+	active := hook.IsActive
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&hook).Error; err != nil {
+			return err
+		}
+		// is_active 的列默认值为 true，GORM 创建时会忽略 false，需要再单独更新
+		if !active {
+			return tx.Model(&hook).Update("is_active", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookWithSecret{Webhook: hook, Secret: secret}, ReloadWebhooks()
+}
+
+// UpdateWebhook 修改 Webhook，更换了密钥时返回新密钥，否则返回空字符串
+func UpdateWebhook(id uint, in WebhookInput) (*database.Webhook, string, error) {
+	hook, err := findWebhook(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := in.validate(); err != nil {
+		return nil, "", err
+	}
+	secret, err := in.apply(hook)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := database.DB.Select("Name", "URL", "Secret", "Events", "IsActive", "UpdatedAt").Save(hook).Error; err != nil {
+		return nil, "", err
+	}
+	return hook, secret, ReloadWebhooks()
+}
+
+// DeleteWebhook 删除 Webhook 及其推送日志
+func DeleteWebhook(id uint) error {
+	if _, err := findWebhook(id); err != nil {
+		return err
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&database.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&database.Webhook{}, id).Error
+	})
+	if err != nil {
+		return err
+	}
+	return ReloadWebhooks()
+}
+
+func findWebhook(id uint) (*database.Webhook, error) {
+	var hook database.Webhook
+	if err := database.DB.First(&hook, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// PingWebhook 立即向 Webhook 发送一次 ping 事件 (即使已停用)，结果写入推送日志，失败不重试
+func PingWebhook(id uint) (*database.WebhookDelivery, error) {
+	hook, err := findWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(webhookPayload{Event: WebhookPing, Time: time.Now(), Data: map[string]interface{}{"webhook_id": hook.ID}})
+	if err != nil {
+		return nil, err
+	}
+	// 先写入记录以取得推送 ID，状态为 failed 使推送协程不会处理它
+	delivery := database.WebhookDelivery{WebhookID: hook.ID, Event: WebhookPing, Payload: payload, Status: webhookDeliveryFailed, NextRunAt: time.Now()}
+	if err := database.DB.Create(&delivery).Error; err != nil {
+		return nil, err
+	}
+	delivery.Attempts = 1
+	delivery.ResponseCode, err = sendWebhook(*hook, &delivery)
+	if err == nil {
+		now := time.Now()
+		delivery.Status = webhookDeliverySucceeded
+		delivery.DeliveredAt = &now
+	} else {
+		delivery.LastError = err.Error()
+	}
+	saveWebhookDelivery(&delivery)
+	return &delivery, nil
+}
+
+// ListWebhookDeliveries 分页列出 Webhook 的推送日志，最新的在前，status 为空时不筛选
+func ListWebhookDeliveries(webhookID uint, status string, page, pageSize int) (*WebhookDeliveryPage, error) {
+	if _, err := findWebhook(webhookID); err != nil {
+		return nil, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	query := database.DB.Model(&database.WebhookDelivery{}).Where("webhook_id = ?", webhookID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	result := &WebhookDeliveryPage{Items: []database.WebhookDelivery{}}
+	if err := query.Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+	if err := query.Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&result.Items).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RedeliverWebhookDelivery 把推送记录重新放入队列，重置尝试次数后由推送协程立即推送
+func RedeliverWebhookDelivery(id uint) error {
+	var delivery database.WebhookDelivery
+	if err := database.DB.First(&delivery, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWebhookDeliveryNotFound
+		}
+		return err
+	}
+	err := database.DB.Model(&delivery).Updates(map[string]interface{}{
+		"status":      webhookDeliveryPending,
+		"attempts":    0,
+		"next_run_at": time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	wakeWebhookWorker()
+	return nil
+}
@@ -0,0 +1,21 @@
+// 根据图片记录的 Content-Type 生成带真实扩展名的访问路径，与服务端 service.ImageViewPath 一致
+// BASE_PATH 由页面模板定义 (server.base_path)
+const IMAGE_EXTENSIONS = {
+    'image/jpeg': 'jpg',
+    'image/png': 'png',
+    'image/gif': 'gif',
+    'image/webp': 'webp',
+    'image/bmp': 'bmp',
+    'image/x-icon': 'ico',
+    'image/tiff': 'tiff',
+    'image/avif': 'avif',
+    'image/heic': 'heic',
+    'image/heif': 'heif',
+    'image/svg+xml': 'svg'
+};
+
+function imageViewPath(uuid, contentType) {
+    if (!contentType) return `${BASE_PATH}/i/${uuid}`;
+    const ext = IMAGE_EXTENSIONS[contentType.split(';')[0].trim().toLowerCase()] || 'jpg';
+    return `${BASE_PATH}/image/${uuid}.${ext}`;
+}
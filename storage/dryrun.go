@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+)
+
+// DryRunType 试运行后端生成的存储位置类型
+const DryRunType = "dryrun"
+
+// DryRunUploader 包装一个真实的 Uploader，只模拟上传而不写入真实存储
+// 用于在不消耗付费存储的情况下测试路由、预设和处理流程
+type DryRunUploader struct {
+	BackendName string
+	Inner       Uploader
+}
+
+// NewDryRunUploader 创建试运行包装
+func NewDryRunUploader(backendName string, inner Uploader) *DryRunUploader {
+	return &DryRunUploader{BackendName: backendName, Inner: inner}
+}
+
+// Upload 读完文件内容以走完整个处理流程，返回一个不可访问的伪地址
+func (d *DryRunUploader) Upload(fileHeader *multipart.FileHeader, uniqueFilename string, src io.Reader) (string, error) {
+	n, err := io.Copy(io.Discard, src)
+	if err != nil {
+		return "", fmt.Errorf("dry-run read failed: %w", err)
+	}
+	log.Printf("[dry-run] Would upload %s (%d bytes) to backend %s (%s)", uniqueFilename, n, d.BackendName, d.Inner.Type())
+	return fmt.Sprintf("%s://%s/%s", DryRunType, d.BackendName, uniqueFilename), nil
+}
+
+func (d *DryRunUploader) Type() string {
+	return DryRunType
+}
+
+func (d *DryRunUploader) UploadFromFile(localPath string, uniqueFilename string) (string, error) {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	return d.Upload(nil, uniqueFilename, src)
+}
+
+// Delete 试运行的文件从未真正写入，只记录日志
+func (d *DryRunUploader) Delete(deleteIdentifier string) error {
+	log.Printf("[dry-run] Would delete %s from backend %s", deleteIdentifier, d.BackendName)
+	return nil
+}
@@ -28,15 +28,30 @@ func (l *LocalUploader) Upload(fileHeader *multipart.FileHeader, uniqueFilename
 	cleanStoragePath := filepath.Base(l.StoragePath)
 	relativeURL := fmt.Sprintf("/%s/%s", cleanStoragePath, uniqueFilename)
 
-	// 物理文件保存逻辑不变
+	// 先写入同目录下的临时文件 (以 "." 开头，不会被当作孤立文件)，完整写入后再改名，
+	// 上传中断或服务关闭时不会留下写了一半的文件
 	dst := filepath.Join(l.StoragePath, uniqueFilename)
-	out, err := os.Create(dst)
+	out, err := os.CreateTemp(filepath.Dir(dst), ".upload-*.tmp")
 	if err != nil {
 		return "", err
 	}
-	defer out.Close()
-
+	tmpPath := out.Name()
 	if _, err = io.Copy(out, src); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	// CreateTemp 创建的文件权限为 0600，改为常规的 0644
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
 		return "", err
 	}
 
@@ -3,20 +3,37 @@ package storage
 import (
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strconv"
 	"yanshu-imgbed/util"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 )
 
+const (
+	// defaultMultipartThresholdMB 超过该大小的文件使用分片上传
+	defaultMultipartThresholdMB = 16
+	// defaultPartSizeMB 分片上传时每个分片的大小
+	defaultPartSizeMB = 5
+	// multipartRoutines 并发上传的分片数
+	multipartRoutines = 3
+	// multipartAttempts 分片上传失败后从断点续传的最大尝试次数
+	multipartAttempts = 3
+)
+
 // OssUploader 实现了 Uploader 接口，用于阿里云OSS
 type OssUploader struct {
 	Client     *oss.Client
 	Bucket     *oss.Bucket
 	PublicURL  string // 对外访问的基础 URL，用于自定义域名
 	UploadPath string // OSS上的存储路径前缀
+
+	MultipartThreshold int64  // 使用分片上传的文件大小阈值 (字节)
+	PartSize           int64  // 分片大小 (字节)
+	CheckpointDir      string // 分片上传断点记录的目录
 }
 
 // NewOssUploader 创建一个新的OSS存储实例
@@ -41,10 +58,13 @@ func NewOssUploader(config map[string]string) (*OssUploader, error) {
 	}
 
 	uploader := &OssUploader{
-		Client:     client,
-		Bucket:     bucket,
-		PublicURL:  config["publicUrl"],
-		UploadPath: config["uploadPath"],
+		Client:             client,
+		Bucket:             bucket,
+		PublicURL:          config["publicUrl"],
+		UploadPath:         config["uploadPath"],
+		MultipartThreshold: megabytesOrDefault(config["multipartThresholdMB"], defaultMultipartThresholdMB),
+		PartSize:           megabytesOrDefault(config["partSizeMB"], defaultPartSizeMB),
+		CheckpointDir:      filepath.Join(os.TempDir(), "yanshu-imgbed", "oss-checkpoints"),
 	}
 
 	return uploader, nil
@@ -53,11 +73,59 @@ func NewOssUploader(config map[string]string) (*OssUploader, error) {
 func (o *OssUploader) Upload(fileHeader *multipart.FileHeader, uniqueFilename string, src io.Reader) (string, error) {
 	objectKey := filepath.ToSlash(filepath.Join(o.UploadPath, uniqueFilename))
 
-	err := o.Bucket.PutObject(objectKey, src)
-	if err != nil {
+	if fileHeader != nil && fileHeader.Size > o.MultipartThreshold {
+		if err := o.uploadMultipart(objectKey, src); err != nil {
+			return "", err
+		}
+	} else if err := o.Bucket.PutObject(objectKey, src); err != nil {
 		return "", fmt.Errorf("failed to upload object to OSS: %w", err)
 	}
+	return o.uploadResult(objectKey), nil
+}
+
+// uploadMultipart 使用 OSS 原生分片上传，断点记录在 CheckpointDir 中
+// 某个分片因连接中断失败时，重试只会补传未完成的分片，而不是从头上传整个对象
+func (o *OssUploader) uploadMultipart(objectKey string, src io.Reader) error {
+	// 分片上传需要可以随机读取的本地文件，已落盘的上传文件直接使用，否则先写入临时文件
+	localPath := ""
+	if f, ok := src.(*os.File); ok {
+		localPath = f.Name()
+	} else {
+		tmp, err := os.CreateTemp("", "oss-multipart-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temp file for multipart upload: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, src)
+		tmp.Close()
+		if err != nil {
+			return fmt.Errorf("failed to buffer file for multipart upload: %w", err)
+		}
+		localPath = tmp.Name()
+	}
+	return o.uploadFileMultipart(objectKey, localPath)
+}
+
+// uploadFileMultipart 分片上传本地文件，失败时从断点继续
+func (o *OssUploader) uploadFileMultipart(objectKey, localPath string) error {
+	if err := os.MkdirAll(o.CheckpointDir, 0755); err != nil {
+		return fmt.Errorf("failed to create OSS checkpoint directory: %w", err)
+	}
+	var err error
+	for attempt := 1; attempt <= multipartAttempts; attempt++ {
+		err = o.Bucket.UploadFile(objectKey, localPath, o.PartSize,
+			oss.Routines(multipartRoutines),
+			oss.CheckpointDir(true, o.CheckpointDir))
+		if err == nil {
+			return nil
+		}
+		log.Printf("Multipart upload of %s failed (attempt %d/%d), resuming from checkpoint: %v", objectKey, attempt, multipartAttempts, err)
+	}
+	return fmt.Errorf("failed to upload object to OSS in parts: %w", err)
+}
 
+// uploadResult 生成 Upload 的返回值
+func (o *OssUploader) uploadResult(objectKey string) string {
 	var publicURL string
 	if o.PublicURL != "" {
 		publicURL = fmt.Sprintf("%s/%s", o.PublicURL, objectKey)
@@ -67,7 +135,7 @@ func (o *OssUploader) Upload(fileHeader *multipart.FileHeader, uniqueFilename st
 
 	// --- 已修改：返回包含URL和Object Key的特殊格式 ---
 	// 格式为 "public_url@@@object_key"
-	return fmt.Sprintf("%s@@@%s", publicURL, objectKey), nil
+	return fmt.Sprintf("%s@@@%s", publicURL, objectKey)
 }
 
 func (o *OssUploader) Type() string {
@@ -75,6 +143,14 @@ func (o *OssUploader) Type() string {
 }
 
 func (o *OssUploader) UploadFromFile(localPath string, uniqueFilename string) (string, error) {
+	if info, err := os.Stat(localPath); err == nil && info.Size() > o.MultipartThreshold {
+		objectKey := filepath.ToSlash(filepath.Join(o.UploadPath, uniqueFilename))
+		if err := o.uploadFileMultipart(objectKey, localPath); err != nil {
+			return "", err
+		}
+		return o.uploadResult(objectKey), nil
+	}
+
 	src, err := os.Open(localPath)
 	if err != nil {
 		return "", err
@@ -91,3 +167,11 @@ func (o *OssUploader) Delete(objectKey string) error {
 	}
 	return o.Bucket.DeleteObject(objectKey)
 }
+
+// megabytesOrDefault 解析以 MB 为单位的配置项，未配置或无效时使用默认值
+func megabytesOrDefault(value string, defaultMB int64) int64 {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 {
+		return n * 1024 * 1024
+	}
+	return defaultMB * 1024 * 1024
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// NewFileHeader 用内存中的数据构造一个 multipart.FileHeader
+// 上传流程中的各个环节都基于 FileHeader.Open() 读取文件，处理后的图片通过它重新进入流程
+func NewFileHeader(filename, contentType string, data []byte) (*multipart.FileHeader, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(filename)))
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	part, err := writer.CreatePart(h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	form, err := multipart.NewReader(body, writer.Boundary()).ReadForm(int64(len(data)) + 1024)
+	if err != nil {
+		return nil, err
+	}
+	files := form.File["file"]
+	if len(files) == 0 {
+		return nil, errors.New("failed to build file header")
+	}
+	return files[0], nil
+}
+
+// NewFileHeaderFromFile 用磁盘上的文件构造一个 multipart.FileHeader
+// 文件内容以流的方式写入，大文件会落到临时文件而不是全部读入内存
+// 调用方在使用完毕后需要调用返回的 cleanup 删除临时文件
+func NewFileHeaderFromFile(filename, contentType, path string) (*multipart.FileHeader, func(), error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		defer src.Close()
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(filename)))
+		if contentType != "" {
+			h.Set("Content-Type", contentType)
+		}
+		part, err := writer.CreatePart(h)
+		if err == nil {
+			_, err = io.Copy(part, src)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	// maxMemory 很小，文件部分会写入临时文件
+	form, err := multipart.NewReader(pr, writer.Boundary()).ReadForm(1 << 20)
+	pr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { form.RemoveAll() }
+	files := form.File["file"]
+	if len(files) == 0 {
+		cleanup()
+		return nil, nil, errors.New("failed to build file header")
+	}
+	return files[0], cleanup, nil
+}
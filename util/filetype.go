@@ -0,0 +1,191 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+)
+
+// sniffLength 识别文件类型时读取的头部字节数
+const sniffLength = 512
+
+// FileType 通过文件头识别出的文件类型
+type FileType struct {
+	Ext  string // 规范化的扩展名，不带点，例如 "jpg"
+	MIME string
+}
+
+// DetectFileType 根据文件头的魔数识别图片类型，无法识别时返回 nil
+func DetectFileType(header []byte) *FileType {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return &FileType{Ext: "jpg", MIME: "image/jpeg"}
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return &FileType{Ext: "png", MIME: "image/png"}
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return &FileType{Ext: "gif", MIME: "image/gif"}
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return &FileType{Ext: "webp", MIME: "image/webp"}
+	case isBMP(header):
+		return &FileType{Ext: "bmp", MIME: "image/bmp"}
+	case isICO(header):
+		return &FileType{Ext: "ico", MIME: "image/x-icon"}
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return &FileType{Ext: "tiff", MIME: "image/tiff"}
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return detectISOBMFF(header)
+	}
+	if isSVG(header) {
+		return &FileType{Ext: "svg", MIME: "image/svg+xml"}
+	}
+	return nil
+}
+
+// mimeExtensions DetectFileType 能识别的 MIME 类型对应的扩展名
+var mimeExtensions = map[string]string{
+	"image/jpeg":    "jpg",
+	"image/png":     "png",
+	"image/gif":     "gif",
+	"image/webp":    "webp",
+	"image/bmp":     "bmp",
+	"image/x-icon":  "ico",
+	"image/tiff":    "tiff",
+	"image/avif":    "avif",
+	"image/heic":    "heic",
+	"image/heif":    "heif",
+	"image/svg+xml": "svg",
+}
+
+// ExtensionForMIME 返回 MIME 类型对应的扩展名 (不带点)，未知类型返回空字符串
+func ExtensionForMIME(mime string) string {
+	if i := strings.IndexByte(mime, ';'); i >= 0 {
+		mime = mime[:i]
+	}
+	return mimeExtensions[strings.ToLower(strings.TrimSpace(mime))]
+}
+
+// detectISOBMFF 根据 ftyp 的主品牌识别 AVIF/HEIC/HEIF
+func detectISOBMFF(header []byte) *FileType {
+	switch string(header[8:12]) {
+	case "avif", "avis":
+		return &FileType{Ext: "avif", MIME: "image/avif"}
+	case "heic", "heix", "hevc", "hevx":
+		return &FileType{Ext: "heic", MIME: "image/heic"}
+	case "mif1", "msf1", "heim", "heis":
+		return &FileType{Ext: "heif", MIME: "image/heif"}
+	}
+	return nil
+}
+
+// isBMP 校验 BMP 文件头：保留字段为 0，DIB 头长度是已知版本，像素数据偏移落在头部之后
+func isBMP(header []byte) bool {
+	if len(header) < 18 || !bytes.HasPrefix(header, []byte("BM")) {
+		return false
+	}
+	if binary.LittleEndian.Uint32(header[6:10]) != 0 {
+		return false
+	}
+	dibSize := binary.LittleEndian.Uint32(header[14:18])
+	switch dibSize {
+	case 12, 40, 52, 56, 64, 108, 124:
+	default:
+		return false
+	}
+	return binary.LittleEndian.Uint32(header[10:14]) >= 14+dibSize
+}
+
+// isICO 校验 ICO 文件头和第一个目录项
+func isICO(header []byte) bool {
+	if len(header) < 22 || !bytes.HasPrefix(header, []byte{0x00, 0x00, 0x01, 0x00}) {
+		return false
+	}
+	count := binary.LittleEndian.Uint16(header[4:6])
+	if count == 0 {
+		return false
+	}
+	entry := header[6:22]
+	if entry[3] != 0 {
+		return false
+	}
+	if planes := binary.LittleEndian.Uint16(entry[4:6]); planes > 1 {
+		return false
+	}
+	switch binary.LittleEndian.Uint16(entry[6:8]) {
+	case 0, 1, 4, 8, 16, 24, 32:
+	default:
+		return false
+	}
+	size := binary.LittleEndian.Uint32(entry[8:12])
+	offset := binary.LittleEndian.Uint32(entry[12:16])
+	return size > 0 && offset >= 6+16*uint32(count)
+}
+
+// isSVG 判断文本内容是否为 SVG 文档，根元素必须是 <svg>
+// 允许前面出现 XML 声明、注释和 DOCTYPE，其它内容 (例如 HTML 页面) 一律拒绝
+func isSVG(header []byte) bool {
+	rest := bytes.TrimSpace(bytes.TrimPrefix(header, []byte("\xEF\xBB\xBF")))
+	for {
+		lower := bytes.ToLower(rest)
+		switch {
+		case bytes.HasPrefix(lower, []byte("<?xml")):
+			rest = skipPast(rest, "?>")
+		case bytes.HasPrefix(lower, []byte("<!--")):
+			rest = skipPast(rest, "-->")
+		case bytes.HasPrefix(lower, []byte("<!doctype svg")):
+			rest = skipPast(rest, ">")
+		case bytes.HasPrefix(lower, []byte("<svg")) && len(lower) > 4:
+			next := lower[4]
+			return next == ' ' || next == '>' || next == '\t' || next == '\n' || next == '\r' || next == '/'
+		default:
+			return false
+		}
+		if rest == nil {
+			return false
+		}
+		rest = bytes.TrimSpace(rest)
+	}
+}
+
+// skipPast 返回 marker 之后的内容，找不到 marker 时返回 nil
+func skipPast(data []byte, marker string) []byte {
+	i := bytes.Index(data, []byte(marker))
+	if i < 0 {
+		return nil
+	}
+	return data[i+len(marker):]
+}
+
+// SniffFileHeader 读取上传文件的头部并识别类型
+func SniffFileHeader(file *multipart.FileHeader) (*FileType, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(src, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return DetectFileType(header[:n]), nil
+}
+
+// SniffLocalFile 读取磁盘文件的头部并识别类型
+func SniffLocalFile(path string) (*FileType, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(src, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return DetectFileType(header[:n]), nil
+}
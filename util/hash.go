@@ -2,23 +2,56 @@ package util
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"image"
 	"io"
 	"mime/multipart"
+	"os"
 )
 
-// CalculateFileMD5 计算 multipart.FileHeader 的 MD5 哈希值
-func CalculateFileMD5(file *multipart.FileHeader) (string, error) {
+// FileDigest 一次读取文件得到的哈希值和图片尺寸
+type FileDigest struct {
+	MD5    string // 仅为兼容旧数据保留，去重以 SHA256 为准
+	SHA256 string
+	Width  int // 无法解析尺寸的格式 (如 SVG) 为 0
+	Height int
+}
+
+// DigestFile 以流的方式读取一次上传文件，同时计算 MD5、SHA-256 并探测图片尺寸
+func DigestFile(file *multipart.FileHeader) (*FileDigest, error) {
 	src, err := file.Open()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	defer src.Close()
+	return digestReader(src)
+}
+
+// DigestLocalFile 与 DigestFile 相同，作用于磁盘上的文件
+func DigestLocalFile(path string) (*FileDigest, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
 	defer src.Close()
+	return digestReader(src)
+}
 
-	hash := md5.New()
-	if _, err := io.Copy(hash, src); err != nil {
-		return "", err
+func digestReader(src io.Reader) (*FileDigest, error) {
+	md5Hash, shaHash := md5.New(), sha256.New()
+	// 所有读出的字节都会先经过哈希，DecodeConfig 只读取文件头，剩余部分再一并读完
+	tee := io.TeeReader(src, io.MultiWriter(md5Hash, shaHash))
+
+	digest := &FileDigest{}
+	if config, _, err := image.DecodeConfig(tee); err == nil {
+		digest.Width, digest.Height = config.Width, config.Height
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return nil, err
 	}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	digest.MD5 = hex.EncodeToString(md5Hash.Sum(nil))
+	digest.SHA256 = hex.EncodeToString(shaHash.Sum(nil))
+	return digest, nil
 }
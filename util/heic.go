@@ -0,0 +1,48 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ConvertHEICToJPEG 调用外部命令将 HEIC/HEIF 数据转换为 JPEG
+// command 中的 {input} 和 {output} 会被替换为临时文件路径，命令超过 timeout 会被终止
+func ConvertHEICToJPEG(data []byte, command string, timeout time.Duration) ([]byte, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("HEIC converter is not configured")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "heic-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	input := filepath.Join(tmpDir, "input.heic")
+	output := filepath.Join(tmpDir, "output.jpg")
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+
+	for i, arg := range args {
+		arg = strings.ReplaceAll(arg, "{input}", input)
+		args[i] = strings.ReplaceAll(arg, "{output}", output)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("HEIC conversion timed out after %s", timeout)
+		}
+		return nil, fmt.Errorf("HEIC conversion failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.ReadFile(output)
+}
@@ -0,0 +1,117 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+)
+
+// DefaultJPEGQuality 重新编码 JPEG 时使用的默认质量
+const DefaultJPEGQuality = 90
+
+// DecodeImage 从字节中解码图片，返回图片和格式名 (jpeg/png/gif)
+func DecodeImage(data []byte) (image.Image, string, error) {
+	return image.Decode(bytes.NewReader(data))
+}
+
+// ToRGBA 将任意图片转换为可绘制的 *image.RGBA
+func ToRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok {
+		return rgba
+	}
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, src, b.Min, draw.Src)
+	return dst
+}
+
+// EncodeImage 按给定格式编码图片，返回数据和对应的 Content-Type
+// 目前支持 jpeg 和 png，quality 仅对 jpeg 生效
+func EncodeImage(img image.Image, format string, quality int) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	switch format {
+	case "jpeg":
+		if quality <= 0 || quality > 100 {
+			quality = DefaultJPEGQuality
+		}
+		if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	case "png":
+		if err := png.Encode(buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/png", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported encode format: %s", format)
+	}
+}
+
+// FitDimensions 计算在不超过 maxWidth/maxHeight 的前提下保持宽高比的尺寸
+// 任一限制 <= 0 表示该方向不限制
+func FitDimensions(width, height, maxWidth, maxHeight int) (int, int) {
+	ratio := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		ratio = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && height > maxHeight {
+		if r := float64(maxHeight) / float64(height); r < ratio {
+			ratio = r
+		}
+	}
+	w, h := int(float64(width)*ratio), int(float64(height)*ratio)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// ResizeImage 使用区域平均法将图片缩放到指定尺寸，适合缩小图片
+func ResizeImage(src image.Image, width, height int) *image.RGBA {
+	sb := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xScale := float64(sb.Dx()) / float64(width)
+	yScale := float64(sb.Dy()) / float64(height)
+
+	for y := 0; y < height; y++ {
+		y0 := sb.Min.Y + int(float64(y)*yScale)
+		y1 := sb.Min.Y + int(float64(y+1)*yScale)
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := sb.Min.X + int(float64(x)*xScale)
+			x1 := sb.Min.X + int(float64(x+1)*xScale)
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1 && sy < sb.Max.Y; sy++ {
+				for sx := x0; sx < x1 && sx < sb.Max.X; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
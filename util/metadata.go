@@ -0,0 +1,396 @@
+package util
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// 单个 EXIF 字段最多展开的值个数，避免超大数组撑爆响应
+const maxExifValues = 64
+
+// ImageMetadata 从图片文件中解析出的技术元数据
+type ImageMetadata struct {
+	EXIF map[string]interface{} `json:"exif,omitempty"`
+	GPS  map[string]interface{} `json:"gps,omitempty"`
+	ICC  *ICCProfile            `json:"icc,omitempty"`
+	XMP  string                 `json:"xmp,omitempty"`
+}
+
+// ICCProfile ICC 色彩配置文件的头部信息
+type ICCProfile struct {
+	Size        int    `json:"size"`
+	Version     string `json:"version"`
+	DeviceClass string `json:"device_class"`
+	ColorSpace  string `json:"color_space"`
+	PCS         string `json:"pcs"`
+	Description string `json:"description,omitempty"`
+}
+
+// ParseImageMetadata 解析 JPEG/PNG/WebP 中的 EXIF、ICC 和 XMP 数据
+// 不认识的格式或没有元数据时返回空结构，解析失败的部分直接忽略
+func ParseImageMetadata(data []byte) *ImageMetadata {
+	meta := &ImageMetadata{}
+	var exif, icc []byte
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		exif, icc, meta.XMP = scanJPEGMetadata(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		exif, icc, meta.XMP = scanPNGMetadata(data)
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		exif, icc, meta.XMP = scanWebPMetadata(data)
+	}
+	if len(exif) > 0 {
+		meta.EXIF, meta.GPS = parseTIFF(exif)
+	}
+	if len(icc) > 0 {
+		meta.ICC = parseICCProfile(icc)
+	}
+	return meta
+}
+
+func scanJPEGMetadata(data []byte) (exif, icc []byte, xmp string) {
+	const xmpHeader = "http://ns.adobe.com/xap/1.0/\x00"
+	var iccChunks [][]byte
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			break
+		}
+		marker := data[i+1]
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0xFF {
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // 图像数据开始，后面不会再有元数据段
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			exif = segment[6:]
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte(xmpHeader)):
+			xmp = string(segment[len(xmpHeader):])
+		case marker == 0xE2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")) && len(segment) > 14:
+			// ICC 可能被拆成多段，按段号拼接
+			iccChunks = append(iccChunks, segment[14:])
+		}
+		i += 2 + length
+	}
+	if len(iccChunks) > 0 {
+		icc = bytes.Join(iccChunks, nil)
+	}
+	return exif, icc, xmp
+}
+
+func scanPNGMetadata(data []byte) (exif, icc []byte, xmp string) {
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		chunkType := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) {
+			break
+		}
+		body := data[i+8 : i+8+length]
+		switch chunkType {
+		case "eXIf":
+			exif = body
+		case "iCCP":
+			// 配置名\0 压缩方式(1字节) zlib 数据
+			if idx := bytes.IndexByte(body, 0); idx >= 0 && idx+2 <= len(body) {
+				if r, err := zlib.NewReader(bytes.NewReader(body[idx+2:])); err == nil {
+					icc, _ = io.ReadAll(io.LimitReader(r, 4<<20))
+					r.Close()
+				}
+			}
+		case "iTXt":
+			if bytes.HasPrefix(body, []byte("XML:com.adobe.xmp\x00")) {
+				xmp = parsePNGiTXt(body[len("XML:com.adobe.xmp\x00"):])
+			}
+		case "IDAT", "IEND":
+			return exif, icc, xmp
+		}
+		i += 12 + length
+	}
+	return exif, icc, xmp
+}
+
+// parsePNGiTXt 解析 iTXt 关键字之后的部分: 压缩标志、压缩方式、语言\0、翻译关键字\0、文本
+func parsePNGiTXt(rest []byte) string {
+	if len(rest) < 2 {
+		return ""
+	}
+	compressed := rest[0] == 1
+	rest = rest[2:]
+	for n := 0; n < 2; n++ {
+		idx := bytes.IndexByte(rest, 0)
+		if idx < 0 {
+			return ""
+		}
+		rest = rest[idx+1:]
+	}
+	if !compressed {
+		return string(rest)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	text, _ := io.ReadAll(io.LimitReader(r, 4<<20))
+	return string(text)
+}
+
+func scanWebPMetadata(data []byte) (exif, icc []byte, xmp string) {
+	for i := 12; i+8 <= len(data); {
+		chunkType := string(data[i : i+4])
+		length := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		if length < 0 || i+8+length > len(data) {
+			break
+		}
+		body := data[i+8 : i+8+length]
+		switch chunkType {
+		case "EXIF":
+			exif = bytes.TrimPrefix(body, []byte("Exif\x00\x00"))
+		case "ICCP":
+			icc = body
+		case "XMP ":
+			xmp = string(body)
+		}
+		i += 8 + length + length%2 // 块按偶数字节对齐
+	}
+	return exif, icc, xmp
+}
+
+// exifTagNames 常用 EXIF 标签名，未列出的标签以十六进制编号展示
+var exifTagNames = map[uint16]string{
+	0x010F: "Make", 0x0110: "Model", 0x0112: "Orientation", 0x011A: "XResolution", 0x011B: "YResolution",
+	0x0128: "ResolutionUnit", 0x0131: "Software", 0x0132: "DateTime", 0x013B: "Artist", 0x8298: "Copyright",
+	0x829A: "ExposureTime", 0x829D: "FNumber", 0x8822: "ExposureProgram", 0x8827: "ISOSpeedRatings",
+	0x9000: "ExifVersion", 0x9003: "DateTimeOriginal", 0x9004: "DateTimeDigitized", 0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal", 0x9201: "ShutterSpeedValue", 0x9202: "ApertureValue", 0x9204: "ExposureBiasValue",
+	0x9205: "MaxApertureValue", 0x9207: "MeteringMode", 0x9209: "Flash", 0x920A: "FocalLength",
+	0xA001: "ColorSpace", 0xA002: "PixelXDimension", 0xA003: "PixelYDimension", 0xA402: "ExposureMode",
+	0xA403: "WhiteBalance", 0xA405: "FocalLengthIn35mmFilm", 0xA406: "SceneCaptureType",
+	0xA430: "CameraOwnerName", 0xA431: "BodySerialNumber", 0xA432: "LensSpecification", 0xA433: "LensMake",
+	0xA434: "LensModel",
+}
+
+// gpsTagNames GPS IFD 中的标签名
+var gpsTagNames = map[uint16]string{
+	0x0000: "GPSVersionID", 0x0001: "GPSLatitudeRef", 0x0002: "GPSLatitude", 0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude", 0x0005: "GPSAltitudeRef", 0x0006: "GPSAltitude", 0x0007: "GPSTimeStamp",
+	0x0010: "GPSImgDirectionRef", 0x0011: "GPSImgDirection", 0x001D: "GPSDateStamp",
+}
+
+// 不展开的标签: 子 IFD 指针、厂商私有数据、缩略图
+var skippedExifTags = map[uint16]bool{0x8769: true, 0x8825: true, 0xA005: true, 0x927C: true, 0x0201: true, 0x0202: true}
+
+// parseTIFF 解析 TIFF 结构的 EXIF 数据，返回 IFD0+Exif IFD 的字段和 GPS 字段
+func parseTIFF(data []byte) (map[string]interface{}, map[string]interface{}) {
+	if len(data) < 8 {
+		return nil, nil
+	}
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil
+	}
+	p := &tiffParser{data: data, order: order}
+
+	exif := map[string]interface{}{}
+	ifd0 := p.readIFD(order.Uint32(data[4:8]), exifTagNames, exif)
+	if offset, ok := ifd0[0x8769]; ok {
+		p.readIFD(offset, exifTagNames, exif)
+	}
+	var gps map[string]interface{}
+	if offset, ok := ifd0[0x8825]; ok {
+		gps = map[string]interface{}{}
+		p.readIFD(offset, gpsTagNames, gps)
+	}
+	if len(exif) == 0 {
+		exif = nil
+	}
+	if len(gps) == 0 {
+		gps = nil
+	}
+	return exif, gps
+}
+
+type tiffParser struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// typeSizes TIFF 字段类型对应的单个值字节数
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// readIFD 读取一个 IFD 的字段写入 out，返回子 IFD 指针 (Exif/GPS) 供继续解析
+func (p *tiffParser) readIFD(offset uint32, names map[uint16]string, out map[string]interface{}) map[uint16]uint32 {
+	pointers := map[uint16]uint32{}
+	if int(offset)+2 > len(p.data) {
+		return pointers
+	}
+	count := int(p.order.Uint16(p.data[offset:]))
+	for n := 0; n < count; n++ {
+		entry := int(offset) + 2 + n*12
+		if entry+12 > len(p.data) {
+			break
+		}
+		tag := p.order.Uint16(p.data[entry:])
+		typ := p.order.Uint16(p.data[entry+2:])
+		valueCount := int(p.order.Uint32(p.data[entry+4:]))
+		if tag == 0x8769 || tag == 0x8825 {
+			pointers[tag] = p.order.Uint32(p.data[entry+8:])
+			continue
+		}
+		if skippedExifTags[tag] {
+			continue
+		}
+		size, ok := typeSizes[typ]
+		if !ok || valueCount <= 0 {
+			continue
+		}
+		total := size * valueCount
+		var raw []byte
+		if total <= 4 {
+			raw = p.data[entry+8 : entry+8+total]
+		} else {
+			start := int(p.order.Uint32(p.data[entry+8:]))
+			if start < 0 || start+total > len(p.data) || total < 0 {
+				continue
+			}
+			raw = p.data[start : start+total]
+		}
+
+		name, ok := names[tag]
+		if !ok {
+			name = fmt.Sprintf("0x%04X", tag)
+		}
+		if value := p.decodeValue(typ, valueCount, raw); value != nil {
+			out[name] = value
+		}
+	}
+	return pointers
+}
+
+func (p *tiffParser) decodeValue(typ uint16, count int, raw []byte) interface{} {
+	switch typ {
+	case 2: // ASCII
+		return strings.TrimRight(string(raw), "\x00 ")
+	case 7: // UNDEFINED，短的按字符串展示 (如 ExifVersion)，长的不展开
+		if count > maxExifValues {
+			return nil
+		}
+		return strings.TrimRight(string(raw), "\x00")
+	}
+
+	if count > maxExifValues {
+		count = maxExifValues
+	}
+	values := make([]interface{}, 0, count)
+	for i := 0; i < count; i++ {
+		switch typ {
+		case 1, 6:
+			values = append(values, int(raw[i]))
+		case 3, 8:
+			values = append(values, int(p.order.Uint16(raw[i*2:])))
+		case 4:
+			values = append(values, p.order.Uint32(raw[i*4:]))
+		case 9:
+			values = append(values, int32(p.order.Uint32(raw[i*4:])))
+		case 5:
+			values = append(values, formatRational(int64(p.order.Uint32(raw[i*8:])), int64(p.order.Uint32(raw[i*8+4:]))))
+		case 10:
+			values = append(values, formatRational(int64(int32(p.order.Uint32(raw[i*8:]))), int64(int32(p.order.Uint32(raw[i*8+4:])))))
+		default:
+			return nil
+		}
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+// formatRational 整除时返回整数，否则返回保留精度的小数
+func formatRational(num, den int64) interface{} {
+	if den == 0 {
+		return nil
+	}
+	if num%den == 0 {
+		return num / den
+	}
+	return float64(num) / float64(den)
+}
+
+// parseICCProfile 解析 ICC 头部和描述标签
+func parseICCProfile(data []byte) *ICCProfile {
+	if len(data) < 132 {
+		return nil
+	}
+	profile := &ICCProfile{
+		Size:        len(data),
+		Version:     fmt.Sprintf("%d.%d.%d", data[8], data[9]>>4, data[9]&0x0F),
+		DeviceClass: strings.TrimSpace(string(data[12:16])),
+		ColorSpace:  strings.TrimSpace(string(data[16:20])),
+		PCS:         strings.TrimSpace(string(data[20:24])),
+	}
+
+	tagCount := int(binary.BigEndian.Uint32(data[128:132]))
+	for n := 0; n < tagCount; n++ {
+		entry := 132 + n*12
+		if entry+12 > len(data) {
+			break
+		}
+		if string(data[entry:entry+4]) != "desc" {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 12 || offset+size > len(data) {
+			break
+		}
+		profile.Description = parseICCDescription(data[offset : offset+size])
+		break
+	}
+	return profile
+}
+
+// parseICCDescription 支持 v2 的 desc 类型和 v4 的 mluc 类型
+func parseICCDescription(tag []byte) string {
+	switch string(tag[0:4]) {
+	case "desc":
+		length := int(binary.BigEndian.Uint32(tag[8:12]))
+		if length <= 0 || 12+length > len(tag) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+length]), "\x00")
+	case "mluc":
+		if len(tag) < 28 {
+			return ""
+		}
+		// 只取第一条本地化记录
+		length := int(binary.BigEndian.Uint32(tag[20:24]))
+		offset := int(binary.BigEndian.Uint32(tag[24:28]))
+		if length <= 0 || offset+length > len(tag) {
+			return ""
+		}
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package util
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// pHash 计算时先缩放到的边长，以及保留的低频区域边长
+const (
+	phashSampleSize = 32
+	phashLowFreq    = 8
+)
+
+// PerceptualHash 计算图片的感知哈希 (pHash)
+// 图片缩放为 32x32 灰度图后做二维 DCT，取左上角 8x8 低频系数与中位数比较得到 64 位哈希
+func PerceptualHash(img image.Image) uint64 {
+	small := ResizeImage(img, phashSampleSize, phashSampleSize)
+	pixels := make([][]float64, phashSampleSize)
+	for y := 0; y < phashSampleSize; y++ {
+		pixels[y] = make([]float64, phashSampleSize)
+		for x := 0; x < phashSampleSize; x++ {
+			i := small.PixOffset(x, y)
+			pixels[y][x] = luminance(small.Pix[i], small.Pix[i+1], small.Pix[i+2])
+		}
+	}
+
+	coeffs := dct2D(pixels)
+	lowFreq := make([]float64, 0, phashLowFreq*phashLowFreq)
+	for y := 0; y < phashLowFreq; y++ {
+		for x := 0; x < phashLowFreq; x++ {
+			lowFreq = append(lowFreq, coeffs[y][x])
+		}
+	}
+
+	// 直流分量只反映整体亮度，计算中位数时排除
+	sorted := append([]float64(nil), lowFreq[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i, v := range lowFreq {
+		if v > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// HammingDistance 返回两个哈希不同的位数，0 表示感知上相同
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// DiffImage 生成两张图片的可视化差异图
+// 两张图都缩放到 width x height，相同的区域显示为变暗的灰度图，差异越大的像素越红
+func DiffImage(a, b image.Image, width, height int) *image.RGBA {
+	ra := ResizeImage(a, width, height)
+	rb := ResizeImage(b, width, height)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := ra.PixOffset(x, y)
+			diff := (absDiff(ra.Pix[i], rb.Pix[i]) + absDiff(ra.Pix[i+1], rb.Pix[i+1]) + absDiff(ra.Pix[i+2], rb.Pix[i+2])) / 3
+			gray := uint8(luminance(ra.Pix[i], ra.Pix[i+1], ra.Pix[i+2]) / 3)
+			c := color.RGBA{R: gray, G: gray, B: gray, A: 255}
+			if diff > 16 {
+				c = color.RGBA{R: uint8(math.Min(255, float64(gray)+float64(diff)*2)), G: gray / 2, B: gray / 2, A: 255}
+			}
+			dst.SetRGBA(x, y, c)
+		}
+	}
+	return dst
+}
+
+func luminance(r, g, b uint8) float64 {
+	return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// dct2D 对方阵做二维 DCT-II (先按行再按列)
+func dct2D(in [][]float64) [][]float64 {
+	n := len(in)
+	rows := make([][]float64, n)
+	for y := 0; y < n; y++ {
+		rows[y] = dct1D(in[y])
+	}
+	out := make([][]float64, n)
+	for y := range out {
+		out[y] = make([]float64, n)
+	}
+	col := make([]float64, n)
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			col[y] = rows[y][x]
+		}
+		transformed := dct1D(col)
+		for y := 0; y < n; y++ {
+			out[y][x] = transformed[y]
+		}
+	}
+	return out
+}
+
+func dct1D(in []float64) []float64 {
+	n := len(in)
+	out := make([]float64, n)
+	for k := 0; k < n; k++ {
+		var sum float64
+		for i, v := range in {
+			sum += v * math.Cos(math.Pi/float64(n)*(float64(i)+0.5)*float64(k))
+		}
+		out[k] = sum
+	}
+	return out
+}
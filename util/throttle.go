@@ -0,0 +1,87 @@
+package util
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunkSize 限速读取时每次读取的最大字节数，避免单次读取造成突发流量
+const throttleChunkSize = 32 * 1024
+
+// BandwidthLimiter 基于令牌桶的带宽限制器，可被多个读取者共享
+// 速率 <= 0 表示不限速
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   int64 // 字节/秒
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidthLimiter 创建一个带宽限制器
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	return &BandwidthLimiter{rate: bytesPerSecond, last: time.Now()}
+}
+
+// SetRate 修改速率，正在等待的读取者会在下一次读取时使用新速率
+func (l *BandwidthLimiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate != bytesPerSecond {
+		l.rate = bytesPerSecond
+		l.tokens = 0
+		l.last = time.Now()
+	}
+}
+
+// Wait 消耗 n 个字节的配额，配额不足时阻塞到可用为止
+func (l *BandwidthLimiter) Wait(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	// 最多积累 1 秒的配额
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// ThrottledReader 按一个或多个带宽限制器限速的 io.Reader
+type ThrottledReader struct {
+	r        io.Reader
+	limiters []*BandwidthLimiter
+}
+
+// NewThrottledReader 包装 r，读取的数据会依次计入每个限制器
+func NewThrottledReader(r io.Reader, limiters ...*BandwidthLimiter) *ThrottledReader {
+	return &ThrottledReader{r: r, limiters: limiters}
+}
+
+func (t *ThrottledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		for _, l := range t.limiters {
+			if l != nil {
+				l.Wait(n)
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,174 @@
+package util
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// 水印位置
+const (
+	WatermarkTopLeft     = "top-left"
+	WatermarkTopRight    = "top-right"
+	WatermarkBottomLeft  = "bottom-left"
+	WatermarkBottomRight = "bottom-right"
+	WatermarkCenter      = "center"
+)
+
+const (
+	glyphWidth  = 5
+	glyphHeight = 7
+)
+
+// glyphs 是一个 5x7 的点阵字体，每行低 5 位从左到右表示像素
+// 小写字母按大写渲染，不支持的字符需在保存设置时通过 UnsupportedWatermarkRunes 拒绝
+var glyphs = map[rune][glyphHeight]uint8{
+	' ':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	'!':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x00, 0x04},
+	'#':  {0x0A, 0x0A, 0x1F, 0x0A, 0x1F, 0x0A, 0x0A},
+	'&':  {0x0C, 0x12, 0x14, 0x08, 0x15, 0x12, 0x0D},
+	'(':  {0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02},
+	')':  {0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08},
+	'+':  {0x00, 0x04, 0x04, 0x1F, 0x04, 0x04, 0x00},
+	',':  {0x00, 0x00, 0x00, 0x00, 0x0C, 0x04, 0x08},
+	'-':  {0x00, 0x00, 0x00, 0x1F, 0x00, 0x00, 0x00},
+	'.':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x0C, 0x0C},
+	'/':  {0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00},
+	':':  {0x00, 0x0C, 0x0C, 0x00, 0x0C, 0x0C, 0x00},
+	'=':  {0x00, 0x00, 0x1F, 0x00, 0x1F, 0x00, 0x00},
+	'?':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0x00, 0x04},
+	'@':  {0x0E, 0x11, 0x17, 0x15, 0x17, 0x10, 0x0E},
+	'_':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1F},
+	'|':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'©':  {0x0E, 0x11, 0x17, 0x19, 0x17, 0x11, 0x0E},
+	'0':  {0x0E, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0E},
+	'1':  {0x04, 0x0C, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'2':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1F},
+	'3':  {0x1F, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0E},
+	'4':  {0x02, 0x06, 0x0A, 0x12, 0x1F, 0x02, 0x02},
+	'5':  {0x1F, 0x10, 0x1E, 0x01, 0x01, 0x11, 0x0E},
+	'6':  {0x06, 0x08, 0x10, 0x1E, 0x11, 0x11, 0x0E},
+	'7':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8':  {0x0E, 0x11, 0x11, 0x0E, 0x11, 0x11, 0x0E},
+	'9':  {0x0E, 0x11, 0x11, 0x0F, 0x01, 0x02, 0x0C},
+	'A':  {0x0E, 0x11, 0x11, 0x11, 0x1F, 0x11, 0x11},
+	'B':  {0x1E, 0x11, 0x11, 0x1E, 0x11, 0x11, 0x1E},
+	'C':  {0x0E, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0E},
+	'D':  {0x1C, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1C},
+	'E':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x1F},
+	'F':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x10},
+	'G':  {0x0E, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0F},
+	'H':  {0x11, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'I':  {0x0E, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'J':  {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0C},
+	'K':  {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L':  {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1F},
+	'M':  {0x11, 0x1B, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N':  {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O':  {0x0E, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'P':  {0x1E, 0x11, 0x11, 0x1E, 0x10, 0x10, 0x10},
+	'Q':  {0x0E, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0D},
+	'R':  {0x1E, 0x11, 0x11, 0x1E, 0x14, 0x12, 0x11},
+	'S':  {0x0F, 0x10, 0x10, 0x0E, 0x01, 0x01, 0x1E},
+	'T':  {0x1F, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'V':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x0A, 0x04},
+	'W':  {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0A},
+	'X':  {0x11, 0x11, 0x0A, 0x04, 0x0A, 0x11, 0x11},
+	'Y':  {0x11, 0x11, 0x11, 0x0A, 0x04, 0x04, 0x04},
+	'Z':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1F},
+	'\'': {0x0C, 0x04, 0x08, 0x00, 0x00, 0x00, 0x00},
+}
+
+// UnsupportedWatermarkRunes 返回文本中点阵字体无法渲染的字符 (去重，按出现顺序)
+func UnsupportedWatermarkRunes(text string) []rune {
+	var unsupported []rune
+	seen := make(map[rune]bool)
+	for _, r := range strings.ToUpper(text) {
+		if _, ok := glyphs[r]; ok || seen[r] {
+			continue
+		}
+		seen[r] = true
+		unsupported = append(unsupported, r)
+	}
+	return unsupported
+}
+
+// RenderTextWatermark 将文本渲染为带阴影的白色文字图层
+// scale 为每个点阵像素放大的倍数
+func RenderTextWatermark(text string, scale int) *image.RGBA {
+	if scale < 1 {
+		scale = 1
+	}
+	runes := []rune(strings.ToUpper(text))
+	// 每个字符之间留 1 列间距，四周留 1 个像素给阴影
+	width := (len(runes)*(glyphWidth+1) + 1) * scale
+	height := (glyphHeight + 2) * scale
+	layer := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	shadow := color.RGBA{A: 160}
+	fg := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	for pass, c := range []color.RGBA{shadow, fg} {
+		offset := 0
+		if pass == 0 {
+			offset = scale / 2
+			if offset == 0 {
+				offset = 1
+			}
+		}
+		for i, r := range runes {
+			glyph, ok := glyphs[r]
+			if !ok {
+				continue
+			}
+			originX := i*(glyphWidth+1)*scale + offset
+			for row := 0; row < glyphHeight; row++ {
+				for col := 0; col < glyphWidth; col++ {
+					if glyph[row]&(1<<(glyphWidth-1-col)) == 0 {
+						continue
+					}
+					px := originX + col*scale
+					py := row*scale + offset
+					draw.Draw(layer, image.Rect(px, py, px+scale, py+scale), image.NewUniform(c), image.Point{}, draw.Over)
+				}
+			}
+		}
+	}
+	return layer
+}
+
+// ApplyWatermark 将水印图层以指定透明度 (0-100) 绘制到 dst 的指定位置
+func ApplyWatermark(dst *image.RGBA, mark image.Image, position string, opacity int) {
+	if opacity <= 0 {
+		return
+	}
+	if opacity > 100 {
+		opacity = 100
+	}
+
+	db := dst.Bounds()
+	mb := mark.Bounds()
+	margin := db.Dx() / 50
+	if margin < 8 {
+		margin = 8
+	}
+
+	var origin image.Point
+	switch position {
+	case WatermarkTopLeft:
+		origin = image.Pt(db.Min.X+margin, db.Min.Y+margin)
+	case WatermarkTopRight:
+		origin = image.Pt(db.Max.X-mb.Dx()-margin, db.Min.Y+margin)
+	case WatermarkBottomLeft:
+		origin = image.Pt(db.Min.X+margin, db.Max.Y-mb.Dy()-margin)
+	case WatermarkCenter:
+		origin = image.Pt(db.Min.X+(db.Dx()-mb.Dx())/2, db.Min.Y+(db.Dy()-mb.Dy())/2)
+	default:
+		origin = image.Pt(db.Max.X-mb.Dx()-margin, db.Max.Y-mb.Dy()-margin)
+	}
+
+	target := image.Rectangle{Min: origin, Max: origin.Add(mb.Size())}
+	mask := image.NewUniform(color.Alpha{A: uint8(opacity * 255 / 100)})
+	draw.DrawMask(dst, target, mark, mb.Min, mask, image.Point{}, draw.Over)
+}